@@ -1,15 +1,19 @@
 package api
 
 import (
+	"errors"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gorilla/websocket"
 
+	"github.com/abrander/agento/audit"
 	"github.com/abrander/agento/core"
 	"github.com/abrander/agento/logger"
 	"github.com/abrander/agento/plugins"
+	"github.com/abrander/agento/secret"
+	"github.com/abrander/agento/timeseries"
 	"github.com/abrander/agento/userdb"
 )
 
@@ -72,6 +76,19 @@ func getSubject(c *gin.Context) userdb.Subject {
 	return c.MustGet("subject").(userdb.Subject)
 }
 
+// authorize will abort the request with 403 unless the subject has permission
+// on object.
+func authorize(c *gin.Context, object userdb.Object, permission userdb.Permission) bool {
+	err := getSubject(c).Authorize(object, permission)
+	if err != nil {
+		logger.Yellow("api", "[%s %s] %s denied %s", c.Request.Method, c.Request.URL.Path, getSubject(c).GetId(), permission)
+		abortWithError(c, err)
+		return false
+	}
+
+	return true
+}
+
 func getAccountId(c *gin.Context) string {
 	// First try to read header
 	accountId := c.Request.Header.Get("X-Agento-Account")
@@ -87,7 +104,7 @@ func getAccountId(c *gin.Context) string {
 	case userdb.User:
 		accounts, err := subject.(userdb.User).GetAccounts()
 		if err != nil {
-			c.AbortWithError(http.StatusInternalServerError, err)
+			abortWithError(c, err)
 			return ""
 		}
 
@@ -96,45 +113,55 @@ func getAccountId(c *gin.Context) string {
 		}
 	}
 
-	c.AbortWithError(http.StatusInternalServerError, userdb.ErrorInvalidAccountId)
+	// The subject has access to multiple accounts, the client must choose.
+	abortWithStatus(c, http.StatusBadRequest, errors.New("X-Agento-Account header required"))
 	return ""
 }
 
-func Init(router gin.IRouter, store core.Store, emitter core.Emitter, db userdb.Database) {
-	router.GET("/ws/:key", func(c *gin.Context) {
-		key := c.Param("key")
-		subject, error := db.ResolveKey(key)
-		if error != nil {
-			logger.Yellow("api", "[%s %s] Could not resolve API key '%s' from %s, aborting", c.Request.Method, c.Request.URL, key, c.Request.RemoteAddr)
+func Init(router gin.IRouter, store core.Store, emitter core.Emitter, db userdb.Database, tsdb timeseries.Database) {
+	initLogin(router, db)
+
+	router.GET("/ws", func(c *gin.Context) {
+		_, subject, err := resolveSession(c, db)
+		if err != nil {
+			logger.Yellow("api", "[%s %s] No session found, aborting websocket from %s", c.Request.Method, c.Request.URL.Path, c.Request.RemoteAddr)
 			c.AbortWithStatus(http.StatusForbidden)
 			return
 		}
 
-		logger.Green("api", "[%s %s] API key '%s' authorized for %s", c.Request.Method, c.Request.URL, key, subject.GetId())
+		logger.Green("api", "[%s %s] Session authorized for %s", c.Request.Method, c.Request.URL.Path, subject.GetId())
 
 		wsHandler(c, emitter, subject)
 	})
 
-	router.Use(func(c *gin.Context) {
-		key := c.Request.Header.Get("X-Agento-Secret")
-		if key == "" {
-			logger.Yellow("api", "[%s %s] No API key found, aborting request from %s", c.Request.Method, c.Request.URL, c.Request.RemoteAddr)
-			c.AbortWithStatus(http.StatusForbidden)
-			return
-		}
-
+	// Deprecated: The key will end up in access logs of proxies. Use a
+	// session cookie and /ws instead.
+	router.GET("/ws/:key", func(c *gin.Context) {
+		key := c.Param("key")
 		subject, error := db.ResolveKey(key)
 		if error != nil {
-			logger.Yellow("api", "[%s %s] Could not resolve API key '%s' from %s, aborting", c.Request.Method, c.Request.URL, key, c.Request.RemoteAddr)
+			logger.Yellow("api", "[%s /ws/%s] Could not resolve API key from %s, aborting", c.Request.Method, secret.Redacted, c.Request.RemoteAddr)
 			c.AbortWithStatus(http.StatusForbidden)
 			return
 		}
 
-		logger.Green("api", "[%s %s] API key '%s' authorized for %s", c.Request.Method, c.Request.URL, key, subject.GetId())
+		logger.Yellow("api", "[%s /ws/%s] API key authorized for %s using deprecated key in URL", c.Request.Method, secret.Redacted, subject.GetId())
 
-		c.Set("subject", subject)
+		wsHandler(c, emitter, subject)
 	})
 
+	router.Use(authenticate(db))
+
+	initSession(router, db)
+
+	if multiUser, ok := db.(*userdb.MultiUser); ok {
+		initUserdb(router, multiUser)
+	}
+
+	if auditStore, ok := store.(*audit.Store); ok {
+		initAudit(router, auditStore.Sink())
+	}
+
 	{
 		a := router.Group("/agent")
 
@@ -147,111 +174,359 @@ func Init(router gin.IRouter, store core.Store, emitter core.Emitter, db userdb.
 	{
 		h := router.Group("/host")
 
+		h.GET("/:id", func(c *gin.Context) {
+			host, err := store.GetHost(getSubject(c), c.Param("id"))
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+
+			if !authorize(c, host, userdb.PermissionRead) {
+				return
+			}
+
+			c.JSON(200, host)
+		})
+
+		h.GET("/:id/probes", func(c *gin.Context) {
+			subject := getSubject(c)
+
+			host, err := store.GetHost(subject, c.Param("id"))
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+
+			if !authorize(c, host, userdb.PermissionRead) {
+				return
+			}
+
+			all, err := store.GetAllProbes(subject, host.AccountID)
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+
+			probes := []core.Probe{}
+			for _, probe := range all {
+				if probe.HostID == host.ID {
+					probes = append(probes, probe)
+				}
+			}
+
+			listProbes(c, probes)
+		})
+
+		h.PUT("/:id", func(c *gin.Context) {
+			var host core.Host
+			subject := getSubject(c)
+
+			if !bindJSON(c, &host) {
+				return
+			}
+
+			previous, err := store.GetHost(subject, c.Param("id"))
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+
+			if !authorize(c, previous, userdb.PermissionWriteHosts) {
+				return
+			}
+
+			host.ID = previous.ID
+			if host.AccountID == "" {
+				host.AccountID = previous.AccountID
+			}
+
+			// Clients will only ever see redacted secrets. Make sure we
+			// don't overwrite the real values with the placeholder.
+			host.RestoreSecrets(previous)
+
+			if !authorize(c, &host, userdb.PermissionWriteHosts) {
+				return
+			}
+
+			err = host.Validate()
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+
+			err = audit.WithOrigin(store, c.Request.RemoteAddr).UpdateHost(subject, &host)
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+
+			c.JSON(200, host)
+		})
+
 		h.DELETE("/:id", func(c *gin.Context) {
 			id := c.Param("id")
 			subject := getSubject(c)
 
-			err := store.DeleteHost(subject, id)
+			host, err := store.GetHost(subject, id)
 			if err != nil {
-				c.AbortWithError(500, err)
-			} else {
-				c.JSON(200, nil)
+				abortWithError(c, err)
+				return
+			}
+
+			if !authorize(c, host, userdb.PermissionWriteHosts) {
+				return
 			}
+
+			err = audit.WithOrigin(store, c.Request.RemoteAddr).DeleteHost(subject, id)
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+
+			c.JSON(200, nil)
 		})
 
 		h.POST("/new", func(c *gin.Context) {
 			var host core.Host
 			subject := getSubject(c)
 
-			c.Bind(&host)
-			err := store.AddHost(subject, &host)
+			if !bindJSON(c, &host) {
+				return
+			}
+
+			// The store will assign an id.
+			host.ID = ""
+
+			if host.AccountID == "" {
+				host.AccountID = getAccountId(c)
+				if c.IsAborted() {
+					return
+				}
+			}
+
+			if !authorize(c, &host, userdb.PermissionWriteHosts) {
+				return
+			}
+
+			err := host.Validate()
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+
+			err = audit.WithOrigin(store, c.Request.RemoteAddr).AddHost(subject, &host)
 			if err != nil {
-				c.AbortWithError(500, err)
-			} else {
-				c.JSON(200, host)
+				abortWithError(c, err)
+				return
 			}
+
+			c.JSON(200, host)
 		})
 
 		h.GET("/", func(c *gin.Context) {
 			subject := getSubject(c)
 			accountId := getAccountId(c)
+			if c.IsAborted() || !authorize(c, userdb.ObjectProxy(accountId), userdb.PermissionRead) {
+				return
+			}
 
 			hosts, err := store.GetAllHosts(subject, accountId)
 			if err != nil {
-				c.AbortWithError(500, err)
-			} else {
-				c.JSON(200, hosts)
+				abortWithError(c, err)
+				return
 			}
+
+			listHosts(c, hosts)
 		})
 	}
 
+	// validateProbeHost makes sure the host of a probe exists and is
+	// accessible by the subject.
+	validateProbeHost := func(c *gin.Context, probe *core.Probe) bool {
+		if probe.HostID == "" {
+			return true
+		}
+
+		_, err := store.GetHost(getSubject(c), probe.HostID)
+		if err != nil {
+			abortWithError(c, &core.ValidationError{Fields: map[string]string{"host": "unknown host '" + probe.HostID + "'"}})
+			return false
+		}
+
+		return true
+	}
+
 	{
 		m := router.Group("/probe")
 
 		m.GET("/:id", func(c *gin.Context) {
-			id := c.Param("id")
-			subject := getSubject(c)
-
-			probe, err := store.GetProbe(subject, id)
+			probe, err := store.GetProbe(getSubject(c), c.Param("id"))
 			if err != nil {
-				c.AbortWithError(404, err)
-			} else {
-				c.JSON(200, probe)
+				abortWithError(c, err)
+				return
 			}
+
+			if !authorize(c, probe, userdb.PermissionRead) {
+				return
+			}
+
+			c.JSON(200, probe)
 		})
 
 		m.PUT("/:id", func(c *gin.Context) {
 			var probe core.Probe
 			subject := getSubject(c)
 
-			c.Bind(&probe)
-			err := store.UpdateProbe(subject, &probe)
+			if !bindJSON(c, &probe) {
+				return
+			}
+
+			previous, err := store.GetProbe(subject, c.Param("id"))
 			if err != nil {
-				c.AbortWithError(500, err)
-			} else {
-				c.JSON(200, probe)
+				abortWithError(c, err)
+				return
+			}
+
+			if !authorize(c, previous, userdb.PermissionWriteProbes) {
+				return
+			}
+
+			probe.ID = previous.ID
+			if probe.AccountID == "" {
+				probe.AccountID = previous.AccountID
 			}
+
+			if probe.Interval == 0 {
+				probe.Interval = previous.Interval
+			}
+
+			// The state maintained by the scheduler cannot be changed by
+			// clients.
+			probe.LastCheck = previous.LastCheck
+			probe.NextCheck = previous.NextCheck
+			probe.LastPoints = previous.LastPoints
+			probe.LastError = previous.LastError
+
+			// Clients will only ever see redacted secrets. Make sure we
+			// don't overwrite the real values with the placeholder.
+			probe.RestoreSecrets(previous)
+
+			if !authorize(c, &probe, userdb.PermissionWriteProbes) {
+				return
+			}
+
+			err = probe.Validate()
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+
+			if !validateProbeHost(c, &probe) {
+				return
+			}
+
+			err = audit.WithOrigin(store, c.Request.RemoteAddr).UpdateProbe(subject, &probe)
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+
+			c.JSON(200, probe)
 		})
 
 		m.DELETE("/:id", func(c *gin.Context) {
 			id := c.Param("id")
 			subject := getSubject(c)
 
-			err := store.DeleteProbe(subject, id)
+			probe, err := store.GetProbe(subject, id)
 			if err != nil {
-				c.AbortWithError(500, err)
-			} else {
-				c.JSON(200, nil)
+				abortWithError(c, err)
+				return
+			}
+
+			if !authorize(c, probe, userdb.PermissionWriteProbes) {
+				return
 			}
+
+			err = audit.WithOrigin(store, c.Request.RemoteAddr).DeleteProbe(subject, id)
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+
+			c.JSON(200, nil)
 		})
 
 		m.POST("/new", func(c *gin.Context) {
 			var probe core.Probe
 			subject := getSubject(c)
 
-			c.Bind(&probe)
-			err := store.AddProbe(subject, &probe)
+			if !bindJSON(c, &probe) {
+				return
+			}
+
+			if probe.AccountID == "" {
+				probe.AccountID = getAccountId(c)
+				if c.IsAborted() {
+					return
+				}
+			}
+
+			if probe.Interval == 0 {
+				probe.Interval = core.DefaultInterval
+			}
+
+			// Scheduler state is never accepted from clients.
+			probe.LastCheck = time.Time{}
+			probe.NextCheck = time.Time{}
+			probe.LastPoints = nil
+			probe.LastError = ""
+
+			if !authorize(c, &probe, userdb.PermissionWriteProbes) {
+				return
+			}
+
+			err := probe.Validate()
 			if err != nil {
-				logger.Yellow("api", "Error: %s", err.Error())
-				c.AbortWithError(500, err)
-			} else {
-				c.JSON(200, probe)
+				abortWithError(c, err)
+				return
 			}
+
+			if !validateProbeHost(c, &probe) {
+				return
+			}
+
+			err = audit.WithOrigin(store, c.Request.RemoteAddr).AddProbe(subject, &probe)
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+
+			c.JSON(200, probe)
 		})
 
 		m.GET("/", func(c *gin.Context) {
 			subject := getSubject(c)
 			accountId := getAccountId(c)
+			if c.IsAborted() || !authorize(c, userdb.ObjectProxy(accountId), userdb.PermissionRead) {
+				return
+			}
 
 			probes, err := store.GetAllProbes(subject, accountId)
 			if err != nil {
-				c.AbortWithError(500, err)
-			} else {
-				c.JSON(200, probes)
+				abortWithError(c, err)
+				return
 			}
+
+			listProbes(c, probes)
 		})
 	}
 
+	initRun(router, store)
+	initQuery(router, tsdb)
+	initCardinality(router, tsdb)
+	initHostKeys(router)
+
 	{
 		t := router.Group("/transport")
 
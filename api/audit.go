@@ -0,0 +1,98 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/abrander/agento/audit"
+	"github.com/abrander/agento/userdb"
+)
+
+// parseTime parses the query parameter name as RFC3339, "now" or as a
+// duration relative to now, like "-1h". A missing parameter will result in
+// the zero time.
+func parseTime(c *gin.Context, name string, now time.Time) (time.Time, error) {
+	value := c.Query(name)
+	switch value {
+	case "":
+		return time.Time{}, nil
+	case "now":
+		return now, nil
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, value)
+	if err == nil {
+		return t, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, errors.New(name + " must be a RFC3339 time or a duration")
+	}
+
+	return now.Add(d), nil
+}
+
+// initAudit adds the endpoint for querying the audit log.
+func initAudit(router gin.IRouter, sink audit.Sink) {
+	router.GET("/audit", func(c *gin.Context) {
+		var err error
+		now := time.Now()
+
+		filter := audit.Filter{
+			AccountID: c.Query("account"),
+			SubjectID: c.Query("subject"),
+			Action:    c.Query("action"),
+			ObjectID:  c.Query("object"),
+			Limit:     100,
+		}
+
+		if limit := c.Query("limit"); limit != "" {
+			filter.Limit, err = strconv.Atoi(limit)
+			if err == nil && filter.Limit < 0 {
+				err = errors.New("limit must not be negative")
+			}
+
+			if err != nil {
+				abortWithStatus(c, http.StatusBadRequest, err)
+				return
+			}
+		}
+
+		filter.From, err = parseTime(c, "from", now)
+		if err != nil {
+			abortWithStatus(c, http.StatusBadRequest, err)
+			return
+		}
+
+		filter.To, err = parseTime(c, "to", now)
+		if err != nil {
+			abortWithStatus(c, http.StatusBadRequest, err)
+			return
+		}
+
+		// Only God can query across accounts.
+		if filter.AccountID == "" && !userdb.IsGod(getSubject(c)) {
+			filter.AccountID = getAccountId(c)
+			if c.IsAborted() {
+				return
+			}
+		}
+
+		if filter.AccountID != "" && !authorize(c, userdb.ObjectProxy(filter.AccountID), userdb.PermissionAdmin) {
+			return
+		}
+
+		entries, err := sink.Query(filter)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, entries)
+	})
+}
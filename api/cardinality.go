@@ -0,0 +1,70 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/abrander/agento/timeseries"
+	"github.com/abrander/agento/userdb"
+)
+
+type (
+	// cardinalityResponse is returned by GET /cardinality.
+	cardinalityResponse struct {
+		Limits timeseries.GuardLimits        `json:"limits"`
+		Series []timeseries.CardinalityStats `json:"series"`
+	}
+)
+
+// findGuard returns the cardinality guard wrapped by tsdb or nil if none is
+// found.
+func findGuard(tsdb timeseries.Database) *timeseries.Guard {
+	for tsdb != nil {
+		if guard, ok := tsdb.(*timeseries.Guard); ok {
+			return guard
+		}
+
+		wrapper, ok := tsdb.(timeseries.Wrapper)
+		if !ok {
+			return nil
+		}
+
+		tsdb = wrapper.Unwrap()
+	}
+
+	return nil
+}
+
+// initCardinality adds the endpoint for inspecting series cardinality.
+func initCardinality(router gin.IRouter, tsdb timeseries.Database) {
+	guard := findGuard(tsdb)
+
+	router.GET("/cardinality", func(c *gin.Context) {
+		accountID := getAccountId(c)
+		if accountID == "" {
+			return
+		}
+
+		if !authorize(c, userdb.ObjectProxy(accountID), userdb.PermissionRead) {
+			return
+		}
+
+		response := cardinalityResponse{
+			Series: []timeseries.CardinalityStats{},
+		}
+
+		if guard != nil {
+			response.Limits = guard.Limits()
+
+			// God can see all accounts.
+			if accountID == userdb.God.GetAccountId() {
+				response.Series = guard.Stats("", true)
+			} else {
+				response.Series = guard.Stats(accountID, false)
+			}
+		}
+
+		c.JSON(http.StatusOK, response)
+	})
+}
@@ -0,0 +1,72 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/abrander/agento/core"
+	"github.com/abrander/agento/plugins/transports/ssh"
+	"github.com/abrander/agento/timeseries"
+	"github.com/abrander/agento/userdb"
+)
+
+type (
+	// errorResponse is the body returned for all failed requests.
+	errorResponse struct {
+		Error  string            `json:"error"`
+		Fields map[string]string `json:"fields,omitempty"`
+	}
+)
+
+// statusFor maps an error to a HTTP status code.
+func statusFor(err error) int {
+	switch err {
+	case core.ErrHostNotFound, core.ErrProbeNotFound, userdb.ErrorInvalidAccountId, userdb.ErrorInvalidUserId, userdb.ErrorKeyNotFound, ssh.ErrHostKeyNotFound:
+		return http.StatusNotFound
+	case userdb.ErrorNoAccess:
+		return http.StatusForbidden
+	case userdb.ErrorInvalidRole, userdb.ErrorInvalidScope, userdb.ErrorEmptyPassword:
+		return http.StatusBadRequest
+	case userdb.ErrorUserExists:
+		return http.StatusConflict
+	}
+
+	switch err.(type) {
+	case *core.ValidationError, *timeseries.QueryError:
+		return http.StatusBadRequest
+	}
+
+	return http.StatusInternalServerError
+}
+
+// abortWithStatus aborts the request with status and err as a JSON body.
+func abortWithStatus(c *gin.Context, status int, err error) {
+	response := errorResponse{
+		Error: err.Error(),
+	}
+
+	if v, ok := err.(*core.ValidationError); ok {
+		response.Fields = v.Fields
+	}
+
+	c.Error(err)
+	c.AbortWithStatusJSON(status, response)
+}
+
+// abortWithError aborts the request with a status code matching err.
+func abortWithError(c *gin.Context, err error) {
+	abortWithStatus(c, statusFor(err), err)
+}
+
+// bindJSON decodes the request body into obj. The request is aborted with a
+// 400 if the body is malformed.
+func bindJSON(c *gin.Context, obj interface{}) bool {
+	err := c.ShouldBindJSON(obj)
+	if err != nil {
+		abortWithStatus(c, http.StatusBadRequest, err)
+		return false
+	}
+
+	return true
+}
@@ -0,0 +1,65 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/abrander/agento/logger"
+	"github.com/abrander/agento/plugins/transports/ssh"
+)
+
+type (
+	// hostKeyRequest identifies a host key to approve or revoke.
+	hostKeyRequest struct {
+		Address     string `json:"address" binding:"required"`
+		Fingerprint string `json:"fingerprint" binding:"required"`
+	}
+)
+
+// initHostKeys adds endpoints for managing SSH host keys. The known hosts
+// are shared by all accounts, only God can manage them.
+func initHostKeys(router gin.IRouter) {
+	knownHosts := ssh.DefaultKnownHosts
+	h := router.Group("/hostkey")
+
+	h.GET("/", func(c *gin.Context) {
+		if !requireGod(c) {
+			return
+		}
+
+		keys, err := knownHosts.List()
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, keys)
+	})
+
+	change := func(action string, f func(string, string) error) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			if !requireGod(c) {
+				return
+			}
+
+			var request hostKeyRequest
+			if !bindJSON(c, &request) {
+				return
+			}
+
+			err := f(request.Address, request.Fingerprint)
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+
+			logger.Yellow("api", "[%s %s] %s host key %s for %s", c.Request.Method, c.Request.URL.Path, action, request.Fingerprint, request.Address)
+
+			c.JSON(http.StatusOK, nil)
+		}
+	}
+
+	h.POST("/approve", change("Approved", knownHosts.Approve))
+	h.POST("/revoke", change("Revoked", knownHosts.Revoke))
+}
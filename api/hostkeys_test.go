@@ -0,0 +1,41 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/abrander/agento/userdb"
+)
+
+func TestHostKeysSingleUser(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	db := userdb.NewSingleUser("secret")
+
+	router := gin.New()
+	router.Use(authenticate(db))
+	initHostKeys(router)
+
+	_, token, err := db.Login(userdb.SingleUserName, "secret")
+	if err != nil {
+		t.Fatalf("Login() failed: %s", err.Error())
+	}
+
+	byKey, _ := http.NewRequest("GET", "/hostkey/", nil)
+	byKey.Header.Set("X-Agento-Secret", "secret")
+
+	byCookie, _ := http.NewRequest("GET", "/hostkey/", nil)
+	byCookie.AddCookie(&http.Cookie{Name: sessionCookie, Value: token})
+
+	for _, req := range []*http.Request{byKey, byCookie} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("GET /hostkey/ returned %d for the single user", w.Code)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/abrander/agento/core"
+)
+
+type (
+	// listOptions describes paging and sorting of list endpoints.
+	listOptions struct {
+		offset int
+		limit  int
+		sort   string
+		desc   bool
+	}
+)
+
+var (
+	hostSorters = map[string]func(a, b *core.Host) bool{
+		"id":        func(a, b *core.Host) bool { return a.ID < b.ID },
+		"name":      func(a, b *core.Host) bool { return a.Name < b.Name },
+		"transport": func(a, b *core.Host) bool { return a.TransportID < b.TransportID },
+	}
+
+	probeSorters = map[string]func(a, b *core.Probe) bool{
+		"id":        func(a, b *core.Probe) bool { return a.ID < b.ID },
+		"host":      func(a, b *core.Probe) bool { return a.HostID < b.HostID },
+		"agent":     func(a, b *core.Probe) bool { return a.AgentID < b.AgentID },
+		"interval":  func(a, b *core.Probe) bool { return a.Interval < b.Interval },
+		"lastCheck": func(a, b *core.Probe) bool { return a.LastCheck.Before(b.LastCheck) },
+		"nextCheck": func(a, b *core.Probe) bool { return a.NextCheck.Before(b.NextCheck) },
+	}
+)
+
+// parseListOptions reads the query parameters offset, limit and sort. Sort
+// can be prefixed with "-" for descending order and must be accepted by
+// canSort. The request is aborted with a 400 if a parameter is invalid.
+func parseListOptions(c *gin.Context, canSort func(field string) bool) (*listOptions, bool) {
+	var err error
+	opts := &listOptions{sort: "id"}
+
+	if offset := c.Query("offset"); offset != "" {
+		opts.offset, err = strconv.Atoi(offset)
+		if err != nil || opts.offset < 0 {
+			abortWithStatus(c, http.StatusBadRequest, errors.New("offset must be a non-negative integer"))
+			return nil, false
+		}
+	}
+
+	if limit := c.Query("limit"); limit != "" {
+		opts.limit, err = strconv.Atoi(limit)
+		if err != nil || opts.limit < 0 {
+			abortWithStatus(c, http.StatusBadRequest, errors.New("limit must be a non-negative integer"))
+			return nil, false
+		}
+	}
+
+	if s := c.Query("sort"); s != "" {
+		opts.desc = strings.HasPrefix(s, "-")
+		opts.sort = strings.TrimPrefix(s, "-")
+	}
+
+	if !canSort(opts.sort) {
+		abortWithStatus(c, http.StatusBadRequest, errors.New("cannot sort by '"+opts.sort+"'"))
+		return nil, false
+	}
+
+	return opts, true
+}
+
+// page returns the bounds of the requested page of a list of length total. The
+// total is returned to the client in the X-Total-Count header.
+func (o *listOptions) page(c *gin.Context, total int) (int, int) {
+	c.Header("X-Total-Count", strconv.Itoa(total))
+
+	start := o.offset
+	if start > total {
+		start = total
+	}
+
+	end := total
+	if o.limit > 0 && start+o.limit < total {
+		end = start + o.limit
+	}
+
+	return start, end
+}
+
+// listHosts filters, sorts and pages hosts according to the query
+// parameters name and transport.
+func listHosts(c *gin.Context, hosts []core.Host) {
+	opts, ok := parseListOptions(c, func(field string) bool {
+		_, found := hostSorters[field]
+		return found
+	})
+	if !ok {
+		return
+	}
+
+	name := c.Query("name")
+	transport := c.Query("transport")
+
+	filtered := []core.Host{}
+	for _, host := range hosts {
+		if name != "" && host.Name != name {
+			continue
+		}
+
+		if transport != "" && host.TransportID != transport {
+			continue
+		}
+
+		filtered = append(filtered, host)
+	}
+
+	less := hostSorters[opts.sort]
+	sort.SliceStable(filtered, func(i, j int) bool {
+		if opts.desc {
+			return less(&filtered[j], &filtered[i])
+		}
+
+		return less(&filtered[i], &filtered[j])
+	})
+
+	start, end := opts.page(c, len(filtered))
+
+	c.JSON(http.StatusOK, filtered[start:end])
+}
+
+// listProbes filters, sorts and pages probes according to the query
+// parameters agent, host, tag (key:value, can be repeated) and health
+// (healthy or unhealthy).
+func listProbes(c *gin.Context, probes []core.Probe) {
+	opts, ok := parseListOptions(c, func(field string) bool {
+		_, found := probeSorters[field]
+		return found
+	})
+	if !ok {
+		return
+	}
+
+	agent := c.Query("agent")
+	host := c.Query("host")
+	health := c.Query("health")
+
+	if health != "" && health != "healthy" && health != "unhealthy" {
+		abortWithStatus(c, http.StatusBadRequest, errors.New("health must be 'healthy' or 'unhealthy'"))
+		return
+	}
+
+	tags := make(map[string]string)
+	for _, tag := range c.QueryArray("tag") {
+		kv := strings.SplitN(tag, ":", 2)
+		if len(kv) != 2 {
+			abortWithStatus(c, http.StatusBadRequest, errors.New("tag must be in the form key:value"))
+			return
+		}
+
+		tags[kv[0]] = kv[1]
+	}
+
+	now := time.Now()
+	filtered := []core.Probe{}
+	for _, probe := range probes {
+		if agent != "" && probe.AgentID != agent {
+			continue
+		}
+
+		if host != "" && probe.HostID != host {
+			continue
+		}
+
+		if health != "" && probe.Healthy(now) != (health == "healthy") {
+			continue
+		}
+
+		match := true
+		for key, value := range tags {
+			if probe.Tags[key] != value {
+				match = false
+				break
+			}
+		}
+
+		if match {
+			filtered = append(filtered, probe)
+		}
+	}
+
+	less := probeSorters[opts.sort]
+	sort.SliceStable(filtered, func(i, j int) bool {
+		if opts.desc {
+			return less(&filtered[j], &filtered[i])
+		}
+
+		return less(&filtered[i], &filtered[j])
+	})
+
+	start, end := opts.page(c, len(filtered))
+
+	c.JSON(http.StatusOK, filtered[start:end])
+}
@@ -0,0 +1,101 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/abrander/agento/timeseries"
+	"github.com/abrander/agento/userdb"
+)
+
+// parseQuery builds a query from the query string of the request.
+func parseQuery(c *gin.Context, now time.Time) (timeseries.Query, error) {
+	var err error
+
+	q := timeseries.Query{
+		Measurement: c.Query("measurement"),
+		Field:       c.Query("field"),
+		Aggregation: c.Query("aggregation"),
+		Tags:        make(map[string]string),
+	}
+
+	for _, tag := range c.QueryArray("tag") {
+		parts := strings.SplitN(tag, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return q, errors.New("tag must be on the form key:value")
+		}
+
+		q.Tags[parts[0]] = parts[1]
+	}
+
+	q.From, err = parseTime(c, "from", now)
+	if err != nil {
+		return q, err
+	}
+
+	q.To, err = parseTime(c, "to", now)
+	if err != nil {
+		return q, err
+	}
+
+	if step := c.Query("step"); step != "" {
+		q.Step, err = time.ParseDuration(step)
+		if err != nil {
+			return q, err
+		}
+	}
+
+	return q, nil
+}
+
+// scopeQuery limits q to series belonging to accountID. Points from God are
+// not tagged with an id, queries for God are left unscoped.
+func scopeQuery(q *timeseries.Query, accountID string) {
+	delete(q.Tags, "id")
+
+	if accountID != userdb.God.GetAccountId() {
+		q.Tags["id"] = accountID
+	}
+}
+
+// initQuery adds the endpoint for querying historical metrics.
+func initQuery(router gin.IRouter, tsdb timeseries.Database) {
+	router.GET("/query", func(c *gin.Context) {
+		accountID := getAccountId(c)
+		if accountID == "" {
+			return
+		}
+
+		if !authorize(c, userdb.ObjectProxy(accountID), userdb.PermissionRead) {
+			return
+		}
+
+		now := time.Now()
+
+		q, err := parseQuery(c, now)
+		if err != nil {
+			abortWithStatus(c, http.StatusBadRequest, err)
+			return
+		}
+
+		scopeQuery(&q, accountID)
+
+		err = q.Normalize(now)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		series, err := tsdb.Query(q)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, series)
+	})
+}
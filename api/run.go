@@ -0,0 +1,161 @@
+package api
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/abrander/agento/core"
+	"github.com/abrander/agento/plugins"
+	"github.com/abrander/agento/timeseries"
+	"github.com/abrander/agento/userdb"
+)
+
+type (
+	// runResult is returned when running or testing a probe. Failing to
+	// gather is not considered an API error, the error is returned in Error.
+	runResult struct {
+		Points   []*timeseries.Point `json:"points"`
+		Error    string              `json:"error,omitempty"`
+		Duration time.Duration       `json:"duration"`
+	}
+
+	// testRequest is the body accepted by /probe/test. Nothing is saved.
+	testRequest struct {
+		Host       core.Host              `json:"host"`
+		Agent      string                 `json:"agent"`
+		Config     map[string]interface{} `json:"config"`
+		Tags       map[string]string      `json:"tags"`
+		Processors timeseries.Pipeline    `json:"processors"`
+	}
+)
+
+var (
+	// RunTimeout is the maximum time a probe can run when requested through
+	// the API.
+	RunTimeout = 30 * time.Second
+
+	errRunTimeout = errors.New("probe timed out")
+)
+
+// runProbe gathers from probe once and returns the result. If the probe
+// doesn't finish within RunTimeout, it will be abandoned.
+func runProbe(probe *core.Probe, host *core.Host, transport plugins.Transport) runResult {
+	type gathered struct {
+		points []*timeseries.Point
+		err    error
+	}
+
+	done := make(chan gathered, 1)
+	start := time.Now()
+
+	go func() {
+		points, err := probe.Gather(host, transport)
+		done <- gathered{points, err}
+	}()
+
+	result := runResult{Points: []*timeseries.Point{}}
+
+	select {
+	case g := <-done:
+		if g.err != nil {
+			result.Error = g.err.Error()
+		}
+
+		// Points are returned with the error if the agent gathered
+		// some of them.
+		if g.points != nil {
+			result.Points = g.points
+		}
+	case <-time.After(RunTimeout):
+		result.Error = errRunTimeout.Error()
+	}
+
+	result.Duration = time.Since(start)
+
+	return result
+}
+
+// initRun adds endpoints for running probes on demand.
+func initRun(router gin.IRouter, store core.Store) {
+	router.POST("/probe/:id/run", func(c *gin.Context) {
+		subject := getSubject(c)
+
+		probe, err := store.GetProbe(subject, c.Param("id"))
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		if !authorize(c, probe, userdb.PermissionWriteProbes) {
+			return
+		}
+
+		host, err := store.GetHost(subject, probe.HostID)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		transport, err := host.Transport()
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		c.JSON(200, runProbe(probe, host, transport))
+	})
+
+	router.POST("/probe/test", func(c *gin.Context) {
+		var req testRequest
+
+		if !bindJSON(c, &req) {
+			return
+		}
+
+		accountID := getAccountId(c)
+		if c.IsAborted() {
+			return
+		}
+
+		// Testing can reach any host, so we require the same permissions as
+		// for adding the host and the probe.
+		account := userdb.ObjectProxy(accountID)
+		if !authorize(c, account, userdb.PermissionWriteHosts) || !authorize(c, account, userdb.PermissionWriteProbes) {
+			return
+		}
+
+		host := &req.Host
+		host.ID = ""
+		host.AccountID = accountID
+
+		probe := &core.Probe{
+			AccountID:   accountID,
+			HostID:      "unsaved",
+			AgentID:     req.Agent,
+			AgentConfig: req.Config,
+			Interval:    core.DefaultInterval,
+			Tags:        req.Tags,
+			Processors:  req.Processors,
+		}
+
+		err := host.Validate()
+		if err == nil {
+			err = probe.Validate()
+		}
+
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		transport, err := host.NewTransport()
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		c.JSON(200, runProbe(probe, host, transport))
+	})
+}
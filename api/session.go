@@ -0,0 +1,185 @@
+package api
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/abrander/agento/logger"
+	"github.com/abrander/agento/userdb"
+)
+
+type (
+	// loginRequest is the body accepted by /login.
+	loginRequest struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+
+	// sessionResponse describes the current session. The CSRF token must be
+	// sent in the X-Agento-CSRF header with all mutating requests.
+	sessionResponse struct {
+		User    string    `json:"user"`
+		CSRF    string    `json:"csrf"`
+		Expires time.Time `json:"expires"`
+	}
+)
+
+const (
+	// sessionCookie is the name of the cookie holding the session token.
+	sessionCookie = "agento_session"
+
+	// csrfHeader is the header used for sending the CSRF token.
+	csrfHeader = "X-Agento-CSRF"
+)
+
+// isSecure returns true if the request was made using HTTPS, either directly
+// or through a proxy.
+func isSecure(c *gin.Context) bool {
+	return c.Request.TLS != nil || c.Request.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// setSessionCookie sets (or clears if token is empty) the session cookie.
+func setSessionCookie(c *gin.Context, token string, expires time.Time) {
+	cookie := &http.Cookie{
+		Name:     sessionCookie,
+		Value:    token,
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		Secure:   isSecure(c),
+		SameSite: http.SameSiteStrictMode,
+	}
+
+	if token == "" {
+		cookie.MaxAge = -1
+	}
+
+	http.SetCookie(c.Writer, cookie)
+}
+
+// isSafeMethod returns true for methods that should never change state.
+func isSafeMethod(method string) bool {
+	switch method {
+	case "GET", "HEAD", "OPTIONS":
+		return true
+	}
+
+	return false
+}
+
+// resolveSession resolves the session cookie to a session and a subject.
+func resolveSession(c *gin.Context, db userdb.Database) (*userdb.Session, userdb.Subject, error) {
+	token, err := c.Cookie(sessionCookie)
+	if err != nil {
+		return nil, nil, userdb.ErrorSessionNotFound
+	}
+
+	session, err := db.GetSession(token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user, err := db.ResolveCookie(token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return session, user, nil
+}
+
+// authenticate returns a middleware resolving the subject from either the
+// X-Agento-Secret header or the session cookie. Requests authenticated by
+// cookie must carry the CSRF token unless the method is safe.
+func authenticate(db userdb.Database) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.Request.Header.Get("X-Agento-Secret")
+		if key != "" {
+			subject, err := db.ResolveKey(key)
+			if err != nil {
+				logger.Yellow("api", "[%s %s] Could not resolve API key from %s, aborting", c.Request.Method, c.Request.URL.Path, c.Request.RemoteAddr)
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+
+			logger.Green("api", "[%s %s] API key authorized for %s", c.Request.Method, c.Request.URL.Path, subject.GetId())
+
+			c.Set("subject", subject)
+			return
+		}
+
+		session, subject, err := resolveSession(c, db)
+		if err != nil {
+			logger.Yellow("api", "[%s %s] No API key or session found, aborting request from %s", c.Request.Method, c.Request.URL.Path, c.Request.RemoteAddr)
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+
+		if !isSafeMethod(c.Request.Method) {
+			token := c.Request.Header.Get(csrfHeader)
+			if subtle.ConstantTimeCompare([]byte(token), []byte(session.CSRFToken)) != 1 {
+				logger.Yellow("api", "[%s %s] Missing or wrong CSRF token from %s, aborting", c.Request.Method, c.Request.URL.Path, c.Request.RemoteAddr)
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+		}
+
+		logger.Green("api", "[%s %s] Session authorized for %s", c.Request.Method, c.Request.URL.Path, subject.GetId())
+
+		c.Set("subject", subject)
+		c.Set("session", session)
+	}
+}
+
+// initLogin adds the endpoints for logging in. These must be added before the
+// authentication middleware.
+func initLogin(router gin.IRouter, db userdb.Database) {
+	router.POST("/login", func(c *gin.Context) {
+		var req loginRequest
+
+		if !bindJSON(c, &req) {
+			return
+		}
+
+		session, token, err := db.Login(req.Username, req.Password)
+		if err != nil {
+			logger.Yellow("api", "[%s %s] Failed login for '%s' from %s", c.Request.Method, c.Request.URL.Path, req.Username, c.Request.RemoteAddr)
+			c.AbortWithStatus(http.StatusForbidden)
+			return
+		}
+
+		logger.Green("api", "[%s %s] %s logged in from %s", c.Request.Method, c.Request.URL.Path, session.UserID, c.Request.RemoteAddr)
+
+		setSessionCookie(c, token, session.Expires)
+
+		c.JSON(http.StatusOK, sessionResponse{User: session.UserID, CSRF: session.CSRFToken, Expires: session.Expires})
+	})
+}
+
+// initSession adds endpoints for inspecting and ending the current session.
+func initSession(router gin.IRouter, db userdb.Database) {
+	router.GET("/session", func(c *gin.Context) {
+		s, found := c.Get("session")
+		if !found {
+			c.AbortWithStatus(http.StatusNotFound)
+			return
+		}
+
+		session := s.(*userdb.Session)
+
+		c.JSON(http.StatusOK, sessionResponse{User: session.UserID, CSRF: session.CSRFToken, Expires: session.Expires})
+	})
+
+	router.POST("/logout", func(c *gin.Context) {
+		token, err := c.Cookie(sessionCookie)
+		if err == nil {
+			db.Logout(token)
+		}
+
+		setSessionCookie(c, "", time.Time{})
+
+		c.JSON(http.StatusOK, nil)
+	})
+}
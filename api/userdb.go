@@ -0,0 +1,502 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/abrander/agento/userdb"
+)
+
+type (
+	// accountRequest is the body accepted when creating accounts.
+	accountRequest struct {
+		Name string `json:"name"`
+	}
+
+	// userRequest is the body accepted when creating or updating users.
+	userRequest struct {
+		Name     string                 `json:"name"`
+		Accounts []string               `json:"accounts"`
+		Roles    map[string]userdb.Role `json:"roles"`
+		Password string                 `json:"password"`
+	}
+
+	// passwordRequest is the body accepted when changing passwords.
+	passwordRequest struct {
+		Password string `json:"password"`
+	}
+
+	// keyRequest is the body accepted when creating keys.
+	keyRequest struct {
+		Name  string       `json:"name"`
+		Scope userdb.Scope `json:"scope"`
+	}
+
+	// keyResponse is returned when a key is created or rotated. This is the
+	// only time the key itself is revealed.
+	keyResponse struct {
+		*userdb.KeyRecord
+		Key string `json:"key"`
+	}
+)
+
+// requireGod will abort the request unless the subject is God.
+func requireGod(c *gin.Context) bool {
+	if !userdb.IsGod(getSubject(c)) {
+		abortWithError(c, userdb.ErrorNoAccess)
+		return false
+	}
+
+	return true
+}
+
+// canAccessUser checks if subject has permission on user. Having the
+// permission on one of the accounts of the user is enough. A user always has
+// permission on itself, unless authenticated by a scoped key.
+func canAccessUser(subject userdb.Subject, user *userdb.UserRecord, permission userdb.Permission) error {
+	if userdb.IsGod(subject) {
+		return nil
+	}
+
+	if self, ok := subject.(*userdb.UserRecord); ok && self.ID == user.ID {
+		return nil
+	}
+
+	for _, id := range user.AccountIDs {
+		if subject.Authorize(userdb.ObjectProxy(id), permission) == nil {
+			return nil
+		}
+	}
+
+	return userdb.ErrorNoAccess
+}
+
+// canManageUser checks if subject is allowed to change the password and keys
+// of user. This requires PermissionAdmin on every account of the user, so an
+// admin of one account cannot take over a user shared with other accounts.
+func canManageUser(subject userdb.Subject, user *userdb.UserRecord) error {
+	if userdb.IsGod(subject) {
+		return nil
+	}
+
+	if self, ok := subject.(*userdb.UserRecord); ok && self.ID == user.ID {
+		return nil
+	}
+
+	if len(user.AccountIDs) == 0 {
+		return userdb.ErrorNoAccess
+	}
+
+	for _, id := range user.AccountIDs {
+		err := subject.Authorize(userdb.ObjectProxy(id), userdb.PermissionAdmin)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applyRoles sets roles on user. Accounts without a role in roles will use
+// userdb.DefaultRole.
+func applyRoles(user *userdb.UserRecord, roles map[string]userdb.Role) error {
+	user.Roles = nil
+
+	for id, role := range roles {
+		err := user.SetRole(id, role)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sanitizeUser returns a copy of user without the password hash.
+func sanitizeUser(user *userdb.UserRecord) *userdb.UserRecord {
+	u := *user
+	u.Password = ""
+
+	return &u
+}
+
+// sanitizeKey returns a copy of key without the hash.
+func sanitizeKey(key *userdb.KeyRecord) *userdb.KeyRecord {
+	k := *key
+	k.Hash = ""
+
+	return &k
+}
+
+// keyRoutes adds routes for managing API keys to router. canAccess must
+// return nil if the subject is allowed to manage keys for the owner with the
+// id given.
+func keyRoutes(router gin.IRouter, db *userdb.MultiUser, canAccess func(c *gin.Context, ownerID string) error) {
+	router.GET("/:id/key", func(c *gin.Context) {
+		ownerID := c.Param("id")
+
+		err := canAccess(c, ownerID)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		keys, err := db.GetKeys(ownerID)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		sanitized := make([]*userdb.KeyRecord, len(keys))
+		for i, key := range keys {
+			sanitized[i] = sanitizeKey(key)
+		}
+
+		c.JSON(http.StatusOK, sanitized)
+	})
+
+	router.POST("/:id/key/new", func(c *gin.Context) {
+		var req keyRequest
+		ownerID := c.Param("id")
+
+		err := canAccess(c, ownerID)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		if !bindJSON(c, &req) {
+			return
+		}
+
+		key, secret, err := db.CreateKey(ownerID, req.Name, req.Scope)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, keyResponse{KeyRecord: sanitizeKey(key), Key: secret})
+	})
+
+	router.POST("/:id/key/:keyid/rotate", func(c *gin.Context) {
+		ownerID := c.Param("id")
+
+		err := canAccess(c, ownerID)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		key, err := db.GetKey(c.Param("keyid"))
+		if err != nil || key.OwnerID != ownerID {
+			abortWithError(c, userdb.ErrorKeyNotFound)
+			return
+		}
+
+		key, secret, err := db.RotateKey(key.ID)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, keyResponse{KeyRecord: sanitizeKey(key), Key: secret})
+	})
+
+	router.DELETE("/:id/key/:keyid", func(c *gin.Context) {
+		ownerID := c.Param("id")
+
+		err := canAccess(c, ownerID)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		key, err := db.GetKey(c.Param("keyid"))
+		if err != nil || key.OwnerID != ownerID {
+			abortWithError(c, userdb.ErrorKeyNotFound)
+			return
+		}
+
+		err = db.RevokeKey(key.ID)
+		if err != nil {
+			abortWithError(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, nil)
+	})
+}
+
+// initUserdb adds endpoints for managing accounts, users and API keys.
+func initUserdb(router gin.IRouter, db *userdb.MultiUser) {
+	{
+		a := router.Group("/account")
+
+		canManageAccount := func(c *gin.Context, id string) error {
+			account, err := db.GetAccount(id)
+			if err != nil {
+				return err
+			}
+
+			return getSubject(c).Authorize(account, userdb.PermissionAdmin)
+		}
+
+		a.GET("/", func(c *gin.Context) {
+			subject := getSubject(c)
+
+			all, err := db.GetAllAccounts()
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+
+			accounts := []*userdb.AccountRecord{}
+			for _, account := range all {
+				if subject.Authorize(account, userdb.PermissionRead) == nil {
+					accounts = append(accounts, account)
+				}
+			}
+
+			c.JSON(http.StatusOK, accounts)
+		})
+
+		a.POST("/new", func(c *gin.Context) {
+			var req accountRequest
+
+			if !requireGod(c) {
+				return
+			}
+
+			if !bindJSON(c, &req) {
+				return
+			}
+
+			account, err := db.CreateAccount(req.Name)
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+
+			c.JSON(http.StatusOK, account)
+		})
+
+		a.GET("/:id", func(c *gin.Context) {
+			account, err := db.GetAccount(c.Param("id"))
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+
+			err = getSubject(c).Authorize(account, userdb.PermissionRead)
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+
+			c.JSON(http.StatusOK, account)
+		})
+
+		a.DELETE("/:id", func(c *gin.Context) {
+			if !requireGod(c) {
+				return
+			}
+
+			err := db.DeleteAccount(c.Param("id"))
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+
+			c.JSON(http.StatusOK, nil)
+		})
+
+		keyRoutes(a, db, canManageAccount)
+	}
+
+	{
+		u := router.Group("/user")
+
+		canManage := func(c *gin.Context, id string) error {
+			user, err := db.GetUser(id)
+			if err != nil {
+				return err
+			}
+
+			return canManageUser(getSubject(c), user)
+		}
+
+		u.GET("/", func(c *gin.Context) {
+			subject := getSubject(c)
+
+			all, err := db.GetAllUsers()
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+
+			users := []*userdb.UserRecord{}
+			for _, user := range all {
+				if canAccessUser(subject, user, userdb.PermissionRead) == nil {
+					users = append(users, sanitizeUser(user))
+				}
+			}
+
+			c.JSON(http.StatusOK, users)
+		})
+
+		u.POST("/new", func(c *gin.Context) {
+			var req userRequest
+
+			if !requireGod(c) {
+				return
+			}
+
+			if !bindJSON(c, &req) {
+				return
+			}
+
+			user, err := db.CreateUser(req.Name, req.Accounts)
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+
+			err = applyRoles(user, req.Roles)
+			if err == nil && req.Password != "" {
+				err = user.SetPassword(req.Password)
+			}
+
+			if err == nil {
+				err = user.Save()
+			}
+
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+
+			c.JSON(http.StatusOK, sanitizeUser(user))
+		})
+
+		u.GET("/:id", func(c *gin.Context) {
+			user, err := db.GetUser(c.Param("id"))
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+
+			err = canAccessUser(getSubject(c), user, userdb.PermissionRead)
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+
+			c.JSON(http.StatusOK, sanitizeUser(user))
+		})
+
+		u.PUT("/:id", func(c *gin.Context) {
+			var req userRequest
+
+			if !requireGod(c) {
+				return
+			}
+
+			if !bindJSON(c, &req) {
+				return
+			}
+
+			user, err := db.GetUser(c.Param("id"))
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+
+			existing, err := db.GetUserByName(req.Name)
+			if err == nil && existing.ID != user.ID {
+				abortWithError(c, userdb.ErrorUserExists)
+				return
+			}
+
+			for _, id := range req.Accounts {
+				_, err = db.GetAccount(id)
+				if err != nil {
+					abortWithError(c, err)
+					return
+				}
+			}
+
+			user.Name = req.Name
+			user.AccountIDs = req.Accounts
+
+			err = applyRoles(user, req.Roles)
+			if err == nil && req.Password != "" {
+				err = user.SetPassword(req.Password)
+			}
+
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+
+			err = user.Save()
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+
+			c.JSON(http.StatusOK, sanitizeUser(user))
+		})
+
+		u.DELETE("/:id", func(c *gin.Context) {
+			if !requireGod(c) {
+				return
+			}
+
+			err := db.DeleteUser(c.Param("id"))
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+
+			c.JSON(http.StatusOK, nil)
+		})
+
+		u.POST("/:id/password", func(c *gin.Context) {
+			var req passwordRequest
+			id := c.Param("id")
+
+			err := canManage(c, id)
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+
+			if !bindJSON(c, &req) {
+				return
+			}
+
+			user, err := db.GetUser(id)
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+
+			err = user.SetPassword(req.Password)
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+
+			err = user.Save()
+			if err != nil {
+				abortWithError(c, err)
+				return
+			}
+
+			c.JSON(http.StatusOK, nil)
+		})
+
+		keyRoutes(u, db, canManage)
+	}
+}
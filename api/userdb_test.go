@@ -0,0 +1,51 @@
+package api
+
+import (
+	"testing"
+
+	"github.com/abrander/agento/userdb"
+)
+
+func TestCanManageUser(t *testing.T) {
+	store, err := userdb.NewFileStore("")
+	if err != nil {
+		t.Fatalf("NewFileStore() failed: %s", err.Error())
+	}
+	db := userdb.NewMultiUser(store, "admin")
+
+	a, _ := db.CreateAccount("a")
+	b, _ := db.CreateAccount("b")
+
+	admin, _ := db.CreateUser("admin-a", []string{a.ID})
+	shared, _ := db.CreateUser("shared", []string{a.ID, b.ID})
+	local, _ := db.CreateUser("local", []string{a.ID})
+
+	if canManageUser(admin, local) != nil {
+		t.Errorf("Admin of a cannot manage user only in a")
+	}
+
+	if canManageUser(admin, shared) == nil {
+		t.Errorf("Admin of a can manage user shared with b")
+	}
+
+	if canAccessUser(admin, shared, userdb.PermissionRead) != nil {
+		t.Errorf("Admin of a cannot read user shared with b")
+	}
+
+	if canManageUser(shared, shared) != nil {
+		t.Errorf("User cannot manage itself")
+	}
+
+	if canManageUser(userdb.God, shared) != nil {
+		t.Errorf("God cannot manage user")
+	}
+
+	err = local.SetRole(a.ID, userdb.RoleViewer)
+	if err != nil {
+		t.Fatalf("SetRole() failed: %s", err.Error())
+	}
+
+	if canManageUser(local, admin) == nil {
+		t.Errorf("Viewer can manage user")
+	}
+}
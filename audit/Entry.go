@@ -0,0 +1,136 @@
+package audit
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+	"time"
+)
+
+type (
+	// Entry is a single audit log entry describing a mutation.
+	Entry struct {
+		ID         string    `json:"id" bson:"_id"`
+		Time       time.Time `json:"time" bson:"time"`
+		SubjectID  string    `json:"subject" bson:"subject"`
+		AccountID  string    `json:"account" bson:"account"`
+		Action     string    `json:"action" bson:"action"`
+		ObjectID   string    `json:"object" bson:"object"`
+		RemoteAddr string    `json:"remoteAddr,omitempty" bson:"remoteAddr,omitempty"`
+		Changes    []Change  `json:"changes,omitempty" bson:"changes,omitempty"`
+		Error      string    `json:"error,omitempty" bson:"error,omitempty"`
+	}
+
+	// Change is a field changed by a mutation.
+	Change struct {
+		Field  string      `json:"field" bson:"field"`
+		Before interface{} `json:"before,omitempty" bson:"before,omitempty"`
+		After  interface{} `json:"after,omitempty" bson:"after,omitempty"`
+	}
+
+	// Filter selects audit entries. Empty fields match everything.
+	Filter struct {
+		AccountID string
+		SubjectID string
+		Action    string
+		ObjectID  string
+		From      time.Time
+		To        time.Time
+
+		// Limit is the maximum number of entries returned. Zero means no
+		// limit.
+		Limit int
+	}
+)
+
+const (
+	// ActionAddHost is logged when a host is added.
+	ActionAddHost = "host.add"
+
+	// ActionUpdateHost is logged when a host is changed.
+	ActionUpdateHost = "host.update"
+
+	// ActionDeleteHost is logged when a host is deleted.
+	ActionDeleteHost = "host.delete"
+
+	// ActionAddProbe is logged when a probe is added.
+	ActionAddProbe = "probe.add"
+
+	// ActionUpdateProbe is logged when a probe is changed.
+	ActionUpdateProbe = "probe.update"
+
+	// ActionDeleteProbe is logged when a probe is deleted.
+	ActionDeleteProbe = "probe.delete"
+)
+
+// Match returns true if the entry is selected by the filter.
+func (f *Filter) Match(e *Entry) bool {
+	switch {
+	case f.AccountID != "" && f.AccountID != e.AccountID:
+		return false
+	case f.SubjectID != "" && f.SubjectID != e.SubjectID:
+		return false
+	case f.Action != "" && f.Action != e.Action:
+		return false
+	case f.ObjectID != "" && f.ObjectID != e.ObjectID:
+		return false
+	case !f.From.IsZero() && e.Time.Before(f.From):
+		return false
+	case !f.To.IsZero() && e.Time.After(f.To):
+		return false
+	}
+
+	return true
+}
+
+// toMap will convert an object to a map using its JSON representation. This
+// makes sure secrets are redacted the same way as in the API.
+func toMap(object interface{}) map[string]interface{} {
+	m := make(map[string]interface{})
+
+	if object == nil {
+		return m
+	}
+
+	if v := reflect.ValueOf(object); v.Kind() == reflect.Ptr && v.IsNil() {
+		return m
+	}
+
+	b, err := json.Marshal(object)
+	if err != nil {
+		return m
+	}
+
+	json.Unmarshal(b, &m)
+
+	return m
+}
+
+// Diff returns the top level fields that differ between before and after.
+// Both can be nil for additions and deletions.
+func Diff(before interface{}, after interface{}) []Change {
+	a := toMap(before)
+	b := toMap(after)
+
+	fields := make(map[string]bool)
+	for field := range a {
+		fields[field] = true
+	}
+
+	for field := range b {
+		fields[field] = true
+	}
+
+	var changes []Change
+	for field := range fields {
+		if !reflect.DeepEqual(a[field], b[field]) {
+			changes = append(changes, Change{Field: field, Before: a[field], After: b[field]})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Field < changes[j].Field
+	})
+
+	return changes
+}
@@ -0,0 +1,98 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+type (
+	// FileSink is a Sink writing entries as JSON lines to a file.
+	FileSink struct {
+		lock sync.Mutex
+		path string
+	}
+)
+
+// NewFileSink instantiates a new FileSink appending to the file at path.
+func NewFileSink(path string) (*FileSink, error) {
+	// Make sure we can write to the file before accepting the path.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+
+	return &FileSink{path: path}, nil
+}
+
+// Write implements Sink.
+func (s *FileSink) Write(entry *Entry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	f, err := os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = f.Write(append(b, '\n'))
+
+	return err
+}
+
+// Query implements Sink. The whole file will be read.
+func (s *FileSink) Query(filter Filter) ([]Entry, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	entries := []Entry{}
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var entry Entry
+
+		err = json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			// Skip lines we can't parse, a crash could leave a half
+			// written line behind.
+			continue
+		}
+
+		if filter.Match(&entry) {
+			entries = append(entries, entry)
+		}
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// Newest first.
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+
+	if filter.Limit > 0 && len(entries) > filter.Limit {
+		entries = entries[:filter.Limit]
+	}
+
+	return entries, nil
+}
+
+// Ensure compliance
+var _ Sink = (*FileSink)(nil)
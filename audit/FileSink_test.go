@@ -0,0 +1,41 @@
+package audit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agento-audit")
+	if err != nil {
+		t.Fatalf("TempDir(): %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	sink, err := NewFileSink(filepath.Join(dir, "audit.log"))
+	if err != nil {
+		t.Fatalf("NewFileSink() failed: %s", err.Error())
+	}
+
+	now := time.Now()
+	sink.Write(&Entry{ID: "1", Time: now.Add(-time.Hour), AccountID: "a", Action: ActionAddHost})
+	sink.Write(&Entry{ID: "2", Time: now, AccountID: "b", Action: ActionAddHost})
+	sink.Write(&Entry{ID: "3", Time: now, AccountID: "a", Action: ActionDeleteHost})
+
+	entries, err := sink.Query(Filter{AccountID: "a"})
+	if err != nil {
+		t.Fatalf("Query() failed: %s", err.Error())
+	}
+
+	if len(entries) != 2 || entries[0].ID != "3" || entries[1].ID != "1" {
+		t.Errorf("Query() returned wrong entries: %+v", entries)
+	}
+
+	entries, _ = sink.Query(Filter{From: now.Add(-time.Minute), Limit: 1})
+	if len(entries) != 1 || entries[0].ID != "3" {
+		t.Errorf("Query() returned wrong entries: %+v", entries)
+	}
+}
@@ -0,0 +1,83 @@
+package audit
+
+import (
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type (
+	// MongoSink is a Sink storing entries in a MongoDB collection.
+	MongoSink struct {
+		sess       *mgo.Session
+		collection *mgo.Collection
+	}
+)
+
+// NewMongoSink will connect to MongoDB at url and store entries in the
+// collection "audit" in database.
+func NewMongoSink(url string, database string) (*MongoSink, error) {
+	sess, err := mgo.Dial(url)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &MongoSink{
+		sess:       sess,
+		collection: sess.DB(database).C("audit"),
+	}
+
+	err = s.collection.EnsureIndex(mgo.Index{Key: []string{"account", "-time"}})
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Write implements Sink.
+func (s *MongoSink) Write(entry *Entry) error {
+	return s.collection.Insert(entry)
+}
+
+// Query implements Sink.
+func (s *MongoSink) Query(filter Filter) ([]Entry, error) {
+	query := bson.M{}
+
+	if filter.AccountID != "" {
+		query["account"] = filter.AccountID
+	}
+
+	if filter.SubjectID != "" {
+		query["subject"] = filter.SubjectID
+	}
+
+	if filter.Action != "" {
+		query["action"] = filter.Action
+	}
+
+	if filter.ObjectID != "" {
+		query["object"] = filter.ObjectID
+	}
+
+	if !filter.From.IsZero() || !filter.To.IsZero() {
+		t := bson.M{}
+
+		if !filter.From.IsZero() {
+			t["$gte"] = filter.From
+		}
+
+		if !filter.To.IsZero() {
+			t["$lte"] = filter.To
+		}
+
+		query["time"] = t
+	}
+
+	entries := []Entry{}
+	err := s.collection.Find(query).Sort("-time").Limit(filter.Limit).All(&entries)
+
+	return entries, err
+}
+
+// Ensure compliance
+var _ Sink = (*MongoSink)(nil)
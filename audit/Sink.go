@@ -0,0 +1,12 @@
+package audit
+
+type (
+	// Sink is a destination for audit entries.
+	Sink interface {
+		// Write will persist entry.
+		Write(entry *Entry) error
+
+		// Query returns entries matching filter, newest first.
+		Query(filter Filter) ([]Entry, error)
+	}
+)
@@ -0,0 +1,148 @@
+package audit
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/abrander/agento/core"
+	"github.com/abrander/agento/logger"
+	"github.com/abrander/agento/userdb"
+)
+
+type (
+	// Store wraps a core.Store and writes an audit entry for every mutation.
+	Store struct {
+		core.Store
+		sink       Sink
+		remoteAddr string
+	}
+)
+
+// NewStore returns a new Store writing entries about mutations of store to
+// sink.
+func NewStore(store core.Store, sink Sink) *Store {
+	return &Store{
+		Store: store,
+		sink:  sink,
+	}
+}
+
+// Sink returns the sink entries are written to.
+func (s *Store) Sink() Sink {
+	return s.sink
+}
+
+// WithRemoteAddr returns a copy of the store recording remoteAddr as the
+// origin of all mutations.
+func (s *Store) WithRemoteAddr(remoteAddr string) *Store {
+	c := *s
+	c.remoteAddr = remoteAddr
+
+	return &c
+}
+
+// WithOrigin returns store recording remoteAddr as the origin of all
+// mutations if store is an auditing Store. Other stores are returned as is.
+func WithOrigin(store core.Store, remoteAddr string) core.Store {
+	if s, ok := store.(*Store); ok {
+		return s.WithRemoteAddr(remoteAddr)
+	}
+
+	return store
+}
+
+func (s *Store) log(subject userdb.Subject, action string, object userdb.Object, objectID string, before interface{}, after interface{}, err error) {
+	entry := &Entry{
+		ID:         bson.NewObjectId().Hex(),
+		Time:       time.Now(),
+		SubjectID:  subject.GetId(),
+		Action:     action,
+		ObjectID:   objectID,
+		RemoteAddr: s.remoteAddr,
+	}
+
+	if object != nil {
+		entry.AccountID = object.GetAccountId()
+	}
+
+	if err != nil {
+		entry.Error = err.Error()
+	} else {
+		entry.Changes = Diff(before, after)
+	}
+
+	werr := s.sink.Write(entry)
+	if werr != nil {
+		logger.Red("audit", "Failed to write audit entry for %s of %s by %s: %s", action, objectID, entry.SubjectID, werr.Error())
+	}
+}
+
+// AddHost implements core.HostStore.
+func (s *Store) AddHost(subject userdb.Subject, host *core.Host) error {
+	err := s.Store.AddHost(subject, host)
+	s.log(subject, ActionAddHost, host, host.ID, nil, host, err)
+
+	return err
+}
+
+// UpdateHost implements core.HostStore.
+func (s *Store) UpdateHost(subject userdb.Subject, host *core.Host) error {
+	before, _ := s.Store.GetHost(subject, host.ID)
+
+	err := s.Store.UpdateHost(subject, host)
+	s.log(subject, ActionUpdateHost, host, host.ID, before, host, err)
+
+	return err
+}
+
+// DeleteHost implements core.HostStore.
+func (s *Store) DeleteHost(subject userdb.Subject, id string) error {
+	var object userdb.Object
+
+	before, _ := s.Store.GetHost(subject, id)
+	if before != nil {
+		object = before
+	}
+
+	err := s.Store.DeleteHost(subject, id)
+	s.log(subject, ActionDeleteHost, object, id, before, nil, err)
+
+	return err
+}
+
+// AddProbe implements core.ProbeStore.
+func (s *Store) AddProbe(subject userdb.Subject, probe *core.Probe) error {
+	err := s.Store.AddProbe(subject, probe)
+	s.log(subject, ActionAddProbe, probe, probe.ID, nil, probe, err)
+
+	return err
+}
+
+// UpdateProbe implements core.ProbeStore.
+func (s *Store) UpdateProbe(subject userdb.Subject, probe *core.Probe) error {
+	before, _ := s.Store.GetProbe(subject, probe.ID)
+
+	err := s.Store.UpdateProbe(subject, probe)
+	s.log(subject, ActionUpdateProbe, probe, probe.ID, before, probe, err)
+
+	return err
+}
+
+// DeleteProbe implements core.ProbeStore.
+func (s *Store) DeleteProbe(subject userdb.Subject, id string) error {
+	var object userdb.Object
+
+	before, _ := s.Store.GetProbe(subject, id)
+	if before != nil {
+		object = before
+	}
+
+	err := s.Store.DeleteProbe(subject, id)
+	s.log(subject, ActionDeleteProbe, object, id, before, nil, err)
+
+	return err
+}
+
+// Ensure compliance
+var _ core.Store = (*Store)(nil)
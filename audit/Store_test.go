@@ -0,0 +1,184 @@
+package audit
+
+import (
+	"testing"
+
+	"github.com/abrander/agento/core"
+	"github.com/abrander/agento/userdb"
+)
+
+type (
+	memorySink struct {
+		entries []Entry
+	}
+
+	fakeStore struct {
+		hosts  map[string]core.Host
+		probes map[string]core.Probe
+	}
+)
+
+func (s *memorySink) Write(entry *Entry) error {
+	s.entries = append(s.entries, *entry)
+
+	return nil
+}
+
+func (s *memorySink) Query(filter Filter) ([]Entry, error) {
+	var entries []Entry
+
+	for _, e := range s.entries {
+		if filter.Match(&e) {
+			entries = append(entries, e)
+		}
+	}
+
+	return entries, nil
+}
+
+func newFakeStore() *fakeStore {
+	return &fakeStore{
+		hosts:  make(map[string]core.Host),
+		probes: make(map[string]core.Probe),
+	}
+}
+
+func (s *fakeStore) GetAllHosts(subject userdb.Subject, accountID string) ([]core.Host, error) {
+	return nil, nil
+}
+
+func (s *fakeStore) AddHost(subject userdb.Subject, host *core.Host) error {
+	s.hosts[host.ID] = *host
+
+	return nil
+}
+
+func (s *fakeStore) GetHost(subject userdb.Subject, id string) (*core.Host, error) {
+	host, found := s.hosts[id]
+	if !found {
+		return nil, core.ErrHostNotFound
+	}
+
+	return &host, nil
+}
+
+func (s *fakeStore) GetHostByName(subject userdb.Subject, name string) (*core.Host, error) {
+	return nil, core.ErrHostNotFound
+}
+
+func (s *fakeStore) UpdateHost(subject userdb.Subject, host *core.Host) error {
+	s.hosts[host.ID] = *host
+
+	return nil
+}
+
+func (s *fakeStore) DeleteHost(subject userdb.Subject, id string) error {
+	if _, found := s.hosts[id]; !found {
+		return core.ErrHostNotFound
+	}
+
+	delete(s.hosts, id)
+
+	return nil
+}
+
+func (s *fakeStore) GetAllProbes(subject userdb.Subject, accountID string) ([]core.Probe, error) {
+	return nil, nil
+}
+
+func (s *fakeStore) AddProbe(subject userdb.Subject, probe *core.Probe) error {
+	s.probes[probe.ID] = *probe
+
+	return nil
+}
+
+func (s *fakeStore) GetProbe(subject userdb.Subject, id string) (*core.Probe, error) {
+	probe, found := s.probes[id]
+	if !found {
+		return nil, core.ErrProbeNotFound
+	}
+
+	return &probe, nil
+}
+
+func (s *fakeStore) UpdateProbe(subject userdb.Subject, probe *core.Probe) error {
+	s.probes[probe.ID] = *probe
+
+	return nil
+}
+
+func (s *fakeStore) DeleteProbe(subject userdb.Subject, id string) error {
+	delete(s.probes, id)
+
+	return nil
+}
+
+func TestStore(t *testing.T) {
+	sink := &memorySink{}
+	store := NewStore(newFakeStore(), sink)
+
+	host := &core.Host{ID: "h1", AccountID: "a1", Name: "web1", TransportID: "localtransport"}
+
+	err := WithOrigin(store, "192.0.2.1:1234").AddHost(userdb.God, host)
+	if err != nil {
+		t.Fatalf("AddHost() failed: %s", err.Error())
+	}
+
+	probe := &core.Probe{ID: "p1", AccountID: "a1", HostID: "h1", AgentID: "cpustats"}
+	store.AddProbe(userdb.God, probe)
+
+	probe.AgentID = "memstats"
+	store.UpdateProbe(userdb.God, probe)
+
+	store.DeleteProbe(userdb.God, "p1")
+
+	err = store.DeleteHost(userdb.God, "nonexisting")
+	if err == nil {
+		t.Fatalf("DeleteHost() did not fail for unknown host")
+	}
+
+	if len(sink.entries) != 5 {
+		t.Fatalf("Got %d entries, expected 5", len(sink.entries))
+	}
+
+	e := sink.entries[0]
+	if e.Action != ActionAddHost || e.AccountID != "a1" || e.ObjectID != "h1" || e.SubjectID != userdb.God.GetId() {
+		t.Errorf("Wrong entry for AddHost: %+v", e)
+	}
+
+	if e.RemoteAddr != "192.0.2.1:1234" {
+		t.Errorf("Remote address not recorded, got '%s'", e.RemoteAddr)
+	}
+
+	if sink.entries[1].RemoteAddr != "" {
+		t.Errorf("WithOrigin() changed the original store")
+	}
+
+	e = sink.entries[2]
+	if e.Action != ActionUpdateProbe {
+		t.Fatalf("Wrong action %s, expected %s", e.Action, ActionUpdateProbe)
+	}
+
+	found := false
+	for _, c := range e.Changes {
+		if c.Field == "agent" {
+			found = c.Before == "cpustats" && c.After == "memstats"
+		} else if c.Field != "agent" && c.Field != "config" {
+			t.Errorf("Unexpected change in field %s", c.Field)
+		}
+	}
+
+	if !found {
+		t.Errorf("Agent change not recorded: %+v", e.Changes)
+	}
+
+	e = sink.entries[3]
+	if e.Action != ActionDeleteProbe || e.AccountID != "a1" {
+		t.Errorf("Wrong entry for DeleteProbe: %+v", e)
+	}
+
+	e = sink.entries[4]
+	if e.Error == "" {
+		t.Errorf("Failed DeleteHost() did not record error")
+	}
+}
@@ -34,7 +34,16 @@ func GatherAndReport(clientConfig configuration.ClientConfiguration) {
 			continue
 		}
 
-		json, e := json.Marshal(l.Agents)
+		// Send the sample time along with the agents to let the server
+		// stamp the points correctly.
+		results := plugins.Results{
+			"sampletime": &plugins.SampleTime{Time: time.Now()},
+		}
+		for id, agent := range l.Agents {
+			results[id] = agent
+		}
+
+		json, e := json.Marshal(results)
 
 		if e == nil {
 			client := &http.Client{}
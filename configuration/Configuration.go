@@ -9,6 +9,7 @@ import (
 	"github.com/BurntSushi/toml"
 
 	"github.com/abrander/agento/logger"
+	"github.com/abrander/agento/secret"
 )
 
 const (
@@ -20,6 +21,7 @@ var (
 	defaultConfig = `
 [main]
 includedir = "/etc/agento.d/"
+masterkey = "/var/lib/agento/master.key"
 
 [client]
 enabled = false
@@ -28,6 +30,8 @@ secret = "insecure"
 
 [server]
 secret = "insecure"
+tsdb = "influxdb"
+maxClockSkew = 300
 
 [server.http]
 enabled = false
@@ -55,6 +59,27 @@ database = "agento"
 retentionPolicy = "default"
 retries = 0
 
+[server.local]
+path = "/var/lib/agento/tsdb"
+partition = 2
+retention = 168
+downsampleAfter = 24
+resolution = 60
+
+[server.cardinality]
+maxSeriesPerMeasurement = 10000
+maxSeriesPerAccount = 100000
+action = "drop"
+expire = 24
+
+[userdb]
+mode = "single"
+path = "/var/lib/agento/userdb.json"
+
+[audit]
+sink = "file"
+path = "/var/lib/agento/audit.log"
+
 [mongo]
 enabled = false
 url = "127.0.0.1"
@@ -100,6 +125,57 @@ type InfluxdbConfiguration struct {
 	Retries         int    `toml:"retries"`
 }
 
+// LocalDbConfiguration stores the configuration for the embedded time
+// series database.
+type LocalDbConfiguration struct {
+	Path string `toml:"path"`
+
+	// Partition is the number of hours stored in each file.
+	Partition int `toml:"partition"`
+
+	// Retention is the number of hours to keep points.
+	Retention int `toml:"retention"`
+
+	// DownsampleAfter is the age in hours after which points are
+	// downsampled. 0 disables downsampling.
+	DownsampleAfter int `toml:"downsampleAfter"`
+
+	// Resolution is the resolution in seconds of downsampled points.
+	Resolution int `toml:"resolution"`
+}
+
+// RollupConfiguration describes a rollup of points into a coarser interval.
+type RollupConfiguration struct {
+	// Interval is the length in seconds of each aggregated point.
+	Interval int `toml:"interval"`
+
+	// RetentionPolicy is the InfluxDB retention policy to write to. If
+	// empty, the retention policy of the raw points is used.
+	RetentionPolicy string `toml:"retentionPolicy"`
+
+	// Suffix is appended to the measurement name of aggregated points.
+	Suffix string `toml:"suffix"`
+}
+
+// CardinalityConfiguration limits the number of unique series stored.
+type CardinalityConfiguration struct {
+	// MaxSeriesPerMeasurement is the maximum number of series for each
+	// measurement of an account. 0 means unlimited.
+	MaxSeriesPerMeasurement int `toml:"maxSeriesPerMeasurement"`
+
+	// MaxSeriesPerAccount is the maximum number of series for each account.
+	// 0 means unlimited.
+	MaxSeriesPerAccount int `toml:"maxSeriesPerAccount"`
+
+	// Action is either "drop" or "aggregate". Aggregated series will have
+	// all tag values replaced by "_overflow".
+	Action string `toml:"action"`
+
+	// Expire is the number of hours a series is counted after the last
+	// point.
+	Expire int `toml:"expire"`
+}
+
 // ClientConfiguration stores the configuration for Agento as a client.
 type ClientConfiguration struct {
 	Enabled   bool   `toml:"enabled"`
@@ -108,6 +184,16 @@ type ClientConfiguration struct {
 	ServerURL string `toml:"server-url"`
 }
 
+// RelayConfiguration stores the configuration for Agento as a relay.
+type RelayConfiguration struct {
+	URL    string `toml:"url"`
+	Secret string `toml:"secret"`
+
+	// Name is the name of the relay used in host configurations. The
+	// hostname is used if empty.
+	Name string `toml:"name"`
+}
+
 // HTTPConfiguration is the configuration for the built-in HTTP server.
 type HTTPConfiguration struct {
 	Enabled bool   `toml:"enabled"`
@@ -134,11 +220,20 @@ type UDPConfiguration struct {
 
 // ServerConfiguration stores the configuration for Agento as a server.
 type ServerConfiguration struct {
-	Influxdb InfluxdbConfiguration `toml:"influxdb"`
-	HTTP     HTTPConfiguration     `toml:"http"`
-	HTTPS    HTTPSConfiguration    `toml:"https"`
-	Secret   string                `toml:"secret"`
-	UDP      UDPConfiguration      `toml:"udp"`
+	// TSDB is either "influxdb" or "local".
+	TSDB        string                   `toml:"tsdb"`
+	Influxdb    InfluxdbConfiguration    `toml:"influxdb"`
+	Local       LocalDbConfiguration     `toml:"local"`
+	Rollups     []RollupConfiguration    `toml:"rollup"`
+	Cardinality CardinalityConfiguration `toml:"cardinality"`
+	HTTP        HTTPConfiguration        `toml:"http"`
+	HTTPS       HTTPSConfiguration       `toml:"https"`
+	Secret      string                   `toml:"secret"`
+	UDP         UDPConfiguration         `toml:"udp"`
+
+	// MaxClockSkew is the maximum difference in seconds between the sample
+	// time of a report and the time it's received. 0 disables the check.
+	MaxClockSkew int `toml:"maxClockSkew"`
 }
 
 // MongoConfiguration is the configuration for Agento's MongoDB client.
@@ -148,20 +243,46 @@ type MongoConfiguration struct {
 	Database string `toml:"database"`
 }
 
+// UserdbConfiguration is the configuration for the user database.
+type UserdbConfiguration struct {
+	// Mode can be "single" for a single user setup using the server secret
+	// as the only key or "multi" for a multiuser setup.
+	Mode string `toml:"mode"`
+
+	// Path is where the multiuser database will be saved if Mongo is not
+	// enabled.
+	Path string `toml:"path"`
+}
+
+// AuditConfiguration is the configuration of the audit log.
+type AuditConfiguration struct {
+	// Sink is either "file", "mongo" or "none".
+	Sink string `toml:"sink"`
+
+	// Path is the file written to by the "file" sink.
+	Path string `toml:"path"`
+}
+
 // MainConfiguration is the configuration for main behaviour of Agento.
 type MainConfiguration struct {
 	Includedir string `toml:"includedir"`
+	MasterKey  string `toml:"masterkey"`
 }
 
 // Configuration is Agento's main configuration object.
 type Configuration struct {
-	Client   ClientConfiguration       `toml:"client"`
-	Server   ServerConfiguration       `toml:"server"`
-	Mongo    MongoConfiguration        `toml:"mongo"`
-	Hosts    map[string]toml.Primitive `toml:"host"`
-	Probes   map[string]toml.Primitive `toml:"probe"`
-	Main     MainConfiguration         `toml:"main"`
-	metadata toml.MetaData
+	Client     ClientConfiguration       `toml:"client"`
+	Relay      RelayConfiguration        `toml:"relay"`
+	Server     ServerConfiguration       `toml:"server"`
+	Mongo      MongoConfiguration        `toml:"mongo"`
+	Userdb     UserdbConfiguration       `toml:"userdb"`
+	Audit      AuditConfiguration        `toml:"audit"`
+	Hosts      map[string]toml.Primitive `toml:"host"`
+	Probes     map[string]toml.Primitive `toml:"probe"`
+	Discovery  map[string]toml.Primitive `toml:"discovery"`
+	Processors []toml.Primitive          `toml:"processor"`
+	Main       MainConfiguration         `toml:"main"`
+	metadata   toml.MetaData
 }
 
 func fileExists(name string) bool {
@@ -217,6 +338,33 @@ func (c *Configuration) LoadFromFile(path string) error {
 		}
 	}
 
+	return c.ResolveSecrets()
+}
+
+// ResolveSecrets will resolve secret references in the global configuration.
+// See secret.Resolve() for details.
+func (c *Configuration) ResolveSecrets() error {
+	if c.Main.MasterKey != "" {
+		secret.KeyPath = c.Main.MasterKey
+	}
+
+	values := []*string{
+		&c.Client.Secret,
+		&c.Relay.Secret,
+		&c.Server.Secret,
+		&c.Server.Influxdb.Password,
+		&c.Mongo.URL,
+	}
+
+	for _, value := range values {
+		resolved, err := secret.Resolve(*value)
+		if err != nil {
+			return err
+		}
+
+		*value = resolved
+	}
+
 	return nil
 }
 
@@ -235,6 +383,12 @@ func (c *Configuration) LoadFromEnvironment() {
 	if envSecret != "" {
 		c.Client.Secret = envSecret
 		c.Server.Secret = envSecret
+		c.Relay.Secret = envSecret
+	}
+
+	envRelayURL := os.Getenv("AGENTO_RELAY_URL")
+	if envRelayURL != "" {
+		c.Relay.URL = envRelayURL
 	}
 
 	envServer := os.Getenv("AGENTO_SERVER_URL")
@@ -264,3 +418,15 @@ func (c *Configuration) GetHostPrimitives() map[string]toml.Primitive {
 func (c *Configuration) GetProbePrimitives() map[string]toml.Primitive {
 	return c.Probes
 }
+
+// GetDiscoveryPrimitives will return enough for someone to decode
+// [discovery.*] fields from the TOML file.
+func (c *Configuration) GetDiscoveryPrimitives() map[string]toml.Primitive {
+	return c.Discovery
+}
+
+// GetProcessorPrimitives will return enough for someone to decode the global
+// [[processor]] list from the TOML file.
+func (c *Configuration) GetProcessorPrimitives() []toml.Primitive {
+	return c.Processors
+}
@@ -69,7 +69,7 @@ func (s *SimpleEmitter) Broadcast(typ string, payload userdb.Object) {
 
 	s.lock.Lock()
 	for _, listener := range s.listeners {
-		if listener.subject.CanAccess(payload) == nil {
+		if listener.subject.Authorize(payload, userdb.PermissionRead) == nil {
 			listener.channel <- change
 		}
 	}
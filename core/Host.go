@@ -2,31 +2,55 @@ package core
 
 import (
 	"encoding/json"
+	"errors"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/abrander/agento/plugins"
+	"github.com/abrander/agento/userdb"
 )
 
 type (
 	// Host represents a configured host.
 	Host struct {
-		ID              string                 `toml:"-" json:"id"`
-		AccountID       string                 `toml:"-" json:"accountId"`
+		ID              string                 `toml:"-" json:"id" bson:"_id"`
+		AccountID       string                 `toml:"-" json:"accountId" bson:"accountId"`
 		Name            string                 `toml:"name" json:"name"`
 		TransportID     string                 `toml:"transport" json:"transport"`
 		TransportConfig map[string]interface{} `toml:"config" json:"config"`
+
+		// HostClock will stamp points with the time according to the clock
+		// of the host instead of our own. The offset is measured using the
+		// transport.
+		HostClock bool `toml:"hostClock" json:"hostClock,omitempty"`
+
+		// Discovery is the ID of the discovery adding the host. Discovered
+		// hosts are added and deleted by the discovery.
+		Discovery string `toml:"-" json:"discovery,omitempty"`
+	}
+
+	clockOffset struct {
+		offset   time.Duration
+		measured time.Time
 	}
 )
 
 var (
 	transportsLock sync.RWMutex
 	transports     map[string]plugins.Transport
+	offsets        map[string]clockOffset
+
+	// ClockOffsetInterval is how often the clock offset of hosts using
+	// HostClock is measured.
+	ClockOffsetInterval = 10 * time.Minute
 )
 
 func init() {
 	transportsLock.Lock()
 	transports = make(map[string]plugins.Transport)
+	offsets = make(map[string]clockOffset)
 	transportsLock.Unlock()
 }
 
@@ -35,6 +59,12 @@ func (h *Host) GetAccountId() string {
 	return h.AccountID
 }
 
+// trusted returns true if objects owned by accountID are written by the
+// operator. Only God owns hosts and probes from the TOML configuration.
+func trusted(accountID string) bool {
+	return accountID == userdb.God.GetAccountId()
+}
+
 // DecodeTOML will try to decode a simple TOML based configuration.
 func (h *Host) DecodeTOML(prim toml.Primitive) error {
 	err := toml.PrimitiveDecode(prim, h)
@@ -47,12 +77,15 @@ func (h *Host) DecodeTOML(prim toml.Primitive) error {
 
 	// Remove known entries. Someone should find a better method.
 	delete(h.TransportConfig, "transport")
+	delete(h.TransportConfig, "hostClock")
+
+	h.AccountID = userdb.God.GetAccountId()
 
 	return nil
 }
 
 // Transport will return a usable transport for this host.
-func (h *Host) Transport() plugins.Transport {
+func (h *Host) Transport() (plugins.Transport, error) {
 	transportsLock.RLock()
 	transport, found := transports[h.ID]
 	transportsLock.RUnlock()
@@ -60,20 +93,134 @@ func (h *Host) Transport() plugins.Transport {
 	if !found {
 		var err error
 
-		transport, err = plugins.GetTransport(h.TransportID)
+		transport, err = h.NewTransport()
 		if err != nil {
-			panic(err.Error())
+			return nil, err
 		}
 
-		// Use JSON as an intermediary for setting configuration. Its ugly,
-		// but it does the job for now.
-		j, _ := json.Marshal(h.TransportConfig)
-		json.Unmarshal(j, transport)
-
 		transportsLock.Lock()
 		transports[h.ID] = transport
 		transportsLock.Unlock()
 	}
 
-	return transport
+	return transport, nil
+}
+
+// NewTransport will instantiate and configure a new transport for the host.
+// Unlike Transport(), the transport will not be cached. This can be used for
+// hosts that are not saved.
+func (h *Host) NewTransport() (plugins.Transport, error) {
+	transport, err := plugins.GetTransport(h.TransportID)
+	if err != nil {
+		return nil, err
+	}
+
+	resolve := plugins.ResolveUntrustedSecrets
+	if trusted(h.AccountID) {
+		resolve = plugins.ResolveSecrets
+	} else if names := plugins.UntrustedParameters(h.TransportID, h.TransportConfig); len(names) > 0 {
+		return nil, errors.New(strings.Join(names, ", ") + " can only be set by the operator")
+	} else if names := plugins.TrustedDefaults(h.TransportID, h.TransportConfig); len(names) > 0 {
+		return nil, errors.New(strings.Join(names, ", ") + " must be set")
+	}
+
+	// The unresolved configuration must never reach the transport.
+	config, err := resolve(h.TransportID, h.TransportConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	// Use JSON as an intermediary for setting configuration. Its ugly,
+	// but it does the job for now.
+	j, _ := json.Marshal(config)
+	json.Unmarshal(j, transport)
+
+	if a, ok := transport.(plugins.AccountTransport); ok {
+		a.SetAccount(h.AccountID)
+	}
+
+	return transport, nil
+}
+
+// ResetTransport will forget the cached transport for the host. This must be
+// called when a host is changed or deleted.
+func (h *Host) ResetTransport() {
+	transportsLock.Lock()
+	delete(transports, h.ID)
+	delete(offsets, h.ID)
+	transportsLock.Unlock()
+}
+
+// ClockOffset returns the offset of the clock of the host measured using
+// transport. The offset is measured at most every ClockOffsetInterval. 0 is
+// returned if HostClock is not enabled.
+func (h *Host) ClockOffset(transport plugins.Transport, now time.Time) (time.Duration, error) {
+	if !h.HostClock {
+		return 0, nil
+	}
+
+	transportsLock.RLock()
+	cached, found := offsets[h.ID]
+	transportsLock.RUnlock()
+
+	if found && now.Sub(cached.measured) < ClockOffsetInterval {
+		return cached.offset, nil
+	}
+
+	offset, err := plugins.MeasureOffset(transport)
+	if err != nil {
+		return 0, err
+	}
+
+	// Hosts not saved have no ID and are not cached.
+	if h.ID != "" {
+		transportsLock.Lock()
+		offsets[h.ID] = clockOffset{offset: offset, measured: now}
+		transportsLock.Unlock()
+	}
+
+	return offset, nil
+}
+
+// Validate will return a *ValidationError if the host is invalid.
+func (h *Host) Validate() error {
+	e := &ValidationError{}
+
+	if h.Name == "" {
+		e.add("name", "must not be empty")
+	}
+
+	if _, found := plugins.GetTransports()[h.TransportID]; !found {
+		e.add("transport", "unknown transport '"+h.TransportID+"'")
+	} else if h.HostClock {
+		transport, _ := plugins.GetTransport(h.TransportID)
+		if _, ok := transport.(plugins.Clock); !ok {
+			e.add("hostClock", "not supported by transport '"+h.TransportID+"'")
+		}
+	}
+
+	validateSecrets(e, h.AccountID, h.TransportID, h.TransportConfig)
+	validateTrusted(e, h.AccountID, h.TransportID, h.TransportConfig)
+
+	return e.orNil()
+}
+
+// RestoreSecrets will restore secret parameters redacted by MarshalJSON()
+// from previous.
+func (h *Host) RestoreSecrets(previous *Host) {
+	if h.TransportID != previous.TransportID {
+		return
+	}
+
+	plugins.RestoreSecrets(h.TransportID, h.TransportConfig, previous.TransportConfig)
+}
+
+// MarshalJSON implements json.Marshaler. All secret parameters in the
+// transport configuration will be redacted.
+func (h Host) MarshalJSON() ([]byte, error) {
+	type host Host
+
+	h.TransportConfig = plugins.RedactSecrets(h.TransportID, h.TransportConfig)
+
+	return json.Marshal(host(h))
 }
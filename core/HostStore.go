@@ -9,10 +9,13 @@ import (
 
 // HostStore is an interface describing a store for hosts.
 type HostStore interface {
+	// GetAllHosts returns all hosts belonging to accountID. If accountID is
+	// empty, hosts from all accounts are returned if subject is allowed.
 	GetAllHosts(subject userdb.Subject, accountID string) ([]Host, error)
 	AddHost(subject userdb.Subject, host *Host) error
 	GetHost(subject userdb.Subject, id string) (*Host, error)
 	GetHostByName(subject userdb.Subject, name string) (*Host, error)
+	UpdateHost(subject userdb.Subject, host *Host) error
 	DeleteHost(subject userdb.Subject, id string) error
 }
 
@@ -35,7 +38,7 @@ func AddLocalhost(subject userdb.Subject, store HostStore) error {
 		}
 
 		// Save it.
-		err = store.AddHost(nil, host)
+		err = store.AddHost(subject, host)
 		if err != nil {
 			return err
 		}
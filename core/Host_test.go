@@ -3,9 +3,15 @@ package core
 import (
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/BurntSushi/toml"
 
+	"github.com/abrander/agento/plugins"
+	_ "github.com/abrander/agento/plugins/transports/docker"
+	_ "github.com/abrander/agento/plugins/transports/kubernetes"
+	_ "github.com/abrander/agento/plugins/transports/local"
+	_ "github.com/abrander/agento/plugins/transports/ssh"
 	"github.com/abrander/agento/userdb"
 )
 
@@ -13,6 +19,17 @@ type Config struct {
 	Hosts map[string]toml.Primitive `toml:"host"`
 }
 
+type clockTransport struct {
+	plugins.Transport
+	calls int
+}
+
+func (c *clockTransport) RemoteTime() (time.Time, error) {
+	c.calls++
+
+	return time.Now().Add(-time.Minute), nil
+}
+
 func TestHostDecodeTOML(t *testing.T) {
 	cases := map[string]*Host{
 		`[host.testhost]
@@ -118,3 +135,116 @@ func TestHostDecodeJSON(t *testing.T) {
 		}
 	}
 }
+
+func TestHostValidate(t *testing.T) {
+	err := (&Host{}).Validate()
+
+	v, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("Validate() did not return a *ValidationError, got %T", err)
+	}
+
+	if v.Fields["name"] == "" || v.Fields["transport"] == "" {
+		t.Errorf("Validate() did not complain about name and transport: %v", v.Fields)
+	}
+}
+
+func TestHostTenant(t *testing.T) {
+	cases := []struct {
+		transport string
+		config    map[string]interface{}
+	}{
+		{"sshtransport", map[string]interface{}{"password": "file:/etc/hostname"}},
+		{"sshtransport", map[string]interface{}{"password": "env:HOME"}},
+		{"sshtransport", map[string]interface{}{"keyFile": "/etc/hostname"}},
+		{"sshtransport", map[string]interface{}{"agent": true}},
+		{"dockertransport", map[string]interface{}{"container": "web"}},
+		{"dockertransport", map[string]interface{}{"socket": "/tmp/docker.sock"}},
+		{"localtransport", map[string]interface{}{"pid": 1}},
+		{"localtransport", map[string]interface{}{"netns": "/var/run/netns/blue"}},
+		{"kubernetestransport", map[string]interface{}{"pod": "web"}},
+		{"kubernetestransport", map[string]interface{}{"server": "https://k8s:6443", "caFile": "/etc/hostname"}},
+	}
+
+	for _, c := range cases {
+		host := &Host{
+			AccountID:       "tenant",
+			Name:            "tenant",
+			TransportID:     c.transport,
+			TransportConfig: c.config,
+		}
+
+		if host.Validate() == nil {
+			t.Errorf("Validate() accepted %v from tenant", c.config)
+		}
+
+		_, err := host.NewTransport()
+		if err == nil {
+			t.Errorf("NewTransport() accepted %v from tenant", c.config)
+		}
+
+		host.AccountID = userdb.God.GetAccountId()
+
+		err = host.Validate()
+		if err != nil {
+			t.Errorf("Validate() failed for %v from God: %s", c.config, err.Error())
+		}
+	}
+
+	allowed := []struct {
+		transport string
+		config    map[string]interface{}
+	}{
+		{"sshtransport", map[string]interface{}{"password": "hunter2", "agent": false}},
+		{"kubernetestransport", map[string]interface{}{"server": "https://k8s:6443", "token": "hunter2", "pod": "web"}},
+	}
+
+	for _, c := range allowed {
+		host := &Host{
+			AccountID:       "tenant",
+			Name:            "tenant",
+			TransportID:     c.transport,
+			TransportConfig: c.config,
+		}
+
+		if err := host.Validate(); err != nil {
+			t.Errorf("Validate() failed for %v from tenant: %s", c.config, err.Error())
+		}
+	}
+}
+
+func TestHostClockOffset(t *testing.T) {
+	transport := &clockTransport{}
+	host := &Host{ID: "clockhost"}
+	defer host.ResetTransport()
+
+	now := time.Now()
+
+	offset, err := host.ClockOffset(transport, now)
+	if err != nil || offset != 0 || transport.calls != 0 {
+		t.Fatalf("ClockOffset() measured without HostClock: %s %v", offset, err)
+	}
+
+	host.HostClock = true
+
+	offset, err = host.ClockOffset(transport, now)
+	if err != nil {
+		t.Fatalf("ClockOffset() failed: %s", err.Error())
+	}
+
+	if offset > -time.Minute+time.Second || offset < -time.Minute-time.Second {
+		t.Errorf("ClockOffset() returned %s, expected about -1m", offset)
+	}
+
+	calls := transport.calls
+
+	host.ClockOffset(transport, now.Add(time.Minute))
+	if transport.calls != calls {
+		t.Errorf("ClockOffset() did not use the cached offset")
+	}
+
+	host.ClockOffset(transport, now.Add(ClockOffsetInterval))
+	if transport.calls == calls {
+		t.Errorf("ClockOffset() did not measure again after ClockOffsetInterval")
+	}
+}
@@ -2,10 +2,12 @@ package core
 
 import (
 	"encoding/json"
+	"errors"
 	"time"
 
 	"github.com/BurntSushi/toml"
 
+	"github.com/abrander/agento/logger"
 	"github.com/abrander/agento/plugins"
 	"github.com/abrander/agento/timeseries"
 	"github.com/abrander/agento/userdb"
@@ -14,8 +16,8 @@ import (
 type (
 	// Probe describes a probe measuring something with an agent though a transport.
 	Probe struct {
-		ID          string                 `json:"id"`
-		AccountID   string                 `json:"accountId"`
+		ID          string                 `json:"id" bson:"_id"`
+		AccountID   string                 `json:"accountId" bson:"accountId"`
 		HostID      string                 `toml:"host" json:"host"`
 		Interval    time.Duration          `json:"interval"`
 		AgentID     string                 `toml:"agent" json:"agent"`
@@ -24,9 +26,16 @@ type (
 		NextCheck   time.Time              `json:"nextCheck"`
 		LastPoints  []*timeseries.Point    `json:"lastPoints"`
 		Tags        map[string]string      `json:"tags"`
+		Processors  timeseries.Pipeline    `toml:"processor" json:"processors,omitempty"`
+		LastError   string                 `json:"lastError,omitempty"`
 	}
 )
 
+const (
+	// DefaultInterval is used for probes without an interval.
+	DefaultInterval = 10 * time.Second
+)
+
 // GetAccountId will implement userdb.Subject.
 func (p *Probe) GetAccountId() string {
 	return p.AccountID
@@ -44,12 +53,13 @@ func (p *Probe) DecodeTOML(hostStore HostStore, prim toml.Primitive) error {
 		return err
 	}
 	delete(p.AgentConfig, "agent")
+	delete(p.AgentConfig, "processor")
 
 	p.ID = RandomString(20)
 	p.AccountID = userdb.God.GetAccountId()
 
 	if p.Interval == 0 {
-		p.Interval = time.Second * 10
+		p.Interval = DefaultInterval
 	} else {
 		p.Interval = time.Second * p.Interval
 	}
@@ -71,3 +81,139 @@ func (p *Probe) Agent() plugins.Agent {
 
 	return agent
 }
+
+// ResolvedAgent behaves like Agent(), but will resolve all secret parameters
+// before configuring the agent. The returned agent should only be used for
+// gathering, never for logging or serializing.
+func (p *Probe) ResolvedAgent() (plugins.Agent, error) {
+	agent, err := plugins.GetAgent(p.AgentID)
+	if err != nil {
+		return nil, err
+	}
+
+	resolve := plugins.ResolveUntrustedSecrets
+	if trusted(p.AccountID) {
+		resolve = plugins.ResolveSecrets
+	}
+
+	config, err := resolve(p.AgentID, p.AgentConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	j, _ := json.Marshal(config)
+	json.Unmarshal(j, agent)
+
+	return agent, nil
+}
+
+// Gather will run the probe once against host using transport and return
+// the gathered points tagged with the host name and the tags of the probe,
+// processed by the processors of the probe and tagged with the account.
+// Points without a time are stamped with the time the agent finished
+// gathering, adjusted to the clock of the host if HostClock is set. If the
+// agent returns a *plugins.PartialError, the points are returned with the
+// error.
+func (p *Probe) Gather(host *Host, transport plugins.Transport) ([]*timeseries.Point, error) {
+	// Secrets are resolved as late as possible, the resolved agent must
+	// never be logged.
+	agent, err := p.ResolvedAgent()
+	if err != nil {
+		return nil, err
+	}
+
+	gatherErr := agent.Gather(transport)
+
+	var partial *plugins.PartialError
+	if gatherErr != nil && !errors.As(gatherErr, &partial) {
+		return nil, gatherErr
+	}
+
+	collected := time.Now()
+
+	offset, err := host.ClockOffset(transport, collected)
+	if err != nil {
+		logger.Red("probe", "[%s] Could not measure clock offset of %s: %s", p.ID, host.Name, err.Error())
+	}
+
+	points := agent.GetPoints()
+	timeseries.Stamp(points, collected.Add(offset))
+
+	for _, point := range points {
+		point.Tags["hostname"] = host.Name
+
+		for key, value := range p.Tags {
+			point.Tags[key] = value
+		}
+	}
+
+	points = p.Processors.Process(points)
+
+	for _, point := range points {
+		// Tag with the account like reported points, queries are scoped by
+		// this tag.
+		if p.AccountID != "" && p.AccountID != userdb.God.GetAccountId() {
+			point.Tags["id"] = p.AccountID
+		}
+	}
+
+	return points, gatherErr
+}
+
+// Healthy returns true if the probe has run successfully within the last two
+// intervals.
+func (p *Probe) Healthy(now time.Time) bool {
+	if p.LastCheck.IsZero() || p.LastError != "" {
+		return false
+	}
+
+	return now.Sub(p.LastCheck) <= 2*p.Interval
+}
+
+// Validate will return a *ValidationError if the probe is invalid.
+func (p *Probe) Validate() error {
+	e := &ValidationError{}
+
+	if p.HostID == "" {
+		e.add("host", "must not be empty")
+	}
+
+	if _, found := plugins.GetAgents()[p.AgentID]; !found {
+		e.add("agent", "unknown agent '"+p.AgentID+"'")
+	}
+
+	if p.Interval <= 0 {
+		e.add("interval", "must be positive")
+	}
+
+	err := p.Processors.Validate()
+	if err != nil {
+		e.add("processors", err.Error())
+	}
+
+	validateSecrets(e, p.AccountID, p.AgentID, p.AgentConfig)
+	validateTrusted(e, p.AccountID, p.AgentID, p.AgentConfig)
+
+	return e.orNil()
+}
+
+// RestoreSecrets will restore secret parameters redacted by MarshalJSON()
+// from previous. This should be used before saving a probe received from an
+// API client.
+func (p *Probe) RestoreSecrets(previous *Probe) {
+	if p.AgentID != previous.AgentID {
+		return
+	}
+
+	plugins.RestoreSecrets(p.AgentID, p.AgentConfig, previous.AgentConfig)
+}
+
+// MarshalJSON implements json.Marshaler. All secret parameters in the agent
+// configuration will be redacted.
+func (p Probe) MarshalJSON() ([]byte, error) {
+	type probe Probe
+
+	p.AgentConfig = plugins.RedactSecrets(p.AgentID, p.AgentConfig)
+
+	return json.Marshal(probe(p))
+}
@@ -8,6 +8,8 @@ import (
 
 // ProbeStore describes a store capable of storing probes.
 type ProbeStore interface {
+	// GetAllProbes returns all probes belonging to accountID. If accountID is
+	// empty, probes from all accounts are returned if subject is allowed.
 	GetAllProbes(subject userdb.Subject, accountID string) ([]Probe, error)
 	AddProbe(subject userdb.Subject, probe *Probe) error
 	GetProbe(subject userdb.Subject, id string) (*Probe, error)
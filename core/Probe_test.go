@@ -1,15 +1,42 @@
 package core
 
 import (
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/abrander/agento/plugins"
+	_ "github.com/abrander/agento/plugins/agents/mysql"
+	"github.com/abrander/agento/timeseries"
 	"github.com/abrander/agento/userdb"
 )
 
 type (
 	mockHostStore struct{}
+
+	// partialAgent gathers one point and fails partially.
+	partialAgent struct{}
 )
 
+func init() {
+	plugins.Register("partialtest", func() interface{} { return &partialAgent{} })
+}
+
+func (a *partialAgent) GetDoc() *plugins.Doc {
+	return plugins.NewDoc("Partial test agent")
+}
+
+func (a *partialAgent) Gather(transport plugins.Transport) error {
+	return &plugins.PartialError{Err: errors.New("one of two failed")}
+}
+
+func (a *partialAgent) GetPoints() []*timeseries.Point {
+	return []*timeseries.Point{plugins.SimplePoint("partial.value", 1)}
+}
+
 func (s *mockHostStore) GetAllHosts(subject userdb.Subject, accountID string) ([]Host, error) {
 	return nil, nil
 }
@@ -29,6 +56,10 @@ func (s *mockHostStore) GetHostByName(subject userdb.Subject, name string) (*Hos
 	return nil, nil
 }
 
+func (s *mockHostStore) UpdateHost(subject userdb.Subject, host *Host) error {
+	return nil
+}
+
 func (s *mockHostStore) DeleteHost(subject userdb.Subject, id string) error {
 	return nil
 }
@@ -36,3 +67,122 @@ func (s *mockHostStore) DeleteHost(subject userdb.Subject, id string) error {
 func TestProbeDecodeTOML(t *testing.T) {
 
 }
+
+func TestProbeMarshalJSON(t *testing.T) {
+	probe := &Probe{
+		AgentID: "mysql",
+		AgentConfig: map[string]interface{}{
+			"dsn": "agento:hunter2@tcp(localhost)/mysql",
+		},
+	}
+
+	j, err := json.Marshal(probe)
+	if err != nil {
+		t.Fatalf("json.Marshal() failed: %s", err.Error())
+	}
+
+	if strings.Contains(string(j), "hunter2") {
+		t.Errorf("Secret leaked in JSON: %s", string(j))
+	}
+
+	var received Probe
+	json.Unmarshal(j, &received)
+
+	received.RestoreSecrets(probe)
+	if received.AgentConfig["dsn"] != probe.AgentConfig["dsn"] {
+		t.Errorf("Secret not restored, got '%v'", received.AgentConfig["dsn"])
+	}
+
+	if probe.AgentConfig["dsn"] != "agento:hunter2@tcp(localhost)/mysql" {
+		t.Errorf("MarshalJSON() changed the probe")
+	}
+}
+
+func TestProbeValidate(t *testing.T) {
+	probe := &Probe{HostID: "host", AgentID: "mysql", Interval: DefaultInterval}
+	if err := probe.Validate(); err != nil {
+		t.Errorf("Validate() failed for valid probe: %s", err.Error())
+	}
+
+	err := (&Probe{AgentID: "nonexisting", Processors: timeseries.Pipeline{{Action: "untag", Tag: "id"}}}).Validate()
+
+	v, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("Validate() did not return a *ValidationError, got %T", err)
+	}
+
+	for _, field := range []string{"host", "agent", "interval", "processors"} {
+		if v.Fields[field] == "" {
+			t.Errorf("Validate() did not complain about %s", field)
+		}
+	}
+}
+
+func TestProbeLocalSecrets(t *testing.T) {
+	os.Setenv("AGENTO_TEST_DSN", "agento:hunter2@tcp(localhost)/mysql")
+
+	probe := &Probe{
+		AccountID: "tenant",
+		HostID:    "host",
+		AgentID:   "mysql",
+		Interval:  DefaultInterval,
+		AgentConfig: map[string]interface{}{
+			"dsn": "env:AGENTO_TEST_DSN",
+		},
+	}
+
+	err := probe.Validate()
+	if v, ok := err.(*ValidationError); !ok || v.Fields["dsn"] == "" {
+		t.Errorf("Validate() accepted env: reference from tenant, got %v", err)
+	}
+
+	_, err = probe.ResolvedAgent()
+	if err == nil {
+		t.Errorf("ResolvedAgent() resolved env: reference for tenant")
+	}
+
+	probe.AccountID = userdb.God.GetAccountId()
+
+	err = probe.Validate()
+	if err != nil {
+		t.Errorf("Validate() failed for God: %s", err.Error())
+	}
+
+	_, err = probe.ResolvedAgent()
+	if err != nil {
+		t.Errorf("ResolvedAgent() failed for God: %s", err.Error())
+	}
+}
+
+func TestProbeGatherPartial(t *testing.T) {
+	probe := &Probe{AgentID: "partialtest"}
+
+	points, err := probe.Gather(&Host{Name: "partial"}, nil)
+	if err == nil || err.Error() != "one of two failed" {
+		t.Errorf("Gather() returned %v for partial failure", err)
+	}
+
+	if len(points) != 1 || points[0].Tags["hostname"] != "partial" {
+		t.Errorf("Gather() returned %v for partial failure", points)
+	}
+}
+
+func TestProbeHealthy(t *testing.T) {
+	now := time.Now()
+
+	cases := []struct {
+		probe   Probe
+		healthy bool
+	}{
+		{Probe{Interval: time.Second}, false},
+		{Probe{Interval: time.Second, LastCheck: now.Add(-time.Second)}, true},
+		{Probe{Interval: time.Second, LastCheck: now.Add(-time.Second), LastError: "failed"}, false},
+		{Probe{Interval: time.Second, LastCheck: now.Add(-time.Minute)}, false},
+	}
+
+	for i, c := range cases {
+		if c.probe.Healthy(now) != c.healthy {
+			t.Errorf("%d: Healthy() returned %t, expected %t", i, !c.healthy, c.healthy)
+		}
+	}
+}
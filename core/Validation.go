@@ -0,0 +1,75 @@
+package core
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/abrander/agento/plugins"
+)
+
+type (
+	// ValidationError describes why a host or probe is invalid. Fields maps
+	// the JSON name of each invalid field to a description of the problem.
+	ValidationError struct {
+		Fields map[string]string
+	}
+)
+
+// add records a problem with field.
+func (e *ValidationError) add(field string, problem string) {
+	if e.Fields == nil {
+		e.Fields = make(map[string]string)
+	}
+
+	e.Fields[field] = problem
+}
+
+// orNil returns e if any problems was recorded, nil otherwise.
+func (e *ValidationError) orNil() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+
+	return e
+}
+
+// Error implements error.
+func (e *ValidationError) Error() string {
+	fields := make([]string, 0, len(e.Fields))
+	for field, problem := range e.Fields {
+		fields = append(fields, field+": "+problem)
+	}
+	sort.Strings(fields)
+
+	return "validation failed: " + strings.Join(fields, ", ")
+}
+
+// validateSecrets records a problem for every secret parameter of plugin id
+// referring to the environment or files of the server, unless the object is
+// owned by accountID trusted to use them.
+func validateSecrets(e *ValidationError, accountID string, id string, config map[string]interface{}) {
+	if trusted(accountID) {
+		return
+	}
+
+	for _, name := range plugins.LocalSecrets(id, config) {
+		e.add(name, "env: and file: references are not allowed")
+	}
+}
+
+// validateTrusted records a problem for every trusted parameter of plugin id
+// set in config, and for every parameter left at a trusted default, unless
+// the object is owned by accountID trusted to use them.
+func validateTrusted(e *ValidationError, accountID string, id string, config map[string]interface{}) {
+	if trusted(accountID) {
+		return
+	}
+
+	for _, name := range plugins.UntrustedParameters(id, config) {
+		e.add(name, "can only be set by the operator")
+	}
+
+	for _, name := range plugins.TrustedDefaults(id, config) {
+		e.add(name, "must be set, the default can only be used by the operator")
+	}
+}
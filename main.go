@@ -2,15 +2,22 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"io/ioutil"
 	"math/rand"
 	"os"
+	"os/signal"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"github.com/BurntSushi/toml"
 	"github.com/gin-gonic/gin"
 	"github.com/spf13/cobra"
 
 	"github.com/abrander/agento/api"
+	"github.com/abrander/agento/audit"
 	"github.com/abrander/agento/client"
 	"github.com/abrander/agento/configuration"
 	"github.com/abrander/agento/core"
@@ -41,8 +48,13 @@ import (
 	_ "github.com/abrander/agento/plugins/agents/snmpstats"
 	_ "github.com/abrander/agento/plugins/agents/socketstats"
 	_ "github.com/abrander/agento/plugins/agents/tcpport"
-	_ "github.com/abrander/agento/plugins/transports/local"
-	_ "github.com/abrander/agento/plugins/transports/ssh"
+	_ "github.com/abrander/agento/plugins/transports/docker"
+	_ "github.com/abrander/agento/plugins/transports/kubernetes"
+	"github.com/abrander/agento/plugins/transports/local"
+	"github.com/abrander/agento/plugins/transports/record"
+	"github.com/abrander/agento/plugins/transports/relay"
+	"github.com/abrander/agento/plugins/transports/ssh"
+	"github.com/abrander/agento/secret"
 	"github.com/abrander/agento/server"
 	"github.com/abrander/agento/timeseries"
 	"github.com/abrander/agento/userdb"
@@ -51,6 +63,17 @@ import (
 var configPath = "/etc/agento.conf"
 var config = configuration.Configuration{}
 
+// Flags for "probe test".
+var (
+	testAgent           string
+	testParams          []string
+	testTransport       string
+	testTransportParams []string
+	testTags            []string
+	testRecord          string
+	testReplay          string
+)
+
 func init() {
 	// This should not be used for crypto, time.Now() is enough.
 	rand.Seed(time.Now().UnixNano())
@@ -88,6 +111,87 @@ func main() {
 	}
 	rootCommand.AddCommand(runOnceCommand)
 
+	relayCommand := &cobra.Command{
+		Use:   "relay",
+		Short: "Run as a relay for an Agento server",
+		Long:  "Connects to the Agento server configured in [relay] and lets it monitor this host through the connection.",
+		Run:   runRelay,
+		Args:  cobra.NoArgs,
+	}
+	rootCommand.AddCommand(relayCommand)
+
+	secretCommand := &cobra.Command{
+		Use:   "secret",
+		Short: "Manage secrets",
+	}
+	rootCommand.AddCommand(secretCommand)
+
+	secretEncryptCommand := &cobra.Command{
+		Use:   "encrypt",
+		Short: "Encrypt a secret read from stdin",
+		Long:  "Encrypts a secret read from stdin using the local master key. The output can be used in place of the plaintext secret in configuration.",
+		Run:   secretEncrypt,
+		Args:  cobra.NoArgs,
+	}
+	secretCommand.AddCommand(secretEncryptCommand)
+
+	probeCommand := &cobra.Command{
+		Use:   "probe",
+		Short: "Work with probes",
+	}
+	rootCommand.AddCommand(probeCommand)
+
+	probeTestCommand := &cobra.Command{
+		Use:     "test",
+		Short:   "Run an unsaved probe once",
+		Long:    "Configures an agent from the command line, runs it once and outputs gathered statistics in a format suitable for InfluxDB.",
+		Example: "  agento probe test --agent mysql --param dsn=agento@tcp(localhost)/mysql",
+		Run:     probeTest,
+		Args:    cobra.NoArgs,
+	}
+	probeTestCommand.Flags().StringVar(&testAgent, "agent", "", "The agent to run")
+	probeTestCommand.Flags().StringArrayVar(&testParams, "param", nil, "Agent parameter as key=value, can be repeated")
+	probeTestCommand.Flags().StringVar(&testTransport, "transport", "localtransport", "The transport to use")
+	probeTestCommand.Flags().StringArrayVar(&testTransportParams, "transport-param", nil, "Transport parameter as key=value, can be repeated")
+	probeTestCommand.Flags().StringArrayVar(&testTags, "tag", nil, "Tag to add to all points as key=value, can be repeated")
+	probeTestCommand.Flags().StringVar(&testRecord, "record", "", "Record everything read by the agent to a fixture file")
+	probeTestCommand.Flags().StringVar(&testReplay, "replay", "", "Replay a fixture file instead of using the transport")
+	probeTestCommand.MarkFlagRequired("agent")
+	probeCommand.AddCommand(probeTestCommand)
+
+	hostkeyCommand := &cobra.Command{
+		Use:   "hostkey",
+		Short: "Manage SSH host keys",
+	}
+	rootCommand.AddCommand(hostkeyCommand)
+
+	hostkeyListCommand := &cobra.Command{
+		Use:   "list",
+		Short: "List trusted, revoked and pending host keys",
+		Run:   hostkeyList,
+		Args:  cobra.NoArgs,
+	}
+	hostkeyCommand.AddCommand(hostkeyListCommand)
+
+	hostkeyApproveCommand := &cobra.Command{
+		Use:     "approve <address> <fingerprint>",
+		Short:   "Trust a host key",
+		Long:    "Trusts a pending or revoked host key. All other trusted keys for the address are removed.",
+		Example: "  agento hostkey approve db1.example.com SHA256:nThbg6kXUpJWGl7E1IGOCspRomTxdCARLviKw6E5SY8",
+		Run:     hostkeyApprove,
+		Args:    cobra.ExactArgs(2),
+	}
+	hostkeyCommand.AddCommand(hostkeyApproveCommand)
+
+	hostkeyRevokeCommand := &cobra.Command{
+		Use:   "revoke <address> <fingerprint>",
+		Short: "Revoke a host key",
+		Long:  "Marks a host key as revoked. Connections offering the key will be refused.",
+		Run:   hostkeyRevoke,
+		Args:  cobra.ExactArgs(2),
+	}
+	hostkeyCommand.AddCommand(hostkeyRevokeCommand)
+
 	rootCommand.PersistentFlags().StringVar(&configPath, "config", configPath, "The configuration file to use")
 	rootCommand.Execute()
 }
@@ -140,30 +244,245 @@ func getStore(broadcaster core.Broadcaster) core.Store {
 	return store
 }
 
+// getDiscoveries returns the configured discoveries adding hosts to store.
+func getDiscoveries(store core.Store) []*monitor.Discovery {
+	var discoveries []*monitor.Discovery
+
+	for id, primitive := range config.GetDiscoveryPrimitives() {
+		discovery, err := monitor.NewDiscovery(id, primitive, store, userdb.God)
+		if err != nil {
+			logger.Red("agento", "Configuration error: Discovery %s: %s", id, err.Error())
+			os.Exit(1)
+		}
+
+		discoveries = append(discoveries, discovery)
+	}
+
+	return discoveries
+}
+
+// getAuditSink returns the configured audit sink or nil if auditing is
+// disabled.
+func getAuditSink() audit.Sink {
+	var err error
+	var sink audit.Sink
+
+	switch config.Audit.Sink {
+	case "none", "":
+		return nil
+	case "file":
+		sink, err = audit.NewFileSink(config.Audit.Path)
+	case "mongo":
+		sink, err = audit.NewMongoSink(config.Mongo.URL, config.Mongo.Database)
+	default:
+		logger.Red("agento", "Configuration error: Unknown audit sink '%s'", config.Audit.Sink)
+		os.Exit(1)
+	}
+
+	if err != nil {
+		logger.Red("agento", "Audit error: %s", err.Error())
+		os.Exit(1)
+	}
+
+	return sink
+}
+
+// getPipeline returns the global processors applied to all points.
+func getPipeline() timeseries.Pipeline {
+	primitives := config.GetProcessorPrimitives()
+	pipeline := make(timeseries.Pipeline, len(primitives))
+
+	for i, primitive := range primitives {
+		err := toml.PrimitiveDecode(primitive, &pipeline[i])
+		if err == nil {
+			err = pipeline[i].Validate()
+		}
+
+		if err != nil {
+			logger.Red("agento", "Configuration error: Processor %d: %s", i+1, err.Error())
+			os.Exit(1)
+		}
+	}
+
+	return pipeline
+}
+
+// getTSDB returns the configured time series database including rollups,
+// cardinality limits and the processing pipeline.
+// If the database must be closed, it will be closed when Agento is
+// interrupted or terminated.
+func getTSDB() timeseries.Database {
+	var tsdb timeseries.Database
+	var rollups []timeseries.Rollup
+
+	for _, r := range config.Server.Rollups {
+		if r.Interval <= 0 {
+			logger.Red("agento", "Configuration error: Rollup interval must be positive")
+			os.Exit(1)
+		}
+
+		if r.Suffix == "" && (r.RetentionPolicy == "" || config.Server.TSDB == "local") {
+			logger.Red("agento", "Configuration error: Rollup of %d seconds must have a suffix or a retention policy", r.Interval)
+			os.Exit(1)
+		}
+	}
+
+	switch config.Server.TSDB {
+	case "influxdb", "":
+		influx, err := timeseries.NewInfluxDb(&config.Server.Influxdb)
+		if err != nil {
+			logger.Red("agento", "InfluxDB error: %s", err.Error())
+			os.Exit(1)
+		}
+
+		for _, r := range config.Server.Rollups {
+			target := influx
+			if r.RetentionPolicy != "" {
+				target = influx.WithRetentionPolicy(r.RetentionPolicy)
+			}
+
+			rollups = append(rollups, timeseries.Rollup{
+				Interval: time.Duration(r.Interval) * time.Second,
+				Suffix:   r.Suffix,
+				Target:   target,
+			})
+		}
+
+		tsdb = influx
+	case "local":
+		local, err := timeseries.NewLocalDb(&config.Server.Local)
+		if err != nil {
+			logger.Red("agento", "Local TSDB error: %s", err.Error())
+			os.Exit(1)
+		}
+
+		for _, r := range config.Server.Rollups {
+			rollups = append(rollups, timeseries.Rollup{
+				Interval: time.Duration(r.Interval) * time.Second,
+				Suffix:   r.Suffix,
+				Target:   local,
+			})
+		}
+
+		tsdb = local
+	default:
+		logger.Red("agento", "Configuration error: Unknown tsdb '%s'", config.Server.TSDB)
+		os.Exit(1)
+	}
+
+	if len(rollups) > 0 {
+		tsdb = timeseries.NewRollups(tsdb, rollups)
+	}
+
+	tsdb = timeseries.NewGuard(tsdb, getGuardLimits())
+
+	pipeline := getPipeline()
+	if len(pipeline) > 0 {
+		tsdb = timeseries.NewProcessed(tsdb, pipeline)
+	}
+
+	if closer, ok := tsdb.(io.Closer); ok {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+		go func() {
+			<-signals
+
+			err := closer.Close()
+			if err != nil {
+				logger.Red("agento", "TSDB error: %s", err.Error())
+				os.Exit(1)
+			}
+
+			os.Exit(0)
+		}()
+	}
+
+	return tsdb
+}
+
+// getGuardLimits returns the configured cardinality limits.
+func getGuardLimits() timeseries.GuardLimits {
+	c := config.Server.Cardinality
+
+	limits := timeseries.GuardLimits{
+		MaxSeriesPerMeasurement: c.MaxSeriesPerMeasurement,
+		MaxSeriesPerAccount:     c.MaxSeriesPerAccount,
+		Expire:                  time.Duration(c.Expire) * time.Hour,
+	}
+
+	switch c.Action {
+	case "drop", "":
+	case "aggregate":
+		limits.Aggregate = true
+	default:
+		logger.Red("agento", "Configuration error: Unknown cardinality action '%s'", c.Action)
+		os.Exit(1)
+	}
+
+	return limits
+}
+
+func getUserdb() userdb.Database {
+	switch config.Userdb.Mode {
+	case "single", "":
+		return userdb.NewSingleUser(config.Server.Secret)
+	case "multi":
+		var err error
+		var store userdb.Store
+
+		if config.Mongo.Enabled {
+			store, err = userdb.NewMongoStore(config.Mongo.URL, config.Mongo.Database)
+		} else {
+			store, err = userdb.NewFileStore(config.Userdb.Path)
+		}
+
+		if err != nil {
+			logger.Red("agento", "Userdb error: %s", err.Error())
+			os.Exit(1)
+		}
+
+		// The server secret will act as an administrator key.
+		return userdb.NewMultiUser(store, config.Server.Secret)
+	}
+
+	logger.Red("agento", "Configuration error: Unknown userdb mode '%s'", config.Userdb.Mode)
+	os.Exit(1)
+
+	return nil
+}
+
 func run(_ *cobra.Command, _ []string) {
 	var err error
 	wg := sync.WaitGroup{}
 
 	loadConfig()
 
-	db := userdb.NewSingleUser(config.Server.Secret)
+	db := getUserdb()
 	engine := gin.New()
 
 	emitter := core.NewSimpleEmitter()
 
 	store := getStore(emitter)
 
-	scheduler := monitor.NewScheduler(store, db)
+	// The scheduler updates probes on every run, keep that out of the audit
+	// log.
+	scheduler := monitor.NewScheduler(store, userdb.God)
 
-	serv, err := server.NewServer(engine, config.Server, db, store)
-	if err != nil {
-		logger.Red("agento", "Server error: %s", err.Error())
-		os.Exit(1)
+	for _, discovery := range getDiscoveries(store) {
+		go discovery.Loop()
 	}
 
-	tsdb, err := timeseries.NewInfluxDb(&config.Server.Influxdb)
+	sink := getAuditSink()
+	if sink != nil {
+		store = audit.NewStore(store, sink)
+	}
+
+	tsdb := getTSDB()
+
+	serv, err := server.NewServer(engine, config.Server, db, store, tsdb)
 	if err != nil {
-		logger.Red("agento", "InfluxDB error: %s", err.Error())
+		logger.Red("agento", "Server error: %s", err.Error())
 		os.Exit(1)
 	}
 
@@ -190,7 +509,7 @@ func run(_ *cobra.Command, _ []string) {
 	wg.Add(1)
 	go scheduler.Loop(&wg, tsdb)
 
-	go api.Init(engine.Group("/api"), store, emitter, db)
+	go api.Init(engine.Group("/api"), store, emitter, db, tsdb)
 
 	wg.Wait()
 }
@@ -203,33 +522,181 @@ func runOnce(_ *cobra.Command, _ []string) {
 	store := getStore(emitter)
 	core.AddLocalhost(userdb.God, store)
 
-	probes, _ := store.GetAllProbes(userdb.God, userdb.God.GetId())
+	for _, discovery := range getDiscoveries(store) {
+		err := discovery.Sync()
+		if err != nil {
+			logger.Red("agento", "Discovery %s failed: %s", discovery.ID, err.Error())
+		}
+	}
+
+	probes, _ := store.GetAllProbes(userdb.God, "")
 	fmt.Printf("Probes: %d\n", len(probes))
 	for _, probe := range probes {
 		logger.Green("agento", "Gathering for probe %s", probe.ID)
 
-		agent := probe.Agent()
-
 		host, err := store.GetHost(userdb.God, probe.HostID)
 		if err != nil {
 			logger.Red("agento", "Error finding host %s: %s", probe.HostID, err.Error())
 			continue
 		}
 
-		err = agent.Gather(host.Transport())
+		transport, err := host.Transport()
 		if err != nil {
-			logger.Red("agento", "Error gathering %s: %s", probe.ID, err.Error())
+			logger.Red("agento", "Error connecting to %s: %s", host.Name, err.Error())
 			continue
 		}
 
-		for _, point := range agent.GetPoints() {
-			// Tag all points with hostname and arbitrary tags.
-			point.Tags["hostname"] = host.Name
-			for key, value := range probe.Tags {
-				point.Tags[key] = value
-			}
+		points, err := probe.Gather(host, transport)
+		if err != nil {
+			logger.Red("agento", "Error gathering %s: %s", probe.ID, err.Error())
+		}
 
+		for _, point := range points {
 			fmt.Printf("%s\n", point.InfluxDBPoint().String())
 		}
 	}
 }
+
+func runRelay(_ *cobra.Command, _ []string) {
+	loadConfig()
+
+	if config.Relay.URL == "" {
+		logger.Red("agento", "Configuration error: No relay URL")
+		os.Exit(1)
+	}
+
+	relaytransport.Connect(config.Relay, localtransport.NewLocalTransport().(plugins.Transport))
+}
+
+func probeTest(_ *cobra.Command, _ []string) {
+	loadConfig()
+
+	if testAgent == "" {
+		logger.Red("agento", "Please specify an agent using --agent")
+		os.Exit(1)
+	}
+
+	agentConfig, err := plugins.ParseParameters(testAgent, testParams)
+	if err != nil {
+		logger.Red("agento", "Agent error: %s", err.Error())
+		os.Exit(1)
+	}
+
+	transportConfig, err := plugins.ParseParameters(testTransport, testTransportParams)
+	if err != nil {
+		logger.Red("agento", "Transport error: %s", err.Error())
+		os.Exit(1)
+	}
+
+	tags := make(map[string]string)
+	for _, tag := range testTags {
+		kv := strings.SplitN(tag, "=", 2)
+		if len(kv) != 2 {
+			logger.Red("agento", "Tag '%s' is not in the form key=value", tag)
+			os.Exit(1)
+		}
+
+		tags[kv[0]] = kv[1]
+	}
+
+	hostname, _ := os.Hostname()
+
+	// Parameters given on the command line are written by the operator.
+	host := &core.Host{
+		AccountID:       userdb.God.GetAccountId(),
+		Name:            hostname,
+		TransportID:     testTransport,
+		TransportConfig: transportConfig,
+	}
+
+	probe := &core.Probe{
+		AccountID:   userdb.God.GetAccountId(),
+		AgentID:     testAgent,
+		AgentConfig: agentConfig,
+		Tags:        tags,
+	}
+
+	var transport plugins.Transport
+
+	if testReplay != "" {
+		transport, err = recordtransport.LoadReplay(testReplay)
+	} else {
+		transport, err = host.NewTransport()
+	}
+
+	if err != nil {
+		logger.Red("agento", "Transport error: %s", err.Error())
+		os.Exit(1)
+	}
+
+	var recorder *recordtransport.Recorder
+	if testRecord != "" {
+		recorder = recordtransport.NewRecorder(transport)
+		transport = recorder
+	}
+
+	points, err := probe.Gather(host, transport)
+
+	if recorder != nil {
+		saveErr := recorder.Fixture().Save(testRecord)
+		if saveErr != nil {
+			logger.Red("agento", "Error saving fixture: %s", saveErr.Error())
+			os.Exit(1)
+		}
+	}
+
+	for _, point := range points {
+		fmt.Printf("%s\n", point.InfluxDBPoint().String())
+	}
+
+	if err != nil {
+		logger.Red("agento", "Error gathering: %s", err.Error())
+		os.Exit(1)
+	}
+}
+
+func secretEncrypt(_ *cobra.Command, _ []string) {
+	loadConfig()
+
+	plaintext, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		logger.Red("agento", "Error reading secret: %s", err.Error())
+		os.Exit(1)
+	}
+
+	encrypted, err := secret.Encrypt(strings.TrimSpace(string(plaintext)))
+	if err != nil {
+		logger.Red("agento", "Error encrypting secret: %s", err.Error())
+		os.Exit(1)
+	}
+
+	fmt.Printf("%s\n", encrypted)
+}
+
+func hostkeyList(_ *cobra.Command, _ []string) {
+	keys, err := ssh.DefaultKnownHosts.List()
+	if err != nil {
+		logger.Red("agento", "Error reading host keys: %s", err.Error())
+		os.Exit(1)
+	}
+
+	for _, key := range keys {
+		fmt.Printf("%-8s %-30s %-20s %s\n", key.Status, key.Address, key.Type, key.Fingerprint)
+	}
+}
+
+func hostkeyApprove(_ *cobra.Command, args []string) {
+	err := ssh.DefaultKnownHosts.Approve(args[0], args[1])
+	if err != nil {
+		logger.Red("agento", "Error approving host key: %s", err.Error())
+		os.Exit(1)
+	}
+}
+
+func hostkeyRevoke(_ *cobra.Command, args []string) {
+	err := ssh.DefaultKnownHosts.Revoke(args[0], args[1])
+	if err != nil {
+		logger.Red("agento", "Error revoking host key: %s", err.Error())
+		os.Exit(1)
+	}
+}
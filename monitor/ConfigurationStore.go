@@ -1,7 +1,6 @@
 package monitor
 
 import (
-	"fmt"
 	"sync"
 
 	"github.com/BurntSushi/toml"
@@ -72,24 +71,37 @@ func NewConfigurationStore(config *configuration.Configuration, changes core.Bro
 	return s, nil
 }
 
-// GetAllHosts returns the complete list of hosts from configuration file.
-func (s *ConfigurationStore) GetAllHosts(_ userdb.Subject, _ string) ([]core.Host, error) {
-	l := len(s.hosts)
-	hosts := make([]core.Host, l, l)
-	i := 0
-	s.probesLock.RLock()
-	for _, host := range s.hosts {
-		hosts[i] = host
+// GetAllHosts returns the list of hosts belonging to accountID. If accountID
+// is empty, hosts from all accounts are returned.
+func (s *ConfigurationStore) GetAllHosts(subject userdb.Subject, accountID string) ([]core.Host, error) {
+	err := subject.CanAccess(userdb.ObjectProxy(accountID))
+	if err != nil {
+		return nil, err
+	}
 
-		i++
+	s.hostsLock.RLock()
+	hosts := make([]core.Host, 0, len(s.hosts))
+	for _, host := range s.hosts {
+		if accountID == "" || host.AccountID == accountID {
+			hosts = append(hosts, host)
+		}
 	}
-	s.probesLock.RUnlock()
+	s.hostsLock.RUnlock()
 
 	return hosts, nil
 }
 
 // AddHost adds a host to memory, not to the configuration file.
-func (s *ConfigurationStore) AddHost(_ userdb.Subject, host *core.Host) error {
+func (s *ConfigurationStore) AddHost(subject userdb.Subject, host *core.Host) error {
+	if host.AccountID == "" {
+		host.AccountID = subject.GetId()
+	}
+
+	err := subject.CanAccess(host)
+	if err != nil {
+		return err
+	}
+
 	if host.ID == "" {
 		host.ID = core.RandomString(20)
 	}
@@ -104,60 +116,105 @@ func (s *ConfigurationStore) AddHost(_ userdb.Subject, host *core.Host) error {
 }
 
 // GetHost will return the host with the given id.
-func (s *ConfigurationStore) GetHost(_ userdb.Subject, id string) (*core.Host, error) {
+func (s *ConfigurationStore) GetHost(subject userdb.Subject, id string) (*core.Host, error) {
 	s.hostsLock.RLock()
-	defer s.hostsLock.RUnlock()
-
 	host, found := s.hosts[id]
+	s.hostsLock.RUnlock()
+
 	if !found {
 		return nil, core.ErrHostNotFound
 	}
 
+	err := subject.CanAccess(&host)
+	if err != nil {
+		return nil, err
+	}
+
 	return &host, nil
 }
 
 // GetHostByName searches for a host named name.
-func (s *ConfigurationStore) GetHostByName(_ userdb.Subject, name string) (*core.Host, error) {
+func (s *ConfigurationStore) GetHostByName(subject userdb.Subject, name string) (*core.Host, error) {
 	s.hostsLock.RLock()
 	defer s.hostsLock.RUnlock()
 
 	for _, host := range s.hosts {
 		if host.Name == name {
+			err := subject.CanAccess(&host)
+			if err != nil {
+				return nil, err
+			}
+
 			return &host, nil
 		}
 	}
 
-	return nil, fmt.Errorf("Host '%s' not found", name)
+	return nil, core.ErrHostNotFound
+}
+
+// UpdateHost changes a host in memory, not in the configuration file.
+func (s *ConfigurationStore) UpdateHost(subject userdb.Subject, host *core.Host) error {
+	_, err := s.GetHost(subject, host.ID)
+	if err != nil {
+		return err
+	}
+
+	err = subject.CanAccess(host)
+	if err != nil {
+		return err
+	}
+
+	host.ResetTransport()
+
+	s.hostsLock.Lock()
+	s.hosts[host.ID] = *host
+	s.hostsLock.Unlock()
+
+	s.changes.Broadcast("hostchange", host)
+
+	return nil
 }
 
 // DeleteHost will remove a host from memory, but not from configuration file.
-func (s *ConfigurationStore) DeleteHost(_ userdb.Subject, id string) error {
+func (s *ConfigurationStore) DeleteHost(subject userdb.Subject, id string) error {
 	s.hostsLock.Lock()
 	host, found := s.hosts[id]
 	if !found {
+		s.hostsLock.Unlock()
 		return core.ErrHostNotFound
 	}
 
+	err := subject.CanAccess(&host)
+	if err != nil {
+		s.hostsLock.Unlock()
+		return err
+	}
+
 	delete(s.hosts, id)
 
 	s.hostsLock.Unlock()
 
+	host.ResetTransport()
+
 	s.changes.Broadcast("hostdelete", &host)
 
 	return nil
 }
 
-// GetAllProbes return all known probes.
-func (s *ConfigurationStore) GetAllProbes(_ userdb.Subject, _ string) ([]core.Probe, error) {
-	l := len(s.probes)
-	probes := make([]core.Probe, l, l)
-	i := 0
+// GetAllProbes return all known probes belonging to accountID. If accountID
+// is empty, probes from all accounts are returned.
+func (s *ConfigurationStore) GetAllProbes(subject userdb.Subject, accountID string) ([]core.Probe, error) {
+	err := subject.CanAccess(userdb.ObjectProxy(accountID))
+	if err != nil {
+		return nil, err
+	}
 
 	s.probesLock.RLock()
+	probes := make([]core.Probe, 0, len(s.probes))
 	for _, probe := range s.probes {
-		probes[i] = probe
-
-		i++
+		if accountID == "" || probe.AccountID == accountID {
+			probes = append(probes, probe)
+		}
 	}
 	s.probesLock.RUnlock()
 
@@ -165,7 +222,12 @@ func (s *ConfigurationStore) GetAllProbes(_ userdb.Subject, _ string) ([]core.Pr
 }
 
 // AddProbe adds a probe to memory.
-func (s *ConfigurationStore) AddProbe(_ userdb.Subject, probe *core.Probe) error {
+func (s *ConfigurationStore) AddProbe(subject userdb.Subject, probe *core.Probe) error {
+	err := s.checkProbeAccess(subject, probe)
+	if err != nil {
+		return err
+	}
+
 	probe.ID = core.RandomString(20)
 
 	s.probesLock.Lock()
@@ -178,20 +240,35 @@ func (s *ConfigurationStore) AddProbe(_ userdb.Subject, probe *core.Probe) error
 }
 
 // GetProbe will return a probe identified by id if found.
-func (s *ConfigurationStore) GetProbe(_ userdb.Subject, id string) (*core.Probe, error) {
+func (s *ConfigurationStore) GetProbe(subject userdb.Subject, id string) (*core.Probe, error) {
 	s.probesLock.RLock()
-	defer s.probesLock.RUnlock()
-
 	probe, found := s.probes[id]
+	s.probesLock.RUnlock()
+
 	if !found {
 		return nil, core.ErrProbeNotFound
 	}
 
+	err := subject.CanAccess(&probe)
+	if err != nil {
+		return nil, err
+	}
+
 	return &probe, nil
 }
 
 // UpdateProbe accepts the write but otherwise does no writing to disk.
-func (s *ConfigurationStore) UpdateProbe(_ userdb.Subject, probe *core.Probe) error {
+func (s *ConfigurationStore) UpdateProbe(subject userdb.Subject, probe *core.Probe) error {
+	_, err := s.GetProbe(subject, probe.ID)
+	if err != nil {
+		return err
+	}
+
+	err = s.checkProbeAccess(subject, probe)
+	if err != nil {
+		return err
+	}
+
 	s.probesLock.Lock()
 	s.probes[probe.ID] = *probe
 	s.probesLock.Unlock()
@@ -202,7 +279,7 @@ func (s *ConfigurationStore) UpdateProbe(_ userdb.Subject, probe *core.Probe) er
 }
 
 // DeleteProbe does delete the probe from memory but not from file.
-func (s *ConfigurationStore) DeleteProbe(_ userdb.Subject, id string) error {
+func (s *ConfigurationStore) DeleteProbe(subject userdb.Subject, id string) error {
 	s.probesLock.Lock()
 	defer s.probesLock.Unlock()
 
@@ -211,9 +288,27 @@ func (s *ConfigurationStore) DeleteProbe(_ userdb.Subject, id string) error {
 		return core.ErrProbeNotFound
 	}
 
+	err := subject.CanAccess(&probe)
+	if err != nil {
+		return err
+	}
+
 	delete(s.probes, id)
 
 	s.changes.Broadcast("probedelete", &probe)
 
 	return nil
 }
+
+// checkProbeAccess makes sure that subject can access both probe and the host
+// it's probing.
+func (s *ConfigurationStore) checkProbeAccess(subject userdb.Subject, probe *core.Probe) error {
+	err := subject.CanAccess(probe)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.GetHost(subject, probe.HostID)
+
+	return err
+}
@@ -0,0 +1,285 @@
+package monitor
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/abrander/agento/core"
+	"github.com/abrander/agento/logger"
+	"github.com/abrander/agento/plugins"
+	"github.com/abrander/agento/userdb"
+)
+
+type (
+	// Discovery adds and deletes hosts found by a discoverer. Probes for
+	// discovered hosts are added from templates.
+	Discovery struct {
+		ID           string
+		DiscovererID string
+		Interval     time.Duration
+
+		// Probes are the templates of probes added for each host.
+		Probes []core.Probe
+
+		// config is the configuration of the discoverer with unresolved
+		// secrets.
+		config     map[string]interface{}
+		discoverer plugins.Discoverer
+		store      core.Store
+		subject    userdb.Subject
+
+		lock   sync.Mutex
+		synced bool
+	}
+
+	discoveryConfig struct {
+		Discoverer string           `toml:"discoverer"`
+		Interval   int              `toml:"interval"`
+		Probes     []toml.Primitive `toml:"probe"`
+	}
+)
+
+const (
+	// DefaultDiscoveryInterval is used for discoveries without an
+	// interval.
+	DefaultDiscoveryInterval = 30 * time.Second
+)
+
+// NewDiscovery decodes the discovery id from the configuration file. Hosts
+// and probes are added to store using subject.
+func NewDiscovery(id string, prim toml.Primitive, store core.Store, subject userdb.Subject) (*Discovery, error) {
+	var c discoveryConfig
+
+	err := toml.PrimitiveDecode(prim, &c)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Discovery{
+		ID:           id,
+		DiscovererID: c.Discoverer,
+		Interval:     time.Duration(c.Interval) * time.Second,
+		store:        store,
+		subject:      subject,
+	}
+
+	if d.Interval <= 0 {
+		d.Interval = DefaultDiscoveryInterval
+	}
+
+	err = toml.PrimitiveDecode(prim, &d.config)
+	if err != nil {
+		return nil, err
+	}
+
+	delete(d.config, "discoverer")
+	delete(d.config, "interval")
+	delete(d.config, "probe")
+
+	d.discoverer, err = plugins.GetDiscoverer(d.DiscovererID)
+	if err != nil {
+		return nil, err
+	}
+
+	resolved, err := plugins.ResolveSecrets(d.DiscovererID, d.config)
+	if err != nil {
+		return nil, err
+	}
+
+	j, _ := json.Marshal(resolved)
+	json.Unmarshal(j, d.discoverer)
+
+	for _, primitiveProbe := range c.Probes {
+		var probe core.Probe
+
+		err = probe.DecodeTOML(store, primitiveProbe)
+		if err != nil {
+			return nil, err
+		}
+
+		d.Probes = append(d.Probes, probe)
+	}
+
+	return d, nil
+}
+
+// Loop synchronizes the hosts every interval. It never returns.
+func (d *Discovery) Loop() {
+	for {
+		err := d.Sync()
+		if err != nil {
+			logger.Red("discovery", "[%s] %s", d.ID, err.Error())
+		}
+
+		time.Sleep(d.Interval)
+	}
+}
+
+// Sync adds hosts for new targets, updates changed hosts and deletes hosts
+// no longer found together with their probes. Nothing is deleted if the
+// discoverer fails.
+func (d *Discovery) Sync() error {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	targets, err := d.discoverer.Discover()
+	if err != nil {
+		return err
+	}
+
+	hosts, err := d.store.GetAllHosts(d.subject, "")
+	if err != nil {
+		return err
+	}
+
+	existing := make(map[string]core.Host)
+	for _, host := range hosts {
+		if host.Discovery == d.ID {
+			existing[host.Name] = host
+		}
+	}
+
+	for _, target := range targets {
+		d.restoreSecrets(&target)
+
+		host, found := existing[target.Name]
+		delete(existing, target.Name)
+
+		if !found {
+			host = core.Host{
+				AccountID:       userdb.God.GetAccountId(),
+				Name:            target.Name,
+				TransportID:     target.TransportID,
+				TransportConfig: target.TransportConfig,
+				Discovery:       d.ID,
+			}
+
+			err = d.store.AddHost(d.subject, &host)
+			if err != nil {
+				return err
+			}
+
+			logger.Green("discovery", "[%s] Added %s", d.ID, host.Name)
+
+			err = d.addProbes(&host, &target)
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if host.TransportID != target.TransportID || !sameConfig(host.TransportConfig, target.TransportConfig) {
+			host.TransportID = target.TransportID
+			host.TransportConfig = target.TransportConfig
+
+			err = d.store.UpdateHost(d.subject, &host)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Hosts saved by an earlier run of Agento get new probes, the
+		// templates may have changed.
+		if !d.synced {
+			err = d.deleteProbes(&host)
+			if err != nil {
+				return err
+			}
+
+			err = d.addProbes(&host, &target)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, host := range existing {
+		err = d.deleteProbes(&host)
+		if err != nil {
+			return err
+		}
+
+		err = d.store.DeleteHost(d.subject, host.ID)
+		if err != nil {
+			return err
+		}
+
+		logger.Yellow("discovery", "[%s] Deleted %s", d.ID, host.Name)
+	}
+
+	d.synced = true
+
+	return nil
+}
+
+// restoreSecrets replaces secrets copied from the configuration of the
+// discoverer with the unresolved references, to avoid saving them with the
+// hosts.
+func (d *Discovery) restoreSecrets(target *plugins.Target) {
+	secrets := plugins.SecretParameters(d.DiscovererID)
+
+	for key := range target.TransportConfig {
+		value, found := d.config[key]
+		if found && secrets[key] {
+			target.TransportConfig[key] = value
+		}
+	}
+}
+
+// addProbes adds a probe to host for each template.
+func (d *Discovery) addProbes(host *core.Host, target *plugins.Target) error {
+	for _, template := range d.Probes {
+		probe := template
+		probe.HostID = host.ID
+		probe.Tags = make(map[string]string)
+
+		for key, value := range target.Tags {
+			probe.Tags[key] = value
+		}
+
+		for key, value := range template.Tags {
+			probe.Tags[key] = value
+		}
+
+		err := d.store.AddProbe(d.subject, &probe)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// deleteProbes deletes all probes of host.
+func (d *Discovery) deleteProbes(host *core.Host) error {
+	probes, err := d.store.GetAllProbes(d.subject, "")
+	if err != nil {
+		return err
+	}
+
+	for _, probe := range probes {
+		if probe.HostID != host.ID {
+			continue
+		}
+
+		err = d.store.DeleteProbe(d.subject, probe.ID)
+		if err != nil && err != core.ErrProbeNotFound {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sameConfig compares configurations as JSON. Configurations read from a
+// database may use other types for numbers.
+func sameConfig(a map[string]interface{}, b map[string]interface{}) bool {
+	ja, _ := json.Marshal(a)
+	jb, _ := json.Marshal(b)
+
+	return string(ja) == string(jb)
+}
@@ -0,0 +1,212 @@
+package monitor
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+
+	"github.com/abrander/agento/configuration"
+	"github.com/abrander/agento/core"
+	"github.com/abrander/agento/plugins"
+	"github.com/abrander/agento/userdb"
+)
+
+type (
+	// testDiscoverer returns the targets of testTargets.
+	testDiscoverer struct {
+		Password string `json:"password" secret:"true"`
+	}
+
+	nullBroadcaster struct{}
+)
+
+var (
+	testTargets []plugins.Target
+	testError   error
+)
+
+func init() {
+	plugins.Register("testdiscovery", func() interface{} { return &testDiscoverer{} })
+}
+
+func (t *testDiscoverer) GetDoc() *plugins.Doc {
+	return plugins.NewDoc("Test discovery")
+}
+
+func (t *testDiscoverer) Discover() ([]plugins.Target, error) {
+	targets := make([]plugins.Target, len(testTargets))
+
+	for i, target := range testTargets {
+		target.TransportConfig = map[string]interface{}{
+			"host":     target.TransportConfig["host"],
+			"password": t.Password,
+		}
+
+		targets[i] = target
+	}
+
+	return targets, testError
+}
+
+func (nullBroadcaster) Broadcast(typ string, payload userdb.Object) {
+}
+
+func testDiscovery(t *testing.T) (*Discovery, *ConfigurationStore) {
+	store, err := NewConfigurationStore(&configuration.Configuration{}, nullBroadcaster{})
+	if err != nil {
+		t.Fatalf("NewConfigurationStore() failed: %s", err.Error())
+	}
+
+	var c struct {
+		Discovery map[string]toml.Primitive `toml:"discovery"`
+	}
+
+	_, err = toml.Decode(`
+[discovery.test]
+discoverer = "testdiscovery"
+password = "env:AGENTO_TEST_PASSWORD"
+
+[[discovery.test.probe]]
+agent = "null"
+interval = 5
+tags = { role = "db" }
+`, &c)
+	if err != nil {
+		t.Fatalf("Decode() failed: %s", err.Error())
+	}
+
+	os.Setenv("AGENTO_TEST_PASSWORD", "hunter2")
+	defer os.Unsetenv("AGENTO_TEST_PASSWORD")
+
+	d, err := NewDiscovery("test", c.Discovery["test"], store, userdb.God)
+	if err != nil {
+		t.Fatalf("NewDiscovery() failed: %s", err.Error())
+	}
+
+	return d, store
+}
+
+func target(name string, host string) plugins.Target {
+	return plugins.Target{
+		Name:            name,
+		TransportID:     "sshtransport",
+		TransportConfig: map[string]interface{}{"host": host},
+		Tags:            map[string]string{"node": "node1"},
+	}
+}
+
+// discovered returns the discovered hosts by name and the number of probes.
+func discovered(t *testing.T, store *ConfigurationStore) (map[string]core.Host, int) {
+	hosts, _ := store.GetAllHosts(userdb.God, "")
+	probes, _ := store.GetAllProbes(userdb.God, "")
+
+	found := make(map[string]core.Host)
+	for _, host := range hosts {
+		if host.Discovery != "test" {
+			t.Errorf("Host %s not marked as discovered", host.Name)
+		}
+
+		found[host.Name] = host
+	}
+
+	for _, probe := range probes {
+		if probe.AgentID != "null" || probe.Tags["role"] != "db" || probe.Tags["node"] != "node1" || probe.Interval != 5e9 {
+			t.Errorf("Wrong probe: %+v", probe)
+		}
+
+		if _, err := store.GetHost(userdb.God, probe.HostID); err != nil {
+			t.Errorf("Probe %s for deleted host", probe.ID)
+		}
+	}
+
+	return found, len(probes)
+}
+
+func TestDiscoverySync(t *testing.T) {
+	d, store := testDiscovery(t)
+
+	testTargets = []plugins.Target{target("a", "10.0.0.1"), target("b", "10.0.0.2")}
+	testError = nil
+
+	err := d.Sync()
+	if err != nil {
+		t.Fatalf("Sync() failed: %s", err.Error())
+	}
+
+	hosts, probes := discovered(t, store)
+	if len(hosts) != 2 || probes != 2 {
+		t.Fatalf("Found %d hosts and %d probes, expected 2 of each", len(hosts), probes)
+	}
+
+	// Secrets must be saved as references.
+	if hosts["a"].TransportConfig["password"] != "env:AGENTO_TEST_PASSWORD" {
+		t.Errorf("Secret saved as '%v'", hosts["a"].TransportConfig["password"])
+	}
+
+	testTargets = []plugins.Target{target("b", "10.0.0.3"), target("c", "10.0.0.4")}
+
+	err = d.Sync()
+	if err != nil {
+		t.Fatalf("Sync() failed: %s", err.Error())
+	}
+
+	hosts, probes = discovered(t, store)
+	if len(hosts) != 2 || probes != 2 {
+		t.Fatalf("Found %d hosts and %d probes, expected 2 of each", len(hosts), probes)
+	}
+
+	if _, found := hosts["a"]; found {
+		t.Errorf("Host a was not deleted")
+	}
+
+	if hosts["b"].TransportConfig["host"] != "10.0.0.3" {
+		t.Errorf("Host b was not updated: %v", hosts["b"].TransportConfig)
+	}
+
+	// Nothing must be deleted if discovery fails.
+	testTargets = nil
+	testError = errors.New("API down")
+
+	err = d.Sync()
+	if err != testError {
+		t.Errorf("Sync() returned %v", err)
+	}
+
+	hosts, _ = discovered(t, store)
+	if len(hosts) != 2 {
+		t.Errorf("Found %d hosts after failed discovery", len(hosts))
+	}
+}
+
+func TestDiscoveryRestart(t *testing.T) {
+	d, store := testDiscovery(t)
+
+	testTargets = []plugins.Target{target("a", "10.0.0.1")}
+	testError = nil
+
+	d.Sync()
+
+	// A new discovery using the same store must replace the probes, not
+	// duplicate them.
+	restarted := &Discovery{
+		ID:           d.ID,
+		DiscovererID: d.DiscovererID,
+		Probes:       d.Probes,
+		config:       d.config,
+		discoverer:   d.discoverer,
+		store:        store,
+		subject:      userdb.God,
+	}
+
+	err := restarted.Sync()
+	if err != nil {
+		t.Fatalf("Sync() failed: %s", err.Error())
+	}
+
+	hosts, probes := discovered(t, store)
+	if len(hosts) != 1 || probes != 1 {
+		t.Errorf("Found %d hosts and %d probes after restart, expected 1 of each", len(hosts), probes)
+	}
+}
@@ -45,8 +45,19 @@ func NewMongoStore(config configuration.MongoConfiguration, changes core.Broadca
 	return m, nil
 }
 
+// accountQuery returns a query matching all documents belonging to accountID.
+// An empty accountID will match all documents.
+func accountQuery(accountID string) bson.M {
+	if accountID == "" {
+		return nil
+	}
+
+	return bson.M{"accountId": accountID}
+}
+
 // GetAllProbes will return all probes belonging to accountID that is
-// accessible by subject.
+// accessible by subject. If accountID is empty, probes from all accounts
+// will be returned.
 func (s *MongoStore) GetAllProbes(subject userdb.Subject, accountID string) ([]core.Probe, error) {
 	var probes []core.Probe
 
@@ -55,7 +66,7 @@ func (s *MongoStore) GetAllProbes(subject userdb.Subject, accountID string) ([]c
 		return nil, err
 	}
 
-	err = s.probeCollection.Find(bson.M{"accountID": bson.ObjectIdHex(accountID)}).All(&probes)
+	err = s.probeCollection.Find(accountQuery(accountID)).All(&probes)
 	if err != nil {
 		return nil, err
 	}
@@ -71,7 +82,11 @@ func (s *MongoStore) GetProbe(subject userdb.Subject, id string) (*core.Probe, e
 		return nil, core.ErrProbeNotFound
 	}
 
-	err := s.probeCollection.FindId(bson.ObjectIdHex(id)).One(&probe)
+	err := s.probeCollection.FindId(id).One(&probe)
+	if err == mgo.ErrNotFound {
+		return nil, core.ErrProbeNotFound
+	}
+
 	if err != nil {
 		logger.Red("mongostore", "Error getting probe %s from Mongo: %s", id, err.Error())
 		return nil, err
@@ -92,9 +107,20 @@ func (s *MongoStore) UpdateProbe(subject userdb.Subject, probe *core.Probe) erro
 		return err
 	}
 
+	// The probe could have been moved to another account or host.
+	err = subject.CanAccess(probe)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.GetHost(subject, probe.HostID)
+	if err != nil {
+		return err
+	}
+
 	s.changes.Broadcast("probechange", probe)
 
-	return s.probeCollection.UpdateId(bson.ObjectIdHex(probe.ID), probe)
+	return s.probeCollection.UpdateId(probe.ID, probe)
 }
 
 // AddProbe will add a new probe. Everyone can add probes, but subject
@@ -107,6 +133,12 @@ func (s *MongoStore) AddProbe(subject userdb.Subject, probe *core.Probe) error {
 		return err
 	}
 
+	// Make sure the subject is not probing a host it doesn't own.
+	_, err = s.GetHost(subject, probe.HostID)
+	if err != nil {
+		return err
+	}
+
 	s.changes.Broadcast("probeadd", probe)
 
 	return s.probeCollection.Insert(probe)
@@ -125,10 +157,11 @@ func (s *MongoStore) DeleteProbe(subject userdb.Subject, id string) error {
 
 	s.changes.Broadcast("probedelete", probe)
 
-	return s.probeCollection.RemoveId(bson.ObjectIdHex(id))
+	return s.probeCollection.RemoveId(id)
 }
 
-// GetAllHosts will return all hosts accessible by subject.
+// GetAllHosts will return all hosts belonging to accountID accessible by
+// subject. If accountID is empty, hosts from all accounts will be returned.
 func (s *MongoStore) GetAllHosts(subject userdb.Subject, accountID string) ([]core.Host, error) {
 	var hosts []core.Host
 
@@ -137,7 +170,7 @@ func (s *MongoStore) GetAllHosts(subject userdb.Subject, accountID string) ([]co
 		return hosts, err
 	}
 
-	err = s.hostCollection.Find(bson.M{"accountID": bson.ObjectIdHex(accountID)}).All(&hosts)
+	err = s.hostCollection.Find(accountQuery(accountID)).All(&hosts)
 	if err != nil {
 		logger.Red("mongostore", "Error getting hosts from Mongo: %s", err.Error())
 	}
@@ -150,6 +183,10 @@ func (s *MongoStore) GetHostByName(subject userdb.Subject, name string) (*core.H
 	var host core.Host
 
 	err := s.hostCollection.Find(bson.M{"name": name}).One(&host)
+	if err == mgo.ErrNotFound {
+		return nil, core.ErrHostNotFound
+	}
+
 	if err != nil {
 		return nil, err
 	}
@@ -170,7 +207,11 @@ func (s *MongoStore) GetHost(subject userdb.Subject, id string) (*core.Host, err
 		return nil, core.ErrHostNotFound
 	}
 
-	err := s.hostCollection.FindId(bson.ObjectIdHex(id)).One(&host)
+	err := s.hostCollection.FindId(id).One(&host)
+	if err == mgo.ErrNotFound {
+		return nil, core.ErrHostNotFound
+	}
+
 	if err != nil {
 		logger.Red("mongostore", "Error getting host from Mongo: %s", err.Error())
 		return nil, err
@@ -190,7 +231,10 @@ func (s *MongoStore) GetHost(subject userdb.Subject, id string) (*core.Host, err
 func (s *MongoStore) AddHost(subject userdb.Subject, host *core.Host) error {
 	host.ID = bson.NewObjectId().Hex()
 
-	host.AccountID = subject.GetId()
+	if host.AccountID == "" {
+		host.AccountID = subject.GetId()
+	}
+
 	err := subject.CanAccess(host)
 	if err != nil {
 		return err
@@ -201,6 +245,26 @@ func (s *MongoStore) AddHost(subject userdb.Subject, host *core.Host) error {
 	return s.hostCollection.Insert(host)
 }
 
+// UpdateHost will save host if allowed by subject.
+func (s *MongoStore) UpdateHost(subject userdb.Subject, host *core.Host) error {
+	_, err := s.GetHost(subject, host.ID)
+	if err != nil {
+		return err
+	}
+
+	// The host could have been moved to another account.
+	err = subject.CanAccess(host)
+	if err != nil {
+		return err
+	}
+
+	host.ResetTransport()
+
+	s.changes.Broadcast("hostchange", host)
+
+	return s.hostCollection.UpdateId(host.ID, host)
+}
+
 // DeleteHost will delete a host matching id.
 func (s *MongoStore) DeleteHost(subject userdb.Subject, id string) error {
 	if !bson.IsObjectIdHex(id) {
@@ -217,7 +281,9 @@ func (s *MongoStore) DeleteHost(subject userdb.Subject, id string) error {
 		return err
 	}
 
+	host.ResetTransport()
+
 	s.changes.Broadcast("hostdelete", host)
 
-	return s.hostCollection.RemoveId(bson.ObjectIdHex(id))
+	return s.hostCollection.RemoveId(id)
 }
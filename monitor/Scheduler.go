@@ -7,6 +7,7 @@ import (
 
 	"github.com/abrander/agento/core"
 	"github.com/abrander/agento/logger"
+	"github.com/abrander/agento/plugins"
 	"github.com/abrander/agento/timeseries"
 	"github.com/abrander/agento/userdb"
 )
@@ -20,8 +21,8 @@ type (
 )
 
 // NewScheduler will instantiate a new scheduler. The scheduler needs a Store to
-// read/write checks. If the system is not a multiuser system, userdb.God can be
-// used as subject.
+// read/write checks. The subject must be able to access probes from all
+// accounts, for most setups this should be userdb.God.
 func NewScheduler(store core.Store, subject userdb.Subject) *Scheduler {
 	return &Scheduler{
 		store:   store,
@@ -49,7 +50,7 @@ func (s *Scheduler) Loop(wg *sync.WaitGroup, serv timeseries.Database) {
 		// We start by extracting a list of all probes. If this gets too
 		// expensive at some point, we can do it less frequent.
 
-		probes, err := s.store.GetAllProbes(s.subject, userdb.God.GetAccountId())
+		probes, err := s.store.GetAllProbes(s.subject, "")
 
 		if err != nil {
 			logger.Red("scheduler", "Error getting probes from store: %s", err.Error())
@@ -81,7 +82,7 @@ func (s *Scheduler) Loop(wg *sync.WaitGroup, serv timeseries.Database) {
 				probe.NextCheck = t.Add(checkIn)
 				agent := probe.Agent()
 
-				logger.Yellow("scheduler", "[%s] %T:(%+v): start delayed by %s", probe.ID, agent, agent, checkIn)
+				logger.Yellow("scheduler", "[%s] %T:(%s): start delayed by %s", probe.ID, agent, plugins.Redacted(agent), checkIn)
 
 				err = s.store.UpdateProbe(s.subject, &probe)
 				if err != nil {
@@ -96,7 +97,6 @@ func (s *Scheduler) Loop(wg *sync.WaitGroup, serv timeseries.Database) {
 
 				// Execute the probe in its own go routine.
 				go func(probe core.Probe) {
-					agent := probe.Agent()
 					host, err := s.store.GetHost(userdb.God, probe.HostID)
 					if err != nil {
 						logger.Red("scheduler", "[%s] Could not get host '%s': %s", probe.ID, probe.HostID, err.Error())
@@ -106,29 +106,29 @@ func (s *Scheduler) Loop(wg *sync.WaitGroup, serv timeseries.Database) {
 					// Run the job.
 					start := time.Now()
 
-					transport := host.Transport()
-					err = agent.Gather(transport)
+					transport, err := host.Transport()
+
+					var points []*timeseries.Point
+					if err == nil {
+						points, err = probe.Gather(host, transport)
+					}
+
 					if err != nil {
-						logger.Red("scheduler", "[%s] %T(%+v) failed in %s: %s", probe.ID, probe.Agent, probe.Agent, time.Now().Sub(start), err.Error())
+						logger.Red("scheduler", "[%s] %s failed in %s: %s", probe.ID, probe.AgentID, time.Now().Sub(start), err.Error())
+						probe.LastError = err.Error()
 					} else {
-						logger.Green("scheduler", "[%s] %T(%+v) ran in %s", probe.ID, probe.Agent, probe.Agent, time.Now().Sub(start))
-
-						points := agent.GetPoints()
+						logger.Green("scheduler", "[%s] %s ran in %s", probe.ID, probe.AgentID, time.Now().Sub(start))
+						probe.LastError = ""
+					}
 
+					// Points are returned with the error if the agent
+					// gathered some of them.
+					if err == nil || points != nil {
 						if len(points) > 0 {
-							// Tag all points with hostname and arbitrary tags.
-							for _, point := range points {
-								point.Tags["hostname"] = host.Name
-
-								for key, value := range probe.Tags {
-									point.Tags[key] = value
-								}
-							}
-
 							// Write results to TSDB.
 							err = serv.WritePoints(points)
 							if err != nil {
-								logger.Red("scheduler", "[%s] %T(%+v) WritePoints(): %s", probe.ID, probe.Agent, probe.Agent, err.Error())
+								logger.Red("scheduler", "[%s] %s WritePoints(): %s", probe.ID, probe.AgentID, err.Error())
 							}
 						}
 
@@ -143,7 +143,7 @@ func (s *Scheduler) Loop(wg *sync.WaitGroup, serv timeseries.Database) {
 					// Save everything back to store.
 					err = s.store.UpdateProbe(s.subject, &probe)
 					if err != nil {
-						logger.Red("scheduler", "[%s] %T(%+v) UpdateProbe(): %s", probe.ID, probe.Agent, probe.Agent, err.Error())
+						logger.Red("scheduler", "[%s] %s UpdateProbe(): %s", probe.ID, probe.AgentID, err.Error())
 					}
 					// Remove the probe from inFlight map.
 					inFlightLock.Lock()
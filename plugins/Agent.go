@@ -14,8 +14,22 @@ type (
 		Gather(transport Transport) error
 		GetPoints() []*timeseries.Point
 	}
+
+	// PartialError is returned by agents failing to gather some of their
+	// metrics. The points of the agent are still valid.
+	PartialError struct {
+		Err error
+	}
 )
 
+func (e *PartialError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PartialError) Unwrap() error {
+	return e.Err
+}
+
 // GetAgent will return an agent of type id or nil plus an error if the
 // agent was not found.
 func GetAgent(id string) (Agent, error) {
@@ -0,0 +1,82 @@
+package plugins
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// Clock can be implemented by transports able to read the clock of the
+	// remote host.
+	Clock interface {
+		RemoteTime() (time.Time, error)
+	}
+)
+
+var (
+	// ClockSamples is the number of times MeasureOffset will read the
+	// remote clock.
+	ClockSamples = 3
+
+	// ErrNoClock is returned by MeasureOffset if the transport can't read
+	// the remote clock.
+	ErrNoClock = errors.New("transport can not read the remote clock")
+)
+
+// MeasureOffset measures the offset of the remote clock NTP-style. The
+// remote time is assumed to be read halfway through each round trip, and
+// the sample with the shortest round trip is used. A positive offset means
+// the remote clock is ahead of ours.
+func MeasureOffset(transport Transport) (time.Duration, error) {
+	clock, ok := transport.(Clock)
+	if !ok {
+		return 0, ErrNoClock
+	}
+
+	var offset time.Duration
+	delay := time.Duration(-1)
+
+	for i := 0; i < ClockSamples; i++ {
+		t0 := time.Now()
+
+		remote, err := clock.RemoteTime()
+		if err != nil {
+			return 0, err
+		}
+
+		d := time.Since(t0)
+		if delay < 0 || d < delay {
+			delay = d
+			offset = remote.Sub(t0.Add(d / 2))
+		}
+	}
+
+	return offset, nil
+}
+
+// ParseEpoch parses the output of `date +%s.%N`. Implementations of date
+// without %N are accepted with second precision.
+func ParseEpoch(output string) (time.Time, error) {
+	parts := strings.SplitN(strings.TrimSpace(output), ".", 2)
+
+	sec, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	var nsec int64
+	if len(parts) == 2 && len(parts[1]) > 0 && len(parts[1]) <= 9 {
+		// Fractions without %N support are ignored.
+		fraction, err := strconv.ParseInt(parts[1], 10, 64)
+		if err == nil {
+			nsec = fraction
+			for i := len(parts[1]); i < 9; i++ {
+				nsec *= 10
+			}
+		}
+	}
+
+	return time.Unix(sec, nsec), nil
+}
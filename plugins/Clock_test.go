@@ -0,0 +1,77 @@
+package plugins
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type (
+	skewedClock struct {
+		Transport
+		skew  time.Duration
+		calls int
+		err   error
+	}
+)
+
+func (s *skewedClock) RemoteTime() (time.Time, error) {
+	s.calls++
+
+	return time.Now().Add(s.skew), s.err
+}
+
+func TestMeasureOffset(t *testing.T) {
+	clock := &skewedClock{skew: time.Hour}
+
+	offset, err := MeasureOffset(clock)
+	if err != nil {
+		t.Fatalf("MeasureOffset() failed: %s", err.Error())
+	}
+
+	if clock.calls != ClockSamples {
+		t.Errorf("MeasureOffset() read the clock %d times, expected %d", clock.calls, ClockSamples)
+	}
+
+	if offset < time.Hour-time.Second || offset > time.Hour+time.Second {
+		t.Errorf("MeasureOffset() returned %s, expected about 1h", offset)
+	}
+
+	clock.err = errors.New("no date")
+	_, err = MeasureOffset(clock)
+	if err != clock.err {
+		t.Errorf("MeasureOffset() did not return the clock error: %v", err)
+	}
+
+	_, err = MeasureOffset(struct{ Transport }{})
+	if err != ErrNoClock {
+		t.Errorf("MeasureOffset() did not return ErrNoClock: %v", err)
+	}
+}
+
+func TestParseEpoch(t *testing.T) {
+	cases := map[string]time.Time{
+		"1500000000.123456789\n": time.Unix(1500000000, 123456789),
+		"1500000000.5":           time.Unix(1500000000, 500000000),
+		"1500000000.%N\n":        time.Unix(1500000000, 0),
+		"1500000000.N":           time.Unix(1500000000, 0),
+		"1500000000":             time.Unix(1500000000, 0),
+	}
+
+	for input, expected := range cases {
+		parsed, err := ParseEpoch(input)
+		if err != nil {
+			t.Errorf("ParseEpoch(%q) failed: %s", input, err.Error())
+			continue
+		}
+
+		if !parsed.Equal(expected) {
+			t.Errorf("ParseEpoch(%q) returned %s, expected %s", input, parsed, expected)
+		}
+	}
+
+	_, err := ParseEpoch("date: invalid format")
+	if err == nil {
+		t.Errorf("ParseEpoch() accepted garbage")
+	}
+}
@@ -0,0 +1,95 @@
+package plugins
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/abrander/agento/configuration"
+)
+
+// ContainerPath returns path as seen from inside a container. Paths in the
+// proc and sys filesystems of Agento are translated to /proc and /sys, in
+// case Agento is configured with other paths.
+func ContainerPath(path string) string {
+	for from, to := range map[string]string{configuration.ProcPath: "/proc", configuration.SysfsPath: "/sys"} {
+		if from == to {
+			continue
+		}
+
+		if path == from || strings.HasPrefix(path, from+"/") {
+			return to + path[len(from):]
+		}
+	}
+
+	return path
+}
+
+// OpenExec reads path using cat through transport. This is useful for
+// transports without file access, and for files in /proc and /sys which
+// have no size.
+func OpenExec(transport Transport, path string) (io.ReadCloser, error) {
+	var stdout, stderr bytes.Buffer
+
+	err := Run(transport, &Command{
+		Path:   "cat",
+		Args:   []string{path},
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+
+	var exitError *ExitError
+	if errors.As(err, &exitError) {
+		return nil, &os.PathError{Op: "open", Path: path, Err: errors.New(strings.TrimSpace(stderr.String()))}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.NopCloser(&stdout), nil
+}
+
+// StatfsExec fills buf using stat through transport.
+func StatfsExec(transport Transport, path string, buf *syscall.Statfs_t) error {
+	stdout, stderr, err := transport.Exec("stat", "-f", "-c", "%b %f %a %S %c %d %l %s", path)
+	if err != nil {
+		message, _ := ioutil.ReadAll(stderr)
+
+		return &os.PathError{Op: "statfs", Path: path, Err: errors.New(strings.TrimSpace(string(message)))}
+	}
+
+	output, _ := ioutil.ReadAll(stdout)
+
+	fields := strings.Fields(string(output))
+	if len(fields) != 8 {
+		return fmt.Errorf("unexpected output from stat: '%s'", strings.TrimSpace(string(output)))
+	}
+
+	values := make([]uint64, len(fields))
+	for i, field := range fields {
+		values[i], err = strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return err
+		}
+	}
+
+	*buf = syscall.Statfs_t{
+		Blocks:  values[0],
+		Bfree:   values[1],
+		Bavail:  values[2],
+		Frsize:  int64(values[3]),
+		Files:   values[4],
+		Ffree:   values[5],
+		Namelen: int64(values[6]),
+		Bsize:   int64(values[7]),
+	}
+
+	return nil
+}
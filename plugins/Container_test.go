@@ -0,0 +1,27 @@
+package plugins
+
+import (
+	"testing"
+
+	"github.com/abrander/agento/configuration"
+)
+
+func TestContainerPath(t *testing.T) {
+	procPath := configuration.ProcPath
+	configuration.ProcPath = "/host/proc"
+	defer func() {
+		configuration.ProcPath = procPath
+	}()
+
+	cases := map[string]string{
+		"/host/proc/loadavg": "/proc/loadavg",
+		"/host/process":      "/host/process",
+		"/etc/hostname":      "/etc/hostname",
+	}
+
+	for path, expected := range cases {
+		if translated := ContainerPath(path); translated != expected {
+			t.Errorf("ContainerPath(%s) returned %s, expected %s", path, translated, expected)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package plugins
+
+import (
+	"errors"
+	"reflect"
+)
+
+type (
+	// Target is a host found by a Discoverer.
+	Target struct {
+		Name            string
+		TransportID     string
+		TransportConfig map[string]interface{}
+
+		// Tags are added to all points from probes of the target.
+		Tags map[string]string
+	}
+
+	// Discoverer is implemented by plugins finding hosts to monitor. The
+	// returned targets replace the targets of the previous call.
+	Discoverer interface {
+		Plugin
+
+		Discover() ([]Target, error)
+	}
+)
+
+// GetDiscoverer will return a discoverer of type id or nil plus an error if
+// the discoverer was not found.
+func GetDiscoverer(id string) (Discoverer, error) {
+	c, found := pluginConstructors[id]
+	if !found {
+		return nil, errors.New("Discoverer " + id + " not found")
+	}
+
+	discoverer, ok := c().(Discoverer)
+	if !ok {
+		return nil, errors.New("Discoverer " + id + " not found")
+	}
+
+	return discoverer, nil
+}
+
+// GetDiscoverers will return a list of constructors for all compiled
+// discoverers.
+func GetDiscoverers() map[string]PluginConstructor {
+	return getPlugins(reflect.TypeOf((*Discoverer)(nil)).Elem())
+}
@@ -0,0 +1,220 @@
+package plugins
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+type (
+	// Command describes a command to start using a transport.
+	Command struct {
+		// Path is the command to run. Arguments are given in Args.
+		Path string
+		Args []string
+
+		// Env is added to the environment of the command as "KEY=value".
+		Env []string
+
+		// Dir is the working directory of the command.
+		Dir string
+
+		// Stdin is read as the standard input of the command. nil means
+		// no input.
+		Stdin io.Reader
+
+		// Stdout and Stderr are written as output arrives. nil means the
+		// output is discarded.
+		Stdout io.Writer
+		Stderr io.Writer
+
+		// Timeout kills the command if it's still running after the
+		// duration. 0 means no timeout. Timeouts are handled by Start().
+		Timeout time.Duration
+	}
+
+	// Process is a command started by a transport.
+	Process interface {
+		// Signal sends a signal to the process.
+		Signal(sig syscall.Signal) error
+
+		// Kill kills the process.
+		Kill() error
+
+		// Wait waits for the process to exit and all output to be written.
+		// An *ExitError is returned if the command exits with a non-zero
+		// status or is killed by a signal. Wait must be called to release
+		// the resources of the process.
+		Wait() error
+	}
+
+	// Executor can be implemented by transports able to start commands
+	// and stream their input and output. The Timeout of the command can be
+	// ignored.
+	Executor interface {
+		Start(cmd *Command) (Process, error)
+	}
+
+	// ExitError is returned by Process.Wait if the command didn't exit
+	// successfully.
+	ExitError struct {
+		// Code is the exit status of the command, or -1 if killed by a
+		// signal.
+		Code int
+
+		// Signal is the name of the signal killing the command without the
+		// "SIG" prefix, like "KILL".
+		Signal string
+	}
+
+	// timeoutProcess kills a process after a timeout.
+	timeoutProcess struct {
+		Process
+		timer    *time.Timer
+		timedOut int32
+	}
+
+	// finishedProcess is a process run by Transport.Exec.
+	finishedProcess struct {
+		err error
+	}
+)
+
+var (
+	// ErrTimeout is returned by Process.Wait if the command is killed
+	// because of the Timeout of the command.
+	ErrTimeout = errors.New("command timed out")
+
+	// ErrExecNotSupported is returned by Start if the transport doesn't
+	// implement Executor and the command uses Stdin, Env or Dir.
+	ErrExecNotSupported = errors.New("transport does not support stdin, environment or working directory")
+
+	signalNames = map[syscall.Signal]string{
+		syscall.SIGABRT: "ABRT",
+		syscall.SIGALRM: "ALRM",
+		syscall.SIGFPE:  "FPE",
+		syscall.SIGHUP:  "HUP",
+		syscall.SIGILL:  "ILL",
+		syscall.SIGINT:  "INT",
+		syscall.SIGKILL: "KILL",
+		syscall.SIGPIPE: "PIPE",
+		syscall.SIGQUIT: "QUIT",
+		syscall.SIGSEGV: "SEGV",
+		syscall.SIGTERM: "TERM",
+		syscall.SIGUSR1: "USR1",
+		syscall.SIGUSR2: "USR2",
+	}
+)
+
+func (e *ExitError) Error() string {
+	if e.Signal != "" {
+		return "killed by signal " + e.Signal
+	}
+
+	return "exit status " + strconv.Itoa(e.Code)
+}
+
+// SignalName returns the name of sig without the "SIG" prefix as used by
+// SSH. Signals without a name are returned as their number.
+func SignalName(sig syscall.Signal) string {
+	name, found := signalNames[sig]
+	if !found {
+		return strconv.Itoa(int(sig))
+	}
+
+	return name
+}
+
+// ExitCode returns the exit status from an error returned by Process.Wait.
+// 0 is returned for nil and -1 for errors not from the command.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var exitError *ExitError
+	if errors.As(err, &exitError) {
+		return exitError.Code
+	}
+
+	return -1
+}
+
+// Start starts cmd using transport. Transports not implementing Executor
+// run the command using Exec, and the output is written when the command is
+// done. Timeouts are not supported for those.
+func Start(transport Transport, cmd *Command) (Process, error) {
+	executor, ok := transport.(Executor)
+	if !ok {
+		return execFallback(transport, cmd)
+	}
+
+	process, err := executor.Start(cmd)
+	if err != nil || cmd.Timeout <= 0 {
+		return process, err
+	}
+
+	t := &timeoutProcess{Process: process}
+	t.timer = time.AfterFunc(cmd.Timeout, func() {
+		atomic.StoreInt32(&t.timedOut, 1)
+		process.Kill()
+	})
+
+	return t, nil
+}
+
+// Run starts cmd using transport and waits for it to exit.
+func Run(transport Transport, cmd *Command) error {
+	process, err := Start(transport, cmd)
+	if err != nil {
+		return err
+	}
+
+	return process.Wait()
+}
+
+func (t *timeoutProcess) Wait() error {
+	err := t.Process.Wait()
+	t.timer.Stop()
+
+	if atomic.LoadInt32(&t.timedOut) == 1 {
+		return ErrTimeout
+	}
+
+	return err
+}
+
+// execFallback runs cmd using transport.Exec.
+func execFallback(transport Transport, cmd *Command) (Process, error) {
+	if cmd.Stdin != nil || len(cmd.Env) > 0 || cmd.Dir != "" {
+		return nil, ErrExecNotSupported
+	}
+
+	stdout, stderr, err := transport.Exec(cmd.Path, cmd.Args...)
+
+	if stdout != nil && cmd.Stdout != nil {
+		io.Copy(cmd.Stdout, stdout)
+	}
+
+	if stderr != nil && cmd.Stderr != nil {
+		io.Copy(cmd.Stderr, stderr)
+	}
+
+	return &finishedProcess{err: err}, nil
+}
+
+func (f *finishedProcess) Signal(sig syscall.Signal) error {
+	return os.ErrProcessDone
+}
+
+func (f *finishedProcess) Kill() error {
+	return os.ErrProcessDone
+}
+
+func (f *finishedProcess) Wait() error {
+	return f.err
+}
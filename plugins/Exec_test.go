@@ -0,0 +1,108 @@
+package plugins
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+type (
+	execOnly struct {
+		Transport
+	}
+
+	hangingExecutor struct {
+		Transport
+		killed chan struct{}
+	}
+)
+
+func (e *execOnly) Exec(cmd string, arguments ...string) (io.Reader, io.Reader, error) {
+	return strings.NewReader(cmd + " " + strings.Join(arguments, " ")), strings.NewReader("warning"), &ExitError{Code: 2}
+}
+
+func (h *hangingExecutor) Start(cmd *Command) (Process, error) {
+	return h, nil
+}
+
+func (h *hangingExecutor) Signal(sig syscall.Signal) error {
+	return nil
+}
+
+func (h *hangingExecutor) Kill() error {
+	close(h.killed)
+
+	return nil
+}
+
+func (h *hangingExecutor) Wait() error {
+	<-h.killed
+
+	return &ExitError{Code: -1, Signal: "KILL"}
+}
+
+func TestStartFallback(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	err := Run(&execOnly{}, &Command{Path: "ls", Args: []string{"-l", "/"}, Stdout: &stdout, Stderr: &stderr})
+	if ExitCode(err) != 2 {
+		t.Errorf("Run() returned %v, expected exit status 2", err)
+	}
+
+	if stdout.String() != "ls -l /" || stderr.String() != "warning" {
+		t.Errorf("Wrong output '%s' and '%s'", stdout.String(), stderr.String())
+	}
+
+	_, err = Start(&execOnly{}, &Command{Path: "cat", Stdin: strings.NewReader("hello")})
+	if err != ErrExecNotSupported {
+		t.Errorf("Start() returned %v for command with stdin", err)
+	}
+}
+
+func TestStartTimeout(t *testing.T) {
+	executor := &hangingExecutor{killed: make(chan struct{})}
+
+	start := time.Now()
+
+	err := Run(executor, &Command{Path: "sleep", Timeout: 10 * time.Millisecond})
+	if err != ErrTimeout {
+		t.Errorf("Run() returned %v, expected ErrTimeout", err)
+	}
+
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("Timeout took %s", time.Since(start))
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	cases := map[error]int{
+		nil:                                  0,
+		&ExitError{Code: 3}:                  3,
+		&ExitError{Code: -1, Signal: "KILL"}: -1,
+		os.ErrNotExist:                       -1,
+	}
+
+	for err, expected := range cases {
+		if code := ExitCode(err); code != expected {
+			t.Errorf("ExitCode(%v) returned %d, expected %d", err, code, expected)
+		}
+	}
+
+	if !strings.Contains((&ExitError{Code: -1, Signal: "TERM"}).Error(), "TERM") {
+		t.Errorf("ExitError does not mention the signal")
+	}
+}
+
+func TestSignalName(t *testing.T) {
+	if name := SignalName(syscall.SIGTERM); name != "TERM" {
+		t.Errorf("SignalName(SIGTERM) returned '%s'", name)
+	}
+
+	if name := SignalName(syscall.Signal(99)); name != "99" {
+		t.Errorf("SignalName(99) returned '%s'", name)
+	}
+}
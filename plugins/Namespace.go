@@ -0,0 +1,87 @@
+package plugins
+
+import (
+	"net"
+	"os"
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+type (
+	// Namespace is a Linux namespace to join.
+	Namespace struct {
+		// Path is the namespace file, like /proc/<pid>/ns/net.
+		Path string
+
+		// Type is the type of the namespace, like unix.CLONE_NEWNET.
+		Type int
+	}
+)
+
+// join joins the calling thread to ns. An *os.PathError is returned if the
+// namespace can't be joined.
+func (ns Namespace) join() error {
+	f, err := os.Open(ns.Path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = unix.Setns(int(f.Fd()), ns.Type)
+	if err != nil {
+		return &os.PathError{Op: "setns", Path: ns.Path, Err: err}
+	}
+
+	return nil
+}
+
+// InNamespaces calls f in a thread joined to namespaces. The thread is never
+// unlocked, it exits with f instead of being reused by other goroutines.
+func InNamespaces(namespaces []Namespace, f func() error) error {
+	if len(namespaces) == 0 {
+		return f()
+	}
+
+	result := make(chan error, 1)
+
+	go func() {
+		runtime.LockOSThread()
+
+		for _, ns := range namespaces {
+			err := ns.join()
+			if err != nil {
+				result <- err
+				return
+			}
+		}
+
+		result <- f()
+	}()
+
+	return <-result
+}
+
+// DialInNamespaces dials address using dialer in a thread joined to
+// namespaces. Names are resolved in the namespaces of Agento.
+func DialInNamespaces(namespaces []Namespace, dialer *net.Dialer, network string, address string) (net.Conn, error) {
+	if len(namespaces) == 0 {
+		return dialer.Dial(network, address)
+	}
+
+	// Connections to both IPv4 and IPv6 are made in other goroutines,
+	// outside the namespace.
+	d := *dialer
+	d.FallbackDelay = -1
+
+	var conn net.Conn
+
+	err := InNamespaces(namespaces, func() error {
+		var err error
+		conn, err = d.Dial(network, address)
+
+		return err
+	})
+
+	return conn, err
+}
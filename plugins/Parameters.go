@@ -0,0 +1,69 @@
+package plugins
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseParameters will convert parameters in the form "key=value" to a
+// configuration for the plugin identified by id. Values are converted to the
+// type of the parameter.
+func ParseParameters(id string, params []string) (map[string]interface{}, error) {
+	constructor, found := pluginConstructors[id]
+	if !found {
+		return nil, fmt.Errorf("unknown plugin '%s'", id)
+	}
+
+	types := make(map[string]string)
+	for _, p := range getParams(reflect.TypeOf(constructor()).Elem()) {
+		types[p.Name] = p.Type
+	}
+
+	config := make(map[string]interface{})
+
+	for _, param := range params {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("parameter '%s' is not in the form key=value", param)
+		}
+
+		key, value := kv[0], kv[1]
+
+		typ, found := types[key]
+		if !found {
+			return nil, fmt.Errorf("%s has no parameter '%s'", id, key)
+		}
+
+		v, err := parseValue(typ, value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", key, err.Error())
+		}
+
+		config[key] = v
+	}
+
+	return config, nil
+}
+
+// parseValue converts value to a type suitable for JSON decoding into a field
+// of type typ.
+func parseValue(typ string, value string) (interface{}, error) {
+	switch typ {
+	case "bool":
+		return strconv.ParseBool(value)
+	case "int", "int8", "int16", "int32", "int64", "uint", "uint8", "uint16", "uint32", "uint64":
+		return strconv.ParseInt(value, 10, 64)
+	case "float32", "float64":
+		return strconv.ParseFloat(value, 64)
+	case "time.Duration":
+		d, err := time.ParseDuration(value)
+		return int64(d), err
+	case "[]string":
+		return strings.Split(value, ","), nil
+	}
+
+	return value, nil
+}
@@ -0,0 +1,50 @@
+package plugins
+
+import (
+	"testing"
+	"time"
+)
+
+type parameterPlugin struct {
+	Name     string        `json:"name" description:"A name"`
+	Count    int           `json:"count" description:"A count"`
+	Enabled  bool          `json:"enabled" description:"Enabled"`
+	Interval time.Duration `json:"interval" description:"An interval"`
+}
+
+func (p *parameterPlugin) GetDoc() *Doc {
+	return NewDoc("Test plugin")
+}
+
+func init() {
+	Register("parametertest", func() interface{} { return &parameterPlugin{} })
+}
+
+func TestParseParameters(t *testing.T) {
+	config, err := ParseParameters("parametertest", []string{"name=test", "count=42", "enabled=true", "interval=2s", "name=a=b"})
+	if err != nil {
+		t.Fatalf("ParseParameters() failed: %s", err.Error())
+	}
+
+	if config["name"] != "a=b" || config["count"] != int64(42) || config["enabled"] != true || config["interval"] != int64(2*time.Second) {
+		t.Errorf("ParseParameters() returned wrong config: %v", config)
+	}
+
+	failing := [][]string{
+		{"name"},
+		{"unknown=1"},
+		{"count=many"},
+	}
+
+	for _, params := range failing {
+		_, err = ParseParameters("parametertest", params)
+		if err == nil {
+			t.Errorf("ParseParameters() accepted %v", params)
+		}
+	}
+
+	_, err = ParseParameters("nonexisting", nil)
+	if err == nil {
+		t.Errorf("ParseParameters() accepted unknown plugin")
+	}
+}
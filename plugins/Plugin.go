@@ -18,6 +18,7 @@ type Parameter struct {
 	Type        string   `json:"type"`
 	Description string   `json:"description"`
 	EnumValues  []string `json:"enumValues"`
+	Secret      bool     `json:"secret"`
 }
 
 // PluginConstructor is the type for a function that will instantiate a plugin.
@@ -95,6 +96,7 @@ func getParams(elem reflect.Type) []Parameter {
 			p.Name = jsonName
 			p.Type = f.Type.String()
 			p.Description = description
+			p.Secret = isSecret(f)
 			enum := f.Tag.Get("enum")
 			if enum != "" {
 				p.EnumValues = strings.Split(enum, ",")
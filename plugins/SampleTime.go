@@ -0,0 +1,38 @@
+package plugins
+
+import (
+	"time"
+)
+
+type (
+	// SampleTime is the time a report was gathered. It's sent along with
+	// the agents in reports to the server.
+	SampleTime struct {
+		Time time.Time `json:"time"`
+	}
+)
+
+func init() {
+	Register("sampletime", NewSampleTime)
+}
+
+// NewSampleTime returns a new SampleTime.
+func NewSampleTime() interface{} {
+	return new(SampleTime)
+}
+
+// GetDoc implements Plugin.
+func (s *SampleTime) GetDoc() *Doc {
+	return NewDoc("Sample time")
+}
+
+// SampleTime returns the sample time of the results or the zero time if
+// the results carry no sample time.
+func (r Results) SampleTime() time.Time {
+	s, ok := r["sampletime"].(*SampleTime)
+	if !ok {
+		return time.Time{}
+	}
+
+	return s.Time
+}
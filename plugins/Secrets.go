@@ -0,0 +1,183 @@
+package plugins
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/abrander/agento/secret"
+)
+
+// isSecret returns true if the struct field is tagged as secret.
+func isSecret(f reflect.StructField) bool {
+	return f.Tag.Get("secret") == "true"
+}
+
+// jsonName returns the name used for the field in JSON documents.
+func jsonName(f reflect.StructField) string {
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "" {
+		return f.Name
+	}
+
+	return name
+}
+
+func taggedParameters(elem reflect.Type, params map[string]bool, tagged func(reflect.StructField) bool) {
+	if elem.Kind() != reflect.Struct {
+		return
+	}
+
+	for i := 0; i < elem.NumField(); i++ {
+		f := elem.Field(i)
+
+		if f.Anonymous {
+			taggedParameters(f.Type, params, tagged)
+		} else if tagged(f) {
+			params[jsonName(f)] = true
+		}
+	}
+}
+
+// parameters returns the JSON names of all parameters of plugin id where
+// tagged returns true.
+func parameters(id string, tagged func(reflect.StructField) bool) map[string]bool {
+	params := make(map[string]bool)
+
+	c, found := pluginConstructors[id]
+	if !found {
+		return params
+	}
+
+	elem := reflect.TypeOf(c())
+	if elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+
+	taggedParameters(elem, params, tagged)
+
+	return params
+}
+
+// SecretParameters returns the JSON names of all parameters of plugin id
+// tagged as secret. Parameters are marked secret by adding the struct tag
+// `secret:"true"`.
+func SecretParameters(id string) map[string]bool {
+	return parameters(id, isSecret)
+}
+
+// ResolveSecrets will return a copy of config where all secret parameters for
+// plugin id have been resolved using secret.Resolve(). This must only be used
+// for configuration written by the operator.
+func ResolveSecrets(id string, config map[string]interface{}) (map[string]interface{}, error) {
+	return resolveSecrets(id, config, secret.Resolve)
+}
+
+// ResolveUntrustedSecrets behaves like ResolveSecrets(), but will use
+// secret.ResolveUntrusted(). This must be used for configuration supplied by
+// API clients.
+func ResolveUntrustedSecrets(id string, config map[string]interface{}) (map[string]interface{}, error) {
+	return resolveSecrets(id, config, secret.ResolveUntrusted)
+}
+
+func resolveSecrets(id string, config map[string]interface{}, resolve func(string) (string, error)) (map[string]interface{}, error) {
+	secrets := SecretParameters(id)
+	resolved := make(map[string]interface{}, len(config))
+
+	for key, value := range config {
+		str, ok := value.(string)
+		if ok && secrets[key] {
+			plaintext, err := resolve(str)
+			if err != nil {
+				return nil, err
+			}
+
+			value = plaintext
+		}
+
+		resolved[key] = value
+	}
+
+	return resolved, nil
+}
+
+// LocalSecrets returns the sorted names of the secret parameters for plugin
+// id in config referring to the environment or files of the server.
+func LocalSecrets(id string, config map[string]interface{}) []string {
+	var names []string
+
+	for key := range SecretParameters(id) {
+		str, ok := config[key].(string)
+		if ok && secret.IsLocal(str) {
+			names = append(names, key)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// RedactSecrets will return a copy of config where all secret parameters for
+// plugin id have been replaced by secret.Redacted.
+func RedactSecrets(id string, config map[string]interface{}) map[string]interface{} {
+	if config == nil {
+		return nil
+	}
+
+	secrets := SecretParameters(id)
+	redacted := make(map[string]interface{}, len(config))
+
+	for key, value := range config {
+		if secrets[key] {
+			value = secret.Redacted
+		}
+
+		redacted[key] = value
+	}
+
+	return redacted
+}
+
+// RestoreSecrets will replace redacted secret parameters in config with the
+// values from previous. This allows API clients to send back a redacted
+// configuration without overwriting the secrets.
+func RestoreSecrets(id string, config map[string]interface{}, previous map[string]interface{}) {
+	for key := range SecretParameters(id) {
+		if config[key] == secret.Redacted {
+			config[key] = previous[key]
+		}
+	}
+}
+
+// Redacted will return a string representation of plugin like "%+v" with all
+// secret fields redacted. This should be used when logging plugins.
+func Redacted(plugin interface{}) string {
+	v := reflect.ValueOf(plugin)
+	if v.Kind() != reflect.Ptr || v.Elem().Kind() != reflect.Struct {
+		return fmt.Sprintf("%+v", plugin)
+	}
+
+	// Work on a shallow copy to leave the plugin itself untouched.
+	c := reflect.New(v.Elem().Type())
+	c.Elem().Set(v.Elem())
+	redactFields(c.Elem())
+
+	return fmt.Sprintf("%+v", c.Interface())
+}
+
+func redactFields(v reflect.Value) {
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		field := v.Field(i)
+
+		if f.Anonymous && field.Kind() == reflect.Struct {
+			redactFields(field)
+		} else if isSecret(f) && field.Kind() == reflect.String && field.CanSet() && field.String() != "" {
+			field.SetString(secret.Redacted)
+		}
+	}
+}
@@ -19,6 +19,12 @@ type (
 		ReadFile(path string) ([]byte, error)
 		Statfs(path string, buf *syscall.Statfs_t) error
 	}
+
+	// AccountTransport can be implemented by transports needing the
+	// account of the host using them.
+	AccountTransport interface {
+		SetAccount(accountID string)
+	}
 )
 
 // GetTransport will return a transport of type id or nil plus an error if the
@@ -0,0 +1,66 @@
+package plugins
+
+import (
+	"reflect"
+	"sort"
+)
+
+// isTrusted returns true if the struct field is tagged as trusted.
+func isTrusted(f reflect.StructField) bool {
+	return f.Tag.Get("trusted") == "true"
+}
+
+// isTrustedDefault returns true if the default of the struct field is tagged
+// as trusted.
+func isTrustedDefault(f reflect.StructField) bool {
+	return f.Tag.Get("trusted") == "default"
+}
+
+// isSet returns true if key is set to anything but the zero value in config.
+func isSet(config map[string]interface{}, key string) bool {
+	value, found := config[key]
+
+	return found && value != nil && !reflect.ValueOf(value).IsZero()
+}
+
+// TrustedParameters returns the JSON names of all parameters of plugin id
+// tagged as trusted. Trusted parameters give access to files or services of
+// the server, and must only be set by the operator. Parameters are marked
+// trusted by adding the struct tag `trusted:"true"`.
+func TrustedParameters(id string) map[string]bool {
+	return parameters(id, isTrusted)
+}
+
+// UntrustedParameters returns the sorted names of the trusted parameters of
+// plugin id set to anything but the zero value in config.
+func UntrustedParameters(id string, config map[string]interface{}) []string {
+	var names []string
+
+	for key := range TrustedParameters(id) {
+		if isSet(config, key) {
+			names = append(names, key)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// TrustedDefaults returns the sorted names of the parameters of plugin id
+// tagged `trusted:"default"` left at the zero value in config. The defaults
+// of these parameters use resources of the server, like the credentials
+// Agento runs with, and must only be used by the operator.
+func TrustedDefaults(id string, config map[string]interface{}) []string {
+	var names []string
+
+	for key := range parameters(id, isTrustedDefault) {
+		if !isSet(config, key) {
+			names = append(names, key)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
@@ -1,11 +1,36 @@
 package http
 
 import (
+	"net/http"
 	"testing"
 
 	"github.com/abrander/agento/plugins"
+	"github.com/abrander/agento/plugins/transports/mock"
 )
 
 func TestAgent(t *testing.T) {
 	plugins.GenericAgentTest(t, NewHttp())
 }
+
+func TestGather(t *testing.T) {
+	m := mocktransport.NewMock().(*mocktransport.Mock)
+	m.SetService("127.0.0.1:8080", mocktransport.HTTPResponse(http.StatusServiceUnavailable, "down"))
+
+	h := &Http{Url: "http://127.0.0.1:8080/health"}
+
+	err := h.Gather(m)
+	if err != nil {
+		t.Fatalf("Gather() failed: %s", err.Error())
+	}
+
+	if h.Status != http.StatusServiceUnavailable {
+		t.Errorf("Gather() returned status %d", h.Status)
+	}
+
+	h.Url = "http://127.0.0.1:8081/"
+
+	err = h.Gather(m)
+	if err == nil {
+		t.Errorf("Gather() did not fail for refused connection")
+	}
+}
@@ -4,8 +4,28 @@ import (
 	"testing"
 
 	"github.com/abrander/agento/plugins"
+	"github.com/abrander/agento/plugins/transports/record"
 )
 
 func TestAgent(t *testing.T) {
 	plugins.GenericAgentTest(t, NewLoadStats())
 }
+
+func TestGather(t *testing.T) {
+	transport, err := recordtransport.LoadReplay("testdata/host.json")
+	if err != nil {
+		t.Fatalf("LoadReplay() failed: %s", err.Error())
+	}
+
+	l := NewLoadStats().(*LoadStats)
+
+	err = l.Gather(transport)
+	if err != nil {
+		t.Fatalf("Gather() failed: %s", err.Error())
+	}
+
+	expected := LoadStats{Load1: 0.21, Load5: 0.21, Load15: 0.19, ActiveTasks: 0, Tasks: 75}
+	if *l != expected {
+		t.Errorf("Gather() returned %+v, expected %+v", *l, expected)
+	}
+}
@@ -84,7 +84,7 @@ type Mysql struct {
 	WsrepReplicationLatencyStandardDeviation float64 `json:"ws"`
 	WsrepReplicationLatencySampleSize        int64   `json:"wn"`
 
-	DSN string `toml:"dsn" json:"dsn" description:"Mysql DSN"`
+	DSN string `toml:"dsn" json:"dsn" description:"Mysql DSN" secret:"true"`
 }
 
 func init() {
@@ -4,8 +4,42 @@ import (
 	"testing"
 
 	"github.com/abrander/agento/plugins"
+	"github.com/abrander/agento/plugins/transports/mock"
 )
 
 func TestAgent(t *testing.T) {
 	plugins.GenericAgentTest(t, NewMysql())
 }
+
+func TestGather(t *testing.T) {
+	m := mocktransport.NewMock().(*mocktransport.Mock)
+	m.SetService("127.0.0.1:3306", mocktransport.MySQLStatus(map[string]string{
+		"Connections":            "1234",
+		"Threads_connected":      "7",
+		"Uptime":                 "3600",
+		"wsrep_apply_window":     "1.5",
+		"wsrep_evs_repl_latency": "0.1/0.2/0.3/0.04/5",
+	}))
+
+	my := &Mysql{DSN: "agento:secret@tcp(127.0.0.1:3306)/"}
+
+	err := my.Gather(m)
+	if err != nil {
+		t.Fatalf("Gather() failed: %s", err.Error())
+	}
+
+	if my.Connections != 1234 || my.ThreadsConnected != 7 || my.WsrepApplyWindow != 1.5 {
+		t.Errorf("Wrong status: %+v", my)
+	}
+
+	if my.WsrepReplicationLatencyAverage != 0.2 || my.WsrepReplicationLatencySampleSize != 5 {
+		t.Errorf("Wrong replication latency: %+v", my)
+	}
+
+	my.DSN = "agento:secret@tcp(127.0.0.1:3307)/"
+
+	err = my.Gather(m)
+	if err == nil {
+		t.Errorf("Gather() did not fail for refused connection")
+	}
+}
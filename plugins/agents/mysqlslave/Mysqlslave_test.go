@@ -0,0 +1,37 @@
+package mysqlslave
+
+import (
+	"testing"
+
+	"github.com/abrander/agento/plugins"
+	"github.com/abrander/agento/plugins/transports/mock"
+)
+
+func TestAgent(t *testing.T) {
+	plugins.GenericAgentTest(t, NewMysqlSlave())
+}
+
+func TestGather(t *testing.T) {
+	m := mocktransport.NewMock().(*mocktransport.Mock)
+	m.SetService("/run/mysqld/mysqld.sock", mocktransport.MySQLService(map[string]*mocktransport.MySQLResult{
+		"SHOW ALL SLAVES STATUS": {
+			Columns: []string{"Connection_name", "Slave_IO_State", "Seconds_Behind_Master", "Executed_log_entries"},
+			Rows: [][]string{
+				{"", "Waiting for master to send event", "0", "1000"},
+				{"backup", "Waiting for master to send event", "42", "2000"},
+			},
+		},
+	}))
+
+	s := &MysqlSlave{DSN: "agento@unix(/run/mysqld/mysqld.sock)/"}
+
+	err := s.Gather(m)
+	if err != nil {
+		t.Fatalf("Gather() failed: %s", err.Error())
+	}
+
+	expected := []Connection{{"", 0, 1000}, {"backup", 42, 2000}}
+	if len(s.Connections) != 2 || s.Connections[0] != expected[0] || s.Connections[1] != expected[1] {
+		t.Errorf("Gather() returned %+v", s.Connections)
+	}
+}
@@ -23,7 +23,7 @@ type Table struct {
 type MysqlTables struct {
 	Tables []Table `json:"t"`
 
-	DSN string `toml:"dsn" json:"dsn" description:"Mysql DSN"`
+	DSN string `toml:"dsn" json:"dsn" description:"Mysql DSN" secret:"true"`
 }
 
 func init() {
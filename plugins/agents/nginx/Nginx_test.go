@@ -0,0 +1,41 @@
+package nginx
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/abrander/agento/plugins"
+	"github.com/abrander/agento/plugins/transports/mock"
+)
+
+func TestAgent(t *testing.T) {
+	plugins.GenericAgentTest(t, newNginx())
+}
+
+func TestGather(t *testing.T) {
+	m := mocktransport.NewMock().(*mocktransport.Mock)
+	m.SetService("127.0.0.1:80", mocktransport.HTTPResponse(http.StatusOK, `Active connections: 291
+server accepts handled requests
+ 16630948 16630948 31070465
+Reading: 6 Writing: 179 Waiting: 106
+`))
+
+	n := &Nginx{URL: "http://127.0.0.1/nginx_status"}
+
+	err := n.Gather(m)
+	if err != nil {
+		t.Fatalf("Gather() failed: %s", err.Error())
+	}
+
+	expected := Nginx{URL: n.URL, ActiveConnections: 291, Accepts: 16630948, Handled: 16630948, Requests: 31070465, Reading: 6, Writing: 179, Waiting: 106}
+	if *n != expected {
+		t.Errorf("Gather() returned %+v", *n)
+	}
+
+	m.SetService("127.0.0.1:80", mocktransport.HTTPResponse(http.StatusNotFound, "not found"))
+
+	err = n.Gather(m)
+	if err == nil {
+		t.Errorf("Gather() did not fail for 404")
+	}
+}
@@ -1,12 +1,12 @@
 package phpfpm
 
 import (
-	"bufio"
-	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"net"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/abrander/agento/plugins"
 	"github.com/abrander/agento/timeseries"
@@ -24,224 +24,163 @@ func newPHPFPM() interface{} {
 }
 
 type (
-	// PHPFPM will collect metrics from a PHP-FPM pool.
+	// PHPFPM will collect metrics from PHP-FPM pools.
 	PHPFPM struct {
-		ListenPath          string `toml:"listen" json:"listen" description:"The listen path as configured in PHP-FPM"`
-		StatusPath          string `toml:"status" json:"status" description:"The status URI as configured in PHP-FPM"`
-		Pool                string `json:"p"`
-		AcceptedConnections int64  `json:"ac"`
-		ListenQueue         int64  `json:"lq"`
-		ListenQueueMax      int64  `json:"lqm"`
-		ListenQueueLength   int64  `json:"lql"`
-		IdleProcesses       int64  `json:"ip"`
-		ActiveProcesses     int64  `json:"ap"`
-		MaxActiveProcesses  int64  `json:"map"`
-		MaxChildrenReached  int64  `json:"mcr"`
-		SlowRequests        int64  `json:"sr"`
-	}
-
-	record struct {
-		Version       byte
-		Type          byte
-		RequestID     uint16
-		ContentLength uint16
-		PaddingLength byte
-		Reserved      byte
-	}
-
-	appRecord struct {
-		Role     uint16
-		Flags    byte
-		Reserved [5]byte
+		ListenPath string   `toml:"listen" json:"listen" description:"The listen path as configured in PHP-FPM"`
+		StatusPath string   `toml:"status" json:"status" description:"The status URI as configured in PHP-FPM"`
+		Pools      []string `toml:"pools" json:"pools" description:"Listen paths of more pools using the same status URI"`
+		Processes  bool     `toml:"processes" json:"processes" description:"Collect metrics of every process"`
+
+		Status []PoolStatus `json:"s"`
+	}
+
+	// PoolStatus is the status of a pool as returned by PHP-FPM.
+	PoolStatus struct {
+		Pool                string          `json:"pool"`
+		AcceptedConnections int64           `json:"accepted conn"`
+		ListenQueue         int64           `json:"listen queue"`
+		ListenQueueMax      int64           `json:"max listen queue"`
+		ListenQueueLength   int64           `json:"listen queue len"`
+		IdleProcesses       int64           `json:"idle processes"`
+		ActiveProcesses     int64           `json:"active processes"`
+		MaxActiveProcesses  int64           `json:"max active processes"`
+		MaxChildrenReached  int64           `json:"max children reached"`
+		SlowRequests        int64           `json:"slow requests"`
+		Processes           []ProcessStatus `json:"processes,omitempty"`
+	}
+
+	// ProcessStatus is the status of a process of a pool.
+	ProcessStatus struct {
+		Pid               int64   `json:"pid"`
+		State             string  `json:"state"`
+		Requests          int64   `json:"requests"`
+		RequestDuration   int64   `json:"request duration"`
+		LastRequestCPU    float64 `json:"last request cpu"`
+		LastRequestMemory int64   `json:"last request memory"`
 	}
 )
 
-// Gather will connect to PHP-FPM through a tcp or unix socket.
-func (a *PHPFPM) Gather(transport plugins.Transport) error {
-	var network string
+// Timeout limits the time spent on each pool.
+var Timeout = 30 * time.Second
 
-	if strings.HasPrefix(a.ListenPath, "/") {
-		network = "unix"
-	} else {
-		network = "tcp"
-	}
-
-	conn, err := net.Dial(network, a.ListenPath)
-	if err != nil {
-		return err
-	}
+// Gather will get the status of every pool through a tcp or unix socket. If
+// some pools fail, the status of the others is kept and a
+// *plugins.PartialError describing all failures is returned.
+func (a *PHPFPM) Gather(transport plugins.Transport) error {
+	a.Status = nil
 
-	// We implement just enough of FastCGI to "GET" the status page. Nothing
-	// more. It will probably break in exciting ways.
+	var failures []string
 
-	// {FCGI_BEGIN_REQUEST, 1, {FCGI_RESPONDER, 0}}
-	app := appRecord{
-		Role: 1, // FCGI_RESPONDER
-	}
+	for _, listen := range append([]string{a.ListenPath}, a.Pools...) {
+		status, err := a.gather(transport, listen)
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", listen, err.Error()))
+			continue
+		}
 
-	r := record{
-		Version:       1,
-		Type:          1, // FCGI_BEGIN_REQUEST
-		ContentLength: uint16(binary.Size(app)),
+		a.Status = append(a.Status, *status)
 	}
 
-	err = binary.Write(conn, binary.BigEndian, r)
-	if err != nil {
-		return err
+	if len(failures) == 0 {
+		return nil
 	}
 
-	err = binary.Write(conn, binary.BigEndian, app)
-	if err != nil {
+	err := errors.New(strings.Join(failures, "; "))
+	if len(a.Status) == 0 {
 		return err
 	}
 
-	// {FCGI_PARAMS, 1, "\013\002SERVER_PORT80" "\013\016SERVER_ADDR199.170.183.42 ... "}
-	var p params = make(map[string]string)
-
-	p["SCRIPT_NAME"] = a.StatusPath
-	p["SCRIPT_FILENAME"] = a.StatusPath
-	p["REQUEST_METHOD"] = "GET"
-
-	// It is tempting to add 'p["QUERY_STRING"] = "json"' to make parsing easier,
-	// but it doesn't really make it any easier. As of now we're pretty cruel
-	// when reading the reply. We parse internal FastCGI signalling as if it
-	// were ASCII. The json decoder is not as forgiving as ascii parsing.
+	return &plugins.PartialError{Err: err}
+}
 
-	size := p.size()
-	r = record{
-		Version:       1,
-		Type:          4, // FCGI_PARAMS
-		ContentLength: size,
+// gather gets the status of the pool listening on listen.
+func (a *PHPFPM) gather(transport plugins.Transport, listen string) (*PoolStatus, error) {
+	network := "tcp"
+	if strings.HasPrefix(listen, "/") {
+		network = "unix"
 	}
 
-	err = binary.Write(conn, binary.BigEndian, r)
+	conn, err := transport.Dial(network, listen)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	defer conn.Close()
 
-	err = p.write(conn)
-	if err != nil {
-		return err
-	}
+	conn.SetDeadline(time.Now().Add(Timeout))
 
-	// {FCGI_PARAMS, 1, ""}
-	r = record{
-		Version: 1,
-		Type:    4, // FCGI_PARAMS
-	}
-	err = binary.Write(conn, binary.BigEndian, r)
-	if err != nil {
-		return err
+	query := "json"
+	if a.Processes {
+		query = "json&full"
 	}
 
-	// {FCGI_STDIN, 1, ""}
-	r = record{
-		Version: 1,
-		Type:    5, // FCGI_STDIN
-	}
-	err = binary.Write(conn, binary.BigEndian, r)
+	resp, err := request(conn, params{
+		"SCRIPT_NAME":     a.StatusPath,
+		"SCRIPT_FILENAME": a.StatusPath,
+		"REQUEST_METHOD":  "GET",
+		"QUERY_STRING":    query,
+	})
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Read reply
-	var reply record
-	err = binary.Read(conn, binary.BigEndian, &reply)
+	body, err := resp.body()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// if we see FCGI_STDERR, we assume something is wrong.
-	if reply.Type == 7 {
-		l := reply.ContentLength
-		if l > 1024 {
-			l = 1024
-		}
-		buffer := make([]byte, l)
-		n, _ := conn.Read(buffer)
+	status := &PoolStatus{}
 
-		return fmt.Errorf("Could not get '%s': %s", a.StatusPath, string(buffer[0:n]))
-	}
-
-	if reply.ContentLength < 10240 {
-		scanner := bufio.NewScanner(conn)
-		// Read past headers
-		for scanner.Scan() {
-			line := scanner.Text()
-			if line == "" {
-				break
-			}
-		}
-
-		// Read body
-		for scanner.Scan() {
-			line := scanner.Text()
-			fields := strings.FieldsFunc(line, func(c rune) bool {
-				return c == ':'
-			})
-
-			if len(fields) == 2 {
-				key := strings.TrimSpace(fields[0])
-				value := strings.TrimSpace(fields[1])
-				valueInt, _ := strconv.ParseInt(value, 10, 64)
-				if err != nil {
-					continue
-				}
-
-				switch key {
-				case "pool":
-					a.Pool = value
-				case "accepted conn":
-					a.AcceptedConnections = valueInt
-				case "listen queue":
-					a.ListenQueue = valueInt
-				case "max listen queue":
-					a.ListenQueueMax = valueInt
-				case "listen queue len":
-					a.ListenQueueLength = valueInt
-				case "idle processes":
-					a.IdleProcesses = valueInt
-				case "active processes":
-					a.ActiveProcesses = valueInt
-				case "max active processes":
-					a.MaxActiveProcesses = valueInt
-				case "max children reached":
-					a.MaxChildrenReached = valueInt
-				case "slow requests":
-					a.SlowRequests = valueInt
-				}
-			}
-		}
-	}
-	err = conn.Close()
+	err = json.Unmarshal(body, status)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return nil
+	return status, nil
 }
 
 // GetPoints will return points suitable for further processing.
 func (a *PHPFPM) GetPoints() []*timeseries.Point {
-	// If no pool is set, we assume we didn't succeed in gathering metrics and
-	// return no points.
-	if a.Pool == "" {
-		return nil
-	}
-
-	points := make([]*timeseries.Point, 9)
+	var points []*timeseries.Point
 
 	// Each PHP-FPM host can easily run multiple PHP-FPM pools, so we tag all
 	// measurements with the pool name.
-	points[0] = plugins.PointWithTag("phpfpm.AcceptedConnections", a.AcceptedConnections, "pool", a.Pool)
-	points[1] = plugins.PointWithTag("phpfpm.ListenQueue", a.ListenQueue, "pool", a.Pool)
-	points[2] = plugins.PointWithTag("phpfpm.ListenQueueMax", a.ListenQueueMax, "pool", a.Pool)
-	points[3] = plugins.PointWithTag("phpfpm.ListenQueueLength", a.ListenQueueLength, "pool", a.Pool)
-	points[4] = plugins.PointWithTag("phpfpm.IdleProcesses", a.IdleProcesses, "pool", a.Pool)
-	points[5] = plugins.PointWithTag("phpfpm.ActiveProcesses", a.ActiveProcesses, "pool", a.Pool)
-	points[6] = plugins.PointWithTag("phpfpm.MaxActiveProcesses", a.MaxActiveProcesses, "pool", a.Pool)
-	points[7] = plugins.PointWithTag("phpfpm.MaxChildrenReached", a.MaxChildrenReached, "pool", a.Pool)
-	points[8] = plugins.PointWithTag("phpfpm.SlowRequests", a.SlowRequests, "pool", a.Pool)
+	for _, s := range a.Status {
+		points = append(points,
+			plugins.PointWithTag("phpfpm.AcceptedConnections", s.AcceptedConnections, "pool", s.Pool),
+			plugins.PointWithTag("phpfpm.ListenQueue", s.ListenQueue, "pool", s.Pool),
+			plugins.PointWithTag("phpfpm.ListenQueueMax", s.ListenQueueMax, "pool", s.Pool),
+			plugins.PointWithTag("phpfpm.ListenQueueLength", s.ListenQueueLength, "pool", s.Pool),
+			plugins.PointWithTag("phpfpm.IdleProcesses", s.IdleProcesses, "pool", s.Pool),
+			plugins.PointWithTag("phpfpm.ActiveProcesses", s.ActiveProcesses, "pool", s.Pool),
+			plugins.PointWithTag("phpfpm.MaxActiveProcesses", s.MaxActiveProcesses, "pool", s.Pool),
+			plugins.PointWithTag("phpfpm.MaxChildrenReached", s.MaxChildrenReached, "pool", s.Pool),
+			plugins.PointWithTag("phpfpm.SlowRequests", s.SlowRequests, "pool", s.Pool),
+		)
+
+		// Processes are tagged with their position in the list rather
+		// than their pid, pids change every time a process is replaced.
+		// The number of series is limited by the size of the pool.
+		for i, p := range s.Processes {
+			tags := map[string]string{
+				"pool":    s.Pool,
+				"process": strconv.Itoa(i),
+			}
+
+			active := 0
+			if p.State != "Idle" {
+				active = 1
+			}
+
+			points = append(points,
+				plugins.PointWithTags("phpfpm.process.Pid", p.Pid, tags),
+				plugins.PointWithTags("phpfpm.process.Active", active, tags),
+				plugins.PointWithTags("phpfpm.process.Requests", p.Requests, tags),
+				plugins.PointWithTags("phpfpm.process.RequestDuration", float64(p.RequestDuration)/1000.0, tags),
+				plugins.PointWithTags("phpfpm.process.LastRequestCPU", p.LastRequestCPU, tags),
+				plugins.PointWithTags("phpfpm.process.LastRequestMemory", p.LastRequestMemory, tags),
+			)
+		}
+	}
 
 	return points
 }
@@ -253,6 +192,7 @@ func (a *PHPFPM) GetDoc() *plugins.Doc {
 	// FIXME: Someone should consult the PHP-FPM source code to actually understand phpfpm.SlowRequests.
 
 	doc.AddTag("pool", "The PHP-FPM pool the metrics was gathered from")
+	doc.AddTag("process", "The number of the process in the pool")
 
 	doc.AddMeasurement("phpfpm.AcceptedConnections", "The number of request accepted by the pool", "n")
 	doc.AddMeasurement("phpfpm.ListenQueue", "The number of request in the queue of pending connections. If this number is non-zero, then you better increase number of process FPM can spawn", "n")
@@ -263,6 +203,12 @@ func (a *PHPFPM) GetDoc() *plugins.Doc {
 	doc.AddMeasurement("phpfpm.MaxActiveProcesses", "The maximum number of active processes since FPM has started", "n")
 	doc.AddMeasurement("phpfpm.MaxChildrenReached", "Number of times, the process limit has been reached, when pm tries to start more children. If that value is not zero, then you may need to increase max process limit for your PHP-FPM pool (only relevant for dynamic or ondemand modes)", "n")
 	doc.AddMeasurement("phpfpm.SlowRequests", "The number of \"slow\" requests since FPM was started", "n")
+	doc.AddMeasurement("phpfpm.process.Pid", "The pid of the process", "")
+	doc.AddMeasurement("phpfpm.process.Active", "1 if the process is serving a request, 0 if it's idle", "")
+	doc.AddMeasurement("phpfpm.process.Requests", "The number of requests served by the process", "n")
+	doc.AddMeasurement("phpfpm.process.RequestDuration", "The duration of the current or last request", "ms")
+	doc.AddMeasurement("phpfpm.process.LastRequestCPU", "The CPU usage of the last request", "%")
+	doc.AddMeasurement("phpfpm.process.LastRequestMemory", "The peak memory usage of the last request", "b")
 
 	return doc
 }
@@ -0,0 +1,150 @@
+package phpfpm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/abrander/agento/plugins"
+	"github.com/abrander/agento/plugins/transports/mock"
+)
+
+// status returns a FastCGI service answering like the status page of the
+// pool name with processes processes.
+func status(name string, processes int) mocktransport.ServiceFunc {
+	return mocktransport.FastCGIService(func(params map[string]string) (string, string) {
+		if params["SCRIPT_NAME"] != "/status" || params["REQUEST_METHOD"] != "GET" {
+			return "Status: 404 Not Found\r\nContent-type: text/html; charset=UTF-8\r\n\r\nFile not found.\n", "Primary script unknown"
+		}
+
+		var list []string
+		if strings.Contains(params["QUERY_STRING"], "full") {
+			for i := 0; i < processes; i++ {
+				list = append(list, fmt.Sprintf(`{"pid":%d,"state":"Idle","start time":1700000000,"start since":120,"requests":%d,"request duration":1500,"request method":"GET","request uri":"/index.php","content length":0,"user":"-","script":"/srv/www/index.php","last request cpu":12.5,"last request memory":2097152}`, 1000+i, i))
+			}
+		}
+
+		return "Content-type: application/json\r\n\r\n" + fmt.Sprintf(`{"pool":"%s","process manager":"dynamic","start time":1700000000,"start since":120,"accepted conn":42,"listen queue":1,"max listen queue":2,"listen queue len":128,"idle processes":3,"active processes":1,"total processes":4,"max active processes":5,"max children reached":6,"slow requests":7,"processes":[%s]}`, name, strings.Join(list, ",")), ""
+	})
+}
+
+func TestAgent(t *testing.T) {
+	plugins.GenericAgentTest(t, newPHPFPM())
+
+	// Processes must be documented too.
+	a := newPHPFPM().(*PHPFPM)
+	a.Status = []PoolStatus{{Pool: "www", Processes: []ProcessStatus{{Pid: 1, State: "Idle"}}}}
+
+	plugins.GenericAgentTest(t, a)
+}
+
+func TestGather(t *testing.T) {
+	m := mocktransport.NewMock().(*mocktransport.Mock)
+	m.SetService("127.0.0.1:9000", status("www", 2))
+	m.SetService("/run/php/api.sock", status("api", 2))
+
+	a := newPHPFPM().(*PHPFPM)
+	a.Pools = []string{"/run/php/api.sock"}
+
+	err := a.Gather(m)
+	if err != nil {
+		t.Fatalf("Gather() failed: %s", err.Error())
+	}
+
+	if len(a.Status) != 2 || a.Status[0].Pool != "www" || a.Status[1].Pool != "api" {
+		t.Fatalf("Gather() returned %+v", a.Status)
+	}
+
+	s := a.Status[0]
+	if s.AcceptedConnections != 42 || s.ListenQueue != 1 || s.IdleProcesses != 3 || s.SlowRequests != 7 || len(s.Processes) != 0 {
+		t.Errorf("Wrong status: %+v", s)
+	}
+
+	if len(a.GetPoints()) != 18 {
+		t.Errorf("GetPoints() returned %d points for 2 pools", len(a.GetPoints()))
+	}
+}
+
+func TestGatherProcesses(t *testing.T) {
+	m := mocktransport.NewMock().(*mocktransport.Mock)
+
+	// The response is larger than one FastCGI record.
+	m.SetService("127.0.0.1:9000", status("www", 500))
+
+	a := newPHPFPM().(*PHPFPM)
+	a.Processes = true
+
+	err := a.Gather(m)
+	if err != nil {
+		t.Fatalf("Gather() failed: %s", err.Error())
+	}
+
+	processes := a.Status[0].Processes
+	if len(processes) != 500 {
+		t.Fatalf("Gather() returned %d processes", len(processes))
+	}
+
+	p := processes[1]
+	if p.Pid != 1001 || p.State != "Idle" || p.Requests != 1 || p.RequestDuration != 1500 || p.LastRequestCPU != 12.5 || p.LastRequestMemory != 2097152 {
+		t.Errorf("Wrong process: %+v", p)
+	}
+
+	for _, point := range a.GetPoints() {
+		if point.Name == "phpfpm.process.RequestDuration" && point.Fields["value"] != 1.5 {
+			t.Errorf("Request duration is %v, expected 1.5 ms", point.Fields["value"])
+		}
+
+		if point.Name == "phpfpm.process.Active" && point.Fields["value"] != 0 {
+			t.Errorf("Idle process is active")
+		}
+
+		if point.Name == "phpfpm.process.Pid" && point.Tags["process"] == "1" && point.Fields["value"] != int64(1001) {
+			t.Errorf("Pid of process 1 is %v, expected 1001", point.Fields["value"])
+		}
+
+		// Pids and states change all the time, and must not be tags.
+		if strings.HasPrefix(point.Name, "phpfpm.process.") && len(point.Tags) != 2 {
+			t.Errorf("%s is tagged %v", point.Name, point.Tags)
+		}
+	}
+}
+
+func TestGatherErrors(t *testing.T) {
+	m := mocktransport.NewMock().(*mocktransport.Mock)
+	m.SetService("127.0.0.1:9000", status("www", 0))
+
+	a := newPHPFPM().(*PHPFPM)
+	a.StatusPath = "/wrong"
+
+	err := a.Gather(m)
+	if err == nil || !strings.Contains(err.Error(), "Primary script unknown") {
+		t.Errorf("Gather() returned %v for wrong status path", err)
+	}
+
+	a.StatusPath = "/status"
+	a.Pools = []string{"127.0.0.1:9001", "/run/php/missing.sock"}
+
+	// The status of working pools is kept.
+	err = a.Gather(m)
+
+	var partial *plugins.PartialError
+	if !errors.As(err, &partial) || !strings.Contains(err.Error(), "127.0.0.1:9001") || !strings.Contains(err.Error(), "/run/php/missing.sock") {
+		t.Errorf("Gather() returned %v for missing pools", err)
+	}
+
+	if len(a.Status) != 1 || a.Status[0].Pool != "www" || len(a.GetPoints()) != 9 {
+		t.Errorf("Gather() kept %+v with missing pools", a.Status)
+	}
+}
+
+func TestParamsEncode(t *testing.T) {
+	value := strings.Repeat("x", 200)
+
+	encoded := params{"A": value}.encode()
+
+	expected := "\x01\x80\x00\x00\xc8A" + value
+	if string(encoded) != expected {
+		t.Errorf("encode() returned %q", encoded)
+	}
+}
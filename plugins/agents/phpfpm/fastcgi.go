@@ -0,0 +1,181 @@
+package phpfpm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/textproto"
+	"strings"
+)
+
+type (
+	record struct {
+		Version       byte
+		Type          byte
+		RequestID     uint16
+		ContentLength uint16
+		PaddingLength byte
+		Reserved      byte
+	}
+
+	appRecord struct {
+		Role     uint16
+		Flags    byte
+		Reserved [5]byte
+	}
+
+	// response is the response to a FastCGI request.
+	response struct {
+		stdout bytes.Buffer
+		stderr bytes.Buffer
+	}
+)
+
+const (
+	fcgiBeginRequest = 1
+	fcgiEndRequest   = 3
+	fcgiParams       = 4
+	fcgiStdin        = 5
+	fcgiStdout       = 6
+	fcgiStderr       = 7
+
+	fcgiResponder = 1
+
+	// requestID is the ID of the only request sent on a connection.
+	requestID = 1
+
+	// maxResponse is the largest response accepted.
+	maxResponse = 16 << 20
+)
+
+var (
+	// ErrResponseTooLarge is returned if the response exceeds
+	// maxResponse.
+	ErrResponseTooLarge = errors.New("FastCGI response too large")
+)
+
+// writeRecord writes a record of type typ with content.
+func writeRecord(w io.Writer, typ byte, content []byte) error {
+	r := record{
+		Version:       1,
+		Type:          typ,
+		RequestID:     requestID,
+		ContentLength: uint16(len(content)),
+	}
+
+	err := binary.Write(w, binary.BigEndian, r)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(content)
+
+	return err
+}
+
+// request sends a request with p and no input, and reads the response.
+func request(conn io.ReadWriter, p params) (*response, error) {
+	var buf bytes.Buffer
+
+	app := appRecord{
+		Role: fcgiResponder,
+	}
+
+	var begin bytes.Buffer
+	binary.Write(&begin, binary.BigEndian, app)
+	writeRecord(&buf, fcgiBeginRequest, begin.Bytes())
+
+	// Parameters are split in records of up to 65535 bytes, and ended by
+	// an empty record like the input.
+	encoded := p.encode()
+	for len(encoded) > 0 {
+		n := len(encoded)
+		if n > 65535 {
+			n = 65535
+		}
+
+		writeRecord(&buf, fcgiParams, encoded[:n])
+		encoded = encoded[n:]
+	}
+
+	writeRecord(&buf, fcgiParams, nil)
+	writeRecord(&buf, fcgiStdin, nil)
+
+	_, err := conn.Write(buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &response{}
+
+	for {
+		var r record
+
+		err = binary.Read(conn, binary.BigEndian, &r)
+		if err != nil {
+			return nil, err
+		}
+
+		content := make([]byte, int(r.ContentLength)+int(r.PaddingLength))
+
+		_, err = io.ReadFull(conn, content)
+		if err != nil {
+			return nil, err
+		}
+
+		content = content[:r.ContentLength]
+
+		// Management records have request ID 0.
+		if r.RequestID != requestID {
+			continue
+		}
+
+		switch r.Type {
+		case fcgiStdout:
+			resp.stdout.Write(content)
+
+		case fcgiStderr:
+			resp.stderr.Write(content)
+
+		case fcgiEndRequest:
+			// The protocol status follows the 4 bytes of the
+			// application status.
+			if len(content) >= 5 && content[4] != 0 {
+				return nil, fmt.Errorf("request rejected with protocol status %d", content[4])
+			}
+
+			return resp, nil
+		}
+
+		if resp.stdout.Len()+resp.stderr.Len() > maxResponse {
+			return nil, ErrResponseTooLarge
+		}
+	}
+}
+
+// body returns the body of the response. An error is returned if the
+// status is not 200.
+func (r *response) body() ([]byte, error) {
+	reader := bufio.NewReader(&r.stdout)
+
+	header, err := textproto.NewReader(reader).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	status := header.Get("Status")
+	if status != "" && !strings.HasPrefix(status, "200") {
+		message := strings.TrimSpace(r.stderr.String())
+		if message == "" {
+			message = status
+		}
+
+		return nil, errors.New(message)
+	}
+
+	return ioutil.ReadAll(reader)
+}
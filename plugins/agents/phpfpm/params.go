@@ -1,8 +1,8 @@
 package phpfpm
 
 import (
+	"bytes"
 	"encoding/binary"
-	"io"
 )
 
 type (
@@ -10,49 +10,28 @@ type (
 	params map[string]string
 )
 
-// size will predict the needed space for encoding.
-func (p params) size() uint16 {
-	accum := 0
+// encode returns the parameters encoded as FastCGI name-value pairs.
+func (p params) encode() []byte {
+	var buf bytes.Buffer
 
 	for key, value := range p {
-		accum += 2
-		accum += len(key)
-		accum += len(value)
+		writeLength(&buf, len(key))
+		writeLength(&buf, len(value))
+
+		buf.WriteString(key)
+		buf.WriteString(value)
 	}
 
-	return uint16(accum)
+	return buf.Bytes()
 }
 
-// write the encoded parameters to w.
-func (p params) write(w io.Writer) error {
-	for key, value := range p {
-		keyLen := len(key)
-		valueLen := len(value)
-
-		if keyLen > 255 || valueLen > 255 {
-			continue
-		}
-
-		err := binary.Write(w, binary.BigEndian, byte(keyLen))
-		if err != nil {
-			return err
-		}
-
-		err = binary.Write(w, binary.BigEndian, byte(valueLen))
-		if err != nil {
-			return err
-		}
-
-		_, err = w.Write([]byte(key))
-		if err != nil {
-			return err
-		}
-
-		_, err = w.Write([]byte(value))
-		if err != nil {
-			return err
-		}
+// writeLength writes l as one byte if it's below 128, and as four bytes
+// with the high bit set otherwise.
+func writeLength(buf *bytes.Buffer, l int) {
+	if l < 128 {
+		buf.WriteByte(byte(l))
+		return
 	}
 
-	return nil
+	binary.Write(buf, binary.BigEndian, uint32(l)|1<<31)
 }
@@ -1,11 +1,32 @@
 package Tcpport
 
 import (
+	"net"
 	"testing"
 
 	"github.com/abrander/agento/plugins"
+	"github.com/abrander/agento/plugins/transports/mock"
 )
 
 func TestAgent(t *testing.T) {
 	plugins.GenericAgentTest(t, newTcpport())
 }
+
+func TestGather(t *testing.T) {
+	m := mocktransport.NewMock().(*mocktransport.Mock)
+	m.SetService("10.0.0.1:22", func(conn net.Conn) {})
+
+	p := &Tcpport{Address: "10.0.0.1:22"}
+
+	err := p.Gather(m)
+	if err != nil {
+		t.Fatalf("Gather() failed: %s", err.Error())
+	}
+
+	p.Address = "10.0.0.1:23"
+
+	err = p.Gather(m)
+	if err == nil {
+		t.Errorf("Gather() did not fail for refused connection")
+	}
+}
@@ -0,0 +1,242 @@
+package dockertransport
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+)
+
+type (
+	// client is a minimal client for the Docker Engine API.
+	client struct {
+		socket string
+		http   *http.Client
+	}
+
+	// APIError is an error returned by the Docker Engine API.
+	APIError struct {
+		StatusCode int
+		Message    string
+	}
+
+	execConfig struct {
+		AttachStdin  bool
+		AttachStdout bool
+		AttachStderr bool
+		Tty          bool
+		Cmd          []string
+		Env          []string `json:",omitempty"`
+		WorkingDir   string   `json:",omitempty"`
+		User         string   `json:",omitempty"`
+	}
+
+	execStart struct {
+		Detach bool
+		Tty    bool
+	}
+
+	execInspect struct {
+		Running  bool
+		ExitCode int
+		Pid      int
+	}
+
+	containerInspect struct {
+		ID    string `json:"Id"`
+		State struct {
+			Pid int
+		}
+		HostConfig struct {
+			NetworkMode string
+		}
+		NetworkSettings struct {
+			IPAddress string
+			Networks  map[string]struct {
+				IPAddress string
+			}
+		}
+	}
+)
+
+const (
+	// APIVersion is the version of the Docker Engine API used.
+	APIVersion = "v1.41"
+
+	// DefaultSocket is the default Unix socket of the Docker daemon.
+	DefaultSocket = "/var/run/docker.sock"
+)
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("docker: %s (%d)", e.Message, e.StatusCode)
+}
+
+func newClient(socket string) *client {
+	return &client{
+		socket: socket,
+		http: &http.Client{
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _ string, _ string) (net.Conn, error) {
+					var dialer net.Dialer
+
+					return dialer.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+}
+
+// newRequest creates a request for path. body is encoded as JSON if not
+// nil.
+func newRequest(method string, path string, query url.Values, body interface{}) (*http.Request, error) {
+	u := "http://docker/" + APIVersion + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+
+		r = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(method, u, r)
+	if err != nil {
+		return nil, err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return req, nil
+}
+
+// apiError reads the error from a response.
+func apiError(resp *http.Response) error {
+	var message struct {
+		Message string `json:"message"`
+	}
+
+	b, _ := ioutil.ReadAll(resp.Body)
+	if json.Unmarshal(b, &message) != nil || message.Message == "" {
+		message.Message = http.StatusText(resp.StatusCode)
+	}
+
+	return &APIError{StatusCode: resp.StatusCode, Message: message.Message}
+}
+
+// do sends a request. The body of the response must be closed.
+func (c *client) do(method string, path string, query url.Values, body interface{}) (*http.Response, error) {
+	req, err := newRequest(method, path, query, body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 400 {
+		defer resp.Body.Close()
+
+		return nil, apiError(resp)
+	}
+
+	return resp, nil
+}
+
+// call sends a request and decodes the JSON response into result.
+func (c *client) call(method string, path string, body interface{}, result interface{}) error {
+	resp, err := c.do(method, path, nil, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if result == nil {
+		return nil
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// hijack sends a request upgrading the connection to a raw stream. The
+// returned reader must be used for reading from the connection.
+func (c *client) hijack(path string, body interface{}) (net.Conn, *bufio.Reader, error) {
+	req, err := newRequest(http.MethodPost, path, nil, body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+
+	conn, err := net.Dial("unix", c.socket)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = req.Write(conn)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	r := bufio.NewReader(conn)
+
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+
+	if resp.StatusCode >= 400 {
+		err = apiError(resp)
+		conn.Close()
+
+		return nil, nil, err
+	}
+
+	return conn, r, nil
+}
+
+// demux copies a multiplexed stream to stdout and stderr. Each frame has
+// a header of 8 bytes: the stream, 3 bytes of padding and the length of the
+// payload as big endian.
+func demux(r io.Reader, stdout io.Writer, stderr io.Writer) error {
+	var header [8]byte
+
+	for {
+		_, err := io.ReadFull(r, header[:])
+		if err == io.EOF {
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		w := stdout
+		if header[0] == 2 {
+			w = stderr
+		}
+
+		_, err = io.CopyN(w, r, int64(binary.BigEndian.Uint32(header[4:])))
+		if err != nil {
+			return err
+		}
+	}
+}
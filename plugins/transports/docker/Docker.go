@@ -0,0 +1,383 @@
+package dockertransport
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/abrander/agento/configuration"
+	"github.com/abrander/agento/logger"
+	"github.com/abrander/agento/plugins"
+)
+
+func init() {
+	plugins.Register("dockertransport", NewDockerTransport)
+}
+
+func NewDockerTransport() interface{} {
+	return &DockerTransport{Socket: DefaultSocket}
+}
+
+type (
+	// DockerTransport reaches into a container using the Docker Engine API.
+	DockerTransport struct {
+		Socket    string `json:"socket" description:"Unix socket of the Docker daemon" trusted:"true"`
+		Container string `json:"container" description:"ID or name of the container" trusted:"true"`
+		User      string `json:"user" description:"User running commands in the container"`
+
+		lock   sync.Mutex
+		client *client
+	}
+
+	// dockerProcess is a command started by Start.
+	dockerProcess struct {
+		client *client
+		id     string
+		conn   net.Conn
+
+		// done is closed when all output is copied. err is the result.
+		done chan struct{}
+		err  error
+
+		killed int32
+	}
+
+	// archiveFile is a file read from a tar archive.
+	archiveFile struct {
+		io.Reader
+		io.Closer
+	}
+)
+
+var (
+	// ErrNoPid is returned when signalling a command which pid is not
+	// known.
+	ErrNoPid = errors.New("pid of command not known")
+)
+
+func (d *DockerTransport) GetDoc() *plugins.Doc {
+	doc := plugins.NewDoc("Docker transport")
+
+	return doc
+}
+
+// api returns the API client.
+func (d *DockerTransport) api() *client {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.client == nil {
+		d.client = newClient(d.Socket)
+	}
+
+	return d.client
+}
+
+// Dial implements plugins.Transport. If Agento runs on the Docker host,
+// connections are made in the network namespace of the container. Otherwise
+// connections to the loopback address are made to the address of the
+// container instead, and services only listening on the loopback interface
+// of the container can not be reached.
+func (d *DockerTransport) Dial(network string, address string) (net.Conn, error) {
+	var inspect containerInspect
+
+	err := d.api().call(http.MethodGet, "/containers/"+url.PathEscape(d.Container)+"/json", nil, &inspect)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	if inspect.HostConfig.NetworkMode == "host" {
+		return dialer.Dial(network, address)
+	}
+
+	if netns := inspect.netns(); netns != "" {
+		logger.Yellow("docker", "Dialing %s://%s in container %s", network, address, d.Container)
+
+		conn, err := plugins.DialInNamespaces([]plugins.Namespace{{Path: netns, Type: syscall.CLONE_NEWNET}}, dialer, network, address)
+
+		var pathError *os.PathError
+		if !errors.As(err, &pathError) {
+			return conn, err
+		}
+
+		logger.Yellow("docker", "Can't join network namespace of container %s: %s", d.Container, err.Error())
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err == nil && (host == "" || host == "localhost" || net.ParseIP(host).IsLoopback()) {
+		ip, err := inspect.ip()
+		if err != nil {
+			return nil, err
+		}
+
+		address = net.JoinHostPort(ip, port)
+	}
+
+	logger.Yellow("docker", "Dialing %s://%s for container %s", network, address, d.Container)
+
+	return dialer.Dial(network, address)
+}
+
+// netns returns the path of the network namespace of the container, or an
+// empty string if the container is not running on this host. The pid of the
+// container is in the pid namespace of the Docker daemon, so the process
+// must be in a cgroup of the container to be trusted.
+func (inspect *containerInspect) netns() string {
+	if inspect.State.Pid <= 0 || inspect.ID == "" {
+		return ""
+	}
+
+	dir := filepath.Join(configuration.ProcPath, strconv.Itoa(inspect.State.Pid))
+
+	cgroup, err := ioutil.ReadFile(filepath.Join(dir, "cgroup"))
+	if err != nil || !bytes.Contains(cgroup, []byte(inspect.ID)) {
+		return ""
+	}
+
+	return filepath.Join(dir, "ns", "net")
+}
+
+// ip returns the IP address of the container.
+func (inspect *containerInspect) ip() (string, error) {
+	if inspect.NetworkSettings.IPAddress != "" {
+		return inspect.NetworkSettings.IPAddress, nil
+	}
+
+	for _, network := range inspect.NetworkSettings.Networks {
+		if network.IPAddress != "" {
+			return network.IPAddress, nil
+		}
+	}
+
+	return "", fmt.Errorf("container %s has no IP address", inspect.ID)
+}
+
+func (d *DockerTransport) Exec(cmd string, arguments ...string) (io.Reader, io.Reader, error) {
+	var stdout, stderr bytes.Buffer
+
+	err := plugins.Run(d, &plugins.Command{
+		Path:   cmd,
+		Args:   arguments,
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+
+	return &stdout, &stderr, err
+}
+
+// Start implements plugins.Executor using container exec.
+func (d *DockerTransport) Start(cmd *plugins.Command) (plugins.Process, error) {
+	logger.Yellow("docker", "Executing command '%s' in container %s", cmd.Path, d.Container)
+
+	api := d.api()
+
+	config := execConfig{
+		AttachStdin:  cmd.Stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+		Cmd:          append([]string{cmd.Path}, cmd.Args...),
+		Env:          cmd.Env,
+		WorkingDir:   cmd.Dir,
+		User:         d.User,
+	}
+
+	var created struct {
+		ID string `json:"Id"`
+	}
+
+	err := api.call(http.MethodPost, "/containers/"+url.PathEscape(d.Container)+"/exec", config, &created)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, r, err := api.hijack("/exec/"+created.ID+"/start", execStart{})
+	if err != nil {
+		return nil, err
+	}
+
+	p := &dockerProcess{
+		client: api,
+		id:     created.ID,
+		conn:   conn,
+		done:   make(chan struct{}),
+	}
+
+	if cmd.Stdin != nil {
+		go func() {
+			io.Copy(conn, cmd.Stdin)
+
+			// Let the command see the end of the input.
+			if c, ok := conn.(*net.UnixConn); ok {
+				c.CloseWrite()
+			}
+		}()
+	}
+
+	stdout, stderr := cmd.Stdout, cmd.Stderr
+	if stdout == nil {
+		stdout = ioutil.Discard
+	}
+
+	if stderr == nil {
+		stderr = ioutil.Discard
+	}
+
+	go func() {
+		p.err = demux(r, stdout, stderr)
+		close(p.done)
+	}()
+
+	return p, nil
+}
+
+// inspect returns the state of the command.
+func (p *dockerProcess) inspect() (*execInspect, error) {
+	var inspect execInspect
+
+	err := p.client.call(http.MethodGet, "/exec/"+p.id+"/json", nil, &inspect)
+
+	return &inspect, err
+}
+
+// Signal signals the command using its pid on the host. This only works if
+// Agento runs on the Docker host with permission to signal the command.
+func (p *dockerProcess) Signal(sig syscall.Signal) error {
+	inspect, err := p.inspect()
+	if err != nil {
+		return err
+	}
+
+	if inspect.Pid <= 0 {
+		return ErrNoPid
+	}
+
+	return syscall.Kill(inspect.Pid, sig)
+}
+
+// Kill kills the command if possible and closes the connection. The Docker
+// API can't kill commands, so the command may keep running in the container
+// if Signal fails.
+func (p *dockerProcess) Kill() error {
+	err := p.Signal(syscall.SIGKILL)
+
+	atomic.StoreInt32(&p.killed, 1)
+	p.conn.Close()
+
+	return err
+}
+
+func (p *dockerProcess) Wait() error {
+	<-p.done
+	p.conn.Close()
+
+	if atomic.LoadInt32(&p.killed) == 1 {
+		return &plugins.ExitError{Code: -1, Signal: "KILL"}
+	}
+
+	if p.err != nil {
+		return p.err
+	}
+
+	// The output may end slightly before the command is reported as done.
+	for i := 0; ; i++ {
+		inspect, err := p.inspect()
+		if err != nil {
+			return err
+		}
+
+		if !inspect.Running {
+			if inspect.ExitCode != 0 {
+				return &plugins.ExitError{Code: inspect.ExitCode}
+			}
+
+			return nil
+		}
+
+		if i == 10 {
+			return errors.New("command still running after closing output")
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// Open implements plugins.Transport. Files in /proc and /sys have no size
+// and are read using cat in the container. Other files are read using the
+// archive endpoint, which works for containers without cat.
+func (d *DockerTransport) Open(path string) (io.ReadCloser, error) {
+	path = plugins.ContainerPath(path)
+
+	if strings.HasPrefix(path, "/proc/") || strings.HasPrefix(path, "/sys/") {
+		return plugins.OpenExec(d, path)
+	}
+
+	query := url.Values{}
+	query.Set("path", path)
+
+	resp, err := d.api().do(http.MethodGet, "/containers/"+url.PathEscape(d.Container)+"/archive", query, nil)
+
+	// Missing containers are reported as 404 too.
+	var apiError *APIError
+	if errors.As(err, &apiError) && apiError.StatusCode == http.StatusNotFound && !strings.HasPrefix(apiError.Message, "No such container") {
+		return nil, &os.PathError{Op: "open", Path: path, Err: syscall.ENOENT}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	archive := tar.NewReader(resp.Body)
+
+	header, err := archive.Next()
+	if err != nil {
+		resp.Body.Close()
+
+		return nil, err
+	}
+
+	if header.Typeflag == tar.TypeDir {
+		resp.Body.Close()
+
+		return nil, &os.PathError{Op: "open", Path: path, Err: syscall.EISDIR}
+	}
+
+	return &archiveFile{Reader: archive, Closer: resp.Body}, nil
+}
+
+func (d *DockerTransport) ReadFile(path string) ([]byte, error) {
+	r, err := d.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
+
+// Statfs implements plugins.Transport using stat in the container.
+func (d *DockerTransport) Statfs(path string, buf *syscall.Statfs_t) error {
+	return plugins.StatfsExec(d, plugins.ContainerPath(path), buf)
+}
+
+// Ensure compliance
+var _ plugins.Transport = (*DockerTransport)(nil)
+var _ plugins.Executor = (*DockerTransport)(nil)
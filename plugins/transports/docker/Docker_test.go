@@ -0,0 +1,403 @@
+package dockertransport
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+
+	"github.com/abrander/agento/configuration"
+	"github.com/abrander/agento/plugins"
+)
+
+const (
+	testContainerID = "4f66ad9a0b2ec3fa4bd4b06a3c1b5b1f6e4b1a7c0a5d7e9e2d1c3b4a59687766"
+)
+
+type (
+	// fakeDocker emulates the parts of the Docker Engine API used by the
+	// transport for the container "web".
+	fakeDocker struct {
+		sync.Mutex
+		files map[string]string
+		ip    string
+		pid   int
+		execs map[string]*fakeExec
+	}
+
+	fakeExec struct {
+		config   execConfig
+		exitCode int
+		running  bool
+	}
+)
+
+func frame(w io.Writer, stream byte, payload string) {
+	header := [8]byte{stream}
+	binary.BigEndian.PutUint32(header[4:], uint32(len(payload)))
+
+	w.Write(header[:])
+	io.WriteString(w, payload)
+}
+
+// run emulates cat, echo and env.
+func (f *fakeDocker) run(e *fakeExec, stdin io.Reader, w io.Writer) {
+	cmd := e.config.Cmd
+
+	switch cmd[0] {
+	case "cat":
+		if len(cmd) == 1 {
+			input, _ := ioutil.ReadAll(stdin)
+			frame(w, 1, string(input))
+			return
+		}
+
+		contents, found := f.files[cmd[1]]
+		if !found {
+			frame(w, 2, "cat: "+cmd[1]+": No such file or directory\n")
+			e.exitCode = 1
+			return
+		}
+
+		frame(w, 1, contents)
+
+	case "echo":
+		frame(w, 1, strings.Join(cmd[1:], " ")+"\n")
+
+	case "env":
+		frame(w, 1, e.config.WorkingDir+" "+strings.Join(e.config.Env, " "))
+
+	case "stat":
+		frame(w, 1, "100 50 40 4096 1000 900 255 4096\n")
+
+	default:
+		frame(w, 2, "exec failed\n")
+		e.exitCode = 126
+	}
+}
+
+func (f *fakeDocker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	path := strings.TrimPrefix(r.URL.Path, "/"+APIVersion)
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+
+	if parts[0] == "containers" && parts[1] != "web" {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, `{"message": "No such container: `+parts[1]+`"}`)
+		return
+	}
+
+	switch {
+	case path == "/containers/web/json":
+		var inspect containerInspect
+		inspect.ID = testContainerID
+		inspect.State.Pid = f.pid
+		inspect.NetworkSettings.Networks = map[string]struct{ IPAddress string }{"bridge": {IPAddress: f.ip}}
+		json.NewEncoder(w).Encode(inspect)
+
+	case path == "/containers/web/exec":
+		id := "exec" + string(rune('a'+len(f.execs)))
+		e := &fakeExec{running: true}
+		json.NewDecoder(r.Body).Decode(&e.config)
+		f.execs[id] = e
+		json.NewEncoder(w).Encode(map[string]string{"Id": id})
+
+	case len(parts) == 3 && parts[0] == "exec" && parts[2] == "start":
+		e := f.execs[parts[1]]
+
+		ioutil.ReadAll(r.Body)
+
+		conn, buf, _ := w.(http.Hijacker).Hijack()
+		buf.WriteString("HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.raw-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+		buf.Flush()
+
+		f.run(e, buf, conn)
+		e.running = false
+		conn.Close()
+
+	case len(parts) == 3 && parts[0] == "exec" && parts[2] == "json":
+		e := f.execs[parts[1]]
+		json.NewEncoder(w).Encode(execInspect{Running: e.running, ExitCode: e.exitCode})
+
+	case path == "/containers/web/archive":
+		contents, found := f.files[r.URL.Query().Get("path")]
+		if !found {
+			w.WriteHeader(http.StatusNotFound)
+			io.WriteString(w, `{"message": "Could not find the file"}`)
+			return
+		}
+
+		archive := tar.NewWriter(w)
+		archive.WriteHeader(&tar.Header{Name: filepath.Base(r.URL.Query().Get("path")), Mode: 0644, Size: int64(len(contents))})
+		io.WriteString(archive, contents)
+		archive.Close()
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func testDocker(t *testing.T) (*DockerTransport, *fakeDocker) {
+	socket := filepath.Join(t.TempDir(), "docker.sock")
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Listen() failed: %s", err.Error())
+	}
+
+	fake := &fakeDocker{
+		files: map[string]string{
+			"/proc/loadavg":   "0.50 0.40 0.30 1/100 1234\n",
+			"/etc/os-release": "ID=alpine\n",
+		},
+		execs: make(map[string]*fakeExec),
+	}
+
+	server := &http.Server{Handler: fake}
+	go server.Serve(listener)
+
+	t.Cleanup(func() {
+		server.Close()
+	})
+
+	d := NewDockerTransport().(*DockerTransport)
+	d.Socket = socket
+	d.Container = "web"
+
+	return d, fake
+}
+
+func TestDockerExec(t *testing.T) {
+	d, fake := testDocker(t)
+
+	stdout, _, err := d.Exec("echo", "hello", "world")
+	if err != nil {
+		t.Fatalf("Exec() failed: %s", err.Error())
+	}
+
+	out, _ := ioutil.ReadAll(stdout)
+	if string(out) != "hello world\n" {
+		t.Errorf("Exec() returned '%s'", string(out))
+	}
+
+	_, stderr, err := d.Exec("missing")
+	if plugins.ExitCode(err) != 126 {
+		t.Errorf("Exec() returned %v, expected exit status 126", err)
+	}
+
+	errOut, _ := ioutil.ReadAll(stderr)
+	if string(errOut) != "exec failed\n" {
+		t.Errorf("Exec() returned stderr '%s'", string(errOut))
+	}
+
+	var output bytes.Buffer
+
+	err = plugins.Run(d, &plugins.Command{Path: "cat", Stdin: strings.NewReader("input"), Stdout: &output})
+	if err != nil || output.String() != "input" {
+		t.Errorf("Run() returned %v and '%s' for command with input", err, output.String())
+	}
+
+	output.Reset()
+
+	err = plugins.Run(d, &plugins.Command{Path: "env", Env: []string{"A=b"}, Dir: "/srv", Stdout: &output})
+	if err != nil || output.String() != "/srv A=b" {
+		t.Errorf("Run() returned %v and '%s' for command with environment", err, output.String())
+	}
+
+	fake.Lock()
+	defer fake.Unlock()
+
+	for _, e := range fake.execs {
+		if !e.config.AttachStdout || !e.config.AttachStderr || e.config.Tty {
+			t.Errorf("Wrong exec configuration: %+v", e.config)
+		}
+	}
+}
+
+func TestDockerReadFile(t *testing.T) {
+	d, _ := testDocker(t)
+
+	for path, expected := range map[string]string{
+		"/proc/loadavg":   "0.50 0.40 0.30 1/100 1234\n",
+		"/etc/os-release": "ID=alpine\n",
+	} {
+		contents, err := d.ReadFile(path)
+		if err != nil {
+			t.Errorf("ReadFile(%s) failed: %s", path, err.Error())
+			continue
+		}
+
+		if string(contents) != expected {
+			t.Errorf("ReadFile(%s) returned '%s'", path, string(contents))
+		}
+	}
+
+	for _, path := range []string{"/proc/missing", "/etc/missing"} {
+		_, err := d.ReadFile(path)
+		if _, ok := err.(*os.PathError); !ok {
+			t.Errorf("ReadFile(%s) returned %v, expected *os.PathError", path, err)
+		}
+	}
+
+	_, err := d.ReadFile("/etc/missing")
+	if !os.IsNotExist(err) {
+		t.Errorf("ReadFile() returned %v for missing file", err)
+	}
+
+	d.Container = "db"
+
+	_, err = d.ReadFile("/etc/os-release")
+	if apiError, ok := err.(*APIError); !ok || apiError.StatusCode != http.StatusNotFound {
+		t.Errorf("ReadFile() returned %v for unknown container", err)
+	}
+}
+
+func TestDockerStatfs(t *testing.T) {
+	d, _ := testDocker(t)
+
+	var buf syscall.Statfs_t
+
+	err := d.Statfs("/", &buf)
+	if err != nil {
+		t.Fatalf("Statfs() failed: %s", err.Error())
+	}
+
+	if buf.Blocks != 100 || buf.Bfree != 50 || buf.Bavail != 40 || buf.Frsize != 4096 || buf.Files != 1000 || buf.Ffree != 900 {
+		t.Errorf("Wrong result from Statfs(): %+v", buf)
+	}
+}
+
+func TestDockerDial(t *testing.T) {
+	d, fake := testDocker(t)
+
+	// Pretend the container has another loopback address.
+	listener, err := net.Listen("tcp", "127.0.0.2:0")
+	if err != nil {
+		t.Skipf("Can't listen on 127.0.0.2: %s", err.Error())
+	}
+	defer listener.Close()
+
+	fake.ip = "127.0.0.2"
+
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			io.WriteString(conn, "hello")
+			conn.Close()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	conn, err := d.Dial("tcp", "localhost:"+port)
+	if err != nil {
+		t.Fatalf("Dial() failed: %s", err.Error())
+	}
+	defer conn.Close()
+
+	greeting, _ := ioutil.ReadAll(conn)
+	if string(greeting) != "hello" {
+		t.Errorf("Read '%s' from container", string(greeting))
+	}
+}
+
+// unshared starts a process in a new network namespace with the loopback
+// interface up, and returns its pid.
+func unshared(t *testing.T) int {
+	cmd := exec.Command("unshare", "--net", "sh", "-c", "ip link set lo up && echo && exec sleep 30")
+
+	stdout, _ := cmd.StdoutPipe()
+
+	err := cmd.Start()
+	if err != nil {
+		t.Skipf("unshare not available: %s", err.Error())
+	}
+
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	_, err = bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Skipf("Namespaces can't be created")
+	}
+
+	return cmd.Process.Pid
+}
+
+func TestDockerDialNamespace(t *testing.T) {
+	d, fake := testDocker(t)
+
+	pid := unshared(t)
+	netns := "/proc/" + strconv.Itoa(pid) + "/ns/net"
+
+	// The container has pid 4242 in the pid namespace of the daemon.
+	proc := t.TempDir()
+	os.MkdirAll(filepath.Join(proc, "4242", "ns"), 0755)
+	os.Symlink(netns, filepath.Join(proc, "4242", "ns", "net"))
+	ioutil.WriteFile(filepath.Join(proc, "4242", "cgroup"), []byte("0::/system.slice/docker-"+testContainerID+".scope\n"), 0644)
+
+	defer func(path string) { configuration.ProcPath = path }(configuration.ProcPath)
+	configuration.ProcPath = proc
+
+	fake.pid = 4242
+
+	// The service only listens on the loopback interface of the
+	// container.
+	var listener net.Listener
+	err := plugins.InNamespaces([]plugins.Namespace{{Path: netns, Type: syscall.CLONE_NEWNET}}, func() error {
+		var err error
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Listen() in namespace failed: %s", err.Error())
+	}
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			io.WriteString(conn, "hello")
+			conn.Close()
+		}
+	}()
+
+	conn, err := d.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() failed: %s", err.Error())
+	}
+	defer conn.Close()
+
+	greeting, _ := ioutil.ReadAll(conn)
+	if string(greeting) != "hello" {
+		t.Errorf("Read '%s' from container", string(greeting))
+	}
+
+	// Processes outside the cgroups of the container are not trusted.
+	ioutil.WriteFile(filepath.Join(proc, "4242", "cgroup"), []byte("0::/user.slice\n"), 0644)
+
+	inspect := containerInspect{ID: testContainerID}
+	inspect.State.Pid = 4242
+
+	if inspect.netns() != "" {
+		t.Errorf("netns() trusted process outside the container")
+	}
+}
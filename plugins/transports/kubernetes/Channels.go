@@ -0,0 +1,186 @@
+package kubernetestransport
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type (
+	// channels multiplexes streams over a websocket using the channel
+	// protocol of Kubernetes. The first byte of each message is the number
+	// of the stream.
+	channels struct {
+		ws        *websocket.Conn
+		writeLock sync.Mutex
+	}
+
+	// forwardedConn is a connection to a port of a pod using port
+	// forwarding.
+	forwardedConn struct {
+		channels *channels
+
+		readLock sync.Mutex
+		buffer   []byte
+
+		// The first message on each stream is the port number.
+		dataStarted  bool
+		errorStarted bool
+	}
+)
+
+const (
+	// Streams used by exec.
+	stdinChannel  = 0
+	stdoutChannel = 1
+	stderrChannel = 2
+	statusChannel = 3
+
+	// closeChannel is used for closing a stream in version 5 of the
+	// protocol.
+	closeChannel = 255
+
+	// Streams used by port forwarding.
+	dataChannel  = 0
+	errorChannel = 1
+
+	// v4Protocol is supported by all current versions of Kubernetes.
+	v4Protocol = "v4.channel.k8s.io"
+
+	// v5Protocol adds closing of stdin.
+	v5Protocol = "v5.channel.k8s.io"
+)
+
+// write writes data to the stream channel.
+func (c *channels) write(channel byte, data []byte) error {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	return c.ws.WriteMessage(websocket.BinaryMessage, append([]byte{channel}, data...))
+}
+
+// closeStream tells the other end that no more data is written to channel.
+// This is not possible with version 4 of the protocol.
+func (c *channels) closeStream(channel byte) error {
+	if c.ws.Subprotocol() != v5Protocol {
+		return nil
+	}
+
+	return c.write(closeChannel, []byte{channel})
+}
+
+// read returns the next message. io.EOF is returned when the websocket is
+// closed normally.
+func (c *channels) read() (byte, []byte, error) {
+	for {
+		_, message, err := c.ws.ReadMessage()
+		if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+			return 0, nil, io.EOF
+		}
+
+		if err != nil {
+			return 0, nil, err
+		}
+
+		if len(message) > 0 {
+			return message[0], message[1:], nil
+		}
+	}
+}
+
+func (c *channels) Close() error {
+	return c.ws.Close()
+}
+
+// portOf returns the port number of port, which can be a service name.
+func portOf(port string) (int, error) {
+	n, err := strconv.Atoi(port)
+	if err == nil {
+		return n, nil
+	}
+
+	return net.LookupPort("tcp", port)
+}
+
+func (f *forwardedConn) Read(b []byte) (int, error) {
+	f.readLock.Lock()
+	defer f.readLock.Unlock()
+
+	for len(f.buffer) == 0 {
+		channel, message, err := f.channels.read()
+		if err != nil {
+			return 0, err
+		}
+
+		switch channel {
+		case dataChannel:
+			if !f.dataStarted && len(message) >= 2 {
+				f.dataStarted = true
+				message = message[2:]
+			}
+
+			f.buffer = message
+
+		case errorChannel:
+			if !f.errorStarted && len(message) >= 2 {
+				f.errorStarted = true
+				message = message[2:]
+			}
+
+			if len(message) > 0 {
+				return 0, errors.New("port forwarding failed: " + string(message))
+			}
+		}
+	}
+
+	n := copy(b, f.buffer)
+	f.buffer = f.buffer[n:]
+
+	return n, nil
+}
+
+func (f *forwardedConn) Write(b []byte) (int, error) {
+	err := f.channels.write(dataChannel, b)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(b), nil
+}
+
+func (f *forwardedConn) Close() error {
+	return f.channels.Close()
+}
+
+func (f *forwardedConn) LocalAddr() net.Addr {
+	return f.channels.ws.LocalAddr()
+}
+
+func (f *forwardedConn) RemoteAddr() net.Addr {
+	return f.channels.ws.RemoteAddr()
+}
+
+func (f *forwardedConn) SetDeadline(t time.Time) error {
+	err := f.SetReadDeadline(t)
+	if err != nil {
+		return err
+	}
+
+	return f.SetWriteDeadline(t)
+}
+
+func (f *forwardedConn) SetReadDeadline(t time.Time) error {
+	return f.channels.ws.SetReadDeadline(t)
+}
+
+func (f *forwardedConn) SetWriteDeadline(t time.Time) error {
+	return f.channels.ws.SetWriteDeadline(t)
+}
+
+// Ensure compliance
+var _ net.Conn = (*forwardedConn)(nil)
@@ -0,0 +1,256 @@
+package kubernetestransport
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type (
+	// Connection is the configuration for reaching the Kubernetes API. The
+	// defaults use the service account of the pod running Agento.
+	Connection struct {
+		Server   string `json:"server" description:"URL of the Kubernetes API server. Defaults to the cluster running Agento" trusted:"default"`
+		Token    string `json:"token" description:"Bearer token. Defaults to the token of the service account" secret:"true"`
+		CAFile   string `json:"caFile" description:"CA certificates of the API server" trusted:"true"`
+		Insecure bool   `json:"insecure" description:"Don't verify the certificate of the API server"`
+
+		lock   sync.Mutex
+		client *client
+	}
+
+	// client is a minimal client for the Kubernetes API.
+	client struct {
+		server    string
+		token     string
+		tokenFile string
+		http      *http.Client
+		websocket *websocket.Dialer
+	}
+
+	// APIError is an error returned by the Kubernetes API.
+	APIError struct {
+		StatusCode int
+		Message    string
+	}
+
+	// status is the Status object returned for errors and by exec.
+	status struct {
+		Status  string `json:"status"`
+		Message string `json:"message"`
+		Reason  string `json:"reason"`
+		Details struct {
+			Causes []struct {
+				Reason  string `json:"reason"`
+				Message string `json:"message"`
+			} `json:"causes"`
+		} `json:"details"`
+	}
+)
+
+var (
+	// ServiceAccountPath is where Kubernetes mounts the service account of
+	// pods.
+	ServiceAccountPath = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+	// ErrNotInCluster is returned if no server is configured and Agento
+	// is not running in a pod.
+	ErrNotInCluster = errors.New("no Kubernetes API server configured and not running in a pod")
+)
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("kubernetes: %s (%d)", e.Message, e.StatusCode)
+}
+
+// api returns the API client.
+func (c *Connection) api() (*client, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.client != nil {
+		return c.client, nil
+	}
+
+	server := c.Server
+	caFile := c.CAFile
+
+	api := &client{token: c.Token}
+
+	if server == "" {
+		host := os.Getenv("KUBERNETES_SERVICE_HOST")
+		port := os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, ErrNotInCluster
+		}
+
+		server = "https://" + net.JoinHostPort(host, port)
+
+		if caFile == "" {
+			caFile = ServiceAccountPath + "/ca.crt"
+		}
+
+		// The token is read for every request, it's rotated by the
+		// kubelet.
+		if api.token == "" {
+			api.tokenFile = ServiceAccountPath + "/token"
+		}
+	}
+
+	api.server = strings.TrimSuffix(server, "/")
+
+	config := &tls.Config{
+		InsecureSkipVerify: c.Insecure,
+	}
+
+	if caFile != "" {
+		pem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", caFile)
+		}
+	}
+
+	api.http = &http.Client{
+		Timeout: 30 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: config,
+		},
+	}
+
+	api.websocket = &websocket.Dialer{
+		TLSClientConfig:  config,
+		HandshakeTimeout: 30 * time.Second,
+	}
+
+	c.client = api
+
+	return api, nil
+}
+
+// namespace returns namespace or the namespace of the pod running Agento
+// if empty. "default" is used outside Kubernetes.
+func namespace(namespace string) string {
+	if namespace != "" {
+		return namespace
+	}
+
+	b, err := ioutil.ReadFile(ServiceAccountPath + "/namespace")
+	if err == nil && len(b) > 0 {
+		return strings.TrimSpace(string(b))
+	}
+
+	return "default"
+}
+
+// header returns the headers for authenticating a request.
+func (c *client) header() (http.Header, error) {
+	header := make(http.Header)
+
+	token := c.token
+	if c.tokenFile != "" {
+		b, err := ioutil.ReadFile(c.tokenFile)
+		if err != nil {
+			return nil, err
+		}
+
+		token = strings.TrimSpace(string(b))
+	}
+
+	if token != "" {
+		header.Set("Authorization", "Bearer "+token)
+	}
+
+	return header, nil
+}
+
+// apiError reads the error from a response.
+func apiError(resp *http.Response) error {
+	var s status
+
+	b, _ := ioutil.ReadAll(resp.Body)
+	if json.Unmarshal(b, &s) != nil || s.Message == "" {
+		s.Message = http.StatusText(resp.StatusCode)
+	}
+
+	return &APIError{StatusCode: resp.StatusCode, Message: s.Message}
+}
+
+// get reads path and decodes the JSON response into result.
+func (c *client) get(path string, query url.Values, result interface{}) error {
+	u := c.server + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header, err = c.header()
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 400 {
+		return apiError(resp)
+	}
+
+	return json.NewDecoder(resp.Body).Decode(result)
+}
+
+// stream opens a websocket to path using one of the channel protocols.
+func (c *client) stream(path string, query url.Values, protocols ...string) (*channels, error) {
+	u := c.server + path + "?" + query.Encode()
+
+	if strings.HasPrefix(u, "https://") {
+		u = "wss://" + u[8:]
+	} else if strings.HasPrefix(u, "http://") {
+		u = "ws://" + u[7:]
+	}
+
+	header, err := c.header()
+	if err != nil {
+		return nil, err
+	}
+
+	dialer := *c.websocket
+	dialer.Subprotocols = protocols
+
+	ws, resp, err := dialer.Dial(u, header)
+	if err == websocket.ErrBadHandshake && resp != nil {
+		defer resp.Body.Close()
+
+		return nil, apiError(resp)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &channels{ws: ws}, nil
+}
@@ -0,0 +1,221 @@
+package kubernetestransport
+
+import (
+	"net/url"
+
+	"github.com/abrander/agento/plugins"
+)
+
+func init() {
+	plugins.Register("kubernetesdiscovery", NewKubernetesDiscovery)
+}
+
+func NewKubernetesDiscovery() interface{} {
+	return &KubernetesDiscovery{
+		Role:      "pod",
+		Transport: "sshtransport",
+	}
+}
+
+type (
+	// KubernetesDiscovery finds pods or nodes matching a label selector.
+	KubernetesDiscovery struct {
+		Connection
+		Role      string                 `json:"role" description:"The kind of hosts to discover" enum:"pod,node"`
+		Namespace string                 `json:"namespace" description:"Namespace of pods. Empty means all namespaces"`
+		Selector  string                 `json:"selector" description:"Label selector like 'app=mysql'. Empty matches all"`
+		Container string                 `json:"container" description:"Container of discovered pods to use"`
+		Transport string                 `json:"transport" description:"Transport used for nodes"`
+		Config    map[string]interface{} `json:"config" description:"Configuration of the transport used for nodes"`
+	}
+
+	metadata struct {
+		Name              string            `json:"name"`
+		Namespace         string            `json:"namespace"`
+		Labels            map[string]string `json:"labels"`
+		DeletionTimestamp *string           `json:"deletionTimestamp"`
+	}
+
+	podList struct {
+		Items []struct {
+			Metadata metadata `json:"metadata"`
+			Spec     struct {
+				NodeName string `json:"nodeName"`
+			} `json:"spec"`
+			Status struct {
+				Phase string `json:"phase"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+
+	nodeList struct {
+		Items []struct {
+			Metadata metadata `json:"metadata"`
+			Status   struct {
+				Addresses []struct {
+					Type    string `json:"type"`
+					Address string `json:"address"`
+				} `json:"addresses"`
+				Conditions []struct {
+					Type   string `json:"type"`
+					Status string `json:"status"`
+				} `json:"conditions"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+)
+
+func (k *KubernetesDiscovery) GetDoc() *plugins.Doc {
+	doc := plugins.NewDoc("Kubernetes pods and nodes")
+
+	doc.AddTag("namespace", "The namespace of the pod")
+	doc.AddTag("node", "The node running the pod")
+
+	return doc
+}
+
+// Discover implements plugins.Discoverer.
+func (k *KubernetesDiscovery) Discover() ([]plugins.Target, error) {
+	if k.Role == "node" {
+		return k.nodes()
+	}
+
+	return k.pods()
+}
+
+// query returns the query selecting objects.
+func (k *KubernetesDiscovery) query() url.Values {
+	query := url.Values{}
+
+	if k.Selector != "" {
+		query.Set("labelSelector", k.Selector)
+	}
+
+	return query
+}
+
+// pods returns running pods. The pods are reached using the transport of
+// this package.
+func (k *KubernetesDiscovery) pods() ([]plugins.Target, error) {
+	api, err := k.api()
+	if err != nil {
+		return nil, err
+	}
+
+	path := "/api/v1/pods"
+	if k.Namespace != "" {
+		path = "/api/v1/namespaces/" + url.PathEscape(k.Namespace) + "/pods"
+	}
+
+	var list podList
+
+	err = api.get(path, k.query(), &list)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := []plugins.Target{}
+
+	for _, pod := range list.Items {
+		if pod.Status.Phase != "Running" || pod.Metadata.DeletionTimestamp != nil {
+			continue
+		}
+
+		config := map[string]interface{}{
+			"namespace": pod.Metadata.Namespace,
+			"pod":       pod.Metadata.Name,
+		}
+
+		if k.Container != "" {
+			config["container"] = k.Container
+		}
+
+		if k.Server != "" {
+			config["server"] = k.Server
+		}
+
+		if k.Token != "" {
+			config["token"] = k.Token
+		}
+
+		if k.CAFile != "" {
+			config["caFile"] = k.CAFile
+		}
+
+		if k.Insecure {
+			config["insecure"] = true
+		}
+
+		targets = append(targets, plugins.Target{
+			Name:            pod.Metadata.Name,
+			TransportID:     "kubernetestransport",
+			TransportConfig: config,
+			Tags: map[string]string{
+				"namespace": pod.Metadata.Namespace,
+				"node":      pod.Spec.NodeName,
+			},
+		})
+	}
+
+	return targets, nil
+}
+
+// nodes returns ready nodes. The nodes are reached using the configured
+// transport, with the "host" parameter set to the address of the node.
+func (k *KubernetesDiscovery) nodes() ([]plugins.Target, error) {
+	api, err := k.api()
+	if err != nil {
+		return nil, err
+	}
+
+	var list nodeList
+
+	err = api.get("/api/v1/nodes", k.query(), &list)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := []plugins.Target{}
+
+	for _, node := range list.Items {
+		ready := false
+		for _, condition := range node.Status.Conditions {
+			if condition.Type == "Ready" {
+				ready = condition.Status == "True"
+			}
+		}
+
+		if !ready {
+			continue
+		}
+
+		// Prefer the internal address.
+		address := ""
+		for _, typ := range []string{"InternalIP", "ExternalIP", "Hostname"} {
+			for _, a := range node.Status.Addresses {
+				if address == "" && a.Type == typ {
+					address = a.Address
+				}
+			}
+		}
+
+		config := make(map[string]interface{}, len(k.Config)+1)
+		for key, value := range k.Config {
+			config[key] = value
+		}
+
+		config["host"] = address
+
+		targets = append(targets, plugins.Target{
+			Name:            node.Metadata.Name,
+			TransportID:     k.Transport,
+			TransportConfig: config,
+			Tags:            map[string]string{},
+		})
+	}
+
+	return targets, nil
+}
+
+// Ensure compliance
+var _ plugins.Discoverer = (*KubernetesDiscovery)(nil)
@@ -0,0 +1,312 @@
+package kubernetestransport
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/abrander/agento/logger"
+	"github.com/abrander/agento/plugins"
+)
+
+func init() {
+	plugins.Register("kubernetestransport", NewKubernetesTransport)
+}
+
+func NewKubernetesTransport() interface{} {
+	return &KubernetesTransport{}
+}
+
+type (
+	// KubernetesTransport reaches into a pod using the Kubernetes API.
+	KubernetesTransport struct {
+		Connection
+		Namespace string `json:"namespace" description:"Namespace of the pod. Defaults to the namespace of Agento"`
+		Pod       string `json:"pod" description:"Name of the pod"`
+		Container string `json:"container" description:"Container in the pod. Defaults to the first container"`
+	}
+
+	// podProcess is a command started by Start.
+	podProcess struct {
+		channels *channels
+
+		// done is closed when the command is done. err is the result.
+		done chan struct{}
+		err  error
+
+		killed int32
+	}
+)
+
+var (
+	// ErrNoSignals is returned when signalling commands. The exec API of
+	// Kubernetes can't signal commands.
+	ErrNoSignals = errors.New("commands in pods can not be signalled")
+
+	// ErrUnixSockets is returned when dialing Unix sockets. Only TCP ports
+	// can be forwarded.
+	ErrUnixSockets = errors.New("unix sockets in pods can not be reached")
+)
+
+func (k *KubernetesTransport) GetDoc() *plugins.Doc {
+	doc := plugins.NewDoc("Kubernetes transport")
+
+	return doc
+}
+
+// path returns the API path of the pod.
+func (k *KubernetesTransport) path(subresource string) string {
+	return "/api/v1/namespaces/" + url.PathEscape(namespace(k.Namespace)) + "/pods/" + url.PathEscape(k.Pod) + "/" + subresource
+}
+
+// Dial implements plugins.Transport. Connections to the loopback address are
+// forwarded to the pod using port forwarding. Other addresses are dialed
+// directly.
+func (k *KubernetesTransport) Dial(network string, address string) (net.Conn, error) {
+	if strings.HasPrefix(network, "unix") {
+		return nil, ErrUnixSockets
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil || !(host == "" || host == "localhost" || net.ParseIP(host).IsLoopback()) {
+		logger.Yellow("kubernetes", "Dialing %s://%s for pod %s", network, address, k.Pod)
+
+		dialer := &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}
+
+		return dialer.Dial(network, address)
+	}
+
+	logger.Yellow("kubernetes", "Forwarding port %s of pod %s", port, k.Pod)
+
+	n, err := portOf(port)
+	if err != nil {
+		return nil, err
+	}
+
+	api, err := k.api()
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	query.Set("ports", strconv.Itoa(n))
+
+	c, err := api.stream(k.path("portforward"), query, v4Protocol)
+	if err != nil {
+		return nil, err
+	}
+
+	return &forwardedConn{channels: c}, nil
+}
+
+func (k *KubernetesTransport) Exec(cmd string, arguments ...string) (io.Reader, io.Reader, error) {
+	var stdout, stderr bytes.Buffer
+
+	err := plugins.Run(k, &plugins.Command{
+		Path:   cmd,
+		Args:   arguments,
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+
+	return &stdout, &stderr, err
+}
+
+// commandLine returns the arguments for running cmd. The exec API can't set
+// the environment or working directory, env and sh are used for that.
+func commandLine(cmd *plugins.Command) []string {
+	args := append([]string{cmd.Path}, cmd.Args...)
+
+	if len(cmd.Env) > 0 {
+		args = append(append([]string{"env"}, cmd.Env...), args...)
+	}
+
+	if cmd.Dir != "" {
+		args = append([]string{"sh", "-c", `cd "$0" && exec "$@"`, cmd.Dir}, args...)
+	}
+
+	return args
+}
+
+// Start implements plugins.Executor using the exec API.
+func (k *KubernetesTransport) Start(cmd *plugins.Command) (plugins.Process, error) {
+	logger.Yellow("kubernetes", "Executing command '%s' in pod %s", cmd.Path, k.Pod)
+
+	api, err := k.api()
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	for _, arg := range commandLine(cmd) {
+		query.Add("command", arg)
+	}
+
+	if k.Container != "" {
+		query.Set("container", k.Container)
+	}
+
+	query.Set("stdout", "true")
+	query.Set("stderr", "true")
+
+	if cmd.Stdin != nil {
+		query.Set("stdin", "true")
+	}
+
+	c, err := api.stream(k.path("exec"), query, v5Protocol, v4Protocol)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &podProcess{
+		channels: c,
+		done:     make(chan struct{}),
+	}
+
+	if cmd.Stdin != nil {
+		go func() {
+			buf := make([]byte, 32*1024)
+
+			for {
+				n, err := cmd.Stdin.Read(buf)
+				if n > 0 && c.write(stdinChannel, buf[:n]) != nil {
+					return
+				}
+
+				if err != nil {
+					break
+				}
+			}
+
+			// Let the command see the end of the input.
+			c.closeStream(stdinChannel)
+		}()
+	}
+
+	stdout, stderr := cmd.Stdout, cmd.Stderr
+	if stdout == nil {
+		stdout = ioutil.Discard
+	}
+
+	if stderr == nil {
+		stderr = ioutil.Discard
+	}
+
+	go func() {
+		p.err = p.copy(stdout, stderr)
+		close(p.done)
+	}()
+
+	return p, nil
+}
+
+// copy copies output until the status of the command is received.
+func (p *podProcess) copy(stdout io.Writer, stderr io.Writer) error {
+	for {
+		channel, message, err := p.channels.read()
+		if err == io.EOF {
+			return errors.New("connection closed before the command was done")
+		}
+
+		if err != nil {
+			return err
+		}
+
+		switch channel {
+		case stdoutChannel:
+			stdout.Write(message)
+
+		case stderrChannel:
+			stderr.Write(message)
+
+		case statusChannel:
+			return exitError(message)
+		}
+	}
+}
+
+// exitError returns the error for the status of a command.
+func exitError(message []byte) error {
+	var s status
+
+	err := json.Unmarshal(message, &s)
+	if err != nil {
+		return err
+	}
+
+	if s.Status == "Success" {
+		return nil
+	}
+
+	if s.Reason == "NonZeroExitCode" {
+		for _, cause := range s.Details.Causes {
+			if cause.Reason == "ExitCode" {
+				code, err := strconv.Atoi(cause.Message)
+				if err == nil {
+					return &plugins.ExitError{Code: code}
+				}
+			}
+		}
+	}
+
+	return errors.New(s.Message)
+}
+
+func (p *podProcess) Signal(sig syscall.Signal) error {
+	return ErrNoSignals
+}
+
+// Kill closes the connection. Kubernetes can't kill commands, it's left to
+// the command to exit when its output is closed.
+func (p *podProcess) Kill() error {
+	atomic.StoreInt32(&p.killed, 1)
+
+	return p.channels.Close()
+}
+
+func (p *podProcess) Wait() error {
+	<-p.done
+	p.channels.Close()
+
+	if atomic.LoadInt32(&p.killed) == 1 {
+		return &plugins.ExitError{Code: -1, Signal: "KILL"}
+	}
+
+	return p.err
+}
+
+// Open implements plugins.Transport using cat in the container.
+func (k *KubernetesTransport) Open(path string) (io.ReadCloser, error) {
+	return plugins.OpenExec(k, plugins.ContainerPath(path))
+}
+
+func (k *KubernetesTransport) ReadFile(path string) ([]byte, error) {
+	r, err := k.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return ioutil.ReadAll(r)
+}
+
+// Statfs implements plugins.Transport using stat in the container.
+func (k *KubernetesTransport) Statfs(path string, buf *syscall.Statfs_t) error {
+	return plugins.StatfsExec(k, plugins.ContainerPath(path), buf)
+}
+
+// Ensure compliance
+var _ plugins.Transport = (*KubernetesTransport)(nil)
+var _ plugins.Executor = (*KubernetesTransport)(nil)
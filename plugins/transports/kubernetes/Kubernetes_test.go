@@ -0,0 +1,321 @@
+package kubernetestransport
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/abrander/agento/plugins"
+)
+
+type (
+	// fakeAPI emulates the parts of the Kubernetes API used by the
+	// transport and discovery for the pod "web" in the namespace "default".
+	fakeAPI struct {
+		upgrader websocket.Upgrader
+	}
+)
+
+const (
+	podsJSON = `{"items": [
+		{"metadata": {"name": "mysql-0", "namespace": "db"}, "spec": {"nodeName": "node1"}, "status": {"phase": "Running"}},
+		{"metadata": {"name": "mysql-1", "namespace": "db"}, "spec": {"nodeName": "node2"}, "status": {"phase": "Pending"}},
+		{"metadata": {"name": "mysql-2", "namespace": "db", "deletionTimestamp": "2026-01-01T00:00:00Z"}, "spec": {"nodeName": "node2"}, "status": {"phase": "Running"}}
+	]}`
+
+	nodesJSON = `{"items": [
+		{"metadata": {"name": "node1"}, "status": {
+			"addresses": [{"type": "Hostname", "address": "node1"}, {"type": "InternalIP", "address": "10.0.0.1"}],
+			"conditions": [{"type": "Ready", "status": "True"}]}},
+		{"metadata": {"name": "node2"}, "status": {
+			"addresses": [{"type": "InternalIP", "address": "10.0.0.2"}],
+			"conditions": [{"type": "Ready", "status": "False"}]}}
+	]}`
+)
+
+func send(ws *websocket.Conn, channel byte, payload string) {
+	ws.WriteMessage(websocket.BinaryMessage, append([]byte{channel}, payload...))
+}
+
+// exec emulates echo, cat and a failing command.
+func (f *fakeAPI) exec(ws *websocket.Conn, command []string) {
+	switch command[0] {
+	case "echo":
+		send(ws, stdoutChannel, strings.Join(command[1:], " ")+"\n")
+
+	case "cat":
+		if len(command) > 1 {
+			if command[1] != "/proc/loadavg" {
+				send(ws, stderrChannel, "cat: "+command[1]+": No such file or directory\n")
+				send(ws, statusChannel, `{"status": "Failure", "reason": "NonZeroExitCode", "details": {"causes": [{"reason": "ExitCode", "message": "1"}]}}`)
+				return
+			}
+
+			send(ws, stdoutChannel, "0.50 0.40 0.30 1/100 1234\n")
+			break
+		}
+
+		// Echo input until stdin is closed.
+		for {
+			_, message, err := ws.ReadMessage()
+			if err != nil {
+				return
+			}
+
+			if message[0] == closeChannel {
+				break
+			}
+
+			send(ws, stdoutChannel, string(message[1:]))
+		}
+
+	case "sh":
+		send(ws, stdoutChannel, strings.Join(command, " "))
+
+	case "stat":
+		send(ws, stdoutChannel, "100 50 40 4096 1000 900 255 4096\n")
+
+	default:
+		send(ws, statusChannel, `{"status": "Failure", "reason": "InternalError", "message": "executable file not found"}`)
+		return
+	}
+
+	send(ws, statusChannel, `{"status": "Success"}`)
+}
+
+// forward answers the first message with a greeting including the port.
+func (f *fakeAPI) forward(ws *websocket.Conn, port string) {
+	prefix := make([]byte, 2)
+	binary.LittleEndian.PutUint16(prefix, 3306)
+
+	send(ws, dataChannel, string(prefix))
+	send(ws, errorChannel, string(prefix))
+
+	_, message, err := ws.ReadMessage()
+	if err != nil {
+		return
+	}
+
+	send(ws, dataChannel, "port "+port+": "+string(message[1:]))
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, `{"kind": "Status", "status": "Failure", "message": "Unauthorized", "code": 401}`)
+		return
+	}
+
+	switch r.URL.Path {
+	case "/api/v1/namespaces/default/pods/web/exec":
+		if r.URL.Query().Get("container") != "app" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		ws, err := f.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+
+		f.exec(ws, r.URL.Query()["command"])
+
+	case "/api/v1/namespaces/default/pods/web/portforward":
+		ws, err := f.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+
+		f.forward(ws, r.URL.Query().Get("ports"))
+
+	case "/api/v1/namespaces/db/pods":
+		if r.URL.Query().Get("labelSelector") != "app=mysql" {
+			io.WriteString(w, `{"items": []}`)
+			return
+		}
+
+		io.WriteString(w, podsJSON)
+
+	case "/api/v1/nodes":
+		io.WriteString(w, nodesJSON)
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, `{"kind": "Status", "status": "Failure", "message": "pods \"missing\" not found", "code": 404}`)
+	}
+}
+
+func testAPI(t *testing.T, protocols ...string) Connection {
+	server := httptest.NewServer(&fakeAPI{
+		upgrader: websocket.Upgrader{Subprotocols: protocols},
+	})
+	t.Cleanup(server.Close)
+
+	return Connection{Server: server.URL, Token: "secret"}
+}
+
+func testTransport(t *testing.T, protocols ...string) *KubernetesTransport {
+	return &KubernetesTransport{
+		Connection: testAPI(t, protocols...),
+		Namespace:  "default",
+		Pod:        "web",
+		Container:  "app",
+	}
+}
+
+func TestKubernetesExec(t *testing.T) {
+	k := testTransport(t, v5Protocol, v4Protocol)
+
+	stdout, _, err := k.Exec("echo", "hello", "world")
+	if err != nil {
+		t.Fatalf("Exec() failed: %s", err.Error())
+	}
+
+	out, _ := ioutil.ReadAll(stdout)
+	if string(out) != "hello world\n" {
+		t.Errorf("Exec() returned '%s'", string(out))
+	}
+
+	_, _, err = k.Exec("missing")
+	if err == nil || err.Error() != "executable file not found" {
+		t.Errorf("Exec() returned %v for missing command", err)
+	}
+
+	var output bytes.Buffer
+
+	err = plugins.Run(k, &plugins.Command{Path: "cat", Stdin: strings.NewReader("input"), Stdout: &output})
+	if err != nil || output.String() != "input" {
+		t.Errorf("Run() returned %v and '%s' for command with input", err, output.String())
+	}
+
+	output.Reset()
+
+	err = plugins.Run(k, &plugins.Command{Path: "echo", Env: []string{"A=b"}, Dir: "/srv", Stdout: &output})
+	if err != nil || output.String() != `sh -c cd "$0" && exec "$@" /srv env A=b echo` {
+		t.Errorf("Run() returned %v and '%s' for command with environment", err, output.String())
+	}
+
+	process, _ := plugins.Start(k, &plugins.Command{Path: "cat", Stdin: strings.NewReader("input")})
+	if process.Signal(15) != ErrNoSignals {
+		t.Errorf("Signal() did not fail")
+	}
+
+	process.Wait()
+}
+
+func TestKubernetesReadFile(t *testing.T) {
+	k := testTransport(t, v4Protocol)
+
+	contents, err := k.ReadFile("/proc/loadavg")
+	if err != nil {
+		t.Fatalf("ReadFile() failed: %s", err.Error())
+	}
+
+	if string(contents) != "0.50 0.40 0.30 1/100 1234\n" {
+		t.Errorf("ReadFile() returned '%s'", string(contents))
+	}
+
+	_, err = k.ReadFile("/etc/missing")
+	if err == nil || !strings.Contains(err.Error(), "No such file or directory") {
+		t.Errorf("ReadFile() returned %v for missing file", err)
+	}
+
+	k.Token = "wrong"
+	k.client = nil
+
+	_, err = k.ReadFile("/proc/loadavg")
+	if apiError, ok := err.(*APIError); !ok || apiError.StatusCode != http.StatusUnauthorized {
+		t.Errorf("ReadFile() returned %v with wrong token", err)
+	}
+}
+
+func TestKubernetesDial(t *testing.T) {
+	k := testTransport(t, v4Protocol)
+
+	conn, err := k.Dial("tcp", "127.0.0.1:3306")
+	if err != nil {
+		t.Fatalf("Dial() failed: %s", err.Error())
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("hello"))
+
+	greeting := make([]byte, 100)
+	n, _ := conn.Read(greeting)
+
+	if string(greeting[:n]) != "port 3306: hello" {
+		t.Errorf("Read '%s' from pod", string(greeting[:n]))
+	}
+
+	_, err = k.Dial("unix", "/run/mysqld/mysqld.sock")
+	if err != ErrUnixSockets {
+		t.Errorf("Dial() returned %v for unix socket", err)
+	}
+}
+
+func TestKubernetesDiscoveryPods(t *testing.T) {
+	d := NewKubernetesDiscovery().(*KubernetesDiscovery)
+	d.Connection = testAPI(t)
+	d.Namespace = "db"
+	d.Selector = "app=mysql"
+	d.Container = "mysql"
+
+	targets, err := d.Discover()
+	if err != nil {
+		t.Fatalf("Discover() failed: %s", err.Error())
+	}
+
+	if len(targets) != 1 {
+		t.Fatalf("Discover() returned %d targets, expected 1", len(targets))
+	}
+
+	target := targets[0]
+	if target.Name != "mysql-0" || target.TransportID != "kubernetestransport" || target.Tags["node"] != "node1" {
+		t.Errorf("Wrong target: %+v", target)
+	}
+
+	// The configuration must configure a working transport.
+	j, _ := json.Marshal(target.TransportConfig)
+
+	k := NewKubernetesTransport().(*KubernetesTransport)
+	json.Unmarshal(j, k)
+
+	if k.Server != d.Server || k.Token != "secret" || k.Namespace != "db" || k.Pod != "mysql-0" || k.Container != "mysql" {
+		t.Errorf("Wrong transport configuration: %s", string(j))
+	}
+}
+
+func TestKubernetesDiscoveryNodes(t *testing.T) {
+	d := NewKubernetesDiscovery().(*KubernetesDiscovery)
+	d.Connection = testAPI(t)
+	d.Role = "node"
+	d.Config = map[string]interface{}{"username": "agento"}
+
+	targets, err := d.Discover()
+	if err != nil {
+		t.Fatalf("Discover() failed: %s", err.Error())
+	}
+
+	if len(targets) != 1 {
+		t.Fatalf("Discover() returned %d targets, expected 1", len(targets))
+	}
+
+	target := targets[0]
+	if target.Name != "node1" || target.TransportID != "sshtransport" || target.TransportConfig["host"] != "10.0.0.1" || target.TransportConfig["username"] != "agento" {
+		t.Errorf("Wrong target: %+v", target)
+	}
+
+	if _, found := d.Config["host"]; found {
+		t.Errorf("Discover() changed the transport configuration")
+	}
+}
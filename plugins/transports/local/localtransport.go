@@ -2,6 +2,7 @@ package localtransport
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"io/ioutil"
 	"net"
@@ -22,7 +23,17 @@ func NewLocalTransport() interface{} {
 }
 
 type (
+	// LocalTransport reaches the host running Agento. If Pid or Netns is
+	// set, the namespaces of a container or a VRF are used instead of the
+	// ones of Agento.
 	LocalTransport struct {
+		Pid   int    `json:"pid" description:"Use the namespaces and root directory of this process" trusted:"true"`
+		Netns string `json:"netns" description:"Network namespace to use, like /var/run/netns/blue" trusted:"true"`
+	}
+
+	// localProcess is a command started by Start.
+	localProcess struct {
+		command *exec.Cmd
 	}
 )
 
@@ -32,42 +43,136 @@ func (l *LocalTransport) GetDoc() *plugins.Doc {
 	return doc
 }
 
+// Dial implements plugins.Transport. The connection is made in the network
+// namespace, names are resolved in the namespace of Agento.
 func (l *LocalTransport) Dial(network string, address string) (net.Conn, error) {
 	dialer := &net.Dialer{
 		Timeout:   30 * time.Second,
 		KeepAlive: 30 * time.Second,
 	}
 
-	return dialer.Dial(network, address)
+	return plugins.DialInNamespaces(l.namespaces("net"), dialer, network, address)
+}
+
+// command returns a command running in the root directory of the process.
+func (l *LocalTransport) command(path string, args ...string) *exec.Cmd {
+	command := exec.Command(path, args...)
+
+	root := l.root()
+	if root != "" {
+		command.SysProcAttr = &syscall.SysProcAttr{Chroot: root}
+		command.Dir = "/"
+	}
+
+	return command
+}
+
+// start starts command in the namespaces.
+func (l *LocalTransport) start(command *exec.Cmd) error {
+	return plugins.InNamespaces(l.namespaces(execNamespaces...), command.Start)
 }
 
 func (l *LocalTransport) Exec(cmd string, arguments ...string) (io.Reader, io.Reader, error) {
-	command := exec.Command(cmd, arguments...)
+	command := l.command(cmd, arguments...)
 
-	var out bytes.Buffer
-	command.Stdout = &out
+	var stdout, stderr bytes.Buffer
+	command.Stdout = &stdout
+	command.Stderr = &stderr
 
-	stderr, err := command.StderrPipe()
-	if err != nil {
-		return nil, nil, err
+	err := l.start(command)
+	if err == nil {
+		err = command.Wait()
 	}
 
-	err = command.Run()
+	return &stdout, &stderr, err
+}
+
+// Start implements plugins.Executor.
+func (l *LocalTransport) Start(cmd *plugins.Command) (plugins.Process, error) {
+	command := l.command(cmd.Path, cmd.Args...)
+	if cmd.Dir != "" {
+		command.Dir = cmd.Dir
+	}
+	command.Stdin = cmd.Stdin
+	command.Stdout = cmd.Stdout
+	command.Stderr = cmd.Stderr
+
+	if len(cmd.Env) > 0 {
+		command.Env = append(os.Environ(), cmd.Env...)
+	}
 
-	return &out, stderr, err
+	err := l.start(command)
+	if err != nil {
+		return nil, err
+	}
+
+	return &localProcess{command: command}, nil
 }
 
+// Open implements plugins.Transport. Files read in namespaces are read
+// completely, some files show the namespace of the reader at the time of
+// reading.
 func (l *LocalTransport) Open(path string) (io.ReadCloser, error) {
-	return os.Open(path)
+	p, namespaces := l.path(path)
+	if len(namespaces) == 0 {
+		return os.Open(p)
+	}
+
+	var contents []byte
+
+	err := plugins.InNamespaces(namespaces, func() error {
+		var err error
+		contents, err = ioutil.ReadFile(p)
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(contents)), nil
 }
 
 func (l *LocalTransport) ReadFile(path string) ([]byte, error) {
-	return ioutil.ReadFile(path)
+	f, err := l.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ioutil.ReadAll(f)
 }
 
 func (l *LocalTransport) Statfs(path string, buf *syscall.Statfs_t) error {
-	return syscall.Statfs(path, buf)
+	p, _ := l.path(path)
+
+	return syscall.Statfs(p, buf)
+}
+
+func (p *localProcess) Signal(sig syscall.Signal) error {
+	return p.command.Process.Signal(sig)
+}
+
+func (p *localProcess) Kill() error {
+	return p.command.Process.Kill()
+}
+
+func (p *localProcess) Wait() error {
+	err := p.command.Wait()
+
+	var exitError *exec.ExitError
+	if !errors.As(err, &exitError) {
+		return err
+	}
+
+	status, ok := exitError.Sys().(syscall.WaitStatus)
+	if ok && status.Signaled() {
+		return &plugins.ExitError{Code: -1, Signal: plugins.SignalName(status.Signal())}
+	}
+
+	return &plugins.ExitError{Code: exitError.ExitCode()}
 }
 
 // Ensure compliance
 var _ plugins.Transport = (*LocalTransport)(nil)
+var _ plugins.Executor = (*LocalTransport)(nil)
@@ -0,0 +1,252 @@
+package localtransport
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/abrander/agento/configuration"
+	"github.com/abrander/agento/plugins"
+)
+
+func TestExec(t *testing.T) {
+	l := &LocalTransport{}
+
+	stdout, stderr, err := l.Exec("sh", "-c", "echo out; echo err >&2")
+	if err != nil {
+		t.Fatalf("Exec() failed: %s", err.Error())
+	}
+
+	out, _ := ioutil.ReadAll(stdout)
+	errOut, _ := ioutil.ReadAll(stderr)
+
+	if string(out) != "out\n" || string(errOut) != "err\n" {
+		t.Errorf("Exec() returned '%s' and '%s'", string(out), string(errOut))
+	}
+}
+
+func TestStart(t *testing.T) {
+	l := &LocalTransport{}
+
+	var stdout bytes.Buffer
+
+	err := plugins.Run(l, &plugins.Command{
+		Path:   "sh",
+		Args:   []string{"-c", "pwd; echo $AGENTO_TEST; cat; exit 3"},
+		Env:    []string{"AGENTO_TEST=hello"},
+		Dir:    "/",
+		Stdin:  strings.NewReader("input\n"),
+		Stdout: &stdout,
+	})
+
+	if plugins.ExitCode(err) != 3 {
+		t.Errorf("Run() returned %v, expected exit status 3", err)
+	}
+
+	if stdout.String() != "/\nhello\ninput\n" {
+		t.Errorf("Wrong output '%s'", stdout.String())
+	}
+}
+
+func TestStartStreaming(t *testing.T) {
+	l := &LocalTransport{}
+
+	stdinReader, stdinWriter := io.Pipe()
+	stdoutReader, stdoutWriter := io.Pipe()
+
+	process, err := plugins.Start(l, &plugins.Command{
+		Path:   "sh",
+		Args:   []string{"-c", "echo first; read line; echo $line"},
+		Stdin:  stdinReader,
+		Stdout: stdoutWriter,
+	})
+	if err != nil {
+		t.Fatalf("Start() failed: %s", err.Error())
+	}
+
+	lines := bufio.NewReader(stdoutReader)
+
+	// The first line must arrive while the command waits for input.
+	line, _ := lines.ReadString('\n')
+	if line != "first\n" {
+		t.Errorf("Read '%s', expected first line", line)
+	}
+
+	io.WriteString(stdinWriter, "second\n")
+	stdinWriter.Close()
+
+	line, _ = lines.ReadString('\n')
+	if line != "second\n" {
+		t.Errorf("Read '%s', expected second line", line)
+	}
+
+	go ioutil.ReadAll(stdoutReader)
+
+	err = process.Wait()
+	if err != nil {
+		t.Errorf("Wait() failed: %s", err.Error())
+	}
+}
+
+func TestStartSignal(t *testing.T) {
+	l := &LocalTransport{}
+
+	process, err := plugins.Start(l, &plugins.Command{Path: "sleep", Args: []string{"10"}})
+	if err != nil {
+		t.Fatalf("Start() failed: %s", err.Error())
+	}
+
+	err = process.Signal(syscall.SIGTERM)
+	if err != nil {
+		t.Fatalf("Signal() failed: %s", err.Error())
+	}
+
+	err = process.Wait()
+
+	exitError, ok := err.(*plugins.ExitError)
+	if !ok || exitError.Signal != "TERM" {
+		t.Errorf("Wait() returned %v, expected SIGTERM", err)
+	}
+
+	err = plugins.Run(l, &plugins.Command{Path: "sleep", Args: []string{"10"}, Timeout: 10 * time.Millisecond})
+	if err != plugins.ErrTimeout {
+		t.Errorf("Run() returned %v, expected timeout", err)
+	}
+}
+
+func TestPath(t *testing.T) {
+	l := &LocalTransport{Pid: 1}
+
+	cases := map[string]string{
+		"/etc/mysql/my.cnf":      "/proc/1/root/etc/mysql/my.cnf",
+		"/proc/loadavg":          "/proc/loadavg",
+		"/proc/net/dev":          "/proc/thread-self/net/dev",
+		"/proc/sys/net/ipv4":     "/proc/sys/net/ipv4",
+		"/sys/block/sda/stat":    "/sys/block/sda/stat",
+		"/proc/sys/kernel/panic": "/proc/sys/kernel/panic",
+	}
+
+	for in, expected := range cases {
+		path, _ := l.path(in)
+		if path != expected {
+			t.Errorf("path(%s) returned %s, expected %s", in, path, expected)
+		}
+	}
+
+	l = &LocalTransport{Netns: "/var/run/netns/blue"}
+
+	path, namespaces := l.path("/etc/hosts")
+	if path != "/etc/hosts" || len(namespaces) != 0 {
+		t.Errorf("path() returned %s and %v for netns", path, namespaces)
+	}
+
+	_, namespaces = l.path("/proc/net/tcp")
+	if len(namespaces) != 1 || namespaces[0].Path != "/var/run/netns/blue" {
+		t.Errorf("path() returned %v for /proc/net/tcp", namespaces)
+	}
+}
+
+// unshared starts a process in new network and UTS namespaces and returns
+// its pid.
+func unshared(t *testing.T) int {
+	cmd := exec.Command("unshare", "--net", "--uts", "sh", "-c", "echo agentotest > /proc/sys/kernel/hostname && echo && exec sleep 30")
+
+	stdout, _ := cmd.StdoutPipe()
+
+	err := cmd.Start()
+	if err != nil {
+		t.Skipf("unshare not available: %s", err.Error())
+	}
+
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+
+	_, err = bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		t.Skipf("Namespaces can't be created")
+	}
+
+	return cmd.Process.Pid
+}
+
+func TestNamespaces(t *testing.T) {
+	pid := unshared(t)
+
+	l := &LocalTransport{Pid: pid}
+
+	contents, err := l.ReadFile(configuration.ProcPath + "/net/dev")
+	if err != nil {
+		t.Fatalf("ReadFile() failed: %s", err.Error())
+	}
+
+	if !strings.Contains(string(contents), "lo:") || strings.Count(string(contents), ":") != 1 {
+		t.Errorf("ReadFile() read interfaces outside the namespace: %s", string(contents))
+	}
+
+	contents, _ = l.ReadFile(configuration.ProcPath + "/sys/kernel/hostname")
+	if string(contents) != "agentotest\n" {
+		t.Errorf("ReadFile() read hostname '%s'", string(contents))
+	}
+
+	stdout, _, err := l.Exec("cat", "/proc/sys/kernel/hostname")
+	if err != nil {
+		t.Fatalf("Exec() failed: %s", err.Error())
+	}
+
+	out, _ := ioutil.ReadAll(stdout)
+	if string(out) != "agentotest\n" {
+		t.Errorf("Exec() ran command with hostname '%s'", string(out))
+	}
+
+	// The namespaces must not leak to Agento.
+	contents, _ = (&LocalTransport{}).ReadFile(configuration.ProcPath + "/sys/kernel/hostname")
+	if string(contents) == "agentotest\n" {
+		t.Errorf("Hostname of namespace read without namespace")
+	}
+}
+
+func TestNamespaceDial(t *testing.T) {
+	pid := unshared(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() failed: %s", err.Error())
+	}
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			conn.Close()
+		}
+	}()
+
+	l := &LocalTransport{Netns: configuration.ProcPath + "/" + strconv.Itoa(pid) + "/ns/net"}
+
+	// The loopback interface of the new namespace is down.
+	_, err = l.Dial("tcp", listener.Addr().String())
+	if err == nil {
+		t.Errorf("Dial() connected to listener outside the namespace")
+	}
+
+	conn, err := (&LocalTransport{}).Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() failed without namespace: %s", err.Error())
+	}
+
+	conn.Close()
+}
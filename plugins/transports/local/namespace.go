@@ -0,0 +1,128 @@
+package localtransport
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/abrander/agento/configuration"
+	"github.com/abrander/agento/plugins"
+)
+
+type (
+	// namespacedFile is a file in the proc filesystem showing the
+	// namespace of the process reading it.
+	namespacedFile struct {
+		path string
+		ns   string
+	}
+)
+
+var (
+	namespaceTypes = map[string]int{
+		"net": syscall.CLONE_NEWNET,
+		"uts": syscall.CLONE_NEWUTS,
+		"ipc": syscall.CLONE_NEWIPC,
+		"pid": syscall.CLONE_NEWPID,
+	}
+
+	// execNamespaces are the namespaces joined by commands. Mount and
+	// user namespaces can't be joined by a multithreaded process, the
+	// root directory of the process is used instead.
+	execNamespaces = []string{"net", "uts", "ipc", "pid"}
+
+	namespacedFiles = []namespacedFile{
+		{"/net", "net"},
+		{"/sys/net", "net"},
+		{"/sys/kernel/hostname", "uts"},
+		{"/sys/kernel/domainname", "uts"},
+	}
+)
+
+// namespace returns the namespace named name, or nil if the transport is
+// not configured to use one.
+func (l *LocalTransport) namespace(name string) *plugins.Namespace {
+	if name == "net" && l.Netns != "" {
+		return &plugins.Namespace{Path: l.Netns, Type: syscall.CLONE_NEWNET}
+	}
+
+	if l.Pid == 0 {
+		return nil
+	}
+
+	return &plugins.Namespace{
+		Path: filepath.Join(configuration.ProcPath, strconv.Itoa(l.Pid), "ns", name),
+		Type: namespaceTypes[name],
+	}
+}
+
+// namespaces returns the configured namespaces of names.
+func (l *LocalTransport) namespaces(names ...string) []plugins.Namespace {
+	var namespaces []plugins.Namespace
+
+	for _, name := range names {
+		ns := l.namespace(name)
+		if ns != nil {
+			namespaces = append(namespaces, *ns)
+		}
+	}
+
+	return namespaces
+}
+
+// root returns the root directory of the process, or an empty string if no
+// process is configured.
+func (l *LocalTransport) root() string {
+	if l.Pid == 0 {
+		return ""
+	}
+
+	return filepath.Join(configuration.ProcPath, strconv.Itoa(l.Pid), "root")
+}
+
+// path translates path to the path to open, and returns the namespaces
+// needed to open it. Files in the proc filesystem showing the namespace of
+// the reader are read in the namespace, other files in proc and sys are
+// read as is. Everything else is read below the root directory of the
+// process.
+func (l *LocalTransport) path(path string) (string, []plugins.Namespace) {
+	if l.Pid == 0 && l.Netns == "" {
+		return path, nil
+	}
+
+	if path == configuration.ProcPath || strings.HasPrefix(path, configuration.ProcPath+"/") {
+		rel := path[len(configuration.ProcPath):]
+
+		for _, file := range namespacedFiles {
+			if rel != file.path && !strings.HasPrefix(rel, file.path+"/") {
+				continue
+			}
+
+			ns := l.namespace(file.ns)
+			if ns == nil {
+				break
+			}
+
+			// /proc/net links to the process, not the thread
+			// joining the namespace.
+			if file.path == "/net" {
+				return "/proc/thread-self" + rel, []plugins.Namespace{*ns}
+			}
+
+			return "/proc" + rel, []plugins.Namespace{*ns}
+		}
+
+		return path, nil
+	}
+
+	if path == configuration.SysfsPath || strings.HasPrefix(path, configuration.SysfsPath+"/") {
+		return path, nil
+	}
+
+	if l.Pid != 0 {
+		return l.root() + path, nil
+	}
+
+	return path, nil
+}
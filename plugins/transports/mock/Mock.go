@@ -4,7 +4,10 @@ import (
 	"bytes"
 	"errors"
 	"io"
+	"io/ioutil"
 	"net"
+	"os"
+	"sync"
 	"syscall"
 
 	"github.com/abrander/agento/plugins"
@@ -16,14 +19,38 @@ func init() {
 
 func NewMock() interface{} {
 	return &Mock{
-		files: make(map[string][]byte),
+		files:    make(map[string][]byte),
+		commands: make(map[string]CommandFunc),
+		services: make(map[string]ServiceFunc),
 	}
 }
 
 type (
 	// Mock is a type that can help in writing tests for agents.
 	Mock struct {
-		files map[string][]byte
+		files    map[string][]byte
+		commands map[string]CommandFunc
+		services map[string]ServiceFunc
+	}
+
+	// CommandFunc emulates a command. It can read the input and write the
+	// output of cmd, which are never nil. Signals sent to the process are
+	// delivered on signals. The exit status is returned.
+	CommandFunc func(cmd *plugins.Command, signals <-chan syscall.Signal) int
+
+	// ServiceFunc emulates a network service. It's called with the server
+	// end of every connection dialed to the service. The connection is
+	// closed when it returns.
+	ServiceFunc func(conn net.Conn)
+
+	// mockProcess is a command started by Start.
+	mockProcess struct {
+		signals chan syscall.Signal
+		done    chan struct{}
+		code    int
+
+		killOnce sync.Once
+		killed   chan struct{}
 	}
 )
 
@@ -39,18 +66,125 @@ func (m *Mock) SetFile(path string, contents []byte) {
 	m.files[path] = contents
 }
 
+// SetCommand sets a function emulating the command path for Exec() and
+// Start().
+func (m *Mock) SetCommand(path string, f CommandFunc) {
+	m.commands[path] = f
+}
+
+// SetService sets a function emulating the service listening on address for
+// Dial().
+func (m *Mock) SetService(address string, f ServiceFunc) {
+	m.services[address] = f
+}
+
 func (m *Mock) GetDoc() *plugins.Doc {
 	doc := plugins.NewDoc("Mock transport for testing")
 
 	return doc
 }
 
+// Dial implements plugins.Transport using the functions set by
+// SetService(). The connection is refused for other addresses.
 func (m *Mock) Dial(network string, address string) (net.Conn, error) {
-	return nil, errors.New("Not supported yet")
+	f, found := m.services[address]
+	if !found {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: syscall.ECONNREFUSED}
+	}
+
+	client, server := net.Pipe()
+
+	go func() {
+		defer server.Close()
+
+		f(server)
+	}()
+
+	return client, nil
 }
 
 func (m *Mock) Exec(cmd string, arguments ...string) (io.Reader, io.Reader, error) {
-	return nil, nil, errors.New("Not supported yet")
+	var stdout, stderr bytes.Buffer
+
+	err := plugins.Run(m, &plugins.Command{
+		Path:   cmd,
+		Args:   arguments,
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+
+	return &stdout, &stderr, err
+}
+
+// Start implements plugins.Executor using the functions set by
+// SetCommand().
+func (m *Mock) Start(cmd *plugins.Command) (plugins.Process, error) {
+	f, found := m.commands[cmd.Path]
+	if !found {
+		return nil, errors.New("command not found")
+	}
+
+	c := *cmd
+
+	if c.Stdin == nil {
+		c.Stdin = bytes.NewReader(nil)
+	}
+
+	if c.Stdout == nil {
+		c.Stdout = ioutil.Discard
+	}
+
+	if c.Stderr == nil {
+		c.Stderr = ioutil.Discard
+	}
+
+	p := &mockProcess{
+		signals: make(chan syscall.Signal, 10),
+		done:    make(chan struct{}),
+		killed:  make(chan struct{}),
+	}
+
+	go func() {
+		p.code = f(&c, p.signals)
+		close(p.done)
+	}()
+
+	return p, nil
+}
+
+func (p *mockProcess) Signal(sig syscall.Signal) error {
+	select {
+	case <-p.done:
+		return os.ErrProcessDone
+	case p.signals <- sig:
+		return nil
+	}
+}
+
+// Kill makes Wait return right away. The function emulating the command
+// gets SIGKILL but doesn't have to exit.
+func (p *mockProcess) Kill() error {
+	err := p.Signal(syscall.SIGKILL)
+
+	p.killOnce.Do(func() {
+		close(p.killed)
+	})
+
+	return err
+}
+
+func (p *mockProcess) Wait() error {
+	select {
+	case <-p.done:
+		if p.code != 0 {
+			return &plugins.ExitError{Code: p.code}
+		}
+
+		return nil
+
+	case <-p.killed:
+		return &plugins.ExitError{Code: -1, Signal: "KILL"}
+	}
 }
 
 func (m *Mock) Open(path string) (io.ReadCloser, error) {
@@ -77,3 +211,4 @@ func (m *Mock) Statfs(path string, buf *syscall.Statfs_t) error {
 
 // Ensure compliance
 var _ plugins.Transport = (*Mock)(nil)
+var _ plugins.Executor = (*Mock)(nil)
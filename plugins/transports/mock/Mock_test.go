@@ -0,0 +1,162 @@
+package mocktransport
+
+import (
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+
+	"github.com/abrander/agento/plugins"
+)
+
+func TestExec(t *testing.T) {
+	m := NewMock().(*Mock)
+
+	m.SetCommand("echo", func(cmd *plugins.Command, signals <-chan syscall.Signal) int {
+		for _, arg := range cmd.Args {
+			io.WriteString(cmd.Stdout, arg)
+		}
+
+		return 0
+	})
+
+	stdout, _, err := m.Exec("echo", "a", "b")
+	if err != nil {
+		t.Fatalf("Exec() failed: %s", err.Error())
+	}
+
+	out, _ := ioutil.ReadAll(stdout)
+	if string(out) != "ab" {
+		t.Errorf("Exec() returned '%s'", string(out))
+	}
+
+	_, _, err = m.Exec("missing")
+	if err == nil {
+		t.Errorf("Exec() did not fail for unknown command")
+	}
+}
+
+func TestStartSignal(t *testing.T) {
+	m := NewMock().(*Mock)
+
+	m.SetCommand("tail", func(cmd *plugins.Command, signals <-chan syscall.Signal) int {
+		sig := <-signals
+
+		return 128 + int(sig)
+	})
+
+	process, err := m.Start(&plugins.Command{Path: "tail"})
+	if err != nil {
+		t.Fatalf("Start() failed: %s", err.Error())
+	}
+
+	process.Signal(syscall.SIGTERM)
+
+	err = process.Wait()
+	if plugins.ExitCode(err) != 128+int(syscall.SIGTERM) {
+		t.Errorf("Wait() returned %v", err)
+	}
+
+	m.SetCommand("hang", func(cmd *plugins.Command, signals <-chan syscall.Signal) int {
+		select {}
+	})
+
+	process, _ = m.Start(&plugins.Command{Path: "hang"})
+	process.Kill()
+
+	err = process.Wait()
+
+	exitError, ok := err.(*plugins.ExitError)
+	if !ok || exitError.Signal != "KILL" {
+		t.Errorf("Wait() returned %v after Kill()", err)
+	}
+}
+
+func TestDial(t *testing.T) {
+	m := NewMock().(*Mock)
+
+	_, err := m.Dial("tcp", "127.0.0.1:80")
+	if err == nil {
+		t.Errorf("Dial() did not fail for unknown address")
+	}
+
+	m.SetService("127.0.0.1:80", HTTPResponse(http.StatusTeapot, "short and stout"))
+
+	resp, err := plugins.HTTPClient(m).Get("http://127.0.0.1/")
+	if err != nil {
+		t.Fatalf("Get() failed: %s", err.Error())
+	}
+	defer resp.Body.Close()
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusTeapot || string(body) != "short and stout" {
+		t.Errorf("Get() returned %d and '%s'", resp.StatusCode, string(body))
+	}
+}
+
+// fastCGIRequest sends a request with params and returns the records of the
+// response.
+func fastCGIRequest(conn net.Conn, params map[string]string) map[byte]string {
+	var p []byte
+	for name, value := range params {
+		p = append(p, byte(len(name)), byte(len(value)))
+		p = append(p, name...)
+		p = append(p, value...)
+	}
+
+	writeFastCGIRecord(conn, 1, 1, []byte{0, 1, 0, 0, 0, 0, 0, 0})
+	writeFastCGIRecord(conn, fcgiParams, 1, p)
+	writeFastCGIRecord(conn, fcgiParams, 1, nil)
+	writeFastCGIRecord(conn, fcgiStdin, 1, nil)
+
+	records := make(map[byte]string)
+
+	for {
+		var h fcgiHeader
+
+		err := binary.Read(conn, binary.BigEndian, &h)
+		if err != nil {
+			return records
+		}
+
+		content := make([]byte, h.ContentLength)
+		io.ReadFull(conn, content)
+
+		records[h.Type] += string(content)
+
+		if h.Type == fcgiEndRequest {
+			return records
+		}
+	}
+}
+
+func TestFastCGIService(t *testing.T) {
+	m := NewMock().(*Mock)
+
+	m.SetService("/run/php/fpm.sock", FastCGIService(func(params map[string]string) (string, string) {
+		if params["SCRIPT_NAME"] != "/status" {
+			return "Status: 404 Not Found\r\n\r\n", "Primary script unknown"
+		}
+
+		return "Content-type: text/plain\r\n\r\npool: www\n", ""
+	}))
+
+	conn, err := m.Dial("unix", "/run/php/fpm.sock")
+	if err != nil {
+		t.Fatalf("Dial() failed: %s", err.Error())
+	}
+	defer conn.Close()
+
+	records := fastCGIRequest(conn, map[string]string{"SCRIPT_NAME": "/status"})
+	if records[fcgiStdout] != "Content-type: text/plain\r\n\r\npool: www\n" || records[fcgiStderr] != "" {
+		t.Errorf("Wrong response %q", records)
+	}
+
+	records = fastCGIRequest(conn, map[string]string{"SCRIPT_NAME": "/missing"})
+	if records[fcgiStderr] != "Primary script unknown" {
+		t.Errorf("Wrong response %q for missing script", records)
+	}
+}
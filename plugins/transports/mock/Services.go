@@ -0,0 +1,444 @@
+package mocktransport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+)
+
+type (
+	// FastCGIFunc answers a FastCGI request. The output written to stdout
+	// must start with the headers of the response.
+	FastCGIFunc func(params map[string]string) (stdout string, stderr string)
+
+	// MySQLResult is the result of a MySQL query. All columns are
+	// strings.
+	MySQLResult struct {
+		Columns []string
+		Rows    [][]string
+	}
+
+	// fcgiHeader is the header of a FastCGI record.
+	fcgiHeader struct {
+		Version       byte
+		Type          byte
+		RequestID     uint16
+		ContentLength uint16
+		PaddingLength byte
+		Reserved      byte
+	}
+
+	// mysqlConn writes MySQL packets with sequence numbers.
+	mysqlConn struct {
+		conn net.Conn
+		r    *bufio.Reader
+		seq  byte
+	}
+)
+
+const (
+	fcgiEndRequest = 3
+	fcgiParams     = 4
+	fcgiStdin      = 5
+	fcgiStdout     = 6
+	fcgiStderr     = 7
+
+	// mysqlCapabilities are the capabilities of the MySQL server:
+	// CLIENT_LONG_PASSWORD, CLIENT_LONG_FLAG, CLIENT_CONNECT_WITH_DB,
+	// CLIENT_PROTOCOL_41, CLIENT_TRANSACTIONS, CLIENT_SECURE_CONNECTION
+	// and CLIENT_PLUGIN_AUTH.
+	mysqlCapabilities = 0x1 | 0x4 | 0x8 | 0x200 | 0x2000 | 0x8000 | 0x80000
+
+	mysqlComQuit  = 0x01
+	mysqlComQuery = 0x03
+	mysqlComPing  = 0x0e
+)
+
+// HTTPService returns a service serving HTTP requests using handler.
+func HTTPService(handler http.Handler) ServiceFunc {
+	return func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+
+		for {
+			req, err := http.ReadRequest(r)
+			if err != nil {
+				return
+			}
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, req)
+
+			resp := recorder.Result()
+			resp.ContentLength = int64(recorder.Body.Len())
+			resp.Close = req.Close
+
+			err = resp.Write(conn)
+			if err != nil || req.Close {
+				return
+			}
+		}
+	}
+}
+
+// HTTPResponse returns a service answering all HTTP requests with status
+// and body.
+func HTTPResponse(status int, body string) ServiceFunc {
+	return HTTPService(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}))
+}
+
+// FastCGIService returns a service answering FastCGI requests using f, like
+// PHP-FPM.
+func FastCGIService(f FastCGIFunc) ServiceFunc {
+	return func(conn net.Conn) {
+		for {
+			id, params, err := readFastCGIRequest(conn)
+			if err != nil {
+				return
+			}
+
+			stdout, stderr := f(params)
+
+			if stderr != "" {
+				writeFastCGIStream(conn, fcgiStderr, id, stderr)
+			}
+
+			writeFastCGIStream(conn, fcgiStdout, id, stdout)
+
+			// The application status and protocol status are 0.
+			writeFastCGIRecord(conn, fcgiEndRequest, id, make([]byte, 8))
+		}
+	}
+}
+
+// FastCGIResponse returns a service answering all FastCGI requests with
+// body as text.
+func FastCGIResponse(body string) ServiceFunc {
+	return FastCGIService(func(map[string]string) (string, string) {
+		return "Content-type: text/plain;charset=UTF-8\r\n\r\n" + body, ""
+	})
+}
+
+// readFastCGIRequest reads records until the end of the input of a request.
+func readFastCGIRequest(r io.Reader) (uint16, map[string]string, error) {
+	var params bytes.Buffer
+
+	for {
+		var h fcgiHeader
+
+		err := binary.Read(r, binary.BigEndian, &h)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		content := make([]byte, int(h.ContentLength)+int(h.PaddingLength))
+
+		_, err = io.ReadFull(r, content)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch h.Type {
+		case fcgiParams:
+			params.Write(content[:h.ContentLength])
+
+		case fcgiStdin:
+			if h.ContentLength == 0 {
+				return h.RequestID, decodeFastCGIParams(params.Bytes()), nil
+			}
+		}
+	}
+}
+
+// decodeFastCGIParams decodes name-value pairs.
+func decodeFastCGIParams(b []byte) map[string]string {
+	params := make(map[string]string)
+
+	length := func() int {
+		if len(b) == 0 {
+			return 0
+		}
+
+		if b[0]&0x80 == 0 {
+			l := int(b[0])
+			b = b[1:]
+
+			return l
+		}
+
+		if len(b) < 4 {
+			b = nil
+			return 0
+		}
+
+		l := int(binary.BigEndian.Uint32(b) & 0x7fffffff)
+		b = b[4:]
+
+		return l
+	}
+
+	for len(b) > 0 {
+		nameLength := length()
+		valueLength := length()
+
+		if nameLength+valueLength > len(b) {
+			break
+		}
+
+		params[string(b[:nameLength])] = string(b[nameLength : nameLength+valueLength])
+		b = b[nameLength+valueLength:]
+	}
+
+	return params
+}
+
+// writeFastCGIStream writes s as a stream of records ended by an empty one.
+func writeFastCGIStream(w io.Writer, typ byte, id uint16, s string) {
+	for len(s) > 0 {
+		n := len(s)
+		if n > 65535 {
+			n = 65535
+		}
+
+		writeFastCGIRecord(w, typ, id, []byte(s[:n]))
+		s = s[n:]
+	}
+
+	writeFastCGIRecord(w, typ, id, nil)
+}
+
+func writeFastCGIRecord(w io.Writer, typ byte, id uint16, content []byte) error {
+	h := fcgiHeader{
+		Version:       1,
+		Type:          typ,
+		RequestID:     id,
+		ContentLength: uint16(len(content)),
+	}
+
+	var buf bytes.Buffer
+
+	binary.Write(&buf, binary.BigEndian, h)
+	buf.Write(content)
+
+	_, err := w.Write(buf.Bytes())
+
+	return err
+}
+
+// MySQLService returns a service answering MySQL queries with results. The
+// query must match exactly. Other queries fail with a syntax error. Any
+// user and password is accepted.
+func MySQLService(results map[string]*MySQLResult) ServiceFunc {
+	return func(conn net.Conn) {
+		c := &mysqlConn{conn: conn, r: bufio.NewReader(conn)}
+
+		if c.handshake() != nil {
+			return
+		}
+
+		for {
+			c.seq = 0
+
+			packet, err := c.read()
+			if err != nil || len(packet) == 0 {
+				return
+			}
+
+			switch packet[0] {
+			case mysqlComQuit:
+				return
+
+			case mysqlComPing:
+				c.ok()
+
+			case mysqlComQuery:
+				query := strings.TrimSpace(string(packet[1:]))
+
+				result, found := results[query]
+				if !found && query == "SELECT @@max_allowed_packet" {
+					result = &MySQLResult{
+						Columns: []string{"@@max_allowed_packet"},
+						Rows:    [][]string{{"4194304"}},
+					}
+				}
+
+				if result == nil {
+					c.error(1064, "42000", "You have an error in your SQL syntax near '"+query+"'")
+					continue
+				}
+
+				c.result(result)
+
+			default:
+				c.error(1047, "08S01", "Unknown command")
+			}
+		}
+	}
+}
+
+// MySQLStatus returns a MySQL service answering SHOW GLOBAL STATUS with
+// status.
+func MySQLStatus(status map[string]string) ServiceFunc {
+	result := &MySQLResult{Columns: []string{"Variable_name", "Value"}}
+
+	names := make([]string, 0, len(status))
+	for name := range status {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	for _, name := range names {
+		result.Rows = append(result.Rows, []string{name, status[name]})
+	}
+
+	return MySQLService(map[string]*MySQLResult{"SHOW GLOBAL STATUS": result})
+}
+
+// read reads a packet.
+func (c *mysqlConn) read() ([]byte, error) {
+	var header [4]byte
+
+	_, err := io.ReadFull(c.r, header[:])
+	if err != nil {
+		return nil, err
+	}
+
+	length := int(header[0]) | int(header[1])<<8 | int(header[2])<<16
+	c.seq = header[3] + 1
+
+	packet := make([]byte, length)
+
+	_, err = io.ReadFull(c.r, packet)
+
+	return packet, err
+}
+
+// write writes a packet.
+func (c *mysqlConn) write(packet []byte) error {
+	length := len(packet)
+
+	header := []byte{byte(length), byte(length >> 8), byte(length >> 16), c.seq}
+	c.seq++
+
+	_, err := c.conn.Write(append(header, packet...))
+
+	return err
+}
+
+// handshake sends the initial handshake, and accepts the response.
+func (c *mysqlConn) handshake() error {
+	var p bytes.Buffer
+
+	p.WriteByte(10)
+	p.WriteString("5.7.0-agento-mock\x00")
+	binary.Write(&p, binary.LittleEndian, uint32(1))
+	p.WriteString("12345678\x00")
+	binary.Write(&p, binary.LittleEndian, uint16(mysqlCapabilities&0xffff))
+	p.WriteByte(33)
+	binary.Write(&p, binary.LittleEndian, uint16(2))
+	binary.Write(&p, binary.LittleEndian, uint16(mysqlCapabilities>>16))
+	p.WriteByte(21)
+	p.Write(make([]byte, 10))
+	p.WriteString("123456789012\x00")
+	p.WriteString("mysql_native_password\x00")
+
+	err := c.write(p.Bytes())
+	if err != nil {
+		return err
+	}
+
+	_, err = c.read()
+	if err != nil {
+		return err
+	}
+
+	return c.ok()
+}
+
+// ok sends an OK packet.
+func (c *mysqlConn) ok() error {
+	return c.write([]byte{0x00, 0, 0, 2, 0, 0, 0})
+}
+
+// eof sends an EOF packet.
+func (c *mysqlConn) eof() error {
+	return c.write([]byte{0xfe, 0, 0, 2, 0})
+}
+
+// error sends an error packet.
+func (c *mysqlConn) error(code uint16, state string, message string) error {
+	var p bytes.Buffer
+
+	p.WriteByte(0xff)
+	binary.Write(&p, binary.LittleEndian, code)
+	p.WriteString("#" + state + message)
+
+	return c.write(p.Bytes())
+}
+
+// result sends a result set.
+func (c *mysqlConn) result(result *MySQLResult) error {
+	c.write(lengthEncodedInt(nil, uint64(len(result.Columns))))
+
+	for _, column := range result.Columns {
+		var p []byte
+
+		for _, s := range []string{"def", "", "", "", column, column} {
+			p = lengthEncodedString(p, s)
+		}
+
+		// Length of the fixed fields, utf8, length, VAR_STRING, flags,
+		// decimals and filler.
+		p = append(p, 0x0c, 33, 0, 0, 1, 0, 0, 0xfd, 0, 0, 0, 0, 0)
+
+		c.write(p)
+	}
+
+	c.eof()
+
+	for _, row := range result.Rows {
+		var p []byte
+
+		for _, value := range row {
+			p = lengthEncodedString(p, value)
+		}
+
+		c.write(p)
+	}
+
+	return c.eof()
+}
+
+func lengthEncodedInt(b []byte, n uint64) []byte {
+	switch {
+	case n < 251:
+		return append(b, byte(n))
+	case n < 1<<16:
+		return append(b, 0xfc, byte(n), byte(n>>8))
+	case n < 1<<24:
+		return append(b, 0xfd, byte(n), byte(n>>8), byte(n>>16))
+	}
+
+	b = append(b, 0xfe)
+
+	for i := uint(0); i < 64; i += 8 {
+		b = append(b, byte(n>>i))
+	}
+
+	return b
+}
+
+func lengthEncodedString(b []byte, s string) []byte {
+	b = lengthEncodedInt(b, uint64(len(s)))
+
+	return append(b, s...)
+}
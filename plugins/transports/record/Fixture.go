@@ -0,0 +1,186 @@
+package recordtransport
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"unicode/utf8"
+
+	"github.com/abrander/agento/plugins"
+)
+
+type (
+	// Fixture is everything read from a transport by a recording.
+	Fixture struct {
+		Files    map[string]*File   `json:"files,omitempty"`
+		Statfs   map[string]*Statfs `json:"statfs,omitempty"`
+		Commands []*Command         `json:"commands,omitempty"`
+		Conns    []*Conn            `json:"conns,omitempty"`
+	}
+
+	// File is a file read from the transport.
+	File struct {
+		Contents Data   `json:"contents"`
+		Error    *Error `json:"error,omitempty"`
+	}
+
+	// Statfs is the result of Statfs.
+	Statfs struct {
+		Buf   syscall.Statfs_t `json:"buf"`
+		Error *Error           `json:"error,omitempty"`
+	}
+
+	// Command is a command run using the transport.
+	Command struct {
+		Path   string   `json:"path"`
+		Args   []string `json:"args,omitempty"`
+		Stdout Data     `json:"stdout,omitempty"`
+		Stderr Data     `json:"stderr,omitempty"`
+		Code   int      `json:"code,omitempty"`
+		Signal string   `json:"signal,omitempty"`
+		Error  *Error   `json:"error,omitempty"`
+	}
+
+	// Conn is a connection dialed using the transport. Events are the
+	// data read and written in the order it happened.
+	Conn struct {
+		Network string   `json:"network"`
+		Address string   `json:"address"`
+		Events  []*Event `json:"events,omitempty"`
+		Error   *Error   `json:"error,omitempty"`
+	}
+
+	// Event is data read from or written to a connection.
+	Event struct {
+		Read  Data `json:"read,omitempty"`
+		Write Data `json:"write,omitempty"`
+	}
+
+	// Error is an error returned by the transport. System errors keep
+	// their errno, to let agents check for missing files and the like.
+	Error struct {
+		Message string `json:"message"`
+		Op      string `json:"op,omitempty"`
+		Path    string `json:"path,omitempty"`
+		Errno   int    `json:"errno,omitempty"`
+	}
+
+	// Data is saved as a string if it's valid UTF-8 and as base64
+	// otherwise.
+	Data []byte
+
+	base64Data struct {
+		Base64 string `json:"base64"`
+	}
+)
+
+// LoadFixture reads a fixture saved by Save.
+func LoadFixture(path string) (*Fixture, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &Fixture{}
+
+	err = json.Unmarshal(contents, f)
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// Save saves the fixture as indented JSON.
+func (f *Fixture) Save(path string) error {
+	j, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, append(j, '\n'), 0644)
+}
+
+// newError records err. nil is returned for nil.
+func newError(err error) *Error {
+	if err == nil {
+		return nil
+	}
+
+	e := &Error{Message: err.Error()}
+
+	var pathError *os.PathError
+	if errors.As(err, &pathError) {
+		e.Op = pathError.Op
+		e.Path = pathError.Path
+	}
+
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		e.Errno = int(errno)
+	}
+
+	return e
+}
+
+// Err returns the recorded error.
+func (e *Error) Err() error {
+	if e == nil {
+		return nil
+	}
+
+	if e.Errno != 0 && e.Op != "" {
+		return &os.PathError{Op: e.Op, Path: e.Path, Err: syscall.Errno(e.Errno)}
+	}
+
+	if e.Errno != 0 {
+		return syscall.Errno(e.Errno)
+	}
+
+	return errors.New(e.Message)
+}
+
+// Err returns the error of the command as returned by plugins.Run.
+func (c *Command) Err() error {
+	if c.Error != nil {
+		return c.Error.Err()
+	}
+
+	if c.Code != 0 || c.Signal != "" {
+		return &plugins.ExitError{Code: c.Code, Signal: c.Signal}
+	}
+
+	return nil
+}
+
+func (d Data) MarshalJSON() ([]byte, error) {
+	if utf8.Valid(d) {
+		return json.Marshal(string(d))
+	}
+
+	return json.Marshal(base64Data{Base64: base64.StdEncoding.EncodeToString(d)})
+}
+
+func (d *Data) UnmarshalJSON(j []byte) error {
+	var s string
+
+	err := json.Unmarshal(j, &s)
+	if err == nil {
+		*d = Data(s)
+		return nil
+	}
+
+	var b base64Data
+
+	err = json.Unmarshal(j, &b)
+	if err != nil {
+		return err
+	}
+
+	*d, err = base64.StdEncoding.DecodeString(b.Base64)
+
+	return err
+}
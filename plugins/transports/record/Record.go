@@ -0,0 +1,247 @@
+package recordtransport
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"syscall"
+
+	"github.com/abrander/agento/plugins"
+)
+
+type (
+	// Recorder records everything read using a transport.
+	Recorder struct {
+		transport plugins.Transport
+
+		lock    sync.Mutex
+		fixture Fixture
+	}
+
+	// recordedProcess records the output of a command when it exits.
+	recordedProcess struct {
+		plugins.Process
+		recorder *Recorder
+		command  *Command
+
+		stdout bytes.Buffer
+		stderr bytes.Buffer
+	}
+
+	// recordedConn records the data read and written.
+	recordedConn struct {
+		net.Conn
+		recorder *Recorder
+		conn     *Conn
+	}
+
+	// teeWriter writes to w and a buffer guarded by lock.
+	teeWriter struct {
+		w    io.Writer
+		lock *sync.Mutex
+		buf  *bytes.Buffer
+	}
+)
+
+// NewRecorder returns a transport recording everything read using
+// transport.
+func NewRecorder(transport plugins.Transport) *Recorder {
+	return &Recorder{
+		transport: transport,
+		fixture: Fixture{
+			Files:  make(map[string]*File),
+			Statfs: make(map[string]*Statfs),
+		},
+	}
+}
+
+// Fixture returns everything recorded so far.
+func (r *Recorder) Fixture() *Fixture {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	j, _ := json.Marshal(&r.fixture)
+
+	f := &Fixture{}
+	json.Unmarshal(j, f)
+
+	return f
+}
+
+func (r *Recorder) GetDoc() *plugins.Doc {
+	doc := plugins.NewDoc("Recording transport")
+
+	return doc
+}
+
+func (r *Recorder) Dial(network string, address string) (net.Conn, error) {
+	c := &Conn{Network: network, Address: address}
+
+	conn, err := r.transport.Dial(network, address)
+
+	r.lock.Lock()
+	c.Error = newError(err)
+	r.fixture.Conns = append(r.fixture.Conns, c)
+	r.lock.Unlock()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &recordedConn{Conn: conn, recorder: r, conn: c}, nil
+}
+
+// add adds data to the last event if it's in the same direction, or to a new
+// event.
+func (c *recordedConn) add(b []byte, read bool) {
+	c.recorder.lock.Lock()
+	defer c.recorder.lock.Unlock()
+
+	var last *Event
+	if len(c.conn.Events) > 0 {
+		last = c.conn.Events[len(c.conn.Events)-1]
+	}
+
+	switch {
+	case read && last != nil && len(last.Read) > 0:
+		last.Read = append(last.Read, b...)
+	case !read && last != nil && len(last.Write) > 0:
+		last.Write = append(last.Write, b...)
+	case read:
+		c.conn.Events = append(c.conn.Events, &Event{Read: append(Data(nil), b...)})
+	default:
+		c.conn.Events = append(c.conn.Events, &Event{Write: append(Data(nil), b...)})
+	}
+}
+
+func (c *recordedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.add(b[:n], true)
+	}
+
+	return n, err
+}
+
+func (c *recordedConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if n > 0 {
+		c.add(b[:n], false)
+	}
+
+	return n, err
+}
+
+func (r *Recorder) Exec(cmd string, arguments ...string) (io.Reader, io.Reader, error) {
+	var stdout, stderr bytes.Buffer
+
+	err := plugins.Run(r, &plugins.Command{
+		Path:   cmd,
+		Args:   arguments,
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+
+	return &stdout, &stderr, err
+}
+
+// Start implements plugins.Executor. The command is recorded when it exits.
+// Input is not recorded.
+func (r *Recorder) Start(cmd *plugins.Command) (plugins.Process, error) {
+	p := &recordedProcess{
+		recorder: r,
+		command:  &Command{Path: cmd.Path, Args: cmd.Args},
+	}
+
+	var lock sync.Mutex
+
+	c := *cmd
+	c.Stdout = &teeWriter{w: cmd.Stdout, lock: &lock, buf: &p.stdout}
+	c.Stderr = &teeWriter{w: cmd.Stderr, lock: &lock, buf: &p.stderr}
+
+	process, err := plugins.Start(r.transport, &c)
+	if err != nil {
+		p.command.Error = newError(err)
+		r.addCommand(p.command)
+
+		return nil, err
+	}
+
+	p.Process = process
+
+	return p, nil
+}
+
+func (r *Recorder) addCommand(c *Command) {
+	r.lock.Lock()
+	r.fixture.Commands = append(r.fixture.Commands, c)
+	r.lock.Unlock()
+}
+
+func (p *recordedProcess) Wait() error {
+	err := p.Process.Wait()
+
+	var exitError *plugins.ExitError
+	if errors.As(err, &exitError) {
+		p.command.Code = exitError.Code
+		p.command.Signal = exitError.Signal
+	} else {
+		p.command.Error = newError(err)
+	}
+
+	p.command.Stdout = p.stdout.Bytes()
+	p.command.Stderr = p.stderr.Bytes()
+
+	p.recorder.addCommand(p.command)
+
+	return err
+}
+
+func (t *teeWriter) Write(b []byte) (int, error) {
+	t.lock.Lock()
+	t.buf.Write(b)
+	t.lock.Unlock()
+
+	if t.w == nil {
+		return len(b), nil
+	}
+
+	return t.w.Write(b)
+}
+
+func (r *Recorder) Open(path string) (io.ReadCloser, error) {
+	contents, err := r.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(contents)), nil
+}
+
+func (r *Recorder) ReadFile(path string) ([]byte, error) {
+	contents, err := r.transport.ReadFile(path)
+
+	r.lock.Lock()
+	r.fixture.Files[plugins.ContainerPath(path)] = &File{Contents: contents, Error: newError(err)}
+	r.lock.Unlock()
+
+	return contents, err
+}
+
+func (r *Recorder) Statfs(path string, buf *syscall.Statfs_t) error {
+	err := r.transport.Statfs(path, buf)
+
+	r.lock.Lock()
+	r.fixture.Statfs[plugins.ContainerPath(path)] = &Statfs{Buf: *buf, Error: newError(err)}
+	r.lock.Unlock()
+
+	return err
+}
+
+// Ensure compliance
+var _ plugins.Transport = (*Recorder)(nil)
+var _ plugins.Executor = (*Recorder)(nil)
@@ -0,0 +1,170 @@
+package recordtransport
+
+import (
+	"bufio"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/abrander/agento/plugins"
+	"github.com/abrander/agento/plugins/transports/local"
+)
+
+// greeter greets clients and answers their first line.
+func greeter(t *testing.T) net.Listener {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() failed: %s", err.Error())
+	}
+
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			conn.Write([]byte("hello\n"))
+
+			line, _ := bufio.NewReader(conn).ReadString('\n')
+			conn.Write([]byte("you said " + line))
+			conn.Close()
+		}
+	}()
+
+	return listener
+}
+
+// talk greets the server at address using transport.
+func talk(transport plugins.Transport, address string) (string, error) {
+	conn, err := transport.Dial("tcp", address)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+
+	greeting, _ := r.ReadString('\n')
+	conn.Write([]byte("hi\n"))
+	answer, _ := ioutil.ReadAll(r)
+
+	return greeting + string(answer), nil
+}
+
+// gather reads a bit of everything.
+func gather(transport plugins.Transport, address string) []string {
+	var results []string
+
+	add := func(result interface{}, err error) {
+		if err != nil {
+			results = append(results, "error: "+err.Error())
+		}
+
+		switch r := result.(type) {
+		case []byte:
+			results = append(results, string(r))
+		case string:
+			results = append(results, r)
+		}
+	}
+
+	add(transport.ReadFile("/proc/sys/kernel/ostype"))
+	add(transport.ReadFile("/nonexistent"))
+
+	f, err := transport.Open("/proc/sys/kernel/ostype")
+	if err == nil {
+		add(ioutil.ReadAll(f))
+		f.Close()
+	}
+
+	stdout, stderr, err := transport.Exec("sh", "-c", "echo out; echo err >&2; exit 2")
+	out, _ := ioutil.ReadAll(stdout)
+	errOut, _ := ioutil.ReadAll(stderr)
+	add(string(out)+string(errOut), err)
+
+	var buf syscall.Statfs_t
+	err = transport.Statfs("/", &buf)
+	add(strconv.FormatInt(int64(buf.Bsize), 10), err)
+
+	add(talk(transport, address))
+
+	return results
+}
+
+func TestRecordReplay(t *testing.T) {
+	listener := greeter(t)
+	address := listener.Addr().String()
+
+	recorder := NewRecorder(localtransport.NewLocalTransport().(plugins.Transport))
+	recorded := gather(recorder, address)
+
+	if recorded[len(recorded)-1] != "hello\nyou said hi\n" {
+		t.Fatalf("Connection returned '%s'", recorded[len(recorded)-1])
+	}
+
+	path := filepath.Join(t.TempDir(), "fixture.json")
+
+	err := recorder.Fixture().Save(path)
+	if err != nil {
+		t.Fatalf("Save() failed: %s", err.Error())
+	}
+
+	// The fixture must be replayed without the host.
+	listener.Close()
+
+	replay, err := LoadReplay(path)
+	if err != nil {
+		t.Fatalf("LoadReplay() failed: %s", err.Error())
+	}
+
+	replayed := gather(replay, address)
+
+	if strings.Join(replayed, "|") != strings.Join(recorded, "|") {
+		t.Errorf("Replay returned %q, recorded %q", replayed, recorded)
+	}
+
+	_, err = replay.ReadFile("/nonexistent")
+	if !os.IsNotExist(err) {
+		t.Errorf("ReadFile() returned %v for missing file", err)
+	}
+
+	_, err = replay.ReadFile("/etc/not-recorded")
+	if err == nil || os.IsNotExist(err) {
+		t.Errorf("ReadFile() returned %v for file not recorded", err)
+	}
+
+	_, _, err = replay.Exec("sh", "-c", "exit 0")
+	if err == nil {
+		t.Errorf("Exec() did not fail for command not recorded")
+	}
+
+	// Recordings are reused when all are used.
+	answer, _ := talk(replay, address)
+	if answer != "hello\nyou said hi\n" {
+		t.Errorf("Connection returned '%s' when replayed again", answer)
+	}
+}
+
+func TestData(t *testing.T) {
+	for _, data := range []Data{Data("text\n"), Data{0xff, 0x00, 0xfe}} {
+		j, err := data.MarshalJSON()
+		if err != nil {
+			t.Fatalf("MarshalJSON() failed: %s", err.Error())
+		}
+
+		var d Data
+
+		err = d.UnmarshalJSON(j)
+		if err != nil || string(d) != string(data) {
+			t.Errorf("%s was unmarshalled as %v: %v", string(j), d, err)
+		}
+	}
+}
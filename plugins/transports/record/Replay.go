@@ -0,0 +1,252 @@
+package recordtransport
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/abrander/agento/plugins"
+)
+
+type (
+	// Replay serves what was recorded in a fixture.
+	Replay struct {
+		fixture *Fixture
+
+		lock sync.Mutex
+		used map[interface{}]bool
+	}
+
+	// replayProcess is a command started by Start. The output is written
+	// in the background to not block on pipes.
+	replayProcess struct {
+		done chan struct{}
+		err  error
+	}
+)
+
+var (
+	// ErrNotRecorded is returned for everything not in the fixture.
+	ErrNotRecorded = errors.New("not recorded")
+)
+
+// NewReplay returns a transport serving what's recorded in fixture.
+func NewReplay(fixture *Fixture) *Replay {
+	return &Replay{
+		fixture: fixture,
+		used:    make(map[interface{}]bool),
+	}
+}
+
+// LoadReplay returns a transport serving the fixture saved at path.
+func LoadReplay(path string) (*Replay, error) {
+	fixture, err := LoadFixture(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewReplay(fixture), nil
+}
+
+func (r *Replay) GetDoc() *plugins.Doc {
+	doc := plugins.NewDoc("Replaying transport")
+
+	return doc
+}
+
+// nextConn returns the first unused recording of a connection to address.
+// If all are used, the last is returned again.
+func (r *Replay) nextConn(network string, address string) *Conn {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var last *Conn
+
+	for _, c := range r.fixture.Conns {
+		if c.Network != network || c.Address != address {
+			continue
+		}
+
+		last = c
+
+		if !r.used[c] {
+			r.used[c] = true
+			return c
+		}
+	}
+
+	return last
+}
+
+// nextCommand returns the first unused recording of a command like
+// nextConn.
+func (r *Replay) nextCommand(path string, args []string) *Command {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var last *Command
+
+	for _, c := range r.fixture.Commands {
+		if c.Path != path || !sameArgs(c.Args, args) {
+			continue
+		}
+
+		last = c
+
+		if !r.used[c] {
+			r.used[c] = true
+			return c
+		}
+	}
+
+	return last
+}
+
+func sameArgs(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Dial returns a connection replaying the data read from the recorded
+// connection. Data written is expected to be of the recorded length, but
+// its contents are ignored. The connection is closed after the last data.
+func (r *Replay) Dial(network string, address string) (net.Conn, error) {
+	c := r.nextConn(network, address)
+	if c == nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: ErrNotRecorded}
+	}
+
+	if c.Error != nil {
+		return nil, c.Error.Err()
+	}
+
+	client, server := net.Pipe()
+
+	go func() {
+		defer server.Close()
+
+		for _, event := range c.Events {
+			_, err := io.ReadFull(server, make([]byte, len(event.Write)))
+			if err != nil {
+				return
+			}
+
+			_, err = server.Write(event.Read)
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	return client, nil
+}
+
+func (r *Replay) Exec(cmd string, arguments ...string) (io.Reader, io.Reader, error) {
+	var stdout, stderr bytes.Buffer
+
+	err := plugins.Run(r, &plugins.Command{
+		Path:   cmd,
+		Args:   arguments,
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+
+	return &stdout, &stderr, err
+}
+
+// Start implements plugins.Executor. Commands are matched by path and
+// arguments, input is ignored.
+func (r *Replay) Start(cmd *plugins.Command) (plugins.Process, error) {
+	c := r.nextCommand(cmd.Path, cmd.Args)
+	if c == nil {
+		return nil, &os.PathError{Op: "exec", Path: cmd.Path, Err: ErrNotRecorded}
+	}
+
+	p := &replayProcess{
+		done: make(chan struct{}),
+		err:  c.Err(),
+	}
+
+	go func() {
+		if cmd.Stdout != nil {
+			cmd.Stdout.Write(c.Stdout)
+		}
+
+		if cmd.Stderr != nil {
+			cmd.Stderr.Write(c.Stderr)
+		}
+
+		close(p.done)
+	}()
+
+	return p, nil
+}
+
+func (p *replayProcess) Signal(sig syscall.Signal) error {
+	return os.ErrProcessDone
+}
+
+func (p *replayProcess) Kill() error {
+	return nil
+}
+
+func (p *replayProcess) Wait() error {
+	<-p.done
+
+	return p.err
+}
+
+func (r *Replay) Open(path string) (io.ReadCloser, error) {
+	contents, err := r.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ioutil.NopCloser(bytes.NewReader(contents)), nil
+}
+
+func (r *Replay) ReadFile(path string) ([]byte, error) {
+	f, found := r.fixture.Files[plugins.ContainerPath(path)]
+	if !found {
+		return nil, &os.PathError{Op: "open", Path: path, Err: ErrNotRecorded}
+	}
+
+	if f.Error != nil {
+		return nil, f.Error.Err()
+	}
+
+	return append([]byte(nil), f.Contents...), nil
+}
+
+func (r *Replay) Statfs(path string, buf *syscall.Statfs_t) error {
+	s, found := r.fixture.Statfs[plugins.ContainerPath(path)]
+	if !found {
+		return &os.PathError{Op: "statfs", Path: path, Err: ErrNotRecorded}
+	}
+
+	if s.Error != nil {
+		return s.Error.Err()
+	}
+
+	*buf = s.Buf
+
+	return nil
+}
+
+// Ensure compliance
+var _ plugins.Transport = (*Replay)(nil)
+var _ plugins.Executor = (*Replay)(nil)
@@ -0,0 +1,111 @@
+package relaytransport
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+)
+
+// A relay connects to the server and upgrades the connection. The roles are
+// then reversed, the server sends HTTP/2 requests to the relay:
+//
+//   POST /exec     The body is the command as JSON on one line followed by
+//                  stdin. The response is a stream of frames.
+//   POST /signal   Signals a command started by /exec.
+//   GET  /file     Returns the contents of a file.
+//   GET  /statfs   Returns syscall.Statfs_t as JSON.
+//   POST /dial     The body is written to the connection, and the
+//                  response is read from it.
+
+type (
+	// command is the command sent to /exec.
+	command struct {
+		Path  string   `json:"path"`
+		Args  []string `json:"args,omitempty"`
+		Env   []string `json:"env,omitempty"`
+		Dir   string   `json:"dir,omitempty"`
+		Stdin bool     `json:"stdin,omitempty"`
+	}
+
+	// exitStatus is the last frame of /exec.
+	exitStatus struct {
+		Code   int    `json:"code"`
+		Signal string `json:"signal,omitempty"`
+		Error  string `json:"error,omitempty"`
+	}
+
+	// bufferedConn reads from a buffered reader holding data read past
+	// the upgrade response.
+	bufferedConn struct {
+		net.Conn
+		r *bufio.Reader
+	}
+)
+
+const (
+	// Upgrade is the protocol requested by relays when connecting.
+	Upgrade = "agento-relay"
+
+	// Headers used by relays.
+	secretHeader  = "X-Agento-Secret"
+	nameHeader    = "X-Agento-Relay"
+	processHeader = "X-Agento-Process"
+	errnoHeader   = "X-Agento-Errno"
+
+	// Streams of /exec frames.
+	stdoutStream = 1
+	stderrStream = 2
+	statusStream = 3
+)
+
+// writeFrame writes a frame of 5 bytes header followed by payload. The
+// header is the stream and the length of the payload as big endian.
+func writeFrame(w io.Writer, stream byte, payload []byte) error {
+	var header [5]byte
+
+	header[0] = stream
+	binary.BigEndian.PutUint32(header[1:], uint32(len(payload)))
+
+	_, err := w.Write(append(header[:], payload...))
+
+	return err
+}
+
+// readFrame reads a frame written by writeFrame.
+func readFrame(r io.Reader) (byte, []byte, error) {
+	var header [5]byte
+
+	_, err := io.ReadFull(r, header[:])
+	if err != nil {
+		return 0, nil, err
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint32(header[1:]))
+
+	_, err = io.ReadFull(r, payload)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return header[0], payload, nil
+}
+
+// writeError writes err as the response. The errno of system errors is
+// sent, to let the server reconstruct them.
+func writeError(w http.ResponseWriter, err error) {
+	var errno syscall.Errno
+	if errors.As(err, &errno) {
+		w.Header().Set(errnoHeader, strconv.Itoa(int(errno)))
+	}
+
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
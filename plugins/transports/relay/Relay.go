@@ -0,0 +1,356 @@
+package relaytransport
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/http2"
+
+	"github.com/abrander/agento/configuration"
+	"github.com/abrander/agento/logger"
+	"github.com/abrander/agento/plugins"
+)
+
+type (
+	// handler serves the requests of the server using a transport local to
+	// the relay.
+	handler struct {
+		transport plugins.Transport
+
+		lock      sync.Mutex
+		processes map[string]plugins.Process
+		nextID    int
+	}
+
+	// frameWriter writes to a stream of /exec.
+	frameWriter struct {
+		w      http.ResponseWriter
+		lock   *sync.Mutex
+		stream byte
+	}
+
+	// flushWriter flushes after every write.
+	flushWriter struct {
+		w http.ResponseWriter
+	}
+)
+
+var (
+	// ReconnectMin and ReconnectMax limit the wait between connection
+	// attempts. The wait is doubled for every failed attempt.
+	ReconnectMin = time.Second
+	ReconnectMax = time.Minute
+)
+
+// Connect connects to the Agento server as a relay and serves requests using
+// transport. Lost connections are reconnected. Connect never returns.
+func Connect(config configuration.RelayConfiguration, transport plugins.Transport) {
+	name := config.Name
+	if name == "" {
+		name, _ = os.Hostname()
+	}
+
+	wait := ReconnectMin
+
+	for {
+		start := time.Now()
+
+		err := connect(config.URL, config.Secret, name, transport)
+		logger.Red("relay", "Connection to %s lost: %s", config.URL, err.Error())
+
+		// Connections lasting a while reset the wait.
+		if time.Since(start) > ReconnectMax {
+			wait = ReconnectMin
+		}
+
+		time.Sleep(wait)
+
+		wait *= 2
+		if wait > ReconnectMax {
+			wait = ReconnectMax
+		}
+	}
+}
+
+// connect connects to the server at u and serves requests until the
+// connection is lost.
+func connect(u string, secret string, name string, transport plugins.Transport) error {
+	server, err := url.Parse(u)
+	if err != nil {
+		return err
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+
+	var conn net.Conn
+
+	switch server.Scheme {
+	case "http":
+		conn, err = dialer.Dial("tcp", hostPort(server, "80"))
+	case "https":
+		conn, err = tls.DialWithDialer(dialer, "tcp", hostPort(server, "443"), &tls.Config{ServerName: server.Hostname()})
+	default:
+		return fmt.Errorf("unsupported scheme '%s'", server.Scheme)
+	}
+
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", Upgrade)
+	req.Header.Set(secretHeader, secret)
+	req.Header.Set(nameHeader, name)
+
+	err = req.Write(conn)
+	if err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(conn)
+
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+
+		return fmt.Errorf("server returned %d: %s", resp.StatusCode, message)
+	}
+
+	logger.Green("relay", "Connected to %s as %s", u, name)
+
+	h := &handler{
+		transport: transport,
+		processes: make(map[string]plugins.Process),
+	}
+
+	srv := &http2.Server{}
+	srv.ServeConn(&bufferedConn{Conn: conn, r: r}, &http2.ServeConnOpts{Handler: h})
+
+	return io.EOF
+}
+
+// hostPort returns the host and port of u using port if none is given.
+func hostPort(u *url.URL, port string) string {
+	if u.Port() != "" {
+		return u.Host
+	}
+
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	switch r.URL.Path {
+	case "/exec":
+		h.exec(w, r)
+
+	case "/signal":
+		h.lock.Lock()
+		process, found := h.processes[query.Get("process")]
+		h.lock.Unlock()
+
+		if !found {
+			http.Error(w, os.ErrProcessDone.Error(), http.StatusNotFound)
+			return
+		}
+
+		sig, _ := strconv.Atoi(query.Get("signal"))
+
+		err := process.Signal(syscall.Signal(sig))
+		if err != nil {
+			writeError(w, err)
+		}
+
+	case "/file":
+		f, err := h.transport.Open(query.Get("path"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer f.Close()
+
+		io.Copy(w, f)
+
+	case "/statfs":
+		var buf syscall.Statfs_t
+
+		err := h.transport.Statfs(query.Get("path"), &buf)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		json.NewEncoder(w).Encode(&buf)
+
+	case "/dial":
+		h.dial(w, r)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// exec starts a command and writes its output until it exits. The command
+// is killed if the server cancels the request.
+func (h *handler) exec(w http.ResponseWriter, r *http.Request) {
+	body := bufio.NewReader(r.Body)
+
+	line, err := body.ReadBytes('\n')
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var c command
+
+	err = json.Unmarshal(line, &c)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var lock sync.Mutex
+
+	cmd := &plugins.Command{
+		Path:   c.Path,
+		Args:   c.Args,
+		Env:    c.Env,
+		Dir:    c.Dir,
+		Stdout: &frameWriter{w: w, lock: &lock, stream: stdoutStream},
+		Stderr: &frameWriter{w: w, lock: &lock, stream: stderrStream},
+	}
+
+	if c.Stdin {
+		cmd.Stdin = body
+	}
+
+	// The headers must be sent before any output.
+	lock.Lock()
+
+	process, err := plugins.Start(h.transport, cmd)
+	if err != nil {
+		lock.Unlock()
+		writeError(w, err)
+		return
+	}
+
+	h.lock.Lock()
+	h.nextID++
+	id := strconv.Itoa(h.nextID)
+	h.processes[id] = process
+	h.lock.Unlock()
+
+	defer func() {
+		h.lock.Lock()
+		delete(h.processes, id)
+		h.lock.Unlock()
+	}()
+
+	w.Header().Set(processHeader, id)
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+	lock.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- process.Wait()
+	}()
+
+	select {
+	case err = <-done:
+	case <-r.Context().Done():
+		process.Kill()
+		err = <-done
+	}
+
+	var status exitStatus
+
+	if exitError, ok := err.(*plugins.ExitError); ok {
+		status.Code = exitError.Code
+		status.Signal = exitError.Signal
+	} else if err != nil {
+		status.Code = -1
+		status.Error = err.Error()
+	}
+
+	payload, _ := json.Marshal(status)
+
+	lock.Lock()
+	writeFrame(w, statusStream, payload)
+	lock.Unlock()
+}
+
+// dial connects to the address and copies data both ways until both
+// directions are closed.
+func (h *handler) dial(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	conn, err := h.transport.Dial(query.Get("network"), query.Get("address"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer conn.Close()
+
+	w.WriteHeader(http.StatusOK)
+	w.(http.Flusher).Flush()
+
+	go func() {
+		io.Copy(conn, r.Body)
+
+		// Let the other end see the end of the data.
+		if c, ok := conn.(interface{ CloseWrite() error }); ok {
+			c.CloseWrite()
+		}
+	}()
+
+	io.Copy(&flushWriter{w: w}, conn)
+}
+
+func (f *frameWriter) Write(b []byte) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	err := writeFrame(f.w, f.stream, b)
+	if err != nil {
+		return 0, err
+	}
+
+	f.w.(http.Flusher).Flush()
+
+	return len(b), nil
+}
+
+func (f *flushWriter) Write(b []byte) (int, error) {
+	n, err := f.w.Write(b)
+	f.w.(http.Flusher).Flush()
+
+	return n, err
+}
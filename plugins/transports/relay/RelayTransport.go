@@ -0,0 +1,464 @@
+package relaytransport
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/http2"
+
+	"github.com/abrander/agento/logger"
+	"github.com/abrander/agento/plugins"
+)
+
+func init() {
+	plugins.Register("relaytransport", NewRelayTransport)
+}
+
+func NewRelayTransport() interface{} {
+	return &RelayTransport{}
+}
+
+type (
+	// RelayTransport reaches a host using a relay connected to this server.
+	RelayTransport struct {
+		Name string `json:"name" description:"Name of the relay"`
+
+		accountID string
+	}
+
+	// relay is a connected relay.
+	relay struct {
+		client *http2.ClientConn
+		conn   net.Conn
+	}
+
+	// relayConn closes done when reading from the connection fails.
+	relayConn struct {
+		net.Conn
+		r    *bufio.Reader
+		once sync.Once
+		done chan struct{}
+	}
+
+	// relayProcess is a command started by Start.
+	relayProcess struct {
+		relay  *relay
+		id     string
+		cancel context.CancelFunc
+
+		// done is closed when the status is received. err is the result.
+		done chan struct{}
+		err  error
+
+		killed int32
+	}
+)
+
+var (
+	relaysLock sync.RWMutex
+	relays     = make(map[string]*relay)
+
+	// KeepaliveInterval is how long a relay can be idle before it's
+	// pinged. Relays not answering within KeepaliveTimeout are
+	// disconnected.
+	KeepaliveInterval = 30 * time.Second
+	KeepaliveTimeout  = 15 * time.Second
+
+	// ErrNotConnected is returned if the relay is not connected.
+	ErrNotConnected = errors.New("relay not connected")
+)
+
+// key returns the key of relay name belonging to accountID.
+func key(accountID string, name string) string {
+	return accountID + "/" + name
+}
+
+// Accept upgrades the connection of w to a relay connection for the relay
+// name belonging to accountID. The request must be authenticated by the
+// caller. A relay already connected with the same name is disconnected.
+func Accept(w http.ResponseWriter, accountID string, name string) error {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		return errors.New("connection can not be upgraded")
+	}
+
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		return err
+	}
+
+	buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: " + Upgrade + "\r\n\r\n")
+
+	err = buf.Flush()
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	c := &relayConn{
+		Conn: conn,
+		r:    buf.Reader,
+		done: make(chan struct{}),
+	}
+
+	t := &http2.Transport{
+		ReadIdleTimeout: KeepaliveInterval,
+		PingTimeout:     KeepaliveTimeout,
+	}
+
+	client, err := t.NewClientConn(c)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	r := &relay{client: client, conn: conn}
+	k := key(accountID, name)
+
+	relaysLock.Lock()
+	previous := relays[k]
+	relays[k] = r
+	relaysLock.Unlock()
+
+	if previous != nil {
+		previous.conn.Close()
+	}
+
+	logger.Green("relay", "Relay %s connected from %s", name, conn.RemoteAddr())
+
+	go func() {
+		<-c.done
+
+		relaysLock.Lock()
+		if relays[k] == r {
+			delete(relays, k)
+		}
+		relaysLock.Unlock()
+
+		client.Close()
+		conn.Close()
+
+		logger.Yellow("relay", "Relay %s disconnected", name)
+	}()
+
+	return nil
+}
+
+func (c *relayConn) Read(b []byte) (int, error) {
+	n, err := c.r.Read(b)
+	if err != nil {
+		c.once.Do(func() {
+			close(c.done)
+		})
+	}
+
+	return n, err
+}
+
+func (r *RelayTransport) GetDoc() *plugins.Doc {
+	doc := plugins.NewDoc("Relay transport")
+
+	return doc
+}
+
+// SetAccount implements plugins.AccountTransport. Only relays connected by
+// the account of the host can be used.
+func (r *RelayTransport) SetAccount(accountID string) {
+	r.accountID = accountID
+}
+
+// relay returns the connected relay.
+func (r *RelayTransport) relay() (*relay, error) {
+	relaysLock.RLock()
+	rl, found := relays[key(r.accountID, r.Name)]
+	relaysLock.RUnlock()
+
+	if !found {
+		return nil, ErrNotConnected
+	}
+
+	return rl, nil
+}
+
+// do sends a request to the relay. An error is returned if the relay fails
+// the request.
+func (r *RelayTransport) do(ctx context.Context, method string, path string, query url.Values, body io.Reader) (*http.Response, error) {
+	rl, err := r.relay()
+	if err != nil {
+		return nil, err
+	}
+
+	return rl.do(ctx, method, path, query, body)
+}
+
+func (r *relay) do(ctx context.Context, method string, path string, query url.Values, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, "http://relay"+path+"?"+query.Encode(), body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := r.client.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+
+		message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+
+		errno, err := strconv.Atoi(resp.Header.Get(errnoHeader))
+		if err == nil {
+			return nil, syscall.Errno(errno)
+		}
+
+		return nil, errors.New(string(bytes.TrimSpace(message)))
+	}
+
+	return resp, nil
+}
+
+// pathError returns err as an *os.PathError if it's a system error.
+func pathError(op string, path string, err error) error {
+	if errno, ok := err.(syscall.Errno); ok {
+		return &os.PathError{Op: op, Path: path, Err: errno}
+	}
+
+	return err
+}
+
+// Dial implements plugins.Transport. The connection is made by the relay.
+func (r *RelayTransport) Dial(network string, address string) (net.Conn, error) {
+	logger.Yellow("relay", "Dialing %s://%s using relay %s", network, address, r.Name)
+
+	query := url.Values{}
+	query.Set("network", network)
+	query.Set("address", address)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	upstream, writer := io.Pipe()
+
+	resp, err := r.do(ctx, http.MethodPost, "/dial", query, upstream)
+	if err != nil {
+		cancel()
+		writer.Close()
+
+		return nil, err
+	}
+
+	// A pipe provides deadlines.
+	local, remote := net.Pipe()
+
+	go func() {
+		io.Copy(remote, resp.Body)
+		remote.Close()
+	}()
+
+	go func() {
+		io.Copy(writer, remote)
+		writer.Close()
+		cancel()
+	}()
+
+	return local, nil
+}
+
+func (r *RelayTransport) Exec(cmd string, arguments ...string) (io.Reader, io.Reader, error) {
+	var stdout, stderr bytes.Buffer
+
+	err := plugins.Run(r, &plugins.Command{
+		Path:   cmd,
+		Args:   arguments,
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+
+	return &stdout, &stderr, err
+}
+
+// Start implements plugins.Executor.
+func (r *RelayTransport) Start(cmd *plugins.Command) (plugins.Process, error) {
+	logger.Yellow("relay", "Executing command '%s' using relay %s", cmd.Path, r.Name)
+
+	rl, err := r.relay()
+	if err != nil {
+		return nil, err
+	}
+
+	line, _ := json.Marshal(command{
+		Path:  cmd.Path,
+		Args:  cmd.Args,
+		Env:   cmd.Env,
+		Dir:   cmd.Dir,
+		Stdin: cmd.Stdin != nil,
+	})
+
+	body, writer := io.Pipe()
+
+	go func() {
+		writer.Write(append(line, '\n'))
+
+		if cmd.Stdin != nil {
+			io.Copy(writer, cmd.Stdin)
+		}
+
+		writer.Close()
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	resp, err := rl.do(ctx, http.MethodPost, "/exec", nil, body)
+	if err != nil {
+		cancel()
+		body.Close()
+
+		return nil, err
+	}
+
+	p := &relayProcess{
+		relay:  rl,
+		id:     resp.Header.Get(processHeader),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+
+	stdout, stderr := cmd.Stdout, cmd.Stderr
+	if stdout == nil {
+		stdout = ioutil.Discard
+	}
+
+	if stderr == nil {
+		stderr = ioutil.Discard
+	}
+
+	go func() {
+		p.err = p.copy(resp.Body, stdout, stderr)
+		resp.Body.Close()
+		close(p.done)
+	}()
+
+	return p, nil
+}
+
+// copy copies output until the status of the command is received.
+func (p *relayProcess) copy(r io.Reader, stdout io.Writer, stderr io.Writer) error {
+	for {
+		stream, payload, err := readFrame(r)
+		if err != nil {
+			return err
+		}
+
+		switch stream {
+		case stdoutStream:
+			stdout.Write(payload)
+
+		case stderrStream:
+			stderr.Write(payload)
+
+		case statusStream:
+			var status exitStatus
+
+			err = json.Unmarshal(payload, &status)
+			if err != nil {
+				return err
+			}
+
+			if status.Error != "" {
+				return errors.New(status.Error)
+			}
+
+			if status.Code != 0 || status.Signal != "" {
+				return &plugins.ExitError{Code: status.Code, Signal: status.Signal}
+			}
+
+			return nil
+		}
+	}
+}
+
+func (p *relayProcess) Signal(sig syscall.Signal) error {
+	query := url.Values{}
+	query.Set("process", p.id)
+	query.Set("signal", strconv.Itoa(int(sig)))
+
+	resp, err := p.relay.do(context.Background(), http.MethodPost, "/signal", query, nil)
+	if err != nil {
+		return err
+	}
+
+	return resp.Body.Close()
+}
+
+// Kill kills the command by cancelling the request.
+func (p *relayProcess) Kill() error {
+	atomic.StoreInt32(&p.killed, 1)
+	p.cancel()
+
+	return nil
+}
+
+func (p *relayProcess) Wait() error {
+	<-p.done
+	p.cancel()
+
+	if atomic.LoadInt32(&p.killed) == 1 {
+		return &plugins.ExitError{Code: -1, Signal: "KILL"}
+	}
+
+	return p.err
+}
+
+// Open implements plugins.Transport. The file is streamed from the relay.
+func (r *RelayTransport) Open(path string) (io.ReadCloser, error) {
+	query := url.Values{}
+	query.Set("path", path)
+
+	resp, err := r.do(context.Background(), http.MethodGet, "/file", query, nil)
+	if err != nil {
+		return nil, pathError("open", path, err)
+	}
+
+	return resp.Body, nil
+}
+
+func (r *RelayTransport) ReadFile(path string) ([]byte, error) {
+	f, err := r.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ioutil.ReadAll(f)
+}
+
+func (r *RelayTransport) Statfs(path string, buf *syscall.Statfs_t) error {
+	query := url.Values{}
+	query.Set("path", path)
+
+	resp, err := r.do(context.Background(), http.MethodGet, "/statfs", query, nil)
+	if err != nil {
+		return pathError("statfs", path, err)
+	}
+	defer resp.Body.Close()
+
+	return json.NewDecoder(resp.Body).Decode(buf)
+}
+
+// Ensure compliance
+var _ plugins.Transport = (*RelayTransport)(nil)
+var _ plugins.Executor = (*RelayTransport)(nil)
+var _ plugins.AccountTransport = (*RelayTransport)(nil)
@@ -0,0 +1,201 @@
+package relaytransport
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/abrander/agento/plugins"
+	"github.com/abrander/agento/plugins/transports/local"
+)
+
+// testRelay connects a relay using the local transport and returns a
+// transport using it.
+func testRelay(t *testing.T) *RelayTransport {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(secretHeader) != "secret" {
+			http.Error(w, "wrong secret", http.StatusUnauthorized)
+			return
+		}
+
+		Accept(w, "account", r.Header.Get(nameHeader))
+	}))
+
+	go connect(server.URL, "secret", t.Name(), localtransport.NewLocalTransport().(plugins.Transport))
+
+	r := &RelayTransport{Name: t.Name()}
+	r.SetAccount("account")
+
+	for i := 0; i < 100; i++ {
+		if _, err := r.relay(); err == nil {
+			break
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Cleanup(func() {
+		if rl, err := r.relay(); err == nil {
+			rl.conn.Close()
+		}
+
+		server.Close()
+	})
+
+	return r
+}
+
+func TestRelayConnect(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "wrong secret", http.StatusUnauthorized)
+	}))
+	defer server.Close()
+
+	err := connect(server.URL, "wrong", "relay", nil)
+	if err == nil || !strings.Contains(err.Error(), "wrong secret") {
+		t.Errorf("connect() returned %v for rejected relay", err)
+	}
+}
+
+func TestRelayExec(t *testing.T) {
+	r := testRelay(t)
+
+	stdout, _, err := r.Exec("echo", "hello")
+	if err != nil {
+		t.Fatalf("Exec() failed: %s", err.Error())
+	}
+
+	out, _ := ioutil.ReadAll(stdout)
+	if string(out) != "hello\n" {
+		t.Errorf("Exec() returned '%s'", string(out))
+	}
+
+	var output, errors bytes.Buffer
+
+	err = plugins.Run(r, &plugins.Command{
+		Path:   "sh",
+		Args:   []string{"-c", "cat; echo $A >&2; exit 3"},
+		Env:    []string{"A=b"},
+		Stdin:  strings.NewReader("input"),
+		Stdout: &output,
+		Stderr: &errors,
+	})
+	if exitError, ok := err.(*plugins.ExitError); !ok || exitError.Code != 3 {
+		t.Errorf("Run() returned %v, expected exit code 3", err)
+	}
+
+	if output.String() != "input" || errors.String() != "b\n" {
+		t.Errorf("Run() wrote '%s' and '%s'", output.String(), errors.String())
+	}
+
+	_, _, err = r.Exec("/nonexistent")
+	if err == nil {
+		t.Errorf("Exec() did not fail for missing command")
+	}
+}
+
+func TestRelaySignal(t *testing.T) {
+	r := testRelay(t)
+
+	process, err := r.Start(&plugins.Command{Path: "sleep", Args: []string{"10"}})
+	if err != nil {
+		t.Fatalf("Start() failed: %s", err.Error())
+	}
+
+	err = process.Signal(syscall.SIGTERM)
+	if err != nil {
+		t.Fatalf("Signal() failed: %s", err.Error())
+	}
+
+	err = process.Wait()
+	if exitError, ok := err.(*plugins.ExitError); !ok || exitError.Signal != "TERM" {
+		t.Errorf("Wait() returned %v, expected TERM", err)
+	}
+
+	process, _ = r.Start(&plugins.Command{Path: "sleep", Args: []string{"10"}})
+	process.Kill()
+
+	err = process.Wait()
+	if exitError, ok := err.(*plugins.ExitError); !ok || exitError.Signal != "KILL" {
+		t.Errorf("Wait() returned %v after Kill()", err)
+	}
+}
+
+func TestRelayFiles(t *testing.T) {
+	r := testRelay(t)
+
+	contents, err := r.ReadFile("/proc/self/status")
+	if err != nil {
+		t.Fatalf("ReadFile() failed: %s", err.Error())
+	}
+
+	if !bytes.HasPrefix(contents, []byte("Name:")) {
+		t.Errorf("ReadFile() returned '%s'", string(contents))
+	}
+
+	_, err = r.ReadFile("/nonexistent")
+	if !os.IsNotExist(err) {
+		t.Errorf("ReadFile() returned %v for missing file", err)
+	}
+
+	var buf syscall.Statfs_t
+
+	err = r.Statfs("/", &buf)
+	if err != nil || buf.Blocks == 0 {
+		t.Errorf("Statfs() returned %v and %+v", err, buf)
+	}
+}
+
+func TestRelayDial(t *testing.T) {
+	r := testRelay(t)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() failed: %s", err.Error())
+	}
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		b := make([]byte, 5)
+		conn.Read(b)
+		conn.Write(append([]byte("hello "), b...))
+	}()
+
+	conn, err := r.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() failed: %s", err.Error())
+	}
+	defer conn.Close()
+
+	conn.Write([]byte("relay"))
+
+	greeting, _ := ioutil.ReadAll(conn)
+	if string(greeting) != "hello relay" {
+		t.Errorf("Read '%s' from connection", string(greeting))
+	}
+}
+
+func TestRelayAccount(t *testing.T) {
+	r := testRelay(t)
+
+	other := &RelayTransport{Name: r.Name}
+	other.SetAccount("other")
+
+	_, _, err := other.Exec("echo")
+	if err != ErrNotConnected {
+		t.Errorf("Exec() returned %v for relay of other account", err)
+	}
+}
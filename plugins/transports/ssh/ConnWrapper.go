@@ -15,16 +15,16 @@ type (
 		sync.Mutex
 		underlying net.Conn
 		done       bool
-		ssh        Ssh
+		conn       *connection
 	}
 )
 
-func NewConnWrapper(underlying net.Conn, ssh Ssh) net.Conn {
+func NewConnWrapper(underlying net.Conn, conn *connection) net.Conn {
 	logger.Green("ssh", "New ConnWrapper allocated for %s", underlying.RemoteAddr().String())
 
 	return &ConnWrapper{
 		underlying: underlying,
-		ssh:        ssh,
+		conn:       conn,
 	}
 }
 
@@ -41,7 +41,7 @@ func (c *ConnWrapper) Close() error {
 
 	c.Lock()
 	if !c.done {
-		pool.Done(c.ssh)
+		pool.Done(c.conn)
 		c.done = true
 	}
 	c.Unlock()
@@ -1,33 +1,140 @@
 package ssh
 
 import (
+	"errors"
+	"fmt"
 	"sync"
 	"time"
 
+	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
 
 	"github.com/abrander/agento/logger"
 )
 
 type (
+	// ConnectionPool keeps connections open for reuse. Users of the same
+	// Ssh configuration share a connection.
 	ConnectionPool struct {
-		lock sync.Mutex
-		pool map[Ssh]*connection
+		lock    sync.Mutex
+		pool    map[Ssh]*connection
+		backoff map[Ssh]*backoff
+		stats   PoolStats
+	}
+
+	// PoolStats is statistics for the connection pool.
+	PoolStats struct {
+		// Connections is the number of open connections.
+		Connections int `json:"connections"`
+
+		// Sessions is the number of sessions in use, including SFTP.
+		Sessions int `json:"sessions"`
+
+		// Unreachable is the number of hosts waiting to be retried.
+		Unreachable int `json:"unreachable"`
+
+		// Dials is the number of connection attempts.
+		Dials uint64 `json:"dials"`
+
+		// DialErrors is the number of failed connection attempts.
+		DialErrors uint64 `json:"dialErrors"`
+
+		// Lost is the number of connections closed because they failed.
+		Lost uint64 `json:"lost"`
+
+		// KeepaliveFailures is the number of keepalive requests not
+		// answered in time.
+		KeepaliveFailures uint64 `json:"keepaliveFailures"`
 	}
 
 	connection struct {
+		ssh      Ssh
 		lastUse  time.Time
-		client   *ssh.Client
 		refCount int
+
+		// ready is closed when dialing is done. client and err are not
+		// changed after that.
+		ready  chan struct{}
+		client *ssh.Client
+		err    error
+
+		// closed is set when the connection is closed and removed from the
+		// pool.
+		closed bool
+
+		// sessions holds a value for each open session, limiting the
+		// number of concurrent sessions to MaxSessions.
+		sessions chan struct{}
+
+		// sftp is the SFTP subsystem of the connection. noSftp is set if
+		// the server doesn't support SFTP. sftpStarting is closed when
+		// starting the subsystem is done.
+		sftp         *sftp.Client
+		noSftp       bool
+		sftpStarting chan struct{}
+
+		// parent is the jump host the connection is tunneled through. The
+		// connection holds a reference to it.
+		parent *connection
 	}
+
+	// backoff keeps track of failed attempts to connect to a host.
+	backoff struct {
+		failures int
+		retry    time.Time
+		err      error
+	}
+)
+
+const (
+	// DefaultMaxSessions is the number of concurrent sessions allowed on
+	// a connection, unless configured otherwise. This matches the default
+	// MaxSessions of OpenSSH.
+	DefaultMaxSessions = 10
 )
 
 var (
+	// IdleTimeout is how long unused connections are kept open.
+	IdleTimeout = 10 * time.Second
+
+	// KeepaliveInterval is how often keepalive requests are sent.
+	KeepaliveInterval = 15 * time.Second
+
+	// KeepaliveTimeout is how long to wait for a reply to a keepalive
+	// request before closing the connection.
+	KeepaliveTimeout = 10 * time.Second
+
+	// BackoffMin is the time to wait before connecting to a host again
+	// after the first failure. The time is doubled for each failure up to
+	// BackoffMax.
+	BackoffMin = time.Second
+
+	// BackoffMax is the longest time to wait before connecting to a host
+	// again.
+	BackoffMax = 5 * time.Minute
+
+	// ConnectTimeout is how long to wait for a connection to a host,
+	// including the SSH handshake.
+	ConnectTimeout = 30 * time.Second
+
+	// SftpTimeout is how long to wait for the SFTP subsystem to start.
+	SftpTimeout = 10 * time.Second
+
+	// ErrUnreachable is returned by ConnectionPool.Get while waiting to
+	// connect to a host again after a failure.
+	ErrUnreachable = errors.New("host unreachable")
+
+	// ErrKeepaliveTimeout is the reason for closing connections not
+	// answering keepalive requests.
+	ErrKeepaliveTimeout = errors.New("keepalive timeout")
+
 	pool ConnectionPool
 )
 
 func init() {
 	pool.pool = make(map[Ssh]*connection)
+	pool.backoff = make(map[Ssh]*backoff)
 
 	go loop()
 }
@@ -37,44 +144,454 @@ func loop() {
 	for t := range ticker {
 		pool.lock.Lock()
 		for s, conn := range pool.pool {
-			if t.Sub(conn.lastUse) > time.Second*10 && conn.refCount == 0 && conn.client != nil {
-				conn.client.Close()
-				conn.client = nil
+			if t.Sub(conn.lastUse) > IdleTimeout && conn.refCount == 0 && conn.client != nil {
+				pool.close(conn)
 				logger.Yellow("ssh", "Closing unused connection %s:%d", s.Host, s.Port)
 			}
 		}
 
+		// Forget failures long ago.
+		for s, b := range pool.backoff {
+			if t.Sub(b.retry) > BackoffMax {
+				delete(pool.backoff, s)
+			}
+		}
+
 		pool.lock.Unlock()
 	}
 }
 
-func (pool *ConnectionPool) Get(ssh Ssh) (*ssh.Client, error) {
+// Get returns a connection, connecting through jump hosts if needed. Only
+// one connection is dialed for concurrent calls. Done must be called when
+// the connection is no longer used.
+func (pool *ConnectionPool) Get(s Ssh) (*connection, error) {
 	pool.lock.Lock()
-	defer pool.lock.Unlock()
 
-	conn, found := pool.pool[ssh]
-	if found && conn.client != nil {
+	conn, found := pool.pool[s]
+	if found {
 		conn.refCount++
-		return conn.client, nil
+		pool.lock.Unlock()
+
+		// Someone else may still be dialing.
+		<-conn.ready
+		if conn.err != nil {
+			return nil, conn.err
+		}
+
+		return conn, nil
+	}
+
+	if b, found := pool.backoff[s]; found && time.Now().Before(b.retry) {
+		pool.lock.Unlock()
+
+		return nil, fmt.Errorf("%w, retrying in %s: %s", ErrUnreachable, time.Until(b.retry).Round(time.Second), b.err.Error())
+	}
+
+	conn = &connection{
+		ssh:      s,
+		refCount: 1,
+		ready:    make(chan struct{}),
+		sessions: make(chan struct{}, s.maxSessions()),
+	}
+
+	pool.pool[s] = conn
+	pool.stats.Dials++
+	pool.lock.Unlock()
+
+	client, parent, err := pool.dial(s)
+
+	pool.lock.Lock()
+	conn.client = client
+	conn.parent = parent
+	conn.err = err
+	conn.lastUse = time.Now()
+
+	if err != nil {
+		pool.close(conn)
+		pool.failed(s, err)
+	} else {
+		delete(pool.backoff, s)
+		go pool.keepalive(conn, KeepaliveInterval, KeepaliveTimeout)
 	}
+	pool.lock.Unlock()
+
+	close(conn.ready)
 
-	client, err := ssh.Connect()
 	if err != nil {
 		return nil, err
 	}
 
-	pool.pool[ssh] = &connection{client: client, lastUse: time.Now(), refCount: 1}
+	return conn, nil
+}
 
-	return client, nil
+// dial connects to s. If s is reached through a jump host, the connection
+// to the jump host is returned as well.
+func (pool *ConnectionPool) dial(s Ssh) (*ssh.Client, *connection, error) {
+	jump, err := s.parent()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var parent *connection
+	var through *ssh.Client
+
+	if jump != nil {
+		parent, err = pool.Get(*jump)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		through = parent.client
+	}
+
+	client, err := s.connect(through)
+	if err != nil {
+		if parent != nil {
+			pool.check(parent, err)
+			pool.Done(parent)
+		}
+
+		return nil, nil, err
+	}
+
+	return client, parent, nil
+}
+
+// failed records a failed attempt to connect to s. The lock must be held.
+func (pool *ConnectionPool) failed(s Ssh, err error) {
+	b, found := pool.backoff[s]
+	if !found {
+		b = &backoff{}
+		pool.backoff[s] = b
+	}
+
+	wait := BackoffMax
+	if b.failures < 20 {
+		wait = BackoffMin << uint(b.failures)
+	}
+
+	if wait > BackoffMax {
+		wait = BackoffMax
+	}
+
+	b.failures++
+	b.retry = time.Now().Add(wait)
+	b.err = err
+
+	pool.stats.DialErrors++
+
+	logger.Red("ssh", "Connecting to %s:%d failed, retrying in %s: %s", s.Host, s.Port, wait, err.Error())
+}
+
+// keepalive sends keepalive requests until the connection is closed. The
+// connection is closed if a request is not answered in time.
+func (pool *ConnectionPool) keepalive(conn *connection, interval time.Duration, timeout time.Duration) {
+	closed := make(chan struct{})
+	go func() {
+		conn.client.Wait()
+		close(closed)
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-closed:
+			pool.fail(conn, errors.New("connection closed"))
+			return
+
+		case <-ticker.C:
+			result := make(chan error, 1)
+			go func() {
+				// The reply doesn't matter, OpenSSH will answer
+				// failure.
+				_, _, err := conn.client.SendRequest("keepalive@openssh.com", true, nil)
+				result <- err
+			}()
+
+			var err error
+
+			select {
+			case err = <-result:
+			case <-time.After(timeout):
+				err = ErrKeepaliveTimeout
+
+				pool.lock.Lock()
+				pool.stats.KeepaliveFailures++
+				pool.lock.Unlock()
+			}
+
+			if err != nil {
+				pool.fail(conn, err)
+				return
+			}
+		}
+	}
 }
 
-func (pool *ConnectionPool) Done(ssh Ssh) {
+// fail closes conn because it failed. The next call to Get will connect
+// again.
+func (pool *ConnectionPool) fail(conn *connection, err error) {
 	pool.lock.Lock()
 	defer pool.lock.Unlock()
 
-	conn, found := pool.pool[ssh]
-	if found && conn.client != nil {
-		conn.lastUse = time.Now()
-		conn.refCount--
+	if conn.closed {
+		return
 	}
+
+	logger.Red("ssh", "Connection to %s:%d lost: %s", conn.ssh.Host, conn.ssh.Port, err.Error())
+
+	pool.stats.Lost++
+	pool.close(conn)
+}
+
+// check closes conn if err indicates that the connection failed. Errors
+// returned by the server when opening a channel leave the connection
+// usable. Returns true if the connection was closed.
+func (pool *ConnectionPool) check(conn *connection, err error) bool {
+	var channelError *ssh.OpenChannelError
+	if err == nil || errors.As(err, &channelError) {
+		return false
+	}
+
+	pool.fail(conn, err)
+
+	return true
+}
+
+// close closes the connection, removes it from the pool and releases the
+// jump host. Users of the connection will get errors. The lock must be
+// held.
+func (pool *ConnectionPool) close(conn *connection) {
+	if conn.closed {
+		return
+	}
+
+	conn.closed = true
+
+	if pool.pool[conn.ssh] == conn {
+		delete(pool.pool, conn.ssh)
+	}
+
+	if conn.sftp != nil {
+		conn.sftp.Close()
+		conn.sftp = nil
+	}
+
+	if conn.client != nil {
+		conn.client.Close()
+	}
+
+	if conn.parent != nil {
+		pool.done(conn.parent)
+		conn.parent = nil
+	}
+}
+
+// Session opens a new session on the connection. If MaxSessions sessions
+// are open, Session waits for one to close. CloseSession must be called
+// when the session is no longer used.
+func (pool *ConnectionPool) Session(conn *connection) (*ssh.Session, error) {
+	conn.sessions <- struct{}{}
+
+	session, err := conn.client.NewSession()
+	if err != nil {
+		<-conn.sessions
+		pool.check(conn, err)
+
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// CloseSession closes a session opened by Session.
+func (pool *ConnectionPool) CloseSession(conn *connection, session *ssh.Session) {
+	session.Close()
+	<-conn.sessions
+}
+
+// Sftp returns a connection and the SFTP client of the connection. Done
+// must be called when the client is no longer used. ErrNoSftp is returned
+// if the server doesn't support SFTP, or no session is available for SFTP.
+func (pool *ConnectionPool) Sftp(s Ssh) (*connection, *sftp.Client, error) {
+	conn, err := pool.Get(s)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	// Only one caller starts the subsystem, the lock is not held while
+	// waiting for the server.
+	for conn.sftpStarting != nil {
+		starting := conn.sftpStarting
+
+		pool.lock.Unlock()
+		<-starting
+		pool.lock.Lock()
+	}
+
+	if conn.sftp != nil {
+		return conn, conn.sftp, nil
+	}
+
+	// The SFTP session is kept open and uses one of the sessions. If it's
+	// the only one, it's left for Exec.
+	if !conn.noSftp && !conn.closed && cap(conn.sessions) > 1 {
+		select {
+		case conn.sessions <- struct{}{}:
+			starting := make(chan struct{})
+			conn.sftpStarting = starting
+
+			pool.lock.Unlock()
+			client, err := startSftp(conn, SftpTimeout)
+			pool.lock.Lock()
+
+			conn.sftpStarting = nil
+			close(starting)
+
+			switch {
+			case err != nil:
+				logger.Yellow("ssh", "SFTP not available on %s:%d: %s", s.Host, s.Port, err.Error())
+				conn.noSftp = true
+
+			case conn.closed:
+				// The connection was closed while starting.
+				client.Close()
+				<-conn.sessions
+
+			default:
+				conn.sftp = client
+
+				return conn, client, nil
+			}
+
+		default:
+		}
+	}
+
+	// Release the reference acquired by Get().
+	pool.done(conn)
+
+	return nil, nil, ErrNoSftp
+}
+
+// startSftp starts the SFTP subsystem on conn using a session reserved by
+// the caller. If the subsystem fails or doesn't start within timeout, the
+// session is released when the attempt is over.
+func startSftp(conn *connection, timeout time.Duration) (*sftp.Client, error) {
+	type started struct {
+		client *sftp.Client
+		err    error
+	}
+
+	result := make(chan started, 1)
+	go func() {
+		client, err := sftp.NewClient(conn.client)
+		result <- started{client, err}
+	}()
+
+	select {
+	case r := <-result:
+		if r.err != nil {
+			<-conn.sessions
+		}
+
+		return r.client, r.err
+
+	case <-time.After(timeout):
+		// The attempt is abandoned. The session is released once the
+		// server answers or the connection is closed.
+		go func() {
+			r := <-result
+			if r.client != nil {
+				r.client.Close()
+			}
+
+			<-conn.sessions
+		}()
+
+		return nil, errors.New("timeout starting SFTP")
+	}
+}
+
+// resetSftp forgets the SFTP client if it's still client. This should be
+// called if the SFTP session is lost.
+func (pool *ConnectionPool) resetSftp(conn *connection, client *sftp.Client) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	if conn.sftp == client {
+		conn.sftp = nil
+		client.Close()
+		<-conn.sessions
+	}
+}
+
+// closeAddress closes all connections to address, even if in use.
+func (pool *ConnectionPool) closeAddress(address string) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	for s, conn := range pool.pool {
+		if conn.client == nil || knownhosts.Normalize(s.address()) != address {
+			continue
+		}
+
+		pool.close(conn)
+		logger.Yellow("ssh", "Closing connection %s:%d", s.Host, s.Port)
+	}
+}
+
+// resetBackoff allows connecting to address again right away.
+func (pool *ConnectionPool) resetBackoff(address string) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	for s := range pool.backoff {
+		if knownhosts.Normalize(s.address()) == address {
+			delete(pool.backoff, s)
+		}
+	}
+}
+
+// Stats returns statistics for the pool.
+func (pool *ConnectionPool) Stats() PoolStats {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	stats := pool.stats
+	now := time.Now()
+
+	for _, conn := range pool.pool {
+		if conn.client != nil {
+			stats.Connections++
+			stats.Sessions += len(conn.sessions)
+		}
+	}
+
+	for _, b := range pool.backoff {
+		if now.Before(b.retry) {
+			stats.Unreachable++
+		}
+	}
+
+	return stats
+}
+
+// Done releases a connection returned by Get.
+func (pool *ConnectionPool) Done(conn *connection) {
+	pool.lock.Lock()
+	defer pool.lock.Unlock()
+
+	pool.done(conn)
+}
+
+// done releases a reference. The lock must be held.
+func (pool *ConnectionPool) done(conn *connection) {
+	conn.lastUse = time.Now()
+	conn.refCount--
 }
@@ -0,0 +1,311 @@
+package ssh
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/abrander/agento/plugins"
+)
+
+// testPoolServer starts a test server accepting a new key file and returns
+// the configuration for connecting to it.
+func testPoolServer(t *testing.T) (*testServer, Ssh) {
+	useKnownHosts(t)
+
+	keyFile, key := testKeyFile(t, "")
+
+	server := newTestServer(t, &ssh.ServerConfig{PublicKeyCallback: acceptKey(key)})
+
+	return server, Ssh{Host: "127.0.0.1", Port: server.port, Username: "agento", KeyFile: keyFile}
+}
+
+// waitFor waits up to 5 seconds for condition to become true.
+func waitFor(t *testing.T, what string, condition func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timeout waiting for %s", what)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func isClosed(conn *connection) func() bool {
+	return func() bool {
+		pool.lock.Lock()
+		defer pool.lock.Unlock()
+
+		return conn.closed
+	}
+}
+
+func TestPoolSingleDial(t *testing.T) {
+	server, s := testPoolServer(t)
+
+	var wg sync.WaitGroup
+	conns := make([]*connection, 10)
+
+	for i := range conns {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			conn, err := pool.Get(s)
+			if err != nil {
+				t.Errorf("Get() failed: %s", err.Error())
+				return
+			}
+
+			conns[i] = conn
+		}(i)
+	}
+
+	wg.Wait()
+
+	if accepted := atomic.LoadInt32(&server.accepted); accepted != 1 {
+		t.Errorf("Expected 1 connection, got %d", accepted)
+	}
+
+	for _, conn := range conns {
+		if conn != conns[0] {
+			t.Errorf("Get() returned different connections")
+		}
+
+		if conn != nil {
+			pool.Done(conn)
+		}
+	}
+}
+
+func TestPoolReconnect(t *testing.T) {
+	server, s := testPoolServer(t)
+
+	transport := &SshTransport{s}
+
+	for i := 0; i < 2; i++ {
+		stdout, _, err := transport.Exec("echo", "hello")
+		if err != nil {
+			t.Fatalf("Exec() failed: %s", err.Error())
+		}
+
+		output, _ := ioutil.ReadAll(stdout)
+		if string(output) != "echo hello" {
+			t.Errorf("Exec() returned '%s'", string(output))
+		}
+
+		// Kill the connection behind the back of the pool.
+		server.drop()
+	}
+
+	if accepted := atomic.LoadInt32(&server.accepted); accepted != 2 {
+		t.Errorf("Expected 2 connections, got %d", accepted)
+	}
+}
+
+func TestPoolKeepalive(t *testing.T) {
+	// The settings are read with the lock held when connecting.
+	pool.lock.Lock()
+	interval, timeout := KeepaliveInterval, KeepaliveTimeout
+	KeepaliveInterval, KeepaliveTimeout = 20*time.Millisecond, 50*time.Millisecond
+	pool.lock.Unlock()
+
+	t.Cleanup(func() {
+		pool.lock.Lock()
+		KeepaliveInterval, KeepaliveTimeout = interval, timeout
+		pool.lock.Unlock()
+	})
+
+	server, s := testPoolServer(t)
+
+	failures := pool.Stats().KeepaliveFailures
+
+	conn, err := pool.Get(s)
+	if err != nil {
+		t.Fatalf("Get() failed: %s", err.Error())
+	}
+	pool.Done(conn)
+
+	// Let a few keepalives pass before the server stops answering.
+	time.Sleep(100 * time.Millisecond)
+
+	if isClosed(conn)() {
+		t.Fatalf("Connection closed while answering keepalives")
+	}
+
+	atomic.StoreInt32(&server.hang, 1)
+
+	waitFor(t, "keepalive to fail", isClosed(conn))
+
+	if pool.Stats().KeepaliveFailures <= failures {
+		t.Errorf("Keepalive failure not counted")
+	}
+
+	atomic.StoreInt32(&server.hang, 0)
+
+	conn, err = pool.Get(s)
+	if err != nil {
+		t.Fatalf("Get() failed after keepalive timeout: %s", err.Error())
+	}
+	pool.Done(conn)
+
+	if accepted := atomic.LoadInt32(&server.accepted); accepted != 2 {
+		t.Errorf("Expected 2 connections, got %d", accepted)
+	}
+}
+
+func TestPoolMaxSessions(t *testing.T) {
+	_, s := testPoolServer(t)
+	s.MaxSessions = 2
+
+	conn, err := pool.Get(s)
+	if err != nil {
+		t.Fatalf("Get() failed: %s", err.Error())
+	}
+	defer pool.Done(conn)
+
+	var sessions []*ssh.Session
+
+	for i := 0; i < 2; i++ {
+		session, err := pool.Session(conn)
+		if err != nil {
+			t.Fatalf("Session() failed: %s", err.Error())
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	opened := make(chan *ssh.Session)
+	go func() {
+		session, err := pool.Session(conn)
+		if err != nil {
+			t.Errorf("Session() failed: %s", err.Error())
+		}
+
+		opened <- session
+	}()
+
+	select {
+	case <-opened:
+		t.Fatalf("Opened more than MaxSessions sessions")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	pool.CloseSession(conn, sessions[0])
+
+	select {
+	case session := <-opened:
+		pool.CloseSession(conn, session)
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Session not opened after closing another")
+	}
+
+	pool.CloseSession(conn, sessions[1])
+
+	// With MaxSessions = 1, SFTP would leave no sessions for Exec.
+	_, _, err = pool.Sftp(Ssh{Host: s.Host, Port: s.Port, Username: s.Username, KeyFile: s.KeyFile, MaxSessions: 1})
+	if err != ErrNoSftp {
+		t.Errorf("Sftp() returned %v with MaxSessions = 1", err)
+	}
+}
+
+func TestPoolSftpTimeout(t *testing.T) {
+	server, s := testPoolServer(t)
+	atomic.StoreInt32(&server.hangSftp, 1)
+
+	_, other := testPoolServer(t)
+
+	defer func(timeout time.Duration) { SftpTimeout = timeout }(SftpTimeout)
+	SftpTimeout = 500 * time.Millisecond
+
+	result := make(chan error)
+	go func() {
+		_, _, err := pool.Sftp(s)
+		result <- err
+	}()
+
+	// Wait for the SFTP subsystem to be requested.
+	waitFor(t, "SFTP to start", func() bool {
+		pool.lock.Lock()
+		defer pool.lock.Unlock()
+
+		conn, found := pool.pool[s]
+
+		return found && conn.sftpStarting != nil
+	})
+
+	// Other hosts must not wait for the SFTP subsystem.
+	start := time.Now()
+
+	conn, err := pool.Get(other)
+	if err != nil {
+		t.Fatalf("Get() failed: %s", err.Error())
+	}
+	pool.Done(conn)
+
+	if time.Since(start) > SftpTimeout/2 {
+		t.Errorf("Get() blocked by SFTP for %s", time.Since(start))
+	}
+
+	select {
+	case err = <-result:
+		if err != ErrNoSftp {
+			t.Errorf("Sftp() returned %v for hanging server", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Sftp() did not time out")
+	}
+}
+
+func TestPoolBackoff(t *testing.T) {
+	useKnownHosts(t)
+
+	keyFile, _ := testKeyFile(t, "")
+
+	// Find a port with nothing listening.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() failed: %s", err.Error())
+	}
+	port := uint16(listener.Addr().(*net.TCPAddr).Port)
+	listener.Close()
+
+	s := Ssh{Host: "127.0.0.1", Port: port, Username: "agento", KeyFile: keyFile}
+
+	_, err = pool.Get(s)
+	if err == nil || errors.Is(err, ErrUnreachable) {
+		t.Fatalf("Get() returned %v for closed port", err)
+	}
+
+	dials := pool.Stats().Dials
+
+	_, err = pool.Get(s)
+	if !errors.Is(err, ErrUnreachable) {
+		t.Fatalf("Get() returned %v while backing off", err)
+	}
+
+	if pool.Stats().Dials != dials {
+		t.Errorf("Dialed while backing off")
+	}
+
+	pool.resetBackoff(knownhosts.Normalize(s.address()))
+
+	_, err = pool.Get(s)
+	if err == nil || errors.Is(err, ErrUnreachable) {
+		t.Fatalf("Get() returned %v after resetting backoff", err)
+	}
+}
+
+func TestSshPoolAgent(t *testing.T) {
+	plugins.GenericAgentTest(t, NewSshPool())
+}
@@ -0,0 +1,440 @@
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+
+	"github.com/abrander/agento/configuration"
+	"github.com/abrander/agento/logger"
+)
+
+type (
+	// HostKey is a host key trusted, revoked or waiting for approval.
+	HostKey struct {
+		Address     string `json:"address"`
+		Type        string `json:"type"`
+		Fingerprint string `json:"fingerprint"`
+		Status      string `json:"status"`
+	}
+
+	// HostKeyError is returned when connecting to a host offering a host
+	// key that is not trusted.
+	HostKeyError struct {
+		Address     string
+		Fingerprint string
+		Reason      string
+	}
+
+	// KnownHosts is a known_hosts file in OpenSSH format. Unknown and
+	// changed host keys are saved to a separate file waiting for approval.
+	KnownHosts struct {
+		sync.Mutex
+		path        string
+		pendingPath string
+	}
+
+	// knownHost is a single line of a known_hosts file.
+	knownHost struct {
+		marker string
+		hosts  []string
+		key    ssh.PublicKey
+		line   string
+	}
+)
+
+const (
+	// HostKeyTrusted is the status of keys used for connecting.
+	HostKeyTrusted = "trusted"
+
+	// HostKeyRevoked is the status of keys that will be refused.
+	HostKeyRevoked = "revoked"
+
+	// HostKeyPending is the status of keys waiting for approval.
+	HostKeyPending = "pending"
+
+	knownHostsFilename = "known_hosts"
+)
+
+var (
+	// ErrHostKeyNotFound is returned by Approve and Revoke if the key is
+	// not known.
+	ErrHostKeyNotFound = errors.New("host key not found")
+
+	// DefaultKnownHosts is the known_hosts file used by the SSH transport.
+	DefaultKnownHosts = NewKnownHosts(path.Join(configuration.StateDir, knownHostsFilename))
+)
+
+func (e *HostKeyError) Error() string {
+	return fmt.Sprintf("host key %s for %s %s", e.Fingerprint, e.Address, e.Reason)
+}
+
+// NewKnownHosts returns a KnownHosts using the file at path. Pending keys
+// are saved next to it with the suffix ".pending".
+func NewKnownHosts(path string) *KnownHosts {
+	return &KnownHosts{
+		path:        path,
+		pendingPath: path + ".pending",
+	}
+}
+
+// matches returns true if the line is for address. Hashed and wildcard
+// host patterns are never matched.
+func (h *knownHost) matches(address string) bool {
+	for _, host := range h.hosts {
+		if host == address {
+			return true
+		}
+	}
+
+	return false
+}
+
+// status returns the status of the line.
+func (h *knownHost) status(pending bool) string {
+	switch {
+	case pending:
+		return HostKeyPending
+	case h.marker == "revoked":
+		return HostKeyRevoked
+	default:
+		return HostKeyTrusted
+	}
+}
+
+func newKnownHost(marker string, address string, key ssh.PublicKey) *knownHost {
+	line := knownhosts.Line([]string{address}, key)
+	if marker != "" {
+		line = "@" + marker + " " + line
+	}
+
+	return &knownHost{
+		marker: marker,
+		hosts:  []string{address},
+		key:    key,
+		line:   line,
+	}
+}
+
+// read reads all lines from the file at path. Lines that can't be parsed
+// are kept with a nil key.
+func read(path string) ([]*knownHost, error) {
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []*knownHost
+
+	for _, line := range strings.Split(string(contents), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		marker, hosts, key, _, _, err := ssh.ParseKnownHosts([]byte(line))
+		if err != nil {
+			key = nil
+		}
+
+		lines = append(lines, &knownHost{
+			marker: marker,
+			hosts:  hosts,
+			key:    key,
+			line:   line,
+		})
+	}
+
+	return lines, nil
+}
+
+// write replaces the file at path with lines.
+func write(path string, lines []*knownHost) error {
+	var contents strings.Builder
+
+	for _, line := range lines {
+		contents.WriteString(line.line)
+		contents.WriteString("\n")
+	}
+
+	tmp := path + ".tmp"
+
+	err := ioutil.WriteFile(tmp, []byte(contents.String()), 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+func sameKey(a ssh.PublicKey, b ssh.PublicKey) bool {
+	return a != nil && b != nil && string(a.Marshal()) == string(b.Marshal())
+}
+
+// List returns all keys.
+func (k *KnownHosts) List() ([]HostKey, error) {
+	k.Lock()
+	defer k.Unlock()
+
+	keys := []HostKey{}
+
+	for _, pending := range []bool{false, true} {
+		p := k.path
+		if pending {
+			p = k.pendingPath
+		}
+
+		lines, err := read(p)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, line := range lines {
+			if line.key == nil {
+				continue
+			}
+
+			for _, host := range line.hosts {
+				keys = append(keys, HostKey{
+					Address:     host,
+					Type:        line.key.Type(),
+					Fingerprint: ssh.FingerprintSHA256(line.key),
+					Status:      line.status(pending),
+				})
+			}
+		}
+	}
+
+	return keys, nil
+}
+
+// find returns the key for address with fingerprint from lines.
+func find(lines []*knownHost, address string, fingerprint string) ssh.PublicKey {
+	for _, line := range lines {
+		if line.key != nil && line.matches(address) && ssh.FingerprintSHA256(line.key) == fingerprint {
+			return line.key
+		}
+	}
+
+	return nil
+}
+
+// without returns lines without the lines for address matching remove.
+func without(lines []*knownHost, address string, remove func(*knownHost) bool) []*knownHost {
+	kept := make([]*knownHost, 0, len(lines))
+
+	for _, line := range lines {
+		if line.key != nil && line.matches(address) && remove(line) {
+			continue
+		}
+
+		kept = append(kept, line)
+	}
+
+	return kept
+}
+
+// change looks up the key for address with fingerprint and replaces the
+// lines for address using update.
+func (k *KnownHosts) change(address string, fingerprint string, update func(lines []*knownHost, key ssh.PublicKey) []*knownHost) error {
+	k.Lock()
+	defer k.Unlock()
+
+	lines, err := read(k.path)
+	if err != nil {
+		return err
+	}
+
+	pending, err := read(k.pendingPath)
+	if err != nil {
+		return err
+	}
+
+	key := find(lines, address, fingerprint)
+	if key == nil {
+		key = find(pending, address, fingerprint)
+	}
+
+	if key == nil {
+		return ErrHostKeyNotFound
+	}
+
+	err = write(k.path, update(lines, key))
+	if err != nil {
+		return err
+	}
+
+	pending = without(pending, address, func(line *knownHost) bool {
+		return sameKey(line.key, key)
+	})
+
+	return write(k.pendingPath, pending)
+}
+
+// Approve trusts the key for address with fingerprint. All other trusted
+// keys for address are removed.
+func (k *KnownHosts) Approve(address string, fingerprint string) error {
+	err := k.change(address, fingerprint, func(lines []*knownHost, key ssh.PublicKey) []*knownHost {
+		lines = without(lines, address, func(line *knownHost) bool {
+			return line.marker == "" || sameKey(line.key, key)
+		})
+
+		return append(lines, newKnownHost("", address, key))
+	})
+
+	if err == nil && k == DefaultKnownHosts {
+		pool.resetBackoff(address)
+	}
+
+	return err
+}
+
+// Revoke marks the key for address with fingerprint as revoked. Revoked
+// keys are refused until approved again. Pooled connections to the host
+// are closed.
+func (k *KnownHosts) Revoke(address string, fingerprint string) error {
+	err := k.change(address, fingerprint, func(lines []*knownHost, key ssh.PublicKey) []*knownHost {
+		lines = without(lines, address, func(line *knownHost) bool {
+			return sameKey(line.key, key)
+		})
+
+		return append(lines, newKnownHost("revoked", address, key))
+	})
+
+	if err == nil && k == DefaultKnownHosts {
+		pool.closeAddress(address)
+	}
+
+	return err
+}
+
+// addPending saves key for approval.
+func (k *KnownHosts) addPending(address string, key ssh.PublicKey) error {
+	pending, err := read(k.pendingPath)
+	if err != nil {
+		return err
+	}
+
+	for _, line := range pending {
+		if line.matches(address) && sameKey(line.key, key) {
+			return nil
+		}
+	}
+
+	return write(k.pendingPath, append(pending, newKnownHost("", address, key)))
+}
+
+// check verifies key for address. See Callback.
+func (k *KnownHosts) check(address string, key ssh.PublicKey, strict bool) error {
+	k.Lock()
+	defer k.Unlock()
+
+	fingerprint := ssh.FingerprintSHA256(key)
+
+	lines, err := read(k.path)
+	if err != nil {
+		return err
+	}
+
+	known := false
+
+	for _, line := range lines {
+		if line.key == nil || !line.matches(address) {
+			continue
+		}
+
+		// Hosts with revoked keys are known, new keys must be approved.
+		known = true
+
+		if line.marker == "revoked" {
+			if sameKey(line.key, key) {
+				return &HostKeyError{Address: address, Fingerprint: fingerprint, Reason: "is revoked"}
+			}
+
+			continue
+		}
+
+		if sameKey(line.key, key) {
+			return nil
+		}
+	}
+
+	if known || strict {
+		err = k.addPending(address, key)
+		if err != nil {
+			return err
+		}
+
+		if known {
+			return &HostKeyError{Address: address, Fingerprint: fingerprint, Reason: "has changed, this could be an attack. Approve the key if the change is expected"}
+		}
+
+		return &HostKeyError{Address: address, Fingerprint: fingerprint, Reason: "is unknown. Approve the key to connect"}
+	}
+
+	logger.Yellow("ssh", "Trusting new host key %s for %s", fingerprint, address)
+
+	return write(k.path, append(lines, newKnownHost("", address, key)))
+}
+
+// Callback returns a ssh.HostKeyCallback. If fingerprint is not empty,
+// only the key with that SHA256 fingerprint is accepted. Otherwise keys are
+// trusted on first use, unless strict is true or the host has revoked keys.
+// Keys not trusted are saved for approval and refused.
+func (k *KnownHosts) Callback(fingerprint string, strict bool) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		address := knownhosts.Normalize(hostname)
+
+		if fingerprint != "" {
+			if ssh.FingerprintSHA256(key) == "SHA256:"+strings.TrimPrefix(fingerprint, "SHA256:") {
+				return nil
+			}
+
+			return &HostKeyError{Address: address, Fingerprint: ssh.FingerprintSHA256(key), Reason: "does not match the pinned fingerprint " + fingerprint}
+		}
+
+		return k.check(address, key, strict)
+	}
+}
+
+// Algorithms returns the host key algorithms to prefer for address. This
+// makes sure a trusted key is offered by hosts with more than one key.
+// nil is returned for unknown hosts.
+func (k *KnownHosts) Algorithms(hostname string) []string {
+	k.Lock()
+	defer k.Unlock()
+
+	address := knownhosts.Normalize(hostname)
+
+	lines, err := read(k.path)
+	if err != nil {
+		return nil
+	}
+
+	var algorithms []string
+
+	for _, line := range lines {
+		if line.key == nil || line.marker != "" || !line.matches(address) {
+			continue
+		}
+
+		if line.key.Type() == ssh.KeyAlgoRSA {
+			algorithms = append(algorithms, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256)
+		}
+
+		algorithms = append(algorithms, line.key.Type())
+	}
+
+	return algorithms
+}
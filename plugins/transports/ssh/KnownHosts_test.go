@@ -0,0 +1,196 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func testKey(t *testing.T) ssh.PublicKey {
+	public, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() failed: %s", err.Error())
+	}
+
+	key, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatalf("NewPublicKey() failed: %s", err.Error())
+	}
+
+	return key
+}
+
+func testKnownHosts(t *testing.T) *KnownHosts {
+	dir, err := ioutil.TempDir("", "agento-knownhosts")
+	if err != nil {
+		t.Fatalf("TempDir() failed: %s", err.Error())
+	}
+
+	t.Cleanup(func() {
+		os.RemoveAll(dir)
+	})
+
+	return NewKnownHosts(filepath.Join(dir, "known_hosts"))
+}
+
+func status(t *testing.T, k *KnownHosts, key ssh.PublicKey) []string {
+	keys, err := k.List()
+	if err != nil {
+		t.Fatalf("List() failed: %s", err.Error())
+	}
+
+	var statuses []string
+	for _, hostKey := range keys {
+		if hostKey.Fingerprint == ssh.FingerprintSHA256(key) {
+			statuses = append(statuses, hostKey.Status)
+		}
+	}
+
+	return statuses
+}
+
+func TestKnownHostsTrustOnFirstUse(t *testing.T) {
+	k := testKnownHosts(t)
+	callback := k.Callback("", false)
+
+	key := testKey(t)
+	other := testKey(t)
+
+	err := callback("db1:22", nil, key)
+	if err != nil {
+		t.Fatalf("Callback() refused first key: %s", err.Error())
+	}
+
+	err = callback("db1:22", nil, key)
+	if err != nil {
+		t.Fatalf("Callback() refused trusted key: %s", err.Error())
+	}
+
+	// A different port is a different host.
+	err = callback("db1:2222", nil, other)
+	if err != nil {
+		t.Fatalf("Callback() refused first key for other port: %s", err.Error())
+	}
+
+	err = callback("db1:22", nil, other)
+	if _, ok := err.(*HostKeyError); !ok {
+		t.Fatalf("Callback() accepted changed key: %v", err)
+	}
+
+	if s := status(t, k, other); len(s) != 2 || s[0] != HostKeyTrusted || s[1] != HostKeyPending {
+		t.Errorf("Changed key has wrong status: %v", s)
+	}
+
+	err = k.Approve("db1", ssh.FingerprintSHA256(other))
+	if err != nil {
+		t.Fatalf("Approve() failed: %s", err.Error())
+	}
+
+	err = callback("db1:22", nil, other)
+	if err != nil {
+		t.Errorf("Callback() refused approved key: %s", err.Error())
+	}
+
+	if s := status(t, k, key); len(s) != 0 {
+		t.Errorf("Approve() did not remove the old key: %v", s)
+	}
+}
+
+func TestKnownHostsStrict(t *testing.T) {
+	k := testKnownHosts(t)
+	callback := k.Callback("", true)
+	key := testKey(t)
+
+	err := callback("db1:22", nil, key)
+	if _, ok := err.(*HostKeyError); !ok {
+		t.Fatalf("Callback() accepted unknown key in strict mode: %v", err)
+	}
+
+	if s := status(t, k, key); len(s) != 1 || s[0] != HostKeyPending {
+		t.Errorf("Unknown key has wrong status: %v", s)
+	}
+
+	err = k.Approve("db1", ssh.FingerprintSHA256(key))
+	if err != nil {
+		t.Fatalf("Approve() failed: %s", err.Error())
+	}
+
+	err = callback("db1:22", nil, key)
+	if err != nil {
+		t.Errorf("Callback() refused approved key: %s", err.Error())
+	}
+}
+
+func TestKnownHostsRevoke(t *testing.T) {
+	k := testKnownHosts(t)
+	callback := k.Callback("", false)
+	key := testKey(t)
+
+	callback("db1:22", nil, key)
+
+	err := k.Revoke("db1", ssh.FingerprintSHA256(key))
+	if err != nil {
+		t.Fatalf("Revoke() failed: %s", err.Error())
+	}
+
+	err = callback("db1:22", nil, key)
+	if _, ok := err.(*HostKeyError); !ok {
+		t.Fatalf("Callback() accepted revoked key: %v", err)
+	}
+
+	if s := status(t, k, key); len(s) != 1 || s[0] != HostKeyRevoked {
+		t.Errorf("Revoked key has wrong status: %v", s)
+	}
+
+	// A new key is not trusted on first use after a revocation.
+	other := testKey(t)
+
+	err = callback("db1:22", nil, other)
+	if _, ok := err.(*HostKeyError); !ok {
+		t.Errorf("Callback() accepted new key after revocation: %v", err)
+	}
+
+	if s := status(t, k, other); len(s) != 1 || s[0] != HostKeyPending {
+		t.Errorf("New key has wrong status: %v", s)
+	}
+
+	err = k.Revoke("db1", "SHA256:nonexisting")
+	if err != ErrHostKeyNotFound {
+		t.Errorf("Revoke() did not return ErrHostKeyNotFound: %v", err)
+	}
+}
+
+func TestKnownHostsPinned(t *testing.T) {
+	k := testKnownHosts(t)
+	key := testKey(t)
+
+	err := k.Callback(ssh.FingerprintSHA256(key), true)("db1:22", nil, key)
+	if err != nil {
+		t.Errorf("Callback() refused pinned key: %s", err.Error())
+	}
+
+	err = k.Callback(ssh.FingerprintSHA256(testKey(t)), false)("db1:22", nil, key)
+	if _, ok := err.(*HostKeyError); !ok {
+		t.Errorf("Callback() accepted key not matching pinned fingerprint: %v", err)
+	}
+}
+
+func TestKnownHostsAlgorithms(t *testing.T) {
+	k := testKnownHosts(t)
+
+	if k.Algorithms("db1:22") != nil {
+		t.Errorf("Algorithms() returned algorithms for unknown host")
+	}
+
+	k.Callback("", false)("db1:22", nil, testKey(t))
+
+	algorithms := k.Algorithms("db1:22")
+	if len(algorithms) != 1 || algorithms[0] != ssh.KeyAlgoED25519 {
+		t.Errorf("Algorithms() returned %v", algorithms)
+	}
+}
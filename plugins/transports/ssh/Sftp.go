@@ -0,0 +1,124 @@
+package ssh
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+
+	"github.com/pkg/sftp"
+)
+
+type (
+	// sftpFile is a file opened using SFTP. The connection is returned to
+	// the pool when the file is closed.
+	sftpFile struct {
+		sync.Once
+		file *sftp.File
+		conn *connection
+	}
+)
+
+var (
+	// ErrNoSftp is returned by ConnectionPool.Sftp if the server doesn't
+	// support SFTP.
+	ErrNoSftp = errors.New("SFTP not supported by server")
+)
+
+const (
+	// maxChunk is the largest read sent to the SFTP server. Larger reads
+	// are split into concurrent requests at increasing offsets, which
+	// doesn't work for files in /proc.
+	maxChunk = 32 * 1024
+)
+
+// pathError maps SFTP errors to errors like the ones returned by the os
+// package.
+func pathError(op string, path string, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var status *sftp.StatusError
+
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		err = syscall.ENOENT
+	case errors.Is(err, os.ErrPermission):
+		err = syscall.EACCES
+	case errors.As(err, &status) && status.Code == uint32(sftp.ErrSSHFxOpUnsupported):
+		err = syscall.ENOSYS
+	}
+
+	return &os.PathError{Op: op, Path: path, Err: err}
+}
+
+func (f *sftpFile) Read(b []byte) (int, error) {
+	if len(b) > maxChunk {
+		b = b[:maxChunk]
+	}
+
+	return f.file.Read(b)
+}
+
+func (f *sftpFile) Close() error {
+	err := f.file.Close()
+
+	f.Do(func() {
+		pool.Done(f.conn)
+	})
+
+	return err
+}
+
+// openSftp opens path for reading.
+func openSftp(client *sftp.Client, path string) (*sftp.File, error) {
+	file, err := client.Open(path)
+	if err != nil {
+		return nil, pathError("open", path, err)
+	}
+
+	return file, nil
+}
+
+// statfsSftp fills buf using the statvfs@openssh.com extension.
+func statfsSftp(client *sftp.Client, path string, buf *syscall.Statfs_t) error {
+	stat, err := client.StatVFS(path)
+	if err != nil {
+		return pathError("statfs", path, err)
+	}
+
+	*buf = syscall.Statfs_t{
+		Bsize:   int64(stat.Bsize),
+		Frsize:  int64(stat.Frsize),
+		Blocks:  stat.Blocks,
+		Bfree:   stat.Bfree,
+		Bavail:  stat.Bavail,
+		Files:   stat.Files,
+		Ffree:   stat.Ffree,
+		Namelen: int64(stat.Namemax),
+		Flags:   int64(stat.Flag),
+	}
+
+	return nil
+}
+
+// readAll reads r until EOF in chunks small enough to be read sequentially.
+func readAll(r io.Reader) ([]byte, error) {
+	var contents []byte
+	chunk := make([]byte, maxChunk)
+
+	for {
+		n, err := r.Read(chunk)
+		contents = append(contents, chunk[:n]...)
+
+		if err == io.EOF {
+			return contents, nil
+		}
+
+		if err != nil {
+			return contents, err
+		}
+	}
+}
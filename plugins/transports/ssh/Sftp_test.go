@@ -0,0 +1,130 @@
+package ssh
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/pkg/sftp"
+)
+
+// testSftp returns a client connected to an in-process SFTP server serving
+// the local filesystem.
+func testSftp(t *testing.T) *sftp.Client {
+	clientReader, serverWriter := io.Pipe()
+	serverReader, clientWriter := io.Pipe()
+
+	server, err := sftp.NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{serverReader, serverWriter})
+	if err != nil {
+		t.Fatalf("NewServer() failed: %s", err.Error())
+	}
+	go server.Serve()
+
+	client, err := sftp.NewClientPipe(clientReader, clientWriter)
+	if err != nil {
+		t.Fatalf("NewClientPipe() failed: %s", err.Error())
+	}
+
+	// Closing the server ends the pipe read by the client.
+	t.Cleanup(func() {
+		server.Close()
+		client.Close()
+	})
+
+	return client
+}
+
+func TestSftpRead(t *testing.T) {
+	client := testSftp(t)
+
+	dir, err := ioutil.TempDir("", "agento-sftp")
+	if err != nil {
+		t.Fatalf("TempDir() failed: %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	// Larger than a single chunk.
+	contents := strings.Repeat("agento\n", maxChunk)
+	path := filepath.Join(dir, "file")
+
+	err = ioutil.WriteFile(path, []byte(contents), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile() failed: %s", err.Error())
+	}
+
+	file, err := openSftp(client, path)
+	if err != nil {
+		t.Fatalf("openSftp() failed: %s", err.Error())
+	}
+	defer file.Close()
+
+	read, err := readAll(file)
+	if err != nil {
+		t.Fatalf("readAll() failed: %s", err.Error())
+	}
+
+	if string(read) != contents {
+		t.Errorf("readAll() read %d bytes, expected %d", len(read), len(contents))
+	}
+
+	_, err = openSftp(client, filepath.Join(dir, "nonexisting"))
+	if p, ok := err.(*os.PathError); !ok || p.Err != syscall.ENOENT {
+		t.Errorf("openSftp() did not return ENOENT, got %T %v", err, err)
+	}
+}
+
+func TestSftpProc(t *testing.T) {
+	_, err := os.Stat("/proc/self/stat")
+	if err != nil {
+		t.Skip("no /proc")
+	}
+
+	client := testSftp(t)
+
+	file, err := openSftp(client, "/proc/self/stat")
+	if err != nil {
+		t.Fatalf("openSftp() failed: %s", err.Error())
+	}
+	defer file.Close()
+
+	read, err := readAll(file)
+	if err != nil || len(read) == 0 {
+		t.Errorf("readAll() failed to read /proc: %v", err)
+	}
+}
+
+func TestSftpStatfs(t *testing.T) {
+	client := testSftp(t)
+
+	var buf syscall.Statfs_t
+
+	err := statfsSftp(client, os.TempDir(), &buf)
+	if err != nil {
+		t.Fatalf("statfsSftp() failed: %s", err.Error())
+	}
+
+	var local syscall.Statfs_t
+	syscall.Statfs(os.TempDir(), &local)
+
+	if buf.Blocks != local.Blocks || buf.Bsize != local.Bsize {
+		t.Errorf("statfsSftp() returned %+v, expected %+v", buf, local)
+	}
+}
+
+func TestPathError(t *testing.T) {
+	if pathError("open", "/", nil) != nil {
+		t.Errorf("pathError() did not return nil for nil")
+	}
+
+	err := pathError("open", "/secret", os.ErrPermission)
+	if p, ok := err.(*os.PathError); !ok || p.Err != syscall.EACCES || p.Path != "/secret" {
+		t.Errorf("pathError() returned wrong error: %#v", err)
+	}
+}
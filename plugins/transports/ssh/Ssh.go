@@ -6,12 +6,19 @@ import (
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"os"
 	"path"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 
 	"github.com/abrander/agento/configuration"
 	"github.com/abrander/agento/logger"
@@ -22,6 +29,17 @@ type (
 		Host     string `json:"host" description:"Hostname or IP adress to connect to"`
 		Port     uint16 `json:"port" description:"TCP port to connect to" default:"22"`
 		Username string `json:"username" description:"Username"`
+
+		Fingerprint           string `json:"fingerprint" description:"Only accept the host key with this SHA256 fingerprint"`
+		StrictHostKeyChecking bool   `json:"strictHostKeyChecking" description:"Refuse unknown host keys until approved"`
+
+		KeyFile    string `json:"keyFile" description:"Private key to use instead of the key generated by Agento" trusted:"true"`
+		Passphrase string `json:"passphrase" description:"Passphrase for the private key" secret:"true"`
+		Agent      bool   `json:"agent" description:"Authenticate using the ssh-agent listening at SSH_AUTH_SOCK" trusted:"true"`
+		Password   string `json:"password" description:"Password for password or keyboard-interactive authentication" secret:"true"`
+		Jump       string `json:"jump" description:"Comma separated jump hosts as [user@]host[:port], like ProxyJump"`
+
+		MaxSessions int `json:"maxSessions" description:"Maximum number of concurrent sessions, like MaxSessions of sshd"`
 	}
 )
 
@@ -116,22 +134,229 @@ func GenerateKey() ([]byte, error) {
 	return pemBuffer.Bytes(), nil
 }
 
-// Connect to a remote ssh server using public key authentication
-func (s *Ssh) Connect() (*ssh.Client, error) {
-	dialString := fmt.Sprintf("%s:%d", s.Host, s.Port)
-	logger.Yellow("ssh", "Connecting to %s as %s", dialString, s.Username)
+// signers returns the signers for public key authentication. The key
+// generated by Agento is used unless a key file is configured.
+func (s *Ssh) signers() ([]ssh.Signer, error) {
+	if s.KeyFile == "" {
+		// We have to call PublicKey() to make sure signer is initialized
+		PublicKey()
+
+		if signer == nil {
+			return nil, nil
+		}
+
+		return []ssh.Signer{signer}, nil
+	}
+
+	pemBytes, err := ioutil.ReadFile(s.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var keySigner ssh.Signer
+	if s.Passphrase != "" {
+		keySigner, err = ssh.ParsePrivateKeyWithPassphrase(pemBytes, []byte(s.Passphrase))
+	} else {
+		keySigner, err = ssh.ParsePrivateKey(pemBytes)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", s.KeyFile, err.Error())
+	}
+
+	return []ssh.Signer{keySigner}, nil
+}
+
+// clientConfig returns the configuration for connecting to address. The
+// returned function must be called when the connection is established.
+func (s *Ssh) clientConfig(address string) (*ssh.ClientConfig, func(), error) {
+	done := func() {}
+
+	signers, err := s.signers()
+	if err != nil {
+		return nil, done, err
+	}
+
+	if s.Agent {
+		socket := os.Getenv("SSH_AUTH_SOCK")
+		if socket == "" {
+			return nil, done, errors.New("SSH_AUTH_SOCK not set")
+		}
+
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			return nil, done, err
+		}
+		done = func() { conn.Close() }
+
+		agentSigners, err := agent.NewClient(conn).Signers()
+		if err != nil {
+			done()
+			return nil, func() {}, err
+		}
+
+		signers = append(signers, agentSigners...)
+	}
+
+	var auth []ssh.AuthMethod
+
+	if len(signers) > 0 {
+		auth = append(auth, ssh.PublicKeys(signers...))
+	}
 
-	// We have to call PublicKey() to make sure signer is initialized
-	PublicKey()
+	if s.Password != "" {
+		password := s.Password
+
+		auth = append(auth,
+			ssh.Password(password),
+			ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+				// Answer all hidden prompts with the password.
+				answers := make([]string, len(questions))
+				for i := range questions {
+					if !echos[i] {
+						answers[i] = password
+					}
+				}
+
+				return answers, nil
+			}),
+		)
+	}
 
 	config := &ssh.ClientConfig{
-		User: s.Username,
-		Auth: []ssh.AuthMethod{ssh.PublicKeys(signer)},
+		User:            s.Username,
+		Auth:            auth,
+		HostKeyCallback: DefaultKnownHosts.Callback(s.Fingerprint, s.StrictHostKeyChecking),
+		Timeout:         ConnectTimeout,
+	}
+
+	if s.Fingerprint == "" {
+		config.HostKeyAlgorithms = DefaultKnownHosts.Algorithms(address)
+	}
+
+	return config, done, nil
+}
+
+// parseJump parses a jump host as [user@]host[:port]. Authentication
+// options except the password are inherited from s.
+func (s *Ssh) parseJump(spec string) (Ssh, error) {
+	jump := Ssh{
+		Host:                  strings.TrimSpace(spec),
+		Port:                  22,
+		Username:              s.Username,
+		KeyFile:               s.KeyFile,
+		Passphrase:            s.Passphrase,
+		Agent:                 s.Agent,
+		StrictHostKeyChecking: s.StrictHostKeyChecking,
+	}
+
+	if at := strings.LastIndex(jump.Host, "@"); at >= 0 {
+		jump.Username = jump.Host[:at]
+		jump.Host = jump.Host[at+1:]
 	}
-	client, err := ssh.Dial("tcp", dialString, config)
+
+	if host, port, err := net.SplitHostPort(jump.Host); err == nil {
+		p, err := strconv.ParseUint(port, 10, 16)
+		if err != nil {
+			return jump, errors.New("invalid port in jump host '" + spec + "'")
+		}
+
+		jump.Host = host
+		jump.Port = uint16(p)
+	}
+
+	if jump.Host == "" {
+		return jump, errors.New("invalid jump host '" + spec + "'")
+	}
+
+	return jump, nil
+}
+
+// parent returns the jump host used to reach s or nil if s is reached
+// directly. The parent of the parent is the jump host before it in the
+// chain.
+func (s *Ssh) parent() (*Ssh, error) {
+	if strings.TrimSpace(s.Jump) == "" {
+		return nil, nil
+	}
+
+	hops := strings.Split(s.Jump, ",")
+
+	parent, err := s.parseJump(hops[len(hops)-1])
 	if err != nil {
 		return nil, err
 	}
 
-	return client, nil
+	parent.Jump = strings.Join(hops[:len(hops)-1], ",")
+
+	return &parent, nil
+}
+
+// maxSessions returns the maximum number of concurrent sessions.
+func (s *Ssh) maxSessions() int {
+	if s.MaxSessions > 0 {
+		return s.MaxSessions
+	}
+
+	return DefaultMaxSessions
+}
+
+// address returns the address to dial.
+func (s *Ssh) address() string {
+	return net.JoinHostPort(s.Host, strconv.Itoa(int(s.Port)))
+}
+
+// connect connects to the remote ssh server. If through is not nil, the
+// connection is tunneled through it.
+func (s *Ssh) connect(through *ssh.Client) (*ssh.Client, error) {
+	address := s.address()
+	logger.Yellow("ssh", "Connecting to %s as %s", address, s.Username)
+
+	config, done, err := s.clientConfig(address)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
+	var conn net.Conn
+	if through == nil {
+		conn, err = net.DialTimeout("tcp", address, config.Timeout)
+	} else {
+		conn, err = through.Dial("tcp", address)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return handshake(conn, address, config)
+}
+
+// handshake performs the SSH handshake on conn. The connection is closed if
+// the handshake takes longer than config.Timeout.
+func handshake(conn net.Conn, address string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	var timer *time.Timer
+
+	// Connections tunneled through a jump host don't support deadlines,
+	// they are closed by a timer instead.
+	if conn.SetDeadline(time.Now().Add(config.Timeout)) != nil {
+		timer = time.AfterFunc(config.Timeout, func() {
+			conn.Close()
+		})
+	}
+
+	c, channels, requests, err := ssh.NewClientConn(conn, address, config)
+	if timer != nil && !timer.Stop() && err == nil {
+		c.Close()
+		err = errors.New("ssh: handshake timed out")
+	}
+
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	conn.SetDeadline(time.Time{})
+
+	return ssh.NewClient(c, channels, requests), nil
 }
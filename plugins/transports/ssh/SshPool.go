@@ -0,0 +1,61 @@
+package ssh
+
+import (
+	"github.com/abrander/agento/plugins"
+	"github.com/abrander/agento/timeseries"
+)
+
+type (
+	// SshPool is an agent reporting statistics for the SSH connection pool
+	// of the Agento process running the agent.
+	SshPool struct {
+		PoolStats
+	}
+)
+
+func init() {
+	plugins.Register("sshpool", NewSshPool)
+}
+
+func NewSshPool() interface{} {
+	return new(SshPool)
+}
+
+// Gather implements plugins.Agent. The transport is not used, as the pool
+// lives in this process.
+func (p *SshPool) Gather(transport plugins.Transport) error {
+	p.PoolStats = pool.Stats()
+
+	return nil
+}
+
+func (p *SshPool) GetPoints() []*timeseries.Point {
+	points := make([]*timeseries.Point, 7)
+
+	points[0] = plugins.SimplePoint("sshpool.Connections", p.Connections)
+	points[1] = plugins.SimplePoint("sshpool.Sessions", p.Sessions)
+	points[2] = plugins.SimplePoint("sshpool.Unreachable", p.Unreachable)
+	points[3] = plugins.SimplePoint("sshpool.Dials", p.Dials)
+	points[4] = plugins.SimplePoint("sshpool.DialErrors", p.DialErrors)
+	points[5] = plugins.SimplePoint("sshpool.Lost", p.Lost)
+	points[6] = plugins.SimplePoint("sshpool.KeepaliveFailures", p.KeepaliveFailures)
+
+	return points
+}
+
+func (p *SshPool) GetDoc() *plugins.Doc {
+	doc := plugins.NewDoc("SSH connection pool")
+
+	doc.AddMeasurement("sshpool.Connections", "Open connections", "n")
+	doc.AddMeasurement("sshpool.Sessions", "Sessions in use", "n")
+	doc.AddMeasurement("sshpool.Unreachable", "Hosts waiting to be retried after failing", "n")
+	doc.AddMeasurement("sshpool.Dials", "Connection attempts", "n")
+	doc.AddMeasurement("sshpool.DialErrors", "Failed connection attempts", "n")
+	doc.AddMeasurement("sshpool.Lost", "Connections closed after failing", "n")
+	doc.AddMeasurement("sshpool.KeepaliveFailures", "Keepalive requests not answered in time", "n")
+
+	return doc
+}
+
+// Ensure compliance
+var _ plugins.Agent = (*SshPool)(nil)
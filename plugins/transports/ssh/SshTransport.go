@@ -6,7 +6,13 @@ import (
 	"io"
 	"io/ioutil"
 	"net"
+	"strings"
+	"sync"
 	"syscall"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
 
 	"github.com/abrander/agento/logger"
 	"github.com/abrander/agento/plugins"
@@ -16,6 +22,13 @@ type (
 	SshTransport struct {
 		Ssh
 	}
+
+	// sshProcess is a command started by Start.
+	sshProcess struct {
+		once    sync.Once
+		conn    *connection
+		session *ssh.Session
+	}
 )
 
 func init() {
@@ -38,17 +51,12 @@ func (s *SshTransport) Exec(cmd string, arguments ...string) (io.Reader, io.Read
 	}
 
 	logger.Yellow("ssh", "Executing command '%s' on %s:%d as %s", cmd, s.Ssh.Host, s.Ssh.Port, s.Username)
-	conn, err := pool.Get(s.Ssh)
-	if err != nil {
-		return nil, nil, err
-	}
-	defer pool.Done(s.Ssh)
-
-	session, err := conn.NewSession()
+	conn, session, err := s.session()
 	if err != nil {
 		return nil, nil, err
 	}
-	defer session.Close()
+	defer pool.Done(conn)
+	defer pool.CloseSession(conn, session)
 
 	var stdoutBuf, stderrBuf bytes.Buffer
 	session.Stdout = &stdoutBuf
@@ -62,42 +70,220 @@ func (s *SshTransport) Exec(cmd string, arguments ...string) (io.Reader, io.Read
 	return &stdoutBuf, &stderrBuf, nil
 }
 
-func (s *SshTransport) Dial(network string, address string) (net.Conn, error) {
-	conn, err := pool.Get(s.Ssh)
+// Start implements plugins.Executor. The command is run by the shell of the
+// remote user with all arguments quoted.
+func (s *SshTransport) Start(cmd *plugins.Command) (plugins.Process, error) {
+	line := commandLine(cmd)
+
+	logger.Yellow("ssh", "Starting command '%s' on %s:%d as %s", line, s.Ssh.Host, s.Ssh.Port, s.Username)
+	conn, session, err := s.session()
 	if err != nil {
 		return nil, err
 	}
 
-	logger.Yellow("ssh", "Dialing %s://%s via ssh://%s@%s:%d", network, address, s.Ssh.Username, s.Ssh.Host, s.Ssh.Port)
+	session.Stdin = cmd.Stdin
+	session.Stdout = cmd.Stdout
+	session.Stderr = cmd.Stderr
 
-	c, err := conn.Dial(network, address)
+	err = session.Start(line)
 	if err != nil {
+		pool.CloseSession(conn, session)
+		pool.Done(conn)
+
 		return nil, err
 	}
-	c = NewConnWrapper(c, s.Ssh)
 
-	return c, err
+	return &sshProcess{conn: conn, session: session}, nil
+}
+
+// quote quotes s for the shell.
+func quote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// commandLine returns cmd as a command line for the shell. The environment
+// is set using env, as most servers refuse to set variables requested by
+// the client.
+func commandLine(cmd *plugins.Command) string {
+	var line []string
+
+	if cmd.Dir != "" {
+		line = append(line, "cd", quote(cmd.Dir), "&&")
+	}
+
+	// Replace the shell to let signals reach the command.
+	line = append(line, "exec")
+
+	if len(cmd.Env) > 0 {
+		line = append(line, "env")
+
+		for _, env := range cmd.Env {
+			line = append(line, quote(env))
+		}
+	}
+
+	line = append(line, quote(cmd.Path))
+
+	for _, arg := range cmd.Args {
+		line = append(line, quote(arg))
+	}
+
+	return strings.Join(line, " ")
+}
+
+func (p *sshProcess) Signal(sig syscall.Signal) error {
+	return p.session.Signal(ssh.Signal(plugins.SignalName(sig)))
+}
+
+// Kill sends SIGKILL and closes the session, in case the server doesn't
+// support signals.
+func (p *sshProcess) Kill() error {
+	p.session.Signal(ssh.SIGKILL)
+
+	return p.session.Close()
+}
+
+func (p *sshProcess) Wait() error {
+	err := p.session.Wait()
+
+	p.once.Do(func() {
+		pool.CloseSession(p.conn, p.session)
+		pool.Done(p.conn)
+	})
+
+	var exitError *ssh.ExitError
+	if !errors.As(err, &exitError) {
+		return err
+	}
+
+	if exitError.Signal() != "" {
+		return &plugins.ExitError{Code: -1, Signal: exitError.Signal()}
+	}
+
+	return &plugins.ExitError{Code: exitError.ExitStatus()}
 }
 
+// session opens a new session. If the pooled connection turns out to be
+// dead, a new connection is tried once.
+func (s *SshTransport) session() (*connection, *ssh.Session, error) {
+	for attempt := 0; ; attempt++ {
+		conn, err := pool.Get(s.Ssh)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		session, err := pool.Session(conn)
+		if err == nil {
+			return conn, session, nil
+		}
+
+		pool.Done(conn)
+
+		if attempt > 0 || !pool.check(conn, err) {
+			return nil, nil, err
+		}
+	}
+}
+
+func (s *SshTransport) Dial(network string, address string) (net.Conn, error) {
+	logger.Yellow("ssh", "Dialing %s://%s via ssh://%s@%s:%d", network, address, s.Ssh.Username, s.Ssh.Host, s.Ssh.Port)
+
+	// Like session(), a new connection is tried once if the pooled
+	// connection is dead.
+	for attempt := 0; ; attempt++ {
+		conn, err := pool.Get(s.Ssh)
+		if err != nil {
+			return nil, err
+		}
+
+		c, err := conn.client.Dial(network, address)
+		if err == nil {
+			return NewConnWrapper(c, conn), nil
+		}
+
+		pool.Done(conn)
+
+		if attempt > 0 || !pool.check(conn, err) {
+			return nil, err
+		}
+	}
+}
+
+// Open implements plugins.Transport. Files are read using SFTP if the server
+// supports it.
 func (s *SshTransport) Open(path string) (io.ReadCloser, error) {
-	// Maybe "cat" can be replaces by some sftp/scp magic. This should work for now thou.
-	r, _, err := s.Exec("/bin/cat", path)
+	conn, client, err := pool.Sftp(s.Ssh)
+	if err == ErrNoSftp {
+		r, _, err := s.Exec("/bin/cat", path)
+
+		return ioutil.NopCloser(r), err
+	}
 
-	return ioutil.NopCloser(r), err
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := openSftp(client, path)
+	if err != nil {
+		checkSftp(conn, client, err)
+		pool.Done(conn)
+
+		return nil, err
+	}
+
+	return &sftpFile{file: file, conn: conn}, nil
 }
 
+// ReadFile implements plugins.Transport.
 func (s *SshTransport) ReadFile(path string) ([]byte, error) {
 	r, err := s.Open(path)
 	if err != nil {
 		return nil, err
 	}
+	defer r.Close()
 
-	return ioutil.ReadAll(r)
+	return readAll(r)
 }
 
+// Statfs implements plugins.Transport using the statvfs@openssh.com SFTP
+// extension.
 func (s *SshTransport) Statfs(path string, buf *syscall.Statfs_t) error {
-	return errors.New("FIXME: sshtransport does not implement Statfs()")
+	conn, client, err := pool.Sftp(s.Ssh)
+	if err != nil {
+		return err
+	}
+	defer pool.Done(conn)
+
+	err = statfsSftp(client, path, buf)
+	checkSftp(conn, client, err)
+
+	return err
+}
+
+// checkSftp forgets the SFTP client if err indicates that the session is
+// lost. A new session will be started on next use.
+func checkSftp(conn *connection, client *sftp.Client, err error) {
+	if errors.Is(err, sftp.ErrSSHFxConnectionLost) || errors.Is(err, io.EOF) {
+		pool.resetSftp(conn, client)
+	}
+}
+
+// RemoteTime implements plugins.Clock by running date on the remote host.
+func (s *SshTransport) RemoteTime() (time.Time, error) {
+	r, _, err := s.Exec("date", "+%s.%N")
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	output, err := ioutil.ReadAll(r)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return plugins.ParseEpoch(string(output))
 }
 
 // Ensure compliance
 var _ plugins.Transport = (*SshTransport)(nil)
+var _ plugins.Clock = (*SshTransport)(nil)
+var _ plugins.Executor = (*SshTransport)(nil)
@@ -0,0 +1,58 @@
+package ssh
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/abrander/agento/plugins"
+)
+
+func TestCommandLine(t *testing.T) {
+	cases := map[string]*plugins.Command{
+		`exec 'ls'`:                            {Path: "ls"},
+		`exec 'echo' 'it'\''s' '$HOME'`:        {Path: "echo", Args: []string{"it's", "$HOME"}},
+		`cd '/tmp' && exec env 'A=b c' 'true'`: {Path: "true", Dir: "/tmp", Env: []string{"A=b c"}},
+	}
+
+	for expected, cmd := range cases {
+		if line := commandLine(cmd); line != expected {
+			t.Errorf("commandLine() returned %s, expected %s", line, expected)
+		}
+	}
+}
+
+func TestStart(t *testing.T) {
+	_, s := testPoolServer(t)
+
+	transport := &SshTransport{s}
+
+	var stdout bytes.Buffer
+
+	cmd := &plugins.Command{Path: "echo", Args: []string{"hello world"}, Stdout: &stdout}
+
+	err := plugins.Run(transport, cmd)
+	if err != nil {
+		t.Fatalf("Run() failed: %s", err.Error())
+	}
+
+	// The test server echoes the command line.
+	if stdout.String() != commandLine(cmd) {
+		t.Errorf("Run() wrote '%s'", stdout.String())
+	}
+
+	err = plugins.Run(transport, &plugins.Command{Path: "false"})
+	if plugins.ExitCode(err) != 1 {
+		t.Errorf("Run() returned %v, expected exit status 1", err)
+	}
+
+	// All sessions must be released.
+	conn, err := pool.Get(s)
+	if err != nil {
+		t.Fatalf("Get() failed: %s", err.Error())
+	}
+	defer pool.Done(conn)
+
+	if len(conn.sessions) != 0 {
+		t.Errorf("%d sessions not released", len(conn.sessions))
+	}
+}
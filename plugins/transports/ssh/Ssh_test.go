@@ -0,0 +1,558 @@
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+type (
+	// testServer is a minimal ssh server accepting connections on
+	// localhost.
+	testServer struct {
+		config   *ssh.ServerConfig
+		listener net.Listener
+		port     uint16
+
+		// accepted counts connections and forwarded counts direct-tcpip
+		// channels.
+		accepted  int32
+		forwarded int32
+
+		// hang makes the server stop answering global requests, and
+		// hangSftp stops it from answering subsystem requests.
+		hang     int32
+		hangSftp int32
+
+		lock  sync.Mutex
+		conns []net.Conn
+	}
+)
+
+func newTestServer(t *testing.T, config *ssh.ServerConfig) *testServer {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() failed: %s", err.Error())
+	}
+
+	hostKey, err := ssh.NewSignerFromKey(private)
+	if err != nil {
+		t.Fatalf("NewSignerFromKey() failed: %s", err.Error())
+	}
+
+	config.AddHostKey(hostKey)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() failed: %s", err.Error())
+	}
+
+	t.Cleanup(func() {
+		listener.Close()
+	})
+
+	s := &testServer{
+		config:   config,
+		listener: listener,
+		port:     uint16(listener.Addr().(*net.TCPAddr).Port),
+	}
+
+	go s.serve()
+
+	return s
+}
+
+func (s *testServer) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		atomic.AddInt32(&s.accepted, 1)
+
+		s.lock.Lock()
+		s.conns = append(s.conns, conn)
+		s.lock.Unlock()
+
+		go s.handle(conn)
+	}
+}
+
+// drop closes all connections.
+func (s *testServer) drop() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+
+	s.conns = nil
+}
+
+// requests answers global requests unless the server hangs.
+func (s *testServer) requests(requests <-chan *ssh.Request) {
+	for req := range requests {
+		if atomic.LoadInt32(&s.hang) == 0 && req.WantReply {
+			req.Reply(false, nil)
+		}
+	}
+}
+
+// session handles a session channel by echoing the command of exec
+// requests.
+func (s *testServer) session(channel ssh.Channel, requests <-chan *ssh.Request) {
+	for req := range requests {
+		if req.Type == "subsystem" && atomic.LoadInt32(&s.hangSftp) != 0 {
+			continue
+		}
+
+		if req.Type != "exec" {
+			req.Reply(false, nil)
+			continue
+		}
+
+		var payload struct {
+			Command string
+		}
+
+		ssh.Unmarshal(req.Payload, &payload)
+		req.Reply(true, nil)
+
+		// Commands ending with 'false' fail like false.
+		status := uint32(0)
+		if strings.HasSuffix(payload.Command, "'false'") {
+			status = 1
+		}
+
+		channel.Write([]byte(payload.Command))
+		channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+		channel.Close()
+	}
+}
+
+func (s *testServer) handle(conn net.Conn) {
+	_, channels, requests, err := ssh.NewServerConn(conn, s.config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	go s.requests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() == "session" {
+			channel, channelRequests, err := newChannel.Accept()
+			if err == nil {
+				go s.session(channel, channelRequests)
+			}
+
+			continue
+		}
+
+		if newChannel.ChannelType() != "direct-tcpip" {
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported")
+			continue
+		}
+
+		var payload struct {
+			Host       string
+			Port       uint32
+			OriginHost string
+			OriginPort uint32
+		}
+
+		err = ssh.Unmarshal(newChannel.ExtraData(), &payload)
+		if err != nil {
+			newChannel.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+
+		target, err := net.Dial("tcp", net.JoinHostPort(payload.Host, strconv.Itoa(int(payload.Port))))
+		if err != nil {
+			newChannel.Reject(ssh.ConnectionFailed, err.Error())
+			continue
+		}
+
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			target.Close()
+			continue
+		}
+
+		atomic.AddInt32(&s.forwarded, 1)
+
+		go ssh.DiscardRequests(channelRequests)
+
+		go func() {
+			io.Copy(channel, target)
+			channel.Close()
+		}()
+
+		go func() {
+			io.Copy(target, channel)
+			target.Close()
+		}()
+	}
+}
+
+// testKeyFile writes a new ed25519 key to a temporary file, encrypted
+// using passphrase if not empty.
+func testKeyFile(t *testing.T, passphrase string) (string, ssh.PublicKey) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() failed: %s", err.Error())
+	}
+
+	var block *pem.Block
+	if passphrase != "" {
+		block, err = ssh.MarshalPrivateKeyWithPassphrase(private, "", []byte(passphrase))
+	} else {
+		block, err = ssh.MarshalPrivateKey(private, "")
+	}
+
+	if err != nil {
+		t.Fatalf("MarshalPrivateKey() failed: %s", err.Error())
+	}
+
+	path := filepath.Join(t.TempDir(), "id_ed25519")
+
+	err = ioutil.WriteFile(path, pem.EncodeToMemory(block), 0600)
+	if err != nil {
+		t.Fatalf("WriteFile() failed: %s", err.Error())
+	}
+
+	key, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatalf("NewPublicKey() failed: %s", err.Error())
+	}
+
+	return path, key
+}
+
+// acceptKey returns a PublicKeyCallback accepting key only.
+func acceptKey(key ssh.PublicKey) func(ssh.ConnMetadata, ssh.PublicKey) (*ssh.Permissions, error) {
+	return func(_ ssh.ConnMetadata, offered ssh.PublicKey) (*ssh.Permissions, error) {
+		if sameKey(key, offered) {
+			return nil, nil
+		}
+
+		return nil, io.EOF
+	}
+}
+
+// useKnownHosts replaces DefaultKnownHosts for the duration of the test.
+func useKnownHosts(t *testing.T) {
+	previous := DefaultKnownHosts
+	DefaultKnownHosts = testKnownHosts(t)
+
+	t.Cleanup(func() {
+		DefaultKnownHosts = previous
+	})
+}
+
+func connectTest(t *testing.T, s Ssh) error {
+	conn, err := pool.Get(s)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = conn.client.SendRequest("keepalive@openssh.com", true, nil)
+	pool.Done(conn)
+
+	return err
+}
+
+func TestSshKeyFile(t *testing.T) {
+	useKnownHosts(t)
+
+	keyFile, key := testKeyFile(t, "hunter2")
+
+	server := newTestServer(t, &ssh.ServerConfig{PublicKeyCallback: acceptKey(key)})
+
+	s := Ssh{Host: "127.0.0.1", Port: server.port, Username: "agento", KeyFile: keyFile, Passphrase: "hunter2"}
+
+	err := connectTest(t, s)
+	if err != nil {
+		t.Fatalf("Failed to connect using key file: %s", err.Error())
+	}
+
+	s.Passphrase = "wrong"
+
+	err = connectTest(t, s)
+	if err == nil {
+		t.Fatalf("Connected using wrong passphrase")
+	}
+}
+
+func TestSshPassword(t *testing.T) {
+	useKnownHosts(t)
+
+	keyFile, _ := testKeyFile(t, "")
+
+	password := func(_ ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+		if string(password) == "secret" {
+			return nil, nil
+		}
+
+		return nil, io.EOF
+	}
+
+	interactive := func(_ ssh.ConnMetadata, challenge ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+		answers, err := challenge("", "", []string{"Username: ", "Password: "}, []bool{true, false})
+		if err != nil {
+			return nil, err
+		}
+
+		if len(answers) == 2 && answers[0] == "" && answers[1] == "secret" {
+			return nil, nil
+		}
+
+		return nil, io.EOF
+	}
+
+	configs := map[string]*ssh.ServerConfig{
+		"password":             {PasswordCallback: password},
+		"keyboard-interactive": {KeyboardInteractiveCallback: interactive},
+	}
+
+	for name, config := range configs {
+		server := newTestServer(t, config)
+
+		s := Ssh{Host: "127.0.0.1", Port: server.port, Username: "agento", KeyFile: keyFile, Password: "secret"}
+
+		err := connectTest(t, s)
+		if err != nil {
+			t.Errorf("Failed to connect using %s: %s", name, err.Error())
+		}
+
+		s.Password = "wrong"
+
+		err = connectTest(t, s)
+		if err == nil {
+			t.Errorf("Connected using %s with wrong password", name)
+		}
+	}
+}
+
+func TestSshAgent(t *testing.T) {
+	useKnownHosts(t)
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() failed: %s", err.Error())
+	}
+
+	keyring := agent.NewKeyring()
+
+	err = keyring.Add(agent.AddedKey{PrivateKey: private})
+	if err != nil {
+		t.Fatalf("Add() failed: %s", err.Error())
+	}
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Listen() failed: %s", err.Error())
+	}
+	defer listener.Close()
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			go agent.ServeAgent(keyring, conn)
+		}
+	}()
+
+	t.Setenv("SSH_AUTH_SOCK", socket)
+
+	signers, _ := keyring.Signers()
+	keyFile, _ := testKeyFile(t, "")
+
+	server := newTestServer(t, &ssh.ServerConfig{PublicKeyCallback: acceptKey(signers[0].PublicKey())})
+
+	s := Ssh{Host: "127.0.0.1", Port: server.port, Username: "agento", KeyFile: keyFile, Agent: true}
+
+	err = connectTest(t, s)
+	if err != nil {
+		t.Fatalf("Failed to connect using agent: %s", err.Error())
+	}
+}
+
+func TestSshParent(t *testing.T) {
+	s := Ssh{Host: "db1", Port: 22, Username: "agento", KeyFile: "/key", Password: "secret", Jump: "admin@bastion1:2222, bastion2"}
+
+	parent, err := s.parent()
+	if err != nil {
+		t.Fatalf("parent() failed: %s", err.Error())
+	}
+
+	if parent.Host != "bastion2" || parent.Port != 22 || parent.Username != "agento" || parent.KeyFile != "/key" || parent.Password != "" || parent.Jump != "admin@bastion1:2222" {
+		t.Errorf("Wrong parent: %+v", parent)
+	}
+
+	parent, err = parent.parent()
+	if err != nil {
+		t.Fatalf("parent() failed: %s", err.Error())
+	}
+
+	if parent.Host != "bastion1" || parent.Port != 2222 || parent.Username != "admin" || parent.Jump != "" {
+		t.Errorf("Wrong parent: %+v", parent)
+	}
+
+	parent, err = parent.parent()
+	if err != nil || parent != nil {
+		t.Errorf("parent() returned %v, %v for host without jump hosts", parent, err)
+	}
+
+	for _, jump := range []string{"admin@", "bastion:port", "bastion:70000"} {
+		s.Jump = jump
+
+		_, err = s.parent()
+		if err == nil {
+			t.Errorf("parent() accepted invalid jump host '%s'", jump)
+		}
+	}
+}
+
+func TestSshJump(t *testing.T) {
+	useKnownHosts(t)
+
+	keyFile, key := testKeyFile(t, "")
+
+	bastion := newTestServer(t, &ssh.ServerConfig{PublicKeyCallback: acceptKey(key)})
+	target := newTestServer(t, &ssh.ServerConfig{PublicKeyCallback: acceptKey(key)})
+
+	jump := "127.0.0.1:" + strconv.Itoa(int(bastion.port))
+
+	s1 := Ssh{Host: "127.0.0.1", Port: target.port, Username: "agento", KeyFile: keyFile, Jump: jump}
+	s2 := s1
+	s2.Password = "secret"
+
+	var conns []*connection
+
+	for _, s := range []Ssh{s1, s2} {
+		conn, err := pool.Get(s)
+		if err != nil {
+			t.Fatalf("Failed to connect through jump host: %s", err.Error())
+		}
+
+		conns = append(conns, conn)
+	}
+
+	if forwarded := atomic.LoadInt32(&bastion.forwarded); forwarded != 2 {
+		t.Errorf("Expected 2 forwarded connections, got %d", forwarded)
+	}
+
+	conn := conns[0].parent
+	if conns[1].parent != conn {
+		t.Fatalf("Jump host not shared")
+	}
+
+	pool.lock.Lock()
+	refCount := conn.refCount
+	pool.lock.Unlock()
+
+	if refCount != 2 {
+		t.Errorf("Expected the jump host to be shared by 2 connections, got %d", refCount)
+	}
+
+	for _, c := range conns {
+		pool.Done(c)
+
+		pool.lock.Lock()
+		pool.close(c)
+		pool.lock.Unlock()
+	}
+
+	pool.lock.Lock()
+	refCount = conn.refCount
+	pool.lock.Unlock()
+
+	if refCount != 0 {
+		t.Errorf("Jump host not released, refCount is %d", refCount)
+	}
+}
+
+// silentListener accepts connections on localhost without ever answering.
+func silentListener(t *testing.T) uint16 {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() failed: %s", err.Error())
+	}
+
+	t.Cleanup(func() {
+		listener.Close()
+	})
+
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}()
+
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+
+			conns = append(conns, conn)
+		}
+	}()
+
+	return uint16(listener.Addr().(*net.TCPAddr).Port)
+}
+
+func TestSshConnectTimeout(t *testing.T) {
+	useKnownHosts(t)
+
+	defer func(timeout time.Duration) { ConnectTimeout = timeout }(ConnectTimeout)
+	ConnectTimeout = 200 * time.Millisecond
+
+	keyFile, key := testKeyFile(t, "")
+	bastion := newTestServer(t, &ssh.ServerConfig{PublicKeyCallback: acceptKey(key)})
+
+	through, err := (&Ssh{Host: "127.0.0.1", Port: bastion.port, Username: "agento", KeyFile: keyFile}).connect(nil)
+	if err != nil {
+		t.Fatalf("Failed to connect to jump host: %s", err.Error())
+	}
+	defer through.Close()
+
+	s := &Ssh{Host: "127.0.0.1", Port: silentListener(t), Username: "agento", KeyFile: keyFile}
+
+	for _, via := range []*ssh.Client{nil, through} {
+		start := time.Now()
+
+		client, err := s.connect(via)
+		if err == nil {
+			client.Close()
+			t.Fatalf("connect() succeeded to silent server")
+		}
+
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("connect() took %s to time out", elapsed)
+		}
+	}
+}
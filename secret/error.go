@@ -0,0 +1,14 @@
+package secret
+
+type (
+	// Error describes a failure to resolve a secret reference. The
+	// reference itself is safe to log, the resolved value never is.
+	Error struct {
+		Reference string
+		Err       error
+	}
+)
+
+func (e *Error) Error() string {
+	return "cannot resolve secret '" + e.Reference + "': " + e.Err.Error()
+}
@@ -0,0 +1,214 @@
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	// Redacted is the placeholder used in place of secret values in API
+	// responses and log output.
+	Redacted = "********"
+
+	envPrefix       = "env:"
+	filePrefix      = "file:"
+	encryptedPrefix = "encrypted:"
+
+	keySize = 32
+)
+
+var (
+	// KeyPath is the location of the master key used for encrypting and
+	// decrypting secrets at rest. The key will be generated on first use.
+	KeyPath = "/var/lib/agento/master.key"
+
+	keyLock sync.Mutex
+	key     []byte
+
+	// ErrMalformed will be returned if an encrypted value cannot be decoded.
+	ErrMalformed = errors.New("malformed encrypted secret")
+
+	// ErrEnvNotSet is returned when a secret references an unset
+	// environment variable.
+	ErrEnvNotSet = errors.New("environment variable not set")
+
+	// ErrLocal is returned by ResolveUntrusted() for references to the
+	// environment or files of the server.
+	ErrLocal = errors.New("env: and file: references are not allowed")
+)
+
+// IsReference returns true if value refers to a secret stored elsewhere.
+func IsReference(value string) bool {
+	return strings.HasPrefix(value, envPrefix) ||
+		strings.HasPrefix(value, filePrefix) ||
+		strings.HasPrefix(value, encryptedPrefix)
+}
+
+// IsLocal returns true if value refers to the environment or a file of the
+// server. Such references must only be resolved for configuration written by
+// the operator.
+func IsLocal(value string) bool {
+	return strings.HasPrefix(value, envPrefix) ||
+		strings.HasPrefix(value, filePrefix)
+}
+
+// ResolveUntrusted behaves like Resolve(), but refuses local references.
+// This must be used for configuration supplied by API clients.
+func ResolveUntrusted(value string) (string, error) {
+	if IsLocal(value) {
+		return "", &Error{Reference: value, Err: ErrLocal}
+	}
+
+	return Resolve(value)
+}
+
+// Resolve will resolve a secret reference to its plaintext value. Values not
+// recognized as references are returned as is. The following references are
+// supported:
+//
+//	env:NAME            The value of the environment variable NAME
+//	file:/path/to/file  The contents of a file, surrounding whitespace trimmed
+//	encrypted:BASE64    A value encrypted by Encrypt() using the master key
+func Resolve(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, envPrefix):
+		name := value[len(envPrefix):]
+		v, found := os.LookupEnv(name)
+		if !found {
+			return "", &Error{Reference: value, Err: ErrEnvNotSet}
+		}
+
+		return v, nil
+
+	case strings.HasPrefix(value, filePrefix):
+		b, err := ioutil.ReadFile(value[len(filePrefix):])
+		if err != nil {
+			return "", &Error{Reference: value, Err: err}
+		}
+
+		return strings.TrimSpace(string(b)), nil
+
+	case strings.HasPrefix(value, encryptedPrefix):
+		plaintext, err := decrypt(value[len(encryptedPrefix):])
+		if err != nil {
+			return "", &Error{Reference: encryptedPrefix + "...", Err: err}
+		}
+
+		return plaintext, nil
+	}
+
+	return value, nil
+}
+
+// Encrypt will encrypt plaintext using the master key and return a reference
+// suitable for use in configuration.
+func Encrypt(plaintext string) (string, error) {
+	aead, err := getAEAD()
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err = io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return "", err
+	}
+
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), nil)
+
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func decrypt(encoded string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", ErrMalformed
+	}
+
+	aead, err := getAEAD()
+	if err != nil {
+		return "", err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return "", ErrMalformed
+	}
+
+	nonce := sealed[:aead.NonceSize()]
+	plaintext, err := aead.Open(nil, nonce, sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+
+	return string(plaintext), nil
+}
+
+func getAEAD() (cipher.AEAD, error) {
+	k, err := masterKey()
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// masterKey will read the master key from KeyPath. If no key exists, a new
+// one is generated.
+func masterKey() ([]byte, error) {
+	keyLock.Lock()
+	defer keyLock.Unlock()
+
+	if key != nil {
+		return key, nil
+	}
+
+	k, err := ioutil.ReadFile(KeyPath)
+	if os.IsNotExist(err) {
+		k = make([]byte, keySize)
+		_, err = io.ReadFull(rand.Reader, k)
+		if err != nil {
+			return nil, err
+		}
+
+		err = ioutil.WriteFile(KeyPath, k, 0600)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(k) != keySize {
+		return nil, errors.New("master key in " + KeyPath + " has wrong size")
+	}
+
+	key = k
+
+	return key, nil
+}
+
+// SetKey can be used to set the master key directly. Mostly useful for
+// testing.
+func SetKey(k []byte) error {
+	if len(k) != keySize {
+		return errors.New("wrong key size")
+	}
+
+	keyLock.Lock()
+	key = k
+	keyLock.Unlock()
+
+	return nil
+}
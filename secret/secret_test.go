@@ -0,0 +1,102 @@
+package secret
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	os.Setenv("AGENTO_TEST_SECRET", "from-env")
+
+	dir, err := ioutil.TempDir("", "agento-secret")
+	if err != nil {
+		t.Fatalf("TempDir(): %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "secret")
+	ioutil.WriteFile(path, []byte("from-file\n"), 0600)
+
+	cases := map[string]string{
+		"plain":                  "plain",
+		"":                       "",
+		"env:AGENTO_TEST_SECRET": "from-env",
+		"file:" + path:           "from-file",
+	}
+
+	for in, expected := range cases {
+		out, err := Resolve(in)
+		if err != nil {
+			t.Errorf("Resolve(%s) returned error: %s", in, err.Error())
+		}
+
+		if out != expected {
+			t.Errorf("Resolve(%s) returned '%s', expected '%s'", in, out, expected)
+		}
+	}
+
+	_, err = Resolve("env:AGENTO_TEST_SECRET_UNSET")
+	if err == nil {
+		t.Errorf("Resolve() did not catch unset environment variable")
+	}
+
+	_, err = Resolve("file:" + filepath.Join(dir, "nonexisting"))
+	if err == nil {
+		t.Errorf("Resolve() did not catch missing file")
+	}
+}
+
+func TestResolveUntrusted(t *testing.T) {
+	os.Setenv("AGENTO_TEST_SECRET", "from-env")
+
+	for _, ref := range []string{"env:AGENTO_TEST_SECRET", "file:/etc/hostname"} {
+		_, err := ResolveUntrusted(ref)
+		if err == nil {
+			t.Errorf("ResolveUntrusted(%s) did not fail", ref)
+		}
+	}
+
+	SetKey([]byte("0123456789abcdef0123456789abcdef"))
+
+	ref, _ := Encrypt("hunter2")
+
+	plaintext, err := ResolveUntrusted(ref)
+	if err != nil || plaintext != "hunter2" {
+		t.Errorf("ResolveUntrusted() returned '%s', %v for encrypted secret", plaintext, err)
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	SetKey([]byte("0123456789abcdef0123456789abcdef"))
+
+	ref, err := Encrypt("hunter2")
+	if err != nil {
+		t.Fatalf("Encrypt() failed: %s", err.Error())
+	}
+
+	if !IsReference(ref) {
+		t.Errorf("Encrypt() returned '%s' which is not a reference", ref)
+	}
+
+	plaintext, err := Resolve(ref)
+	if err != nil {
+		t.Fatalf("Resolve() failed: %s", err.Error())
+	}
+
+	if plaintext != "hunter2" {
+		t.Errorf("Got '%s' after round trip, expected 'hunter2'", plaintext)
+	}
+
+	_, err = Resolve("encrypted:bm90IHZhbGlk")
+	if err == nil {
+		t.Errorf("Resolve() did not catch garbage")
+	}
+
+	SetKey([]byte("fedcba9876543210fedcba9876543210"))
+	_, err = Resolve(ref)
+	if err == nil {
+		t.Errorf("Resolve() decrypted using the wrong key")
+	}
+}
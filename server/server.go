@@ -4,14 +4,17 @@ import (
 	"crypto/tls"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
+	"github.com/abrander/agento/audit"
 	"github.com/abrander/agento/configuration"
 	"github.com/abrander/agento/core"
 	"github.com/abrander/agento/logger"
 	"github.com/abrander/agento/plugins"
 	"github.com/abrander/agento/plugins/agents/hostname"
+	"github.com/abrander/agento/plugins/transports/relay"
 	"github.com/abrander/agento/timeseries"
 	"github.com/abrander/agento/userdb"
 )
@@ -23,28 +26,27 @@ type (
 		https     configuration.HTTPSConfiguration
 		udp       configuration.UDPConfiguration
 		secret    string
+		skew      time.Duration
 		db        userdb.Database
 		tsdb      timeseries.Database
 		store     core.HostStore
 	}
 )
 
-func NewServer(router gin.IRouter, cfg configuration.ServerConfiguration, db userdb.Database, store core.HostStore) (*Server, error) {
+func NewServer(router gin.IRouter, cfg configuration.ServerConfiguration, db userdb.Database, store core.HostStore, tsdb timeseries.Database) (*Server, error) {
 	s := &Server{}
 
 	router.Any("/report", s.reportHandler)
 	router.Any("/health", s.healthHandler)
+	router.Any("/relay", s.relayHandler)
 
-	var err error
 	s.http = cfg.HTTP
 	s.https = cfg.HTTPS
 	s.udp = cfg.UDP
 	s.secret = cfg.Secret
+	s.skew = time.Duration(cfg.MaxClockSkew) * time.Second
 	s.db = db
-	s.tsdb, err = timeseries.NewInfluxDb(&cfg.Influxdb)
-	if err != nil {
-		return nil, err
-	}
+	s.tsdb = tsdb
 	s.store = store
 
 	s.inventory = make(map[string]*inventory)
@@ -52,8 +54,9 @@ func NewServer(router gin.IRouter, cfg configuration.ServerConfiguration, db use
 	return s, nil
 }
 
-func (s *Server) sendToInflux(stats plugins.Results, id string) error {
+func (s *Server) sendToInflux(stats plugins.Results, id string, sampleTime time.Time) error {
 	points := stats.GetPoints()
+	timeseries.Stamp(points, sampleTime)
 
 	// Add hostname tag to all points
 	hostname := string(*stats["hostname"].(*hostname.Hostname))
@@ -88,6 +91,12 @@ func (s *Server) reportHandler(c *gin.Context) {
 		return
 	}
 
+	err = account.Authorize(account, userdb.PermissionReport)
+	if err != nil {
+		c.String(http.StatusForbidden, "The key is not allowed to report metrics")
+		return
+	}
+
 	var results = plugins.Results{}
 
 	err = c.BindJSON(&results)
@@ -96,6 +105,23 @@ func (s *Server) reportHandler(c *gin.Context) {
 		return
 	}
 
+	// Reports from older clients carry no sample time.
+	now := time.Now()
+	sampleTime := results.SampleTime()
+	if sampleTime.IsZero() {
+		sampleTime = now
+	}
+
+	skew := now.Sub(sampleTime)
+	if skew < 0 {
+		skew = -skew
+	}
+
+	if s.skew > 0 && skew > s.skew {
+		c.String(http.StatusBadRequest, "Sample time %s is off by %s, check the clock of the client", sampleTime.Format(time.RFC3339), skew)
+		return
+	}
+
 	if s.store != nil {
 		hostname := string(*results["hostname"].(*hostname.Hostname))
 		_, err = s.store.GetHostByName(account, hostname)
@@ -108,7 +134,12 @@ func (s *Server) reportHandler(c *gin.Context) {
 				TransportID: "localtransport",
 			}
 
-			err = s.store.AddHost(account, host)
+			store := s.store
+			if auditStore, ok := store.(*audit.Store); ok {
+				store = auditStore.WithRemoteAddr(c.Request.RemoteAddr)
+			}
+
+			err = store.AddHost(account, host)
 			if err != nil {
 				c.String(http.StatusInternalServerError, "Cannot add host")
 				return
@@ -116,7 +147,7 @@ func (s *Server) reportHandler(c *gin.Context) {
 		}
 	}
 
-	err = s.sendToInflux(results, subject.GetId())
+	err = s.sendToInflux(results, subject.GetId(), sampleTime)
 	if err != nil {
 		c.AbortWithError(http.StatusInternalServerError, err)
 		return
@@ -125,6 +156,51 @@ func (s *Server) reportHandler(c *gin.Context) {
 	c.String(http.StatusOK, "%s", "Got it")
 }
 
+// relayHandler accepts connections from relays. The key must allow
+// changing hosts, a relay can receive the connections of all probes using
+// it.
+func (s *Server) relayHandler(c *gin.Context) {
+	if c.Request.Method != "GET" {
+		c.Header("Allow", "GET")
+		c.String(http.StatusMethodNotAllowed, "only GET allowed")
+		return
+	}
+
+	if c.Request.Header.Get("Upgrade") != relaytransport.Upgrade {
+		c.String(http.StatusUpgradeRequired, "Upgrade to %s required", relaytransport.Upgrade)
+		return
+	}
+
+	name := c.Request.Header.Get("X-Agento-Relay")
+	if name == "" {
+		c.String(http.StatusBadRequest, "Relay name missing")
+		return
+	}
+
+	subject, err := s.db.ResolveKey(c.Request.Header.Get("X-Agento-Secret"))
+	if err != nil {
+		c.String(http.StatusForbidden, "%s", err.Error())
+		return
+	}
+
+	account, ok := subject.(userdb.Account)
+	if !ok {
+		c.String(http.StatusForbidden, "Only account keys can connect relays")
+		return
+	}
+
+	err = account.Authorize(account, userdb.PermissionWriteHosts)
+	if err != nil {
+		c.String(http.StatusForbidden, "The key is not allowed to connect relays")
+		return
+	}
+
+	err = relaytransport.Accept(c.Writer, account.GetId(), name)
+	if err != nil {
+		logger.Red("server", "Relay %s: %s", name, err.Error())
+	}
+}
+
 func (s *Server) healthHandler(c *gin.Context) {
 	if c.Request.Method != "GET" {
 		c.Header("Allow", "GET")
@@ -59,6 +59,10 @@ func (s *Sample) computeKey() string {
 }
 
 func (s *Server) addUDPSample(sample *Sample) error {
+	// UDP samples are not authenticated, the client can never choose the
+	// account.
+	delete(sample.Tags, timeseries.ReservedTag)
+
 	key := sample.computeKey()
 	intValue := int64(sample.Value * exponent)
 
@@ -84,6 +88,8 @@ func (s *Server) addUDPSample(sample *Sample) error {
 }
 
 func (s *Server) reportToInfluxdb() {
+	now := time.Now()
+
 	for key, value := range s.inventory {
 		// If the histogram was unused for a cycle, we remove it from inventory
 		if value.Histogram.Count() == 0 {
@@ -107,6 +113,7 @@ func (s *Server) reportToInfluxdb() {
 				"count": value.Histogram.Count(),
 				"sum":   value.Histogram.Sum() / exponent,
 			},
+			now,
 		)
 		value.Histogram.Sample().Clear()
 
@@ -2,8 +2,26 @@ package server
 
 import (
 	"testing"
+
+	"github.com/abrander/agento/timeseries"
+)
+
+type (
+	recordingDatabase struct {
+		points []*timeseries.Point
+	}
 )
 
+func (d *recordingDatabase) WritePoints(points []*timeseries.Point) error {
+	d.points = append(d.points, points...)
+
+	return nil
+}
+
+func (d *recordingDatabase) Query(q timeseries.Query) ([]timeseries.Series, error) {
+	return nil, nil
+}
+
 func TestComputeKey(t *testing.T) {
 	cases := map[string]Sample{
 		"1:id:a=bc=de=f": Sample{
@@ -42,3 +60,38 @@ func TestComputeKey(t *testing.T) {
 		}
 	}
 }
+
+func TestAddUDPSampleAccount(t *testing.T) {
+	db := &recordingDatabase{}
+	s := &Server{inventory: make(map[string]*inventory), tsdb: db}
+
+	for _, id := range []string{"", "tenant1", "tenant2"} {
+		sample := &Sample{
+			Type:       1,
+			Identifier: "udptest.account",
+			Tags:       map[string]string{"a": "b"},
+			Value:      1.0,
+		}
+
+		if id != "" {
+			sample.Tags[timeseries.ReservedTag] = id
+		}
+
+		s.addUDPSample(sample)
+	}
+
+	s.reportToInfluxdb()
+
+	if len(db.points) != 1 {
+		t.Fatalf("Expected 1 point, got %d", len(db.points))
+	}
+
+	point := db.points[0]
+	if _, found := point.Tags[timeseries.ReservedTag]; found || point.Tags["a"] != "b" {
+		t.Errorf("Wrong tags %v", point.Tags)
+	}
+
+	if point.Fields["count"] != int64(3) {
+		t.Errorf("Expected count 3, got %v", point.Fields["count"])
+	}
+}
@@ -0,0 +1,267 @@
+package timeseries
+
+import (
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+)
+
+type (
+	// Guard is a Database tracking the number of unique series per account
+	// and measurement. New series exceeding the limits are dropped or
+	// aggregated into a single overflow series.
+	Guard struct {
+		Database
+
+		sync.Mutex
+
+		limits    GuardLimits
+		accounts  map[string]*accountSeries
+		lastPrune time.Time
+	}
+
+	// GuardLimits configures a Guard. A limit of 0 means unlimited.
+	GuardLimits struct {
+		MaxSeriesPerMeasurement int `json:"maxSeriesPerMeasurement"`
+		MaxSeriesPerAccount     int `json:"maxSeriesPerAccount"`
+
+		// Aggregate will rewrite new series over the limit to an overflow
+		// series instead of dropping them.
+		Aggregate bool `json:"aggregate"`
+
+		// Expire is how long a series is counted after the last point was
+		// seen.
+		Expire time.Duration `json:"expire"`
+	}
+
+	// CardinalityStats is the number of series for a measurement.
+	CardinalityStats struct {
+		Account     string `json:"account"`
+		Measurement string `json:"measurement"`
+		Series      int    `json:"series"`
+		Dropped     uint64 `json:"dropped"`
+		Aggregated  uint64 `json:"aggregated"`
+	}
+
+	accountSeries struct {
+		total        int
+		measurements map[string]*measurementSeries
+
+		// dropped counts points for new measurements dropped because the
+		// account was over the limit.
+		dropped uint64
+	}
+
+	measurementSeries struct {
+		// series maps a hash of the series key to the Unix time it was last
+		// seen.
+		series     map[uint64]int64
+		dropped    uint64
+		aggregated uint64
+	}
+)
+
+const (
+	// OverflowValue replaces tag values of aggregated series.
+	OverflowValue = "_overflow"
+
+	pruneInterval = time.Minute
+)
+
+// Ensure compliance
+var _ Database = (*Guard)(nil)
+
+// NewGuard returns a Database enforcing limits before writing to db.
+func NewGuard(db Database, limits GuardLimits) *Guard {
+	return &Guard{
+		Database: db,
+		limits:   limits,
+		accounts: make(map[string]*accountSeries),
+	}
+}
+
+// Unwrap returns the underlying database.
+func (g *Guard) Unwrap() Database {
+	return g.Database
+}
+
+// Close closes the underlying database if possible.
+func (g *Guard) Close() error {
+	return closeDatabase(g.Database)
+}
+
+func hashSeries(point *Point) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(seriesKey(point.Name, point.Tags, "")))
+
+	return h.Sum64()
+}
+
+// overflow returns a copy of point with all tag values except the account
+// replaced by OverflowValue.
+func overflow(point *Point) *Point {
+	p := copyPoint(point)
+
+	for key := range p.Tags {
+		if key != ReservedTag {
+			p.Tags[key] = OverflowValue
+		}
+	}
+
+	return p
+}
+
+// prune forgets series not seen since the expiry.
+func (g *Guard) prune(now time.Time) {
+	if g.limits.Expire <= 0 || now.Sub(g.lastPrune) < pruneInterval {
+		return
+	}
+
+	g.lastPrune = now
+	oldest := now.Add(-g.limits.Expire).Unix()
+
+	for _, account := range g.accounts {
+		for _, measurement := range account.measurements {
+			for hash, seen := range measurement.series {
+				if seen < oldest {
+					delete(measurement.series, hash)
+					account.total--
+				}
+			}
+		}
+	}
+}
+
+// admit decides if the point can be written. A nil point is returned if the
+// point should be dropped.
+func (g *Guard) admit(point *Point, now int64) *Point {
+	id := point.Tags[ReservedTag]
+
+	account, found := g.accounts[id]
+	if !found {
+		account = &accountSeries{measurements: make(map[string]*measurementSeries)}
+		g.accounts[id] = account
+	}
+
+	hash := hashSeries(point)
+
+	measurement, found := account.measurements[point.Name]
+	if found {
+		if _, known := measurement.series[hash]; known {
+			measurement.series[hash] = now
+			return point
+		}
+	}
+
+	accountFull := g.limits.MaxSeriesPerAccount > 0 && account.total >= g.limits.MaxSeriesPerAccount
+
+	// New measurements are only created while the account is below the
+	// limit, measurement names can be chosen by clients too.
+	if !found {
+		if accountFull {
+			account.dropped++
+			return nil
+		}
+
+		measurement = &measurementSeries{series: make(map[uint64]int64)}
+		account.measurements[point.Name] = measurement
+	}
+
+	measurementFull := g.limits.MaxSeriesPerMeasurement > 0 && len(measurement.series) >= g.limits.MaxSeriesPerMeasurement
+
+	if accountFull || measurementFull {
+		if !g.limits.Aggregate {
+			measurement.dropped++
+			return nil
+		}
+
+		measurement.aggregated++
+
+		// The overflow series is allowed even if it exceeds the limits.
+		point = overflow(point)
+		hash = hashSeries(point)
+
+		if _, known := measurement.series[hash]; known {
+			measurement.series[hash] = now
+			return point
+		}
+	}
+
+	measurement.series[hash] = now
+	account.total++
+
+	return point
+}
+
+// WritePoints implements Database. Points for new series over the limits are
+// dropped or aggregated.
+func (g *Guard) WritePoints(points []*Point) error {
+	now := time.Now()
+	admitted := make([]*Point, 0, len(points))
+
+	g.Lock()
+	g.prune(now)
+	for _, point := range points {
+		p := g.admit(point, now.Unix())
+		if p != nil {
+			admitted = append(admitted, p)
+		}
+	}
+	g.Unlock()
+
+	if len(admitted) == 0 {
+		return nil
+	}
+
+	return g.Database.WritePoints(admitted)
+}
+
+// Stats returns the number of series for each measurement of account. Points
+// without an account are returned for the empty account. If all is true,
+// stats for all accounts are returned. Points dropped for new measurements
+// are counted for the measurement OverflowValue.
+func (g *Guard) Stats(account string, all bool) []CardinalityStats {
+	stats := []CardinalityStats{}
+
+	g.Lock()
+	for id, a := range g.accounts {
+		if !all && id != account {
+			continue
+		}
+
+		for name, m := range a.measurements {
+			stats = append(stats, CardinalityStats{
+				Account:     id,
+				Measurement: name,
+				Series:      len(m.series),
+				Dropped:     m.dropped,
+				Aggregated:  m.aggregated,
+			})
+		}
+
+		if a.dropped > 0 {
+			stats = append(stats, CardinalityStats{
+				Account:     id,
+				Measurement: OverflowValue,
+				Dropped:     a.dropped,
+			})
+		}
+	}
+	g.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Account != stats[j].Account {
+			return stats[i].Account < stats[j].Account
+		}
+
+		return stats[i].Measurement < stats[j].Measurement
+	})
+
+	return stats
+}
+
+// Limits returns the limits enforced by the guard.
+func (g *Guard) Limits() GuardLimits {
+	return g.limits
+}
@@ -0,0 +1,124 @@
+package timeseries
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func guardPoint(account string, name string, host string) *Point {
+	tags := map[string]string{"hostname": host}
+	if account != "" {
+		tags[ReservedTag] = account
+	}
+
+	return NewPoint(name, tags, map[string]interface{}{"value": 1.0}, time.Time{})
+}
+
+func TestGuardDrop(t *testing.T) {
+	db := &memoryDatabase{}
+	g := NewGuard(db, GuardLimits{MaxSeriesPerMeasurement: 2})
+
+	for i := 0; i < 5; i++ {
+		g.WritePoints([]*Point{guardPoint("", "cpu", strconv.Itoa(i))})
+	}
+
+	// Known series are still written.
+	g.WritePoints([]*Point{guardPoint("", "cpu", "0")})
+
+	if len(db.points) != 3 {
+		t.Fatalf("Guard wrote %d points, expected 3", len(db.points))
+	}
+
+	stats := g.Stats("", false)
+	if len(stats) != 1 || stats[0].Series != 2 || stats[0].Dropped != 3 {
+		t.Errorf("Stats() returned wrong stats: %+v", stats)
+	}
+}
+
+func TestGuardAggregate(t *testing.T) {
+	db := &memoryDatabase{}
+	g := NewGuard(db, GuardLimits{MaxSeriesPerMeasurement: 1, Aggregate: true})
+
+	original := guardPoint("a", "cpu", "2")
+
+	g.WritePoints([]*Point{guardPoint("a", "cpu", "1")})
+	g.WritePoints([]*Point{original})
+	g.WritePoints([]*Point{guardPoint("a", "cpu", "3")})
+
+	if len(db.points) != 3 {
+		t.Fatalf("Guard wrote %d points, expected 3", len(db.points))
+	}
+
+	for _, p := range db.points[1:] {
+		if p.Tags["hostname"] != OverflowValue || p.Tags[ReservedTag] != "a" {
+			t.Errorf("Guard wrote wrong tags for aggregated series: %v", p.Tags)
+		}
+	}
+
+	if original.Tags["hostname"] != "2" {
+		t.Errorf("Guard changed the original point")
+	}
+
+	stats := g.Stats("a", false)
+	if len(stats) != 1 || stats[0].Series != 2 || stats[0].Aggregated != 2 {
+		t.Errorf("Stats() returned wrong stats: %+v", stats)
+	}
+}
+
+func TestGuardAccountLimit(t *testing.T) {
+	db := &memoryDatabase{}
+	g := NewGuard(db, GuardLimits{MaxSeriesPerAccount: 2})
+
+	g.WritePoints([]*Point{
+		guardPoint("a", "cpu", "1"),
+		guardPoint("a", "mem", "1"),
+		guardPoint("a", "cpu", "2"),
+		guardPoint("a", "disk", "1"),
+		guardPoint("b", "cpu", "1"),
+	})
+
+	if len(db.points) != 3 {
+		t.Fatalf("Guard wrote %d points, expected 3", len(db.points))
+	}
+
+	stats := g.Stats("a", false)
+	if len(stats) != 3 {
+		t.Fatalf("Stats() returned %d measurements, expected 3: %+v", len(stats), stats)
+	}
+
+	// New measurements over the limit are counted as overflow.
+	if stats[0].Measurement != OverflowValue || stats[0].Dropped != 1 {
+		t.Errorf("Stats() returned wrong overflow stats: %+v", stats[0])
+	}
+
+	if stats[1].Measurement != "cpu" || stats[1].Dropped != 1 {
+		t.Errorf("Stats() returned wrong cpu stats: %+v", stats[1])
+	}
+
+	if len(g.Stats("", true)) != 4 {
+		t.Errorf("Stats() did not return all accounts")
+	}
+}
+
+func TestGuardExpire(t *testing.T) {
+	db := &memoryDatabase{}
+	g := NewGuard(db, GuardLimits{MaxSeriesPerMeasurement: 1, Expire: time.Hour})
+
+	g.WritePoints([]*Point{guardPoint("", "cpu", "1")})
+	g.WritePoints([]*Point{guardPoint("", "cpu", "2")})
+
+	if len(db.points) != 1 {
+		t.Fatalf("Guard wrote %d points, expected 1", len(db.points))
+	}
+
+	g.Lock()
+	g.prune(time.Now().Add(2 * time.Hour))
+	g.Unlock()
+
+	g.WritePoints([]*Point{guardPoint("", "cpu", "2")})
+
+	if len(db.points) != 2 {
+		t.Fatalf("Guard wrote %d points after expiry, expected 2", len(db.points))
+	}
+}
@@ -1,6 +1,10 @@
 package timeseries
 
 import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/influxdata/influxdb1-client/v2"
@@ -41,6 +45,15 @@ func NewInfluxDb(cfg *configuration.InfluxdbConfiguration) (*InfluxDb, error) {
 	}, nil
 }
 
+// WithRetentionPolicy returns a database sharing the connection of i, but
+// writing to and querying retentionPolicy.
+func (i *InfluxDb) WithRetentionPolicy(retentionPolicy string) *InfluxDb {
+	db := *i
+	db.bpsConf.RetentionPolicy = retentionPolicy
+
+	return &db
+}
+
 // WritePoints Implements Database.
 func (i *InfluxDb) WritePoints(points []*Point) error {
 	bps, err := client.NewBatchPoints(i.bpsConf)
@@ -69,3 +82,124 @@ func (i *InfluxDb) WritePoints(points []*Point) error {
 
 	return err
 }
+
+// quoteIdentifier quotes an InfluxQL identifier.
+func quoteIdentifier(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `"`, `\"`, -1)
+
+	return `"` + s + `"`
+}
+
+// quoteString quotes an InfluxQL string literal.
+func quoteString(s string) string {
+	s = strings.Replace(s, `\`, `\\`, -1)
+	s = strings.Replace(s, `'`, `\'`, -1)
+
+	return `'` + s + `'`
+}
+
+// influxQL builds the InfluxQL statement for q. q must be normalized.
+func influxQL(q Query) string {
+	field := quoteIdentifier(q.Field)
+	if q.Step > 0 {
+		field = q.Aggregation + "(" + field + ")"
+	}
+
+	where := []string{
+		"time >= " + quoteString(q.From.UTC().Format(time.RFC3339Nano)),
+		"time <= " + quoteString(q.To.UTC().Format(time.RFC3339Nano)),
+	}
+
+	keys := make([]string, 0, len(q.Tags))
+	for key := range q.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		where = append(where, quoteIdentifier(key)+" = "+quoteString(q.Tags[key]))
+	}
+
+	stmt := fmt.Sprintf("SELECT %s AS \"value\" FROM %s WHERE %s GROUP BY ",
+		field,
+		quoteIdentifier(q.Measurement),
+		strings.Join(where, " AND "))
+
+	if q.Step > 0 {
+		stmt += fmt.Sprintf("time(%ds), * fill(none)", int64(q.Step/time.Second))
+	} else {
+		stmt += "*"
+	}
+
+	return stmt
+}
+
+// Query implements Database.
+func (i *InfluxDb) Query(q Query) ([]Series, error) {
+	err := q.Normalize(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := i.conn.Query(client.NewQueryWithRP(influxQL(q), i.bpsConf.Database, i.bpsConf.RetentionPolicy, ""))
+	if err != nil {
+		return nil, err
+	}
+
+	if response.Error() != nil {
+		return nil, response.Error()
+	}
+
+	series := []Series{}
+
+	for _, result := range response.Results {
+		for _, row := range result.Series {
+			s := Series{
+				Name:    row.Name,
+				Tags:    row.Tags,
+				Samples: make([]Sample, 0, len(row.Values)),
+			}
+
+			if s.Tags == nil {
+				s.Tags = make(map[string]string)
+			}
+
+			for _, values := range row.Values {
+				if len(values) < 2 {
+					continue
+				}
+
+				ts, ok := values[0].(string)
+				if !ok {
+					continue
+				}
+
+				t, err := time.Parse(time.RFC3339Nano, ts)
+				if err != nil {
+					return nil, err
+				}
+
+				// Only numeric values can be represented by a Sample.
+				var value float64
+				switch v := values[1].(type) {
+				case json.Number:
+					value, err = v.Float64()
+					if err != nil {
+						continue
+					}
+				case float64:
+					value = v
+				default:
+					continue
+				}
+
+				s.Samples = append(s.Samples, Sample{Time: t, Value: value})
+			}
+
+			series = append(series, s)
+		}
+	}
+
+	return series, nil
+}
@@ -0,0 +1,119 @@
+package timeseries
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/abrander/agento/configuration"
+)
+
+func TestQueryNormalize(t *testing.T) {
+	now := time.Now()
+
+	cases := []struct {
+		query Query
+		valid bool
+	}{
+		{Query{}, false},
+		{Query{Measurement: "m"}, true},
+		{Query{Measurement: "m", From: now, To: now}, false},
+		{Query{Measurement: "m", Step: -time.Second}, false},
+		{Query{Measurement: "m", Step: time.Millisecond}, false},
+		{Query{Measurement: "m", Aggregation: "mean"}, false},
+		{Query{Measurement: "m", Step: time.Minute}, true},
+		{Query{Measurement: "m", Step: time.Minute, Aggregation: "max"}, true},
+		{Query{Measurement: "m", Step: time.Minute, Aggregation: "median; DROP"}, false},
+	}
+
+	for i, c := range cases {
+		err := c.query.Normalize(now)
+		if (err == nil) != c.valid {
+			t.Errorf("%d: Normalize() returned %v", i, err)
+		}
+	}
+
+	q := Query{Measurement: "m", Step: time.Minute}
+	q.Normalize(now)
+
+	if q.Field != DefaultField || q.Aggregation != DefaultAggregation || !q.To.Equal(now) || !q.From.Equal(now.Add(-DefaultRange)) {
+		t.Errorf("Normalize() did not apply defaults: %+v", q)
+	}
+}
+
+func TestInfluxQL(t *testing.T) {
+	from := time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	q := Query{
+		Measurement: `cpu"`,
+		Field:       "value",
+		Tags:        map[string]string{"id": "abc", "hostname": `o'hara`},
+		From:        from,
+		To:          from.Add(time.Hour),
+		Step:        time.Minute,
+		Aggregation: "max",
+	}
+
+	expected := `SELECT max("value") AS "value" FROM "cpu\"" WHERE time >= '2016-01-02T03:04:05Z' AND time <= '2016-01-02T04:04:05Z' AND "hostname" = 'o\'hara' AND "id" = 'abc' GROUP BY time(60s), * fill(none)`
+
+	stmt := influxQL(q)
+	if stmt != expected {
+		t.Errorf("influxQL() returned\n%s\nexpected\n%s", stmt, expected)
+	}
+
+	q.Step = 0
+	q.Aggregation = ""
+
+	stmt = influxQL(q)
+	if !strings.HasPrefix(stmt, `SELECT "value" AS "value" FROM`) || !strings.HasSuffix(stmt, "GROUP BY *") {
+		t.Errorf("influxQL() returned wrong raw query: %s", stmt)
+	}
+}
+
+func TestInfluxDbQuery(t *testing.T) {
+	var received string
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.FormValue("q")
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"results":[{"statement_id":0,"series":[
+			{"name":"cpu","tags":{"hostname":"a"},"columns":["time","value"],"values":[["2016-01-02T03:04:00Z",1.5],["2016-01-02T03:05:00Z",2]]},
+			{"name":"cpu","tags":{"hostname":"b"},"columns":["time","value"],"values":[["2016-01-02T03:04:00Z",null]]}
+		]}]}`))
+	}))
+	defer server.Close()
+
+	db, err := NewInfluxDb(&configuration.InfluxdbConfiguration{URL: server.URL, Database: "agento"})
+	if err != nil {
+		t.Fatalf("NewInfluxDb() failed: %s", err.Error())
+	}
+
+	series, err := db.Query(Query{Measurement: "cpu", Step: time.Minute})
+	if err != nil {
+		t.Fatalf("Query() failed: %s", err.Error())
+	}
+
+	if !strings.Contains(received, `mean("value")`) {
+		t.Errorf("Query() sent unexpected statement: %s", received)
+	}
+
+	if len(series) != 2 {
+		t.Fatalf("Query() returned %d series, expected 2", len(series))
+	}
+
+	if series[0].Tags["hostname"] != "a" || len(series[0].Samples) != 2 || series[0].Samples[1].Value != 2 {
+		t.Errorf("Query() returned wrong first series: %+v", series[0])
+	}
+
+	if len(series[1].Samples) != 0 {
+		t.Errorf("Query() should skip null values, got %+v", series[1].Samples)
+	}
+
+	_, err = db.Query(Query{})
+	if _, ok := err.(*QueryError); !ok {
+		t.Errorf("Query() should reject invalid queries, got %v", err)
+	}
+}
@@ -0,0 +1,410 @@
+package timeseries
+
+import (
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/abrander/agento/configuration"
+	"github.com/abrander/agento/logger"
+)
+
+type (
+	// LocalDb is an embedded time series database storing points in
+	// compressed files partitioned by time. Recent partitions are kept in
+	// memory and flushed to disk periodically.
+	LocalDb struct {
+		sync.Mutex
+
+		path            string
+		partition       time.Duration
+		retention       time.Duration
+		downsampleAfter time.Duration
+		resolution      time.Duration
+
+		head map[int64]*partition
+
+		stop chan struct{}
+		done chan struct{}
+	}
+)
+
+var (
+	// FlushInterval is how often LocalDb writes changed partitions to disk
+	// and applies retention and downsampling. Points not yet flushed will
+	// be lost if Agento crashes.
+	FlushInterval = time.Minute
+)
+
+// Ensure compliance
+var _ Database = (*LocalDb)(nil)
+
+// NewLocalDb opens or creates a local database.
+func NewLocalDb(cfg *configuration.LocalDbConfiguration) (*LocalDb, error) {
+	l := &LocalDb{
+		path:            cfg.Path,
+		partition:       time.Duration(cfg.Partition) * time.Hour,
+		retention:       time.Duration(cfg.Retention) * time.Hour,
+		downsampleAfter: time.Duration(cfg.DownsampleAfter) * time.Hour,
+		resolution:      time.Duration(cfg.Resolution) * time.Second,
+		head:            make(map[int64]*partition),
+		stop:            make(chan struct{}),
+		done:            make(chan struct{}),
+	}
+
+	if l.path == "" {
+		return nil, errors.New("path must not be empty")
+	}
+
+	if l.partition <= 0 || l.retention <= 0 {
+		return nil, errors.New("partition and retention must be positive")
+	}
+
+	if l.downsampleAfter > 0 && l.resolution <= 0 {
+		return nil, errors.New("resolution must be positive when downsampling")
+	}
+
+	err := os.MkdirAll(l.path, 0700)
+	if err != nil {
+		return nil, err
+	}
+
+	go l.loop()
+
+	return l, nil
+}
+
+func (l *LocalDb) loop() {
+	ticker := time.NewTicker(FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			err := l.maintain(time.Now())
+			if err != nil {
+				logger.Red("localdb", "Maintenance failed: %s", err.Error())
+			}
+		case <-l.stop:
+			close(l.done)
+			return
+		}
+	}
+}
+
+// Close flushes all points to disk and stops background maintenance.
+func (l *LocalDb) Close() error {
+	close(l.stop)
+	<-l.done
+
+	l.Lock()
+	defer l.Unlock()
+
+	return l.flush()
+}
+
+// headPartition returns the in-memory partition starting at start. If the
+// partition exists on disk, it will be loaded.
+func (l *LocalDb) headPartition(start time.Time) (*partition, error) {
+	p, found := l.head[start.Unix()]
+	if found {
+		return p, nil
+	}
+
+	p, err := readPartition(l.path, start)
+	if os.IsNotExist(err) {
+		p, err = newPartition(start), nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	l.head[start.Unix()] = p
+
+	return p, nil
+}
+
+// numeric converts a field value to float64. Only numbers and booleans can
+// be stored.
+func numeric(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int8:
+		return float64(v), true
+	case int16:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint:
+		return float64(v), true
+	case uint8:
+		return float64(v), true
+	case uint16:
+		return float64(v), true
+	case uint32:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case bool:
+		if v {
+			return 1.0, true
+		}
+
+		return 0.0, true
+	}
+
+	return 0.0, false
+}
+
+// WritePoints implements Database. Points without a time will be stored as
+// now. Points older than the retention and non-numeric fields are ignored.
+func (l *LocalDb) WritePoints(points []*Point) error {
+	now := time.Now()
+	oldest := now.Add(-l.retention)
+
+	l.Lock()
+	defer l.Unlock()
+
+	for _, point := range points {
+		t := point.Time
+		if t.IsZero() {
+			t = now
+		}
+
+		if t.Before(oldest) {
+			continue
+		}
+
+		p, err := l.headPartition(t.Truncate(l.partition))
+		if err != nil {
+			return err
+		}
+
+		tags := make(map[string]string, len(point.Tags))
+		for key, value := range point.Tags {
+			tags[key] = value
+		}
+
+		for field, value := range point.Fields {
+			v, ok := numeric(value)
+			if !ok {
+				continue
+			}
+
+			p.add(point.Name, tags, field, Sample{Time: t, Value: v})
+		}
+	}
+
+	return nil
+}
+
+// flush writes all changed in-memory partitions to disk.
+func (l *LocalDb) flush() error {
+	for _, p := range l.head {
+		if !p.dirty {
+			continue
+		}
+
+		if p.resolution > 0 {
+			// Late points for a downsampled partition.
+			p.downsample(p.resolution)
+		}
+
+		err := p.write(l.path)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// maintain flushes partitions to disk, evicts partitions no longer written
+// to from memory, deletes partitions older than the retention and
+// downsamples old partitions.
+func (l *LocalDb) maintain(now time.Time) error {
+	l.Lock()
+	defer l.Unlock()
+
+	err := l.flush()
+	if err != nil {
+		return err
+	}
+
+	// Keep the previous partition in memory for late points.
+	for start, p := range l.head {
+		if p.start.Add(2 * l.partition).Before(now) {
+			delete(l.head, start)
+		}
+	}
+
+	starts, err := listPartitions(l.path)
+	if err != nil {
+		return err
+	}
+
+	for _, start := range starts {
+		end := start.Add(l.partition)
+
+		if end.Before(now.Add(-l.retention)) {
+			delete(l.head, start.Unix())
+
+			err = os.Remove(partitionPath(l.path, start))
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if l.downsampleAfter <= 0 || !end.Before(now.Add(-l.downsampleAfter)) {
+			continue
+		}
+
+		if _, found := l.head[start.Unix()]; found {
+			continue
+		}
+
+		resolution, err := partitionResolution(l.path, start)
+		if err != nil || resolution > 0 {
+			continue
+		}
+
+		p, err := readPartition(l.path, start)
+		if err != nil {
+			logger.Red("localdb", "Could not read %s: %s", partitionPath(l.path, start), err.Error())
+			continue
+		}
+
+		p.downsample(l.resolution)
+
+		err = p.write(l.path)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// matches returns true if s belongs to the series queried by q.
+func (s *memSeries) matches(q *Query) bool {
+	if s.name != q.Measurement || s.field != q.Field {
+		return false
+	}
+
+	for key, value := range q.Tags {
+		if s.tags[key] != value {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Query implements Database.
+func (l *LocalDb) Query(q Query) ([]Series, error) {
+	err := q.Normalize(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	l.Lock()
+	defer l.Unlock()
+
+	starts, err := listPartitions(l.path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Include partitions only existing in memory.
+	for _, p := range l.head {
+		starts = append(starts, p.start)
+	}
+
+	seen := make(map[int64]bool)
+	found := make(map[string]*Series)
+
+	for _, start := range starts {
+		if seen[start.Unix()] || !start.Before(q.To) || start.Add(l.partition).Before(q.From) {
+			continue
+		}
+		seen[start.Unix()] = true
+
+		p, inHead := l.head[start.Unix()]
+		if !inHead {
+			p, err = readPartition(l.path, start)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for _, s := range p.series {
+			if !s.matches(&q) {
+				continue
+			}
+
+			if inHead {
+				s.compact()
+			}
+
+			key := seriesKey(s.name, s.tags, "")
+
+			series, exists := found[key]
+			if !exists {
+				tags := make(map[string]string, len(s.tags))
+				for k, v := range s.tags {
+					tags[k] = v
+				}
+
+				series = &Series{Name: s.name, Tags: tags}
+				found[key] = series
+			}
+
+			for _, sample := range s.samples {
+				if sample.Time.Before(q.From) || sample.Time.After(q.To) {
+					continue
+				}
+
+				series.Samples = append(series.Samples, sample)
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(found))
+	for key := range found {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := []Series{}
+	for _, key := range keys {
+		s := found[key]
+
+		sort.Slice(s.Samples, func(i, j int) bool {
+			return s.Samples[i].Time.Before(s.Samples[j].Time)
+		})
+
+		if q.Step > 0 {
+			s.Samples = aggregate(s.Samples, q.Step, q.Aggregation)
+		}
+
+		if len(s.Samples) == 0 {
+			continue
+		}
+
+		result = append(result, *s)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,151 @@
+package timeseries
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/abrander/agento/configuration"
+)
+
+func newTestLocalDb(t *testing.T) (*LocalDb, func()) {
+	dir, err := ioutil.TempDir("", "localdb")
+	if err != nil {
+		t.Fatalf("TempDir() failed: %s", err.Error())
+	}
+
+	l, err := NewLocalDb(&configuration.LocalDbConfiguration{
+		Path:            dir,
+		Partition:       2,
+		Retention:       168,
+		DownsampleAfter: 24,
+		Resolution:      60,
+	})
+	if err != nil {
+		t.Fatalf("NewLocalDb() failed: %s", err.Error())
+	}
+
+	return l, func() {
+		l.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestLocalDbWriteQuery(t *testing.T) {
+	l, cleanup := newTestLocalDb(t)
+	defer cleanup()
+
+	now := time.Now().Truncate(time.Second)
+	from := now.Add(-5 * time.Hour)
+
+	var points []*Point
+	for i := 0; i < 300; i++ {
+		ts := from.Add(time.Duration(i) * time.Minute)
+		points = append(points,
+			NewPoint("cpu", map[string]string{"hostname": "a", "id": "1"}, map[string]interface{}{"value": float64(i), "text": "ignored"}, ts),
+			NewPoint("cpu", map[string]string{"hostname": "b", "id": "2"}, map[string]interface{}{"value": 1}, ts),
+		)
+	}
+
+	err := l.WritePoints(points)
+	if err != nil {
+		t.Fatalf("WritePoints() failed: %s", err.Error())
+	}
+
+	check := func(l *LocalDb) {
+		series, err := l.Query(Query{Measurement: "cpu", From: from, To: now})
+		if err != nil {
+			t.Fatalf("Query() failed: %s", err.Error())
+		}
+
+		if len(series) != 2 || len(series[0].Samples) != 300 || series[0].Tags["hostname"] != "a" {
+			t.Fatalf("Query() returned wrong series: %d", len(series))
+		}
+
+		if series[0].Samples[299].Value != 299 {
+			t.Errorf("Query() returned wrong value %f", series[0].Samples[299].Value)
+		}
+
+		series, err = l.Query(Query{Measurement: "cpu", Tags: map[string]string{"id": "1"}, From: from, To: now, Step: time.Hour, Aggregation: "max"})
+		if err != nil {
+			t.Fatalf("Query() failed: %s", err.Error())
+		}
+
+		if len(series) != 1 || series[0].Tags["id"] != "1" || len(series[0].Samples) < 5 {
+			t.Fatalf("Query() returned wrong aggregated series: %+v", series)
+		}
+
+		last := series[0].Samples[len(series[0].Samples)-1]
+		if last.Value != 299 {
+			t.Errorf("Query() returned wrong max %f", last.Value)
+		}
+
+		series, err = l.Query(Query{Measurement: "cpu", Field: "text", From: from, To: now})
+		if err != nil || len(series) != 0 {
+			t.Errorf("Query() should not return non-numeric fields, got %+v, %v", series, err)
+		}
+	}
+
+	check(l)
+
+	// Reopen and make sure everything was persisted.
+	err = l.maintain(now)
+	if err != nil {
+		t.Fatalf("maintain() failed: %s", err.Error())
+	}
+
+	reopened, err := NewLocalDb(&configuration.LocalDbConfiguration{Path: l.path, Partition: 2, Retention: 168})
+	if err != nil {
+		t.Fatalf("NewLocalDb() failed: %s", err.Error())
+	}
+	defer reopened.Close()
+
+	check(reopened)
+}
+
+func TestLocalDbRetention(t *testing.T) {
+	l, cleanup := newTestLocalDb(t)
+	defer cleanup()
+
+	now := time.Now()
+	old := now.Add(-110 * time.Hour)
+	recent := now.Add(-30 * time.Hour)
+
+	var points []*Point
+	for i := 0; i < 120; i++ {
+		points = append(points,
+			NewPoint("m", nil, map[string]interface{}{"value": float64(i)}, old.Add(time.Duration(i)*time.Second)),
+			NewPoint("m", nil, map[string]interface{}{"value": float64(i)}, recent.Add(time.Duration(i)*time.Second)))
+	}
+
+	err := l.WritePoints(points)
+	if err != nil {
+		t.Fatalf("WritePoints() failed: %s", err.Error())
+	}
+
+	// Expire the oldest partition and downsample the rest.
+	err = l.maintain(now.Add(70 * time.Hour))
+	if err != nil {
+		t.Fatalf("maintain() failed: %s", err.Error())
+	}
+
+	starts, _ := listPartitions(l.path)
+	if len(starts) != 1 {
+		t.Fatalf("Expected 1 partition after retention, got %d", len(starts))
+	}
+
+	resolution, err := partitionResolution(l.path, starts[0])
+	if err != nil || resolution != time.Minute {
+		t.Errorf("Partition was not downsampled: %s, %v", resolution, err)
+	}
+
+	series, err := l.Query(Query{Measurement: "m", From: recent.Add(-time.Hour), To: now})
+	if err != nil {
+		t.Fatalf("Query() failed: %s", err.Error())
+	}
+
+	if len(series) != 1 || len(series[0].Samples) > 3 {
+		t.Errorf("Query() returned wrong downsampled series: %+v", series)
+	}
+}
@@ -44,3 +44,12 @@ func (p *Point) InfluxDBPoint() *client.Point {
 
 	return point
 }
+
+// Stamp sets the time of all points without a time to t.
+func Stamp(points []*Point, t time.Time) {
+	for _, point := range points {
+		if point.Time.IsZero() {
+			point.Time = t
+		}
+	}
+}
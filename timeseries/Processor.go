@@ -0,0 +1,359 @@
+package timeseries
+
+import (
+	"errors"
+	"regexp"
+	"sync"
+)
+
+type (
+	// Processor is one step in a Pipeline. Measurement and Tags are regular
+	// expressions selecting the points the processor applies to. Empty
+	// selectors match everything.
+	//
+	// The action decides what happens to matching points:
+	//   drop         Drop the point.
+	//   keep         Drop all points NOT matching.
+	//   rename       Rename the measurement to Replacement. If Measurement
+	//                is set, submatches can be referenced as $1 etc.
+	//   renameField  Rename Field to Replacement.
+	//   tag          Set Tag to Replacement.
+	//   untag        Remove Tag.
+	//   retag        Replace Pattern in the value of Tag with Replacement.
+	//   scale        Multiply Field by Factor. All fields if Field is empty.
+	//   filter       Remove Field if the value is less than Min or larger
+	//                than Max. All fields if Field is empty. Points without
+	//                fields are dropped.
+	Processor struct {
+		Action      string            `toml:"action" json:"action"`
+		Measurement string            `toml:"measurement" json:"measurement,omitempty"`
+		Tags        map[string]string `toml:"tags" json:"tags,omitempty"`
+		Field       string            `toml:"field" json:"field,omitempty"`
+		Tag         string            `toml:"tag" json:"tag,omitempty"`
+		Pattern     string            `toml:"pattern" json:"pattern,omitempty"`
+		Replacement string            `toml:"replacement" json:"replacement,omitempty"`
+		Factor      float64           `toml:"factor" json:"factor,omitempty"`
+		Min         *float64          `toml:"min" json:"min,omitempty"`
+		Max         *float64          `toml:"max" json:"max,omitempty"`
+	}
+
+	// Pipeline is a list of processors applied in order.
+	Pipeline []Processor
+
+	// Processed is a Database applying a pipeline to all points before
+	// writing them to the underlying database.
+	Processed struct {
+		Database
+		pipeline Pipeline
+	}
+)
+
+const (
+	// ReservedTag is the tag identifying the account of a point. It can be
+	// matched by processors, but never changed.
+	ReservedTag = "id"
+
+	maxCachedRegexps = 1000
+)
+
+var (
+	regexpCache     = make(map[string]*regexp.Regexp)
+	regexpCacheLock sync.Mutex
+
+	// ErrReservedTag is returned by Validate if a processor tries to change
+	// ReservedTag.
+	ErrReservedTag = errors.New("the tag '" + ReservedTag + "' can not be changed")
+)
+
+// Ensure compliance
+var _ Database = (*Processed)(nil)
+
+// compile returns the anchored regular expression for pattern. Compiled
+// expressions are cached, pipelines are applied far more often than they
+// change.
+func compile(pattern string, anchored bool) (*regexp.Regexp, error) {
+	if anchored {
+		pattern = "^(?:" + pattern + ")$"
+	}
+
+	regexpCacheLock.Lock()
+	defer regexpCacheLock.Unlock()
+
+	re, found := regexpCache[pattern]
+	if found {
+		return re, nil
+	}
+
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	// Patterns can be changed through the API, don't grow forever.
+	if len(regexpCache) >= maxCachedRegexps {
+		regexpCache = make(map[string]*regexp.Regexp)
+	}
+
+	regexpCache[pattern] = re
+
+	return re, nil
+}
+
+// Validate returns an error if the processor is invalid.
+func (p *Processor) Validate() error {
+	switch p.Action {
+	case "drop", "keep", "untag", "scale", "filter":
+	case "rename", "renameField", "tag", "retag":
+		if p.Replacement == "" && p.Action != "retag" {
+			return errors.New(p.Action + " requires a replacement")
+		}
+	default:
+		return errors.New("unknown action '" + p.Action + "'")
+	}
+
+	switch p.Action {
+	case "renameField":
+		if p.Field == "" {
+			return errors.New("renameField requires a field")
+		}
+	case "tag", "untag", "retag":
+		if p.Tag == "" {
+			return errors.New(p.Action + " requires a tag")
+		}
+
+		if p.Tag == ReservedTag {
+			return ErrReservedTag
+		}
+	}
+
+	if p.Action == "scale" && p.Factor == 0 {
+		return errors.New("scale requires a factor")
+	}
+
+	if p.Action == "filter" && p.Min == nil && p.Max == nil {
+		return errors.New("filter requires min or max")
+	}
+
+	_, err := compile(p.Measurement, true)
+	if err != nil {
+		return err
+	}
+
+	for _, pattern := range p.Tags {
+		_, err = compile(pattern, true)
+		if err != nil {
+			return err
+		}
+	}
+
+	if p.Action == "retag" {
+		_, err = compile(p.Pattern, false)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Validate returns the first error found in the pipeline.
+func (p Pipeline) Validate() error {
+	for i := range p {
+		err := p[i].Validate()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// matches returns true if point is selected by the processor.
+func (p *Processor) matches(point *Point) bool {
+	if p.Measurement != "" {
+		re, err := compile(p.Measurement, true)
+		if err != nil || !re.MatchString(point.Name) {
+			return false
+		}
+	}
+
+	for tag, pattern := range p.Tags {
+		re, err := compile(pattern, true)
+		if err != nil || !re.MatchString(point.Tags[tag]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// fields returns the fields of point the processor applies to.
+func (p *Processor) fields(point *Point) []string {
+	if p.Field != "" {
+		if _, found := point.Fields[p.Field]; found {
+			return []string{p.Field}
+		}
+
+		return nil
+	}
+
+	fields := make([]string, 0, len(point.Fields))
+	for field := range point.Fields {
+		fields = append(fields, field)
+	}
+
+	return fields
+}
+
+// apply applies the processor to point. false is returned if the point
+// should be dropped.
+func (p *Processor) apply(point *Point) bool {
+	matches := p.matches(point)
+
+	if p.Action == "keep" {
+		return matches
+	}
+
+	if !matches {
+		return true
+	}
+
+	switch p.Action {
+	case "drop":
+		return false
+	case "rename":
+		name := p.Replacement
+		if p.Measurement != "" {
+			re, _ := compile(p.Measurement, true)
+			name = re.ReplaceAllString(point.Name, p.Replacement)
+		}
+
+		point.Name = name
+	case "renameField":
+		value, found := point.Fields[p.Field]
+		if found {
+			delete(point.Fields, p.Field)
+			point.Fields[p.Replacement] = value
+		}
+	case "tag":
+		point.Tags[p.Tag] = p.Replacement
+	case "untag":
+		delete(point.Tags, p.Tag)
+	case "retag":
+		value, found := point.Tags[p.Tag]
+		if found {
+			re, err := compile(p.Pattern, false)
+			if err == nil {
+				point.Tags[p.Tag] = re.ReplaceAllString(value, p.Replacement)
+			}
+		}
+	case "scale":
+		for _, field := range p.fields(point) {
+			if v, ok := numeric(point.Fields[field]); ok {
+				point.Fields[field] = v * p.Factor
+			}
+		}
+	case "filter":
+		for _, field := range p.fields(point) {
+			v, ok := numeric(point.Fields[field])
+			if !ok {
+				continue
+			}
+
+			if (p.Min != nil && v < *p.Min) || (p.Max != nil && v > *p.Max) {
+				delete(point.Fields, field)
+			}
+		}
+
+		return len(point.Fields) > 0
+	}
+
+	return true
+}
+
+// Process applies the pipeline to points and returns the points not dropped.
+// Points are changed in place. Invalid processors are ignored.
+func (p Pipeline) Process(points []*Point) []*Point {
+	if len(p) == 0 {
+		return points
+	}
+
+	valid := make([]*Processor, 0, len(p))
+	for i := range p {
+		if p[i].Validate() == nil {
+			valid = append(valid, &p[i])
+		}
+	}
+
+	result := make([]*Point, 0, len(points))
+
+	for _, point := range points {
+		keep := true
+
+		for _, processor := range valid {
+			if !processor.apply(point) {
+				keep = false
+				break
+			}
+		}
+
+		if keep {
+			result = append(result, point)
+		}
+	}
+
+	return result
+}
+
+// copyPoint returns a copy of point that can be changed without affecting
+// the original.
+func copyPoint(point *Point) *Point {
+	tags := make(map[string]string, len(point.Tags))
+	for key, value := range point.Tags {
+		tags[key] = value
+	}
+
+	fields := make(map[string]interface{}, len(point.Fields))
+	for key, value := range point.Fields {
+		fields[key] = value
+	}
+
+	return NewPoint(point.Name, tags, fields, point.Time)
+}
+
+// NewProcessed returns a Database applying pipeline to all points written.
+func NewProcessed(db Database, pipeline Pipeline) *Processed {
+	return &Processed{
+		Database: db,
+		pipeline: pipeline,
+	}
+}
+
+// WritePoints implements Database. The points given are not changed.
+func (p *Processed) WritePoints(points []*Point) error {
+	if len(p.pipeline) == 0 {
+		return p.Database.WritePoints(points)
+	}
+
+	copies := make([]*Point, len(points))
+	for i, point := range points {
+		copies[i] = copyPoint(point)
+	}
+
+	points = p.pipeline.Process(copies)
+	if len(points) == 0 {
+		return nil
+	}
+
+	return p.Database.WritePoints(points)
+}
+
+// Unwrap returns the underlying database.
+func (p *Processed) Unwrap() Database {
+	return p.Database
+}
+
+// Close closes the underlying database if possible.
+func (p *Processed) Close() error {
+	return closeDatabase(p.Database)
+}
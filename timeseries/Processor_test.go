@@ -0,0 +1,106 @@
+package timeseries
+
+import (
+	"testing"
+)
+
+func float(f float64) *float64 {
+	return &f
+}
+
+func TestProcessorValidate(t *testing.T) {
+	cases := []struct {
+		processor Processor
+		valid     bool
+	}{
+		{Processor{Action: "drop", Measurement: "mysql.*"}, true},
+		{Processor{Action: "drop", Measurement: "("}, false},
+		{Processor{Action: "drop", Tags: map[string]string{"table": "("}}, false},
+		{Processor{Action: "explode"}, false},
+		{Processor{Action: "rename"}, false},
+		{Processor{Action: "renameField", Replacement: "b"}, false},
+		{Processor{Action: "tag", Tag: "a", Replacement: "b"}, true},
+		{Processor{Action: "tag", Tag: ReservedTag, Replacement: "b"}, false},
+		{Processor{Action: "untag", Tag: ReservedTag}, false},
+		{Processor{Action: "retag", Tag: ReservedTag, Pattern: "a"}, false},
+		{Processor{Action: "retag", Tag: "a", Pattern: "("}, false},
+		{Processor{Action: "scale"}, false},
+		{Processor{Action: "scale", Factor: 0.001}, true},
+		{Processor{Action: "filter"}, false},
+		{Processor{Action: "filter", Max: float(1)}, true},
+		{Processor{Action: "drop", Tags: map[string]string{ReservedTag: "abc"}}, true},
+	}
+
+	for i, c := range cases {
+		err := c.processor.Validate()
+		if (err == nil) != c.valid {
+			t.Errorf("%d: Validate() returned %v", i, err)
+		}
+	}
+}
+
+func TestPipelineProcess(t *testing.T) {
+	pipeline := Pipeline{
+		{Action: "drop", Measurement: "mysqltables\\..*", Tags: map[string]string{"table": "tmp_.*"}},
+		{Action: "rename", Measurement: "mysqltables\\.(.*)", Replacement: "tables.$1"},
+		{Action: "renameField", Field: "value", Replacement: "bytes"},
+		{Action: "scale", Field: "bytes", Factor: 0.5},
+		{Action: "tag", Tag: "env", Replacement: "prod"},
+		{Action: "untag", Tag: "schema"},
+		{Action: "retag", Tag: "hostname", Pattern: "\\..*", Replacement: ""},
+		{Action: "filter", Field: "bytes", Min: float(10)},
+		{Action: "untag", Tag: ReservedTag},
+	}
+
+	points := []*Point{
+		NewPoint("mysqltables.size", map[string]string{"table": "tmp_1", "hostname": "db1.example.com"}, map[string]interface{}{"value": 100}),
+		NewPoint("mysqltables.size", map[string]string{"table": "users", "schema": "app", "hostname": "db1.example.com", ReservedTag: "abc"}, map[string]interface{}{"value": 100}),
+		NewPoint("mysqltables.size", map[string]string{"table": "small"}, map[string]interface{}{"value": 2}),
+	}
+
+	processed := pipeline.Process(points)
+	if len(processed) != 1 {
+		t.Fatalf("Process() returned %d points, expected 1", len(processed))
+	}
+
+	p := processed[0]
+
+	if p.Name != "tables.size" {
+		t.Errorf("Measurement was not renamed: %s", p.Name)
+	}
+
+	if p.Fields["bytes"] != 50.0 || len(p.Fields) != 1 {
+		t.Errorf("Field was not renamed and scaled: %+v", p.Fields)
+	}
+
+	expected := map[string]string{"table": "users", "hostname": "db1", "env": "prod", ReservedTag: "abc"}
+	if len(p.Tags) != len(expected) {
+		t.Errorf("Wrong tags: %+v", p.Tags)
+	}
+
+	for key, value := range expected {
+		if p.Tags[key] != value {
+			t.Errorf("Tag %s is '%s', expected '%s'", key, p.Tags[key], value)
+		}
+	}
+}
+
+func TestProcessed(t *testing.T) {
+	db := &memoryDatabase{}
+	processed := NewProcessed(db, Pipeline{{Action: "keep", Measurement: "cpu"}, {Action: "tag", Tag: "a", Replacement: "b"}})
+
+	points := []*Point{
+		NewPoint("cpu", nil, map[string]interface{}{"value": 1}),
+		NewPoint("mem", nil, map[string]interface{}{"value": 1}),
+	}
+
+	processed.WritePoints(points)
+
+	if len(db.points) != 1 || db.points[0].Tags["a"] != "b" {
+		t.Errorf("WritePoints() wrote wrong points: %+v", db.points)
+	}
+
+	if len(points) != 2 || points[0].Name != "cpu" || len(points[0].Tags) != 0 {
+		t.Errorf("WritePoints() changed the points given")
+	}
+}
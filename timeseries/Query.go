@@ -0,0 +1,187 @@
+package timeseries
+
+import (
+	"time"
+)
+
+type (
+	// Query describes a range query for a single field of a measurement.
+	// If Step is non-zero, samples are aggregated into buckets of Step
+	// using Aggregation.
+	Query struct {
+		Measurement string            `json:"measurement"`
+		Field       string            `json:"field"`
+		Tags        map[string]string `json:"tags"`
+		From        time.Time         `json:"from"`
+		To          time.Time         `json:"to"`
+		Step        time.Duration     `json:"step"`
+		Aggregation string            `json:"aggregation"`
+	}
+
+	// Sample is a single value at a point in time.
+	Sample struct {
+		Time  time.Time `json:"time"`
+		Value float64   `json:"value"`
+	}
+
+	// Series is the samples for one unique set of tags.
+	Series struct {
+		Name    string            `json:"name"`
+		Tags    map[string]string `json:"tags"`
+		Samples []Sample          `json:"samples"`
+	}
+)
+
+const (
+	// DefaultField is used when a query doesn't name a field.
+	DefaultField = "value"
+
+	// DefaultAggregation is used when a query has a step but no
+	// aggregation.
+	DefaultAggregation = "mean"
+
+	// DefaultRange is the time range queried when From is not set.
+	DefaultRange = time.Hour
+)
+
+var (
+	// Aggregations is the list of supported aggregation functions.
+	Aggregations = []string{"mean", "min", "max", "sum", "count", "first", "last"}
+)
+
+// QueryError is returned when a query is invalid.
+type QueryError struct {
+	Reason string
+}
+
+// Error implements error.
+func (e *QueryError) Error() string {
+	return "invalid query: " + e.Reason
+}
+
+// Normalize fills in defaults and validates the query.
+func (q *Query) Normalize(now time.Time) error {
+	if q.Measurement == "" {
+		return &QueryError{"measurement must not be empty"}
+	}
+
+	if q.Field == "" {
+		q.Field = DefaultField
+	}
+
+	if q.To.IsZero() {
+		q.To = now
+	}
+
+	if q.From.IsZero() {
+		q.From = q.To.Add(-DefaultRange)
+	}
+
+	if !q.From.Before(q.To) {
+		return &QueryError{"from must be before to"}
+	}
+
+	if q.Step < 0 {
+		return &QueryError{"step must not be negative"}
+	}
+
+	if q.Step > 0 && q.Step < time.Second {
+		return &QueryError{"step must be at least one second"}
+	}
+
+	if q.Step == 0 {
+		if q.Aggregation != "" {
+			return &QueryError{"aggregation requires a step"}
+		}
+
+		return nil
+	}
+
+	if q.Aggregation == "" {
+		q.Aggregation = DefaultAggregation
+	}
+
+	for _, a := range Aggregations {
+		if a == q.Aggregation {
+			return nil
+		}
+	}
+
+	return &QueryError{"unknown aggregation '" + q.Aggregation + "'"}
+}
+
+// aggregate groups samples into buckets of step aligned to the Unix epoch
+// and reduces each bucket using aggregation. samples must be sorted by time.
+func aggregate(samples []Sample, step time.Duration, aggregation string) []Sample {
+	result := []Sample{}
+
+	var bucket []Sample
+	var bucketStart int64
+
+	flush := func() {
+		if len(bucket) == 0 {
+			return
+		}
+
+		result = append(result, Sample{
+			Time:  time.Unix(0, bucketStart),
+			Value: reduce(bucket, aggregation),
+		})
+
+		bucket = bucket[:0]
+	}
+
+	for _, s := range samples {
+		ns := s.Time.UnixNano()
+		start := ns - ns%int64(step)
+		if ns < 0 && ns%int64(step) != 0 {
+			start -= int64(step)
+		}
+
+		if len(bucket) > 0 && start != bucketStart {
+			flush()
+		}
+
+		bucketStart = start
+		bucket = append(bucket, s)
+	}
+
+	flush()
+
+	return result
+}
+
+// reduce reduces samples to a single value using aggregation.
+func reduce(samples []Sample, aggregation string) float64 {
+	switch aggregation {
+	case "count":
+		return float64(len(samples))
+	case "first":
+		return samples[0].Value
+	case "last":
+		return samples[len(samples)-1].Value
+	}
+
+	result := samples[0].Value
+	sum := 0.0
+
+	for _, s := range samples {
+		sum += s.Value
+
+		switch {
+		case aggregation == "min" && s.Value < result:
+			result = s.Value
+		case aggregation == "max" && s.Value > result:
+			result = s.Value
+		}
+	}
+
+	switch aggregation {
+	case "sum":
+		return sum
+	case "mean":
+		return sum / float64(len(samples))
+	}
+
+	return result
+}
@@ -0,0 +1,255 @@
+package timeseries
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/abrander/agento/logger"
+)
+
+type (
+	// Rollup describes how points are aggregated into a coarser interval.
+	// Aggregated points are named like the original measurement with Suffix
+	// appended and written to Target. For each field of the original point,
+	// the fields "<field>_min", "<field>_max", "<field>_mean" and
+	// "<field>_count" are written.
+	Rollup struct {
+		Interval time.Duration
+		Suffix   string
+		Target   Database
+	}
+
+	// Rollups is a Database writing points to an underlying database while
+	// maintaining rollups of the points in memory.
+	Rollups struct {
+		Database
+
+		sync.Mutex
+		rollups []*rollupState
+
+		stop chan struct{}
+		done chan struct{}
+	}
+
+	rollupState struct {
+		Rollup
+
+		// watermark is the start of the oldest bucket still open. Points
+		// older than this are dropped.
+		watermark time.Time
+		buckets   map[string]*bucket
+	}
+
+	bucket struct {
+		name   string
+		tags   map[string]string
+		start  time.Time
+		fields map[string]*fieldStats
+	}
+
+	fieldStats struct {
+		min   float64
+		max   float64
+		sum   float64
+		count int
+	}
+)
+
+var (
+	// RollupDelay is how long Rollups will wait for late points after a
+	// bucket has ended.
+	RollupDelay = 30 * time.Second
+
+	// RollupFlushInterval is how often Rollups checks for ended buckets.
+	RollupFlushInterval = 10 * time.Second
+)
+
+// Ensure compliance
+var _ Database = (*Rollups)(nil)
+
+// NewRollups returns a Database writing to db while maintaining rollups.
+func NewRollups(db Database, rollups []Rollup) *Rollups {
+	r := &Rollups{
+		Database: db,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	for _, rollup := range rollups {
+		r.rollups = append(r.rollups, &rollupState{
+			Rollup:  rollup,
+			buckets: make(map[string]*bucket),
+		})
+	}
+
+	go r.loop()
+
+	return r
+}
+
+func (r *Rollups) loop() {
+	ticker := time.NewTicker(RollupFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.flush(time.Now().Add(-RollupDelay))
+		case <-r.stop:
+			close(r.done)
+			return
+		}
+	}
+}
+
+// Close writes all buckets, including unfinished ones, and closes the
+// underlying database if possible.
+func (r *Rollups) Close() error {
+	close(r.stop)
+	<-r.done
+
+	// Flush everything.
+	r.flush(time.Unix(1<<62, 0))
+
+	return closeDatabase(r.Database)
+}
+
+// Unwrap returns the underlying database.
+func (r *Rollups) Unwrap() Database {
+	return r.Database
+}
+
+// WritePoints implements Database.
+func (r *Rollups) WritePoints(points []*Point) error {
+	err := r.Database.WritePoints(points)
+
+	now := time.Now()
+
+	r.Lock()
+	for _, rollup := range r.rollups {
+		rollup.add(points, now)
+	}
+	r.Unlock()
+
+	return err
+}
+
+// add adds points to the buckets of the rollup.
+func (s *rollupState) add(points []*Point, now time.Time) {
+	for _, point := range points {
+		t := point.Time
+		if t.IsZero() {
+			t = now
+		}
+
+		start := t.Truncate(s.Interval)
+		if start.Before(s.watermark) {
+			continue
+		}
+
+		key := seriesKey(point.Name, point.Tags, "") + "\x00" + start.String()
+
+		b, found := s.buckets[key]
+		if !found {
+			tags := make(map[string]string, len(point.Tags))
+			for k, v := range point.Tags {
+				tags[k] = v
+			}
+
+			b = &bucket{
+				name:   point.Name,
+				tags:   tags,
+				start:  start,
+				fields: make(map[string]*fieldStats),
+			}
+			s.buckets[key] = b
+		}
+
+		for field, value := range point.Fields {
+			v, ok := numeric(value)
+			if !ok {
+				continue
+			}
+
+			stats, found := b.fields[field]
+			if !found {
+				b.fields[field] = &fieldStats{min: v, max: v, sum: v, count: 1}
+				continue
+			}
+
+			if v < stats.min {
+				stats.min = v
+			}
+
+			if v > stats.max {
+				stats.max = v
+			}
+
+			stats.sum += v
+			stats.count++
+		}
+	}
+}
+
+// point returns the aggregated point for the bucket.
+func (b *bucket) point(suffix string) *Point {
+	fields := make(map[string]interface{}, len(b.fields)*4)
+	for field, stats := range b.fields {
+		fields[field+"_min"] = stats.min
+		fields[field+"_max"] = stats.max
+		fields[field+"_mean"] = stats.sum / float64(stats.count)
+		fields[field+"_count"] = stats.count
+	}
+
+	return NewPoint(b.name+suffix, b.tags, fields, b.start)
+}
+
+// ended removes and returns all buckets ending before until.
+func (s *rollupState) ended(until time.Time) []*Point {
+	var points []*Point
+
+	for key, b := range s.buckets {
+		if b.start.Add(s.Interval).After(until) {
+			continue
+		}
+
+		delete(s.buckets, key)
+
+		if len(b.fields) > 0 {
+			points = append(points, b.point(s.Suffix))
+		}
+	}
+
+	watermark := until.Truncate(s.Interval)
+	if watermark.After(s.watermark) {
+		s.watermark = watermark
+	}
+
+	sort.Slice(points, func(i, j int) bool {
+		return points[i].Time.Before(points[j].Time)
+	})
+
+	return points
+}
+
+// flush writes all buckets ending before until to their targets.
+func (r *Rollups) flush(until time.Time) {
+	r.Lock()
+	pending := make([][]*Point, len(r.rollups))
+	for i, rollup := range r.rollups {
+		pending[i] = rollup.ended(until)
+	}
+	r.Unlock()
+
+	for i, points := range pending {
+		if len(points) == 0 {
+			continue
+		}
+
+		err := r.rollups[i].Target.WritePoints(points)
+		if err != nil {
+			logger.Red("rollup", "Error writing %d points for %s rollup: %s", len(points), r.rollups[i].Interval, err.Error())
+		}
+	}
+}
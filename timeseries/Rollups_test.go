@@ -0,0 +1,87 @@
+package timeseries
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+type (
+	memoryDatabase struct {
+		sync.Mutex
+		points []*Point
+	}
+)
+
+func (m *memoryDatabase) WritePoints(points []*Point) error {
+	m.Lock()
+	m.points = append(m.points, points...)
+	m.Unlock()
+
+	return nil
+}
+
+func (m *memoryDatabase) Query(q Query) ([]Series, error) {
+	return nil, nil
+}
+
+func TestRollups(t *testing.T) {
+	raw := &memoryDatabase{}
+	target := &memoryDatabase{}
+
+	r := NewRollups(raw, []Rollup{{Interval: time.Minute, Suffix: ".1m", Target: target}})
+
+	start := time.Date(2016, 1, 2, 3, 4, 0, 0, time.UTC)
+
+	var points []*Point
+	for i := 0; i < 120; i++ {
+		points = append(points, NewPoint("cpu", map[string]string{"hostname": "a"}, map[string]interface{}{"value": float64(i), "text": "ignored"}, start.Add(time.Duration(i)*time.Second)))
+	}
+
+	err := r.WritePoints(points)
+	if err != nil {
+		t.Fatalf("WritePoints() failed: %s", err.Error())
+	}
+
+	if len(raw.points) != 120 {
+		t.Errorf("WritePoints() wrote %d raw points, expected 120", len(raw.points))
+	}
+
+	// Only the first bucket has ended.
+	r.flush(start.Add(time.Minute + 30*time.Second))
+
+	if len(target.points) != 1 {
+		t.Fatalf("flush() wrote %d points, expected 1", len(target.points))
+	}
+
+	p := target.points[0]
+	if p.Name != "cpu.1m" || !p.Time.Equal(start) || p.Tags["hostname"] != "a" {
+		t.Errorf("flush() wrote wrong point: %+v", p)
+	}
+
+	expected := map[string]interface{}{
+		"value_min":   0.0,
+		"value_max":   59.0,
+		"value_mean":  29.5,
+		"value_count": 60,
+	}
+
+	if len(p.Fields) != len(expected) {
+		t.Errorf("flush() wrote wrong fields: %+v", p.Fields)
+	}
+
+	for field, value := range expected {
+		if p.Fields[field] != value {
+			t.Errorf("%s is %v, expected %v", field, p.Fields[field], value)
+		}
+	}
+
+	// Late points for a flushed bucket are dropped.
+	r.WritePoints([]*Point{NewPoint("cpu", map[string]string{"hostname": "a"}, map[string]interface{}{"value": 1000.0}, start)})
+
+	r.Close()
+
+	if len(target.points) != 2 || target.points[1].Fields["value_max"] != 119.0 {
+		t.Errorf("Close() did not flush the last bucket correctly: %+v", target.points)
+	}
+}
@@ -0,0 +1,316 @@
+package timeseries
+
+import (
+	"errors"
+	"math"
+	"math/bits"
+	"time"
+)
+
+// This is an implementation of the compression described in "Gorilla: A
+// Fast, Scalable, In-Memory Time Series Database" by Pelkonen et al.
+// Timestamps are stored as delta-of-deltas in milliseconds and values are
+// XOR'ed with the previous value.
+
+type (
+	bitWriter struct {
+		buf   []byte
+		count uint8 // Number of free bits in the last byte
+	}
+
+	bitReader struct {
+		buf   []byte
+		pos   int
+		count uint8 // Number of unread bits in buf[pos]
+	}
+)
+
+var (
+	errShortBlock = errors.New("unexpected end of compressed block")
+)
+
+func (w *bitWriter) writeBit(bit bool) {
+	if w.count == 0 {
+		w.buf = append(w.buf, 0)
+		w.count = 8
+	}
+
+	w.count--
+
+	if bit {
+		w.buf[len(w.buf)-1] |= 1 << w.count
+	}
+}
+
+// writeBits writes the nbits least significant bits of v.
+func (w *bitWriter) writeBits(v uint64, nbits int) {
+	for i := nbits - 1; i >= 0; i-- {
+		w.writeBit(v>>uint(i)&1 == 1)
+	}
+}
+
+func newBitReader(buf []byte) *bitReader {
+	return &bitReader{buf: buf, count: 8}
+}
+
+func (r *bitReader) readBit() (bool, error) {
+	if r.pos >= len(r.buf) {
+		return false, errShortBlock
+	}
+
+	r.count--
+	bit := r.buf[r.pos]>>r.count&1 == 1
+
+	if r.count == 0 {
+		r.pos++
+		r.count = 8
+	}
+
+	return bit, nil
+}
+
+func (r *bitReader) readBits(nbits int) (uint64, error) {
+	var v uint64
+
+	for i := 0; i < nbits; i++ {
+		bit, err := r.readBit()
+		if err != nil {
+			return 0, err
+		}
+
+		v <<= 1
+		if bit {
+			v |= 1
+		}
+	}
+
+	return v, nil
+}
+
+// signed interprets the nbits least significant bits of v as two's
+// complement.
+func signed(v uint64, nbits int) int64 {
+	shift := uint(64 - nbits)
+
+	return int64(v<<shift) >> shift
+}
+
+// encodeSamples compresses samples. The samples must be sorted by time.
+func encodeSamples(samples []Sample) []byte {
+	w := &bitWriter{}
+
+	var prevTime, prevDelta int64
+	var prevValue uint64
+	var prevLeading, prevTrailing int = -1, 0
+
+	for i, s := range samples {
+		t := s.Time.UnixNano() / int64(time.Millisecond)
+		v := math.Float64bits(s.Value)
+
+		if i == 0 {
+			w.writeBits(uint64(t), 64)
+			w.writeBits(v, 64)
+
+			prevTime = t
+			prevValue = v
+
+			continue
+		}
+
+		delta := t - prevTime
+		dod := delta - prevDelta
+
+		switch {
+		case i == 1:
+			w.writeBits(uint64(delta), 64)
+		case dod == 0:
+			w.writeBit(false)
+		case dod >= -63 && dod <= 63:
+			w.writeBits(0x02, 2)
+			w.writeBits(uint64(dod), 7)
+		case dod >= -255 && dod <= 255:
+			w.writeBits(0x06, 3)
+			w.writeBits(uint64(dod), 9)
+		case dod >= -2047 && dod <= 2047:
+			w.writeBits(0x0e, 4)
+			w.writeBits(uint64(dod), 12)
+		default:
+			w.writeBits(0x0f, 4)
+			w.writeBits(uint64(dod), 64)
+		}
+
+		prevTime = t
+		prevDelta = delta
+
+		xor := v ^ prevValue
+		prevValue = v
+
+		if xor == 0 {
+			w.writeBit(false)
+			continue
+		}
+
+		w.writeBit(true)
+
+		leading := bits.LeadingZeros64(xor)
+		trailing := bits.TrailingZeros64(xor)
+
+		// The number of leading zeros is stored in 5 bits.
+		if leading > 31 {
+			leading = 31
+		}
+
+		if prevLeading >= 0 && leading >= prevLeading && trailing >= prevTrailing {
+			// Reuse the window of meaningful bits from the previous value.
+			w.writeBit(false)
+			w.writeBits(xor>>uint(prevTrailing), 64-prevLeading-prevTrailing)
+
+			continue
+		}
+
+		meaningful := 64 - leading - trailing
+
+		w.writeBit(true)
+		w.writeBits(uint64(leading), 5)
+		// 64 meaningful bits are stored as 0.
+		w.writeBits(uint64(meaningful), 6)
+		w.writeBits(xor>>uint(trailing), meaningful)
+
+		prevLeading = leading
+		prevTrailing = trailing
+	}
+
+	return w.buf
+}
+
+// decodeSamples decompresses count samples from block.
+func decodeSamples(block []byte, count int) ([]Sample, error) {
+	r := newBitReader(block)
+	samples := make([]Sample, 0, count)
+
+	var prevTime, prevDelta int64
+	var prevValue uint64
+	var prevLeading, prevTrailing int
+
+	for i := 0; i < count; i++ {
+		var t int64
+		var v uint64
+
+		if i == 0 {
+			ut, err := r.readBits(64)
+			if err != nil {
+				return nil, err
+			}
+
+			v, err = r.readBits(64)
+			if err != nil {
+				return nil, err
+			}
+
+			t = int64(ut)
+		} else {
+			var delta int64
+
+			if i == 1 {
+				d, err := r.readBits(64)
+				if err != nil {
+					return nil, err
+				}
+
+				delta = int64(d)
+			} else {
+				// Count the number of leading ones, at most 4.
+				var prefix int
+				for prefix < 4 {
+					bit, err := r.readBit()
+					if err != nil {
+						return nil, err
+					}
+
+					if !bit {
+						break
+					}
+
+					prefix++
+				}
+
+				var nbits int
+				switch prefix {
+				case 1:
+					nbits = 7
+				case 2:
+					nbits = 9
+				case 3:
+					nbits = 12
+				case 4:
+					nbits = 64
+				}
+
+				var dod int64
+				if nbits > 0 {
+					d, err := r.readBits(nbits)
+					if err != nil {
+						return nil, err
+					}
+
+					dod = signed(d, nbits)
+				}
+
+				delta = prevDelta + dod
+			}
+
+			t = prevTime + delta
+			prevDelta = delta
+
+			changed, err := r.readBit()
+			if err != nil {
+				return nil, err
+			}
+
+			v = prevValue
+
+			if changed {
+				newWindow, err := r.readBit()
+				if err != nil {
+					return nil, err
+				}
+
+				if newWindow {
+					l, err := r.readBits(5)
+					if err != nil {
+						return nil, err
+					}
+
+					m, err := r.readBits(6)
+					if err != nil {
+						return nil, err
+					}
+
+					if m == 0 {
+						m = 64
+					}
+
+					prevLeading = int(l)
+					prevTrailing = 64 - prevLeading - int(m)
+				}
+
+				xor, err := r.readBits(64 - prevLeading - prevTrailing)
+				if err != nil {
+					return nil, err
+				}
+
+				v = prevValue ^ xor<<uint(prevTrailing)
+			}
+		}
+
+		prevTime = t
+		prevValue = v
+
+		samples = append(samples, Sample{
+			Time:  time.Unix(0, t*int64(time.Millisecond)),
+			Value: math.Float64frombits(v),
+		})
+	}
+
+	return samples, nil
+}
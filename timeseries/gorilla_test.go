@@ -0,0 +1,73 @@
+package timeseries
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestGorillaRoundtrip(t *testing.T) {
+	start := time.Unix(1500000000, 0)
+
+	cases := [][]Sample{
+		{},
+		{{start, 1.0}},
+		{{start, 1.0}, {start.Add(time.Second), 1.0}},
+		{{start, 0}, {start.Add(10 * time.Second), math.Inf(1)}, {start.Add(20 * time.Second), -3.5}, {start.Add(20*time.Second + time.Millisecond), math.MaxFloat64}},
+	}
+
+	// Regular intervals with jitter and slowly changing values.
+	var generated []Sample
+	ts := start
+	value := 100.0
+	for i := 0; i < 10000; i++ {
+		ts = ts.Add(10*time.Second + time.Duration(rand.Intn(5000)-2500)*time.Millisecond)
+		if rand.Intn(10) == 0 {
+			ts = ts.Add(time.Duration(rand.Intn(1000000)) * time.Second)
+		}
+
+		value += rand.Float64() - 0.5
+		generated = append(generated, Sample{ts, value})
+	}
+	cases = append(cases, generated)
+
+	for i, samples := range cases {
+		block := encodeSamples(samples)
+
+		decoded, err := decodeSamples(block, len(samples))
+		if err != nil {
+			t.Fatalf("%d: decodeSamples() failed: %s", i, err.Error())
+		}
+
+		for j := range samples {
+			if !decoded[j].Time.Equal(samples[j].Time) || decoded[j].Value != samples[j].Value {
+				t.Fatalf("%d: sample %d decoded as %v, expected %v", i, j, decoded[j], samples[j])
+			}
+		}
+	}
+}
+
+func TestGorillaCompression(t *testing.T) {
+	start := time.Unix(1500000000, 0)
+
+	samples := make([]Sample, 1000)
+	for i := range samples {
+		samples[i] = Sample{start.Add(time.Duration(i) * 10 * time.Second), float64(i % 3)}
+	}
+
+	block := encodeSamples(samples)
+	if len(block) > len(samples)*16/4 {
+		t.Errorf("encodeSamples() compressed %d samples to %d bytes", len(samples), len(block))
+	}
+}
+
+func TestGorillaShortBlock(t *testing.T) {
+	start := time.Unix(1500000000, 0)
+	block := encodeSamples([]Sample{{start, 1}, {start.Add(time.Second), 2}})
+
+	_, err := decodeSamples(block[:len(block)-1], 2)
+	if err != errShortBlock {
+		t.Errorf("decodeSamples() should fail on short block, got %v", err)
+	}
+}
@@ -1,7 +1,27 @@
 package timeseries
 
+import (
+	"io"
+)
+
 type (
 	Database interface {
 		WritePoints(points []*Point) error
+		Query(q Query) ([]Series, error)
+	}
+
+	// Wrapper is implemented by databases adding functionality to another
+	// database.
+	Wrapper interface {
+		Unwrap() Database
 	}
 )
+
+// closeDatabase closes db if it can be closed.
+func closeDatabase(db Database) error {
+	if closer, ok := db.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
+}
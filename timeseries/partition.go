@@ -0,0 +1,382 @@
+package timeseries
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type (
+	// memSeries holds the samples of one field of one series in memory.
+	memSeries struct {
+		name    string
+		field   string
+		tags    map[string]string
+		samples []Sample
+	}
+
+	// partition holds all series for a time window. Partitions are
+	// persisted as one file each.
+	partition struct {
+		start      time.Time
+		resolution time.Duration
+		series     map[string]*memSeries
+		dirty      bool
+	}
+)
+
+const (
+	partitionMagic  = "AGTSDB1\n"
+	partitionSuffix = ".tsdb"
+
+	// maxStringLength guards against allocating huge buffers when reading
+	// corrupted files.
+	maxStringLength = 1 << 16
+)
+
+var (
+	errBadPartition = errors.New("not a partition file")
+)
+
+func newPartition(start time.Time) *partition {
+	return &partition{
+		start:  start,
+		series: make(map[string]*memSeries),
+	}
+}
+
+// seriesKey returns a string uniquely identifying name, tags and field.
+func seriesKey(name string, tags map[string]string, field string) string {
+	keys := make([]string, 0, len(tags))
+	for key := range tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(name)
+	for _, key := range keys {
+		b.WriteByte(0)
+		b.WriteString(key)
+		b.WriteByte('=')
+		b.WriteString(tags[key])
+	}
+	b.WriteByte(0)
+	b.WriteString(field)
+
+	return b.String()
+}
+
+// add adds a sample for the field of a series.
+func (p *partition) add(name string, tags map[string]string, field string, sample Sample) {
+	key := seriesKey(name, tags, field)
+
+	s, found := p.series[key]
+	if !found {
+		s = &memSeries{
+			name:  name,
+			field: field,
+			tags:  tags,
+		}
+		p.series[key] = s
+	}
+
+	s.samples = append(s.samples, sample)
+	p.dirty = true
+}
+
+// compact sorts samples by time. If more than one sample exists for the
+// same millisecond, the last one added wins.
+func (s *memSeries) compact() {
+	sort.SliceStable(s.samples, func(i, j int) bool {
+		return s.samples[i].Time.Before(s.samples[j].Time)
+	})
+
+	compacted := s.samples[:0]
+	for _, sample := range s.samples {
+		sample.Time = sample.Time.Truncate(time.Millisecond)
+
+		if len(compacted) > 0 && compacted[len(compacted)-1].Time.Equal(sample.Time) {
+			compacted[len(compacted)-1] = sample
+			continue
+		}
+
+		compacted = append(compacted, sample)
+	}
+
+	s.samples = compacted
+}
+
+// downsample replaces the samples of all series with the mean of each
+// interval of resolution.
+func (p *partition) downsample(resolution time.Duration) {
+	for _, s := range p.series {
+		s.compact()
+		s.samples = aggregate(s.samples, resolution, "mean")
+	}
+
+	p.resolution = resolution
+	p.dirty = true
+}
+
+// partitionPath returns the path of the file for the partition starting at
+// start.
+func partitionPath(dir string, start time.Time) string {
+	return filepath.Join(dir, strconv.FormatInt(start.Unix(), 10)+partitionSuffix)
+}
+
+// listPartitions returns the start time of all partition files in dir.
+func listPartitions(dir string) ([]time.Time, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*"+partitionSuffix))
+	if err != nil {
+		return nil, err
+	}
+
+	starts := make([]time.Time, 0, len(matches))
+	for _, match := range matches {
+		unix, err := strconv.ParseInt(strings.TrimSuffix(filepath.Base(match), partitionSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+
+		starts = append(starts, time.Unix(unix, 0))
+	}
+
+	sort.Slice(starts, func(i, j int) bool {
+		return starts[i].Before(starts[j])
+	})
+
+	return starts, nil
+}
+
+func writeUvarint(w *bufio.Writer, v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	w.Write(buf[:n])
+}
+
+func writeString(w *bufio.Writer, s string) {
+	writeUvarint(w, uint64(len(s)))
+	w.WriteString(s)
+}
+
+// write persists the partition to dir. The file is replaced atomically.
+func (p *partition) write(dir string) error {
+	path := partitionPath(dir, p.start)
+	tmp := path + ".tmp"
+
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(p.series))
+	for key := range p.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	w := bufio.NewWriter(f)
+	w.WriteString(partitionMagic)
+	writeUvarint(w, uint64(p.start.Unix()))
+	writeUvarint(w, uint64(p.resolution/time.Second))
+	writeUvarint(w, uint64(len(keys)))
+
+	for _, key := range keys {
+		s := p.series[key]
+		s.compact()
+
+		writeString(w, s.name)
+		writeString(w, s.field)
+
+		writeUvarint(w, uint64(len(s.tags)))
+		tagKeys := make([]string, 0, len(s.tags))
+		for k := range s.tags {
+			tagKeys = append(tagKeys, k)
+		}
+		sort.Strings(tagKeys)
+		for _, k := range tagKeys {
+			writeString(w, k)
+			writeString(w, s.tags[k])
+		}
+
+		block := encodeSamples(s.samples)
+		writeUvarint(w, uint64(len(s.samples)))
+		writeUvarint(w, uint64(len(block)))
+		w.Write(block)
+	}
+
+	err = w.Flush()
+	if err == nil {
+		err = f.Sync()
+	}
+
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	err = os.Rename(tmp, path)
+	if err != nil {
+		return err
+	}
+
+	p.dirty = false
+
+	return nil
+}
+
+func readString(r *bufio.Reader) (string, error) {
+	l, err := binary.ReadUvarint(r)
+	if err != nil {
+		return "", err
+	}
+
+	if l > maxStringLength {
+		return "", errBadPartition
+	}
+
+	buf := make([]byte, l)
+	_, err = io.ReadFull(r, buf)
+
+	return string(buf), err
+}
+
+// readPartitionHeader reads the start and resolution of a partition.
+func readPartitionHeader(r *bufio.Reader) (time.Time, time.Duration, error) {
+	magic := make([]byte, len(partitionMagic))
+	_, err := io.ReadFull(r, magic)
+	if err != nil || string(magic) != partitionMagic {
+		return time.Time{}, 0, errBadPartition
+	}
+
+	start, err := binary.ReadUvarint(r)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+
+	resolution, err := binary.ReadUvarint(r)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+
+	return time.Unix(int64(start), 0), time.Duration(resolution) * time.Second, nil
+}
+
+// partitionResolution returns the resolution of the partition file
+// starting at start without reading the samples.
+func partitionResolution(dir string, start time.Time) (time.Duration, error) {
+	f, err := os.Open(partitionPath(dir, start))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	_, resolution, err := readPartitionHeader(bufio.NewReader(f))
+
+	return resolution, err
+}
+
+// readPartition reads the partition starting at start from dir.
+func readPartition(dir string, start time.Time) (*partition, error) {
+	f, err := os.Open(partitionPath(dir, start))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+
+	s, resolution, err := readPartitionHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	p := newPartition(s)
+	p.resolution = resolution
+
+	count, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := uint64(0); i < count; i++ {
+		s := &memSeries{}
+
+		s.name, err = readString(r)
+		if err != nil {
+			return nil, err
+		}
+
+		s.field, err = readString(r)
+		if err != nil {
+			return nil, err
+		}
+
+		ntags, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+
+		if ntags > maxStringLength {
+			return nil, errBadPartition
+		}
+
+		s.tags = make(map[string]string, ntags)
+		for j := uint64(0); j < ntags; j++ {
+			k, err := readString(r)
+			if err != nil {
+				return nil, err
+			}
+
+			v, err := readString(r)
+			if err != nil {
+				return nil, err
+			}
+
+			s.tags[k] = v
+		}
+
+		nsamples, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+
+		blockLen, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+
+		// A block can never be larger than the worst case encoding.
+		if nsamples > blockLen*8 || blockLen > (nsamples+1)*20 {
+			return nil, errBadPartition
+		}
+
+		block := make([]byte, blockLen)
+		_, err = io.ReadFull(r, block)
+		if err != nil {
+			return nil, err
+		}
+
+		s.samples, err = decodeSamples(block, int(nsamples))
+		if err != nil {
+			return nil, err
+		}
+
+		p.series[seriesKey(s.name, s.tags, s.field)] = s
+	}
+
+	return p, nil
+}
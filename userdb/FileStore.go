@@ -0,0 +1,305 @@
+package userdb
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+type (
+	// FileStore is a Store keeping everything in memory. If a path is given,
+	// the contents will be persisted as JSON to that path on every change.
+	FileStore struct {
+		lock sync.RWMutex
+		path string
+		data fileStoreData
+	}
+
+	fileStoreData struct {
+		Accounts map[string]AccountRecord `json:"accounts"`
+		Users    map[string]UserRecord    `json:"users"`
+		Keys     map[string]KeyRecord     `json:"keys"`
+		Sessions map[string]Session       `json:"sessions"`
+	}
+)
+
+// NewFileStore will instantiate a new FileStore. If path is empty, nothing
+// will be persisted.
+func NewFileStore(path string) (*FileStore, error) {
+	s := &FileStore{
+		path: path,
+		data: fileStoreData{
+			Accounts: make(map[string]AccountRecord),
+			Users:    make(map[string]UserRecord),
+			Keys:     make(map[string]KeyRecord),
+			Sessions: make(map[string]Session),
+		},
+	}
+
+	if path == "" {
+		return s, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = json.Unmarshal(b, &s.data)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// copyUser returns a deep copy of user, to avoid sharing slices and maps
+// with callers.
+func copyUser(user UserRecord) UserRecord {
+	u := user
+	u.AccountIDs = append([]string(nil), user.AccountIDs...)
+
+	if user.Roles != nil {
+		u.Roles = make(map[string]Role, len(user.Roles))
+		for id, role := range user.Roles {
+			u.Roles[id] = role
+		}
+	}
+
+	return u
+}
+
+// save will persist the store to disk. The caller must hold the write lock.
+func (s *FileStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(s.data, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, to avoid leaving a half-written
+	// file behind.
+	tmp := s.path + ".tmp"
+	err = ioutil.WriteFile(tmp, b, 0600)
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, s.path)
+}
+
+// GetAccounts implements Store.
+func (s *FileStore) GetAccounts() ([]*AccountRecord, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	accounts := make([]*AccountRecord, 0, len(s.data.Accounts))
+	for _, a := range s.data.Accounts {
+		account := a
+		accounts = append(accounts, &account)
+	}
+
+	return accounts, nil
+}
+
+// GetAccount implements Store.
+func (s *FileStore) GetAccount(id string) (*AccountRecord, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	account, found := s.data.Accounts[id]
+	if !found {
+		return nil, ErrorInvalidAccountId
+	}
+
+	return &account, nil
+}
+
+// SaveAccount implements Store.
+func (s *FileStore) SaveAccount(account *AccountRecord) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.data.Accounts[account.ID] = *account
+
+	return s.save()
+}
+
+// DeleteAccount implements Store.
+func (s *FileStore) DeleteAccount(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	_, found := s.data.Accounts[id]
+	if !found {
+		return ErrorInvalidAccountId
+	}
+
+	delete(s.data.Accounts, id)
+
+	return s.save()
+}
+
+// GetUsers implements Store.
+func (s *FileStore) GetUsers() ([]*UserRecord, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	users := make([]*UserRecord, 0, len(s.data.Users))
+	for _, u := range s.data.Users {
+		user := copyUser(u)
+		users = append(users, &user)
+	}
+
+	return users, nil
+}
+
+// GetUser implements Store.
+func (s *FileStore) GetUser(id string) (*UserRecord, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	u, found := s.data.Users[id]
+	if !found {
+		return nil, ErrorInvalidUserId
+	}
+
+	user := copyUser(u)
+
+	return &user, nil
+}
+
+// SaveUser implements Store.
+func (s *FileStore) SaveUser(user *UserRecord) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.data.Users[user.ID] = copyUser(*user)
+
+	return s.save()
+}
+
+// DeleteUser implements Store.
+func (s *FileStore) DeleteUser(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	_, found := s.data.Users[id]
+	if !found {
+		return ErrorInvalidUserId
+	}
+
+	delete(s.data.Users, id)
+
+	return s.save()
+}
+
+// GetKeys implements Store.
+func (s *FileStore) GetKeys(ownerID string) ([]*KeyRecord, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	var keys []*KeyRecord
+	for _, k := range s.data.Keys {
+		if k.OwnerID == ownerID {
+			key := k
+			keys = append(keys, &key)
+		}
+	}
+
+	return keys, nil
+}
+
+// GetKey implements Store.
+func (s *FileStore) GetKey(id string) (*KeyRecord, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	key, found := s.data.Keys[id]
+	if !found {
+		return nil, ErrorKeyNotFound
+	}
+
+	return &key, nil
+}
+
+// GetKeyByHash implements Store.
+func (s *FileStore) GetKeyByHash(hash string) (*KeyRecord, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	for _, k := range s.data.Keys {
+		if k.Hash == hash {
+			key := k
+			return &key, nil
+		}
+	}
+
+	return nil, ErrorKeyNotFound
+}
+
+// SaveKey implements Store.
+func (s *FileStore) SaveKey(key *KeyRecord) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.data.Keys[key.ID] = *key
+
+	return s.save()
+}
+
+// GetSession implements SessionStore.
+func (s *FileStore) GetSession(id string) (*Session, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	session, found := s.data.Sessions[id]
+	if !found {
+		return nil, ErrorSessionNotFound
+	}
+
+	return &session, nil
+}
+
+// SaveSession implements SessionStore.
+func (s *FileStore) SaveSession(session *Session) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// Take the opportunity to forget about expired sessions.
+	for id, session := range s.data.Sessions {
+		if session.IsExpired() {
+			delete(s.data.Sessions, id)
+		}
+	}
+
+	s.data.Sessions[session.ID] = *session
+
+	return s.save()
+}
+
+// DeleteSession implements SessionStore.
+func (s *FileStore) DeleteSession(id string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	_, found := s.data.Sessions[id]
+	if !found {
+		return ErrorSessionNotFound
+	}
+
+	delete(s.data.Sessions, id)
+
+	return s.save()
+}
+
+// Ensure compliance
+var _ Store = (*FileStore)(nil)
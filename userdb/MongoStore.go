@@ -0,0 +1,219 @@
+package userdb
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+type (
+	// MongoStore is a Store using MongoDB as a backend.
+	MongoStore struct {
+		sess              *mgo.Session
+		accountCollection *mgo.Collection
+		userCollection    *mgo.Collection
+		keyCollection     *mgo.Collection
+		sessionCollection *mgo.Collection
+	}
+)
+
+// NewMongoStore will connect to MongoDB at url and use database for storing
+// accounts, users and keys.
+func NewMongoStore(url string, database string) (*MongoStore, error) {
+	sess, err := mgo.Dial(url)
+	if err != nil {
+		return nil, err
+	}
+
+	db := sess.DB(database)
+
+	s := &MongoStore{
+		sess:              sess,
+		accountCollection: db.C("accounts"),
+		userCollection:    db.C("users"),
+		keyCollection:     db.C("keys"),
+		sessionCollection: db.C("sessions"),
+	}
+
+	err = s.keyCollection.EnsureIndex(mgo.Index{Key: []string{"hash"}})
+	if err != nil {
+		return nil, err
+	}
+
+	// Let MongoDB remove expired sessions.
+	err = s.sessionCollection.EnsureIndex(mgo.Index{Key: []string{"expires"}, ExpireAfter: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// GetAccounts implements Store.
+func (s *MongoStore) GetAccounts() ([]*AccountRecord, error) {
+	var accounts []*AccountRecord
+
+	err := s.accountCollection.Find(nil).All(&accounts)
+
+	return accounts, err
+}
+
+// GetAccount implements Store.
+func (s *MongoStore) GetAccount(id string) (*AccountRecord, error) {
+	var account AccountRecord
+
+	err := s.accountCollection.FindId(id).One(&account)
+	if err == mgo.ErrNotFound {
+		return nil, ErrorInvalidAccountId
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &account, nil
+}
+
+// SaveAccount implements Store.
+func (s *MongoStore) SaveAccount(account *AccountRecord) error {
+	_, err := s.accountCollection.UpsertId(account.ID, account)
+
+	return err
+}
+
+// DeleteAccount implements Store.
+func (s *MongoStore) DeleteAccount(id string) error {
+	err := s.accountCollection.RemoveId(id)
+	if err == mgo.ErrNotFound {
+		return ErrorInvalidAccountId
+	}
+
+	return err
+}
+
+// GetUsers implements Store.
+func (s *MongoStore) GetUsers() ([]*UserRecord, error) {
+	var users []*UserRecord
+
+	err := s.userCollection.Find(nil).All(&users)
+
+	return users, err
+}
+
+// GetUser implements Store.
+func (s *MongoStore) GetUser(id string) (*UserRecord, error) {
+	var user UserRecord
+
+	err := s.userCollection.FindId(id).One(&user)
+	if err == mgo.ErrNotFound {
+		return nil, ErrorInvalidUserId
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
+
+// SaveUser implements Store.
+func (s *MongoStore) SaveUser(user *UserRecord) error {
+	_, err := s.userCollection.UpsertId(user.ID, user)
+
+	return err
+}
+
+// DeleteUser implements Store.
+func (s *MongoStore) DeleteUser(id string) error {
+	err := s.userCollection.RemoveId(id)
+	if err == mgo.ErrNotFound {
+		return ErrorInvalidUserId
+	}
+
+	return err
+}
+
+// GetKeys implements Store.
+func (s *MongoStore) GetKeys(ownerID string) ([]*KeyRecord, error) {
+	var keys []*KeyRecord
+
+	err := s.keyCollection.Find(bson.M{"owner": ownerID}).All(&keys)
+
+	return keys, err
+}
+
+// GetKey implements Store.
+func (s *MongoStore) GetKey(id string) (*KeyRecord, error) {
+	var key KeyRecord
+
+	err := s.keyCollection.FindId(id).One(&key)
+	if err == mgo.ErrNotFound {
+		return nil, ErrorKeyNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+// GetKeyByHash implements Store.
+func (s *MongoStore) GetKeyByHash(hash string) (*KeyRecord, error) {
+	var key KeyRecord
+
+	err := s.keyCollection.Find(bson.M{"hash": hash}).One(&key)
+	if err == mgo.ErrNotFound {
+		return nil, ErrorKeyNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &key, nil
+}
+
+// SaveKey implements Store.
+func (s *MongoStore) SaveKey(key *KeyRecord) error {
+	_, err := s.keyCollection.UpsertId(key.ID, key)
+
+	return err
+}
+
+// GetSession implements SessionStore.
+func (s *MongoStore) GetSession(id string) (*Session, error) {
+	var session Session
+
+	err := s.sessionCollection.FindId(id).One(&session)
+	if err == mgo.ErrNotFound {
+		return nil, ErrorSessionNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &session, nil
+}
+
+// SaveSession implements SessionStore.
+func (s *MongoStore) SaveSession(session *Session) error {
+	_, err := s.sessionCollection.UpsertId(session.ID, session)
+
+	return err
+}
+
+// DeleteSession implements SessionStore.
+func (s *MongoStore) DeleteSession(id string) error {
+	err := s.sessionCollection.RemoveId(id)
+	if err == mgo.ErrNotFound {
+		return ErrorSessionNotFound
+	}
+
+	return err
+}
+
+// Ensure compliance
+var _ Store = (*MongoStore)(nil)
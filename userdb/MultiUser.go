@@ -0,0 +1,650 @@
+package userdb
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type (
+	// MultiUser implements Database for a multiuser system. Accounts, users
+	// and API keys are persisted in a Store.
+	MultiUser struct {
+		store    Store
+		adminKey string
+	}
+
+	// AccountRecord is an account as persisted by a Store. It implements
+	// Account.
+	AccountRecord struct {
+		ID      string    `json:"id" bson:"_id"`
+		Name    string    `json:"name" bson:"name"`
+		Created time.Time `json:"created" bson:"created"`
+
+		db *MultiUser
+	}
+
+	// UserRecord is a user as persisted by a Store. It implements User.
+	UserRecord struct {
+		ID         string          `json:"id" bson:"_id"`
+		Name       string          `json:"name" bson:"name"`
+		AccountIDs []string        `json:"accounts" bson:"accounts"`
+		Roles      map[string]Role `json:"roles,omitempty" bson:"roles,omitempty"`
+		Password   string          `json:"password,omitempty" bson:"password,omitempty"`
+		Created    time.Time       `json:"created" bson:"created"`
+
+		db *MultiUser
+	}
+
+	// KeyRecord is a named API key belonging to an account or a user. Only a
+	// hash of the key itself is stored.
+	KeyRecord struct {
+		ID      string    `json:"id" bson:"_id"`
+		Name    string    `json:"name" bson:"name"`
+		OwnerID string    `json:"owner" bson:"owner"`
+		Hash    string    `json:"hash,omitempty" bson:"hash"`
+		Scope   Scope     `json:"scope" bson:"scope"`
+		Created time.Time `json:"created" bson:"created"`
+		Rotated time.Time `json:"rotated" bson:"rotated"`
+		Revoked time.Time `json:"revoked" bson:"revoked"`
+	}
+
+	// scopedAccount is an account authenticated by a key with a limited
+	// scope.
+	scopedAccount struct {
+		*AccountRecord
+		scope Scope
+	}
+
+	// scopedUser is a user authenticated by a key with a limited scope.
+	scopedUser struct {
+		*UserRecord
+		scope Scope
+	}
+)
+
+var (
+	// ErrorInvalidKey will be returned if an API key cannot be resolved.
+	ErrorInvalidKey = errors.New("invalid key")
+
+	// ErrorKeyNotFound will be returned if a key id is unknown.
+	ErrorKeyNotFound = errors.New("key not found")
+
+	// ErrorUserExists will be returned if a username is already in use.
+	ErrorUserExists = errors.New("user already exists")
+
+	// ErrorEmptyPassword will be returned when trying to set an empty
+	// password.
+	ErrorEmptyPassword = errors.New("password cannot be empty")
+
+	// dummyHash is compared against when logging in as an unknown user, to
+	// avoid revealing which usernames exist through timing.
+	dummyHash, _ = bcrypt.GenerateFromPassword([]byte("agento"), bcrypt.DefaultCost)
+)
+
+// NewMultiUser instantiates a new multiuser database backed by store. If
+// adminKey is not empty, it will resolve to God, and can be used to bootstrap
+// accounts and users.
+func NewMultiUser(store Store, adminKey string) *MultiUser {
+	return &MultiUser{
+		store:    store,
+		adminKey: adminKey,
+	}
+}
+
+// newID will generate a new random id looking like a MongoDB ObjectId.
+func newID() string {
+	b := make([]byte, 12)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		panic("error reading from random source: " + err.Error())
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// newKey generates a new random API key.
+func newKey() string {
+	b := make([]byte, 24)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		panic("error reading from random source: " + err.Error())
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// ResolveKey implements Database.
+func (m *MultiUser) ResolveKey(key string) (Subject, error) {
+	if key == "" {
+		return nil, ErrorInvalidKey
+	}
+
+	if m.adminKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(m.adminKey)) == 1 {
+		return God, nil
+	}
+
+	k, err := m.store.GetKeyByHash(hashKey(key))
+	if err != nil {
+		return nil, ErrorInvalidKey
+	}
+
+	if k.IsRevoked() {
+		return nil, ErrorInvalidKey
+	}
+
+	account, err := m.GetAccount(k.OwnerID)
+	if err == nil {
+		if k.Scope == ScopeFull {
+			return account, nil
+		}
+
+		return &scopedAccount{AccountRecord: account, scope: k.Scope}, nil
+	}
+
+	user, err := m.GetUser(k.OwnerID)
+	if err == nil {
+		if k.Scope == ScopeFull {
+			return user, nil
+		}
+
+		return &scopedUser{UserRecord: user, scope: k.Scope}, nil
+	}
+
+	return nil, ErrorInvalidKey
+}
+
+// ResolveCookie implements Database.
+func (m *MultiUser) ResolveCookie(value string) (User, error) {
+	s, err := m.GetSession(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.GetUser(s.UserID)
+}
+
+// Login implements Database.
+func (m *MultiUser) Login(username string, password string) (*Session, string, error) {
+	u, err := m.GetUserByName(username)
+	if err != nil || u.Password == "" {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+
+		return nil, "", ErrorInvalidLogin
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(u.Password), []byte(password))
+	if err != nil {
+		return nil, "", ErrorInvalidLogin
+	}
+
+	return newSession(m.store, u.ID)
+}
+
+// GetSession implements Database.
+func (m *MultiUser) GetSession(token string) (*Session, error) {
+	return getSession(m.store, token)
+}
+
+// Logout implements Database.
+func (m *MultiUser) Logout(token string) error {
+	return deleteSession(m.store, token)
+}
+
+// CreateAccount will create and save a new account.
+func (m *MultiUser) CreateAccount(name string) (*AccountRecord, error) {
+	a := &AccountRecord{
+		ID:      newID(),
+		Name:    name,
+		Created: time.Now(),
+		db:      m,
+	}
+
+	return a, a.Save()
+}
+
+// GetAccount returns the account identified by id.
+func (m *MultiUser) GetAccount(id string) (*AccountRecord, error) {
+	a, err := m.store.GetAccount(id)
+	if err != nil {
+		return nil, err
+	}
+
+	a.db = m
+
+	return a, nil
+}
+
+// GetAllAccounts returns all accounts known to the database.
+func (m *MultiUser) GetAllAccounts() ([]*AccountRecord, error) {
+	accounts, err := m.store.GetAccounts()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range accounts {
+		a.db = m
+	}
+
+	return accounts, nil
+}
+
+// DeleteAccount deletes an account. All keys belonging to the account are
+// revoked and the account is removed from all users.
+func (m *MultiUser) DeleteAccount(id string) error {
+	_, err := m.GetAccount(id)
+	if err != nil {
+		return err
+	}
+
+	keys, err := m.GetKeys(id)
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		err = m.RevokeKey(k.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	users, err := m.GetAllUsers()
+	if err != nil {
+		return err
+	}
+
+	for _, u := range users {
+		if u.hasAccount(id) {
+			u.RemoveAccount(id)
+
+			err = u.Save()
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return m.store.DeleteAccount(id)
+}
+
+// CreateUser will create and save a new user connected to accountIDs. The
+// user will have DefaultRole on all accounts until changed with SetRole().
+func (m *MultiUser) CreateUser(name string, accountIDs []string) (*UserRecord, error) {
+	_, err := m.GetUserByName(name)
+	if err == nil {
+		return nil, ErrorUserExists
+	}
+
+	for _, id := range accountIDs {
+		_, err := m.GetAccount(id)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	u := &UserRecord{
+		ID:         newID(),
+		Name:       name,
+		AccountIDs: accountIDs,
+		Created:    time.Now(),
+		db:         m,
+	}
+
+	return u, u.Save()
+}
+
+// GetUser returns the user identified by id.
+func (m *MultiUser) GetUser(id string) (*UserRecord, error) {
+	u, err := m.store.GetUser(id)
+	if err != nil {
+		return nil, err
+	}
+
+	u.db = m
+
+	return u, nil
+}
+
+// GetUserByName returns the user with the username name.
+func (m *MultiUser) GetUserByName(name string) (*UserRecord, error) {
+	users, err := m.GetAllUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, u := range users {
+		if u.Name == name {
+			return u, nil
+		}
+	}
+
+	return nil, ErrorInvalidUserId
+}
+
+// GetAllUsers returns all users known to the database.
+func (m *MultiUser) GetAllUsers() ([]*UserRecord, error) {
+	users, err := m.store.GetUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, u := range users {
+		u.db = m
+	}
+
+	return users, nil
+}
+
+// DeleteUser deletes a user and revokes all keys belonging to the user.
+func (m *MultiUser) DeleteUser(id string) error {
+	keys, err := m.GetKeys(id)
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		err = m.RevokeKey(k.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return m.store.DeleteUser(id)
+}
+
+// CreateKey will create a new named API key for the account or user
+// identified by ownerID. The key will be limited to scope. The key itself is
+// returned and cannot be retrieved later.
+func (m *MultiUser) CreateKey(ownerID string, name string, scope Scope) (*KeyRecord, string, error) {
+	err := scope.Valid()
+	if err != nil {
+		return nil, "", err
+	}
+
+	_, err = m.GetAccount(ownerID)
+	if err != nil {
+		_, err = m.GetUser(ownerID)
+	}
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	key := newKey()
+	k := &KeyRecord{
+		ID:      newID(),
+		Name:    name,
+		OwnerID: ownerID,
+		Hash:    hashKey(key),
+		Scope:   scope,
+		Created: time.Now(),
+	}
+
+	return k, key, m.store.SaveKey(k)
+}
+
+// GetKey returns the key record identified by id.
+func (m *MultiUser) GetKey(id string) (*KeyRecord, error) {
+	return m.store.GetKey(id)
+}
+
+// GetKeys returns all keys (including revoked) belonging to ownerID.
+func (m *MultiUser) GetKeys(ownerID string) ([]*KeyRecord, error) {
+	return m.store.GetKeys(ownerID)
+}
+
+// RotateKey will replace the secret part of a key. The old key will stop
+// working immediately and the new key is returned.
+func (m *MultiUser) RotateKey(id string) (*KeyRecord, string, error) {
+	k, err := m.store.GetKey(id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if k.IsRevoked() {
+		return nil, "", ErrorKeyNotFound
+	}
+
+	key := newKey()
+	k.Hash = hashKey(key)
+	k.Rotated = time.Now()
+
+	return k, key, m.store.SaveKey(k)
+}
+
+// RevokeKey revokes a key. Revoked keys are kept for reference.
+func (m *MultiUser) RevokeKey(id string) error {
+	k, err := m.store.GetKey(id)
+	if err != nil {
+		return err
+	}
+
+	if k.IsRevoked() {
+		return nil
+	}
+
+	k.Revoked = time.Now()
+
+	return m.store.SaveKey(k)
+}
+
+// IsRevoked returns true if the key has been revoked.
+func (k *KeyRecord) IsRevoked() bool {
+	return !k.Revoked.IsZero()
+}
+
+// GetId implements Subject.
+func (a *AccountRecord) GetId() string {
+	return a.ID
+}
+
+// GetAccountId implements Object. An account is owned by itself.
+func (a *AccountRecord) GetAccountId() string {
+	return a.ID
+}
+
+// CanAccess implements Subject. An account can access all objects owned by
+// the account.
+func (a *AccountRecord) CanAccess(object Object) error {
+	if object.GetAccountId() == a.ID {
+		return nil
+	}
+
+	return ErrorNoAccess
+}
+
+// Authorize implements Subject. An account has all permissions on objects
+// owned by the account.
+func (a *AccountRecord) Authorize(object Object, permission Permission) error {
+	return a.CanAccess(object)
+}
+
+// Save implements Subject.
+func (a *AccountRecord) Save() error {
+	return a.db.store.SaveAccount(a)
+}
+
+// GetUsers implements Account.
+func (a *AccountRecord) GetUsers() ([]User, error) {
+	all, err := a.db.GetAllUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	var users []User
+	for _, u := range all {
+		if u.hasAccount(a.ID) {
+			users = append(users, u)
+		}
+	}
+
+	return users, nil
+}
+
+// GetId implements Subject.
+func (u *UserRecord) GetId() string {
+	return u.ID
+}
+
+// CanAccess implements Subject. A user can access all objects owned by the
+// accounts the user is connected to.
+func (u *UserRecord) CanAccess(object Object) error {
+	if u.hasAccount(object.GetAccountId()) {
+		return nil
+	}
+
+	return ErrorNoAccess
+}
+
+// Authorize implements Subject. A user has the permissions given by the role
+// on the account owning the object.
+func (u *UserRecord) Authorize(object Object, permission Permission) error {
+	err := u.CanAccess(object)
+	if err != nil {
+		return err
+	}
+
+	if !u.Role(object.GetAccountId()).Allows(permission) {
+		return ErrorNoAccess
+	}
+
+	return nil
+}
+
+// Role returns the role of the user on the account identified by id.
+func (u *UserRecord) Role(id string) Role {
+	role, found := u.Roles[id]
+	if !found {
+		return DefaultRole
+	}
+
+	return role
+}
+
+// SetRole sets the role of the user on an account. The user must be
+// connected to the account and must be saved afterwards.
+func (u *UserRecord) SetRole(id string, role Role) error {
+	err := role.Valid()
+	if err != nil {
+		return err
+	}
+
+	if !u.hasAccount(id) {
+		return ErrorInvalidAccountId
+	}
+
+	if u.Roles == nil {
+		u.Roles = make(map[string]Role)
+	}
+
+	u.Roles[id] = role
+
+	return nil
+}
+
+// SetPassword sets the password used for logging in. Only a bcrypt hash of
+// the password is kept. The user must be saved afterwards.
+func (u *UserRecord) SetPassword(password string) error {
+	if password == "" {
+		return ErrorEmptyPassword
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	u.Password = string(hash)
+
+	return nil
+}
+
+// Save implements Subject.
+func (u *UserRecord) Save() error {
+	return u.db.store.SaveUser(u)
+}
+
+// GetAccounts implements User.
+func (u *UserRecord) GetAccounts() ([]Account, error) {
+	accounts := make([]Account, 0, len(u.AccountIDs))
+
+	for _, id := range u.AccountIDs {
+		a, err := u.db.GetAccount(id)
+		if err != nil {
+			return nil, err
+		}
+
+		accounts = append(accounts, a)
+	}
+
+	return accounts, nil
+}
+
+// AddAccount connects the user to an account. The user must be saved
+// afterwards.
+func (u *UserRecord) AddAccount(id string) {
+	if !u.hasAccount(id) {
+		u.AccountIDs = append(u.AccountIDs, id)
+	}
+}
+
+// RemoveAccount disconnects the user from an account. The user must be saved
+// afterwards.
+func (u *UserRecord) RemoveAccount(id string) {
+	for i, accountID := range u.AccountIDs {
+		if accountID == id {
+			u.AccountIDs = append(u.AccountIDs[:i], u.AccountIDs[i+1:]...)
+			delete(u.Roles, id)
+			return
+		}
+	}
+}
+
+func (u *UserRecord) hasAccount(id string) bool {
+	for _, accountID := range u.AccountIDs {
+		if accountID == id {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Authorize implements Subject. The permissions of the account are further
+// limited by the scope of the key.
+func (a *scopedAccount) Authorize(object Object, permission Permission) error {
+	if !a.scope.Allows(permission) {
+		return ErrorNoAccess
+	}
+
+	return a.AccountRecord.Authorize(object, permission)
+}
+
+// Authorize implements Subject. The permissions of the user are further
+// limited by the scope of the key.
+func (u *scopedUser) Authorize(object Object, permission Permission) error {
+	if !u.scope.Allows(permission) {
+		return ErrorNoAccess
+	}
+
+	return u.UserRecord.Authorize(object, permission)
+}
+
+// Ensure compliance
+var _ Database = (*MultiUser)(nil)
+var _ Account = (*AccountRecord)(nil)
+var _ User = (*UserRecord)(nil)
+var _ Account = (*scopedAccount)(nil)
+var _ User = (*scopedUser)(nil)
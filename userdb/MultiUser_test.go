@@ -0,0 +1,234 @@
+package userdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func newTestMultiUser(t *testing.T) *MultiUser {
+	store, err := NewFileStore("")
+	if err != nil {
+		t.Fatalf("NewFileStore() failed: %s", err.Error())
+	}
+
+	return NewMultiUser(store, "admin")
+}
+
+func TestMultiUserResolveKey(t *testing.T) {
+	db := newTestMultiUser(t)
+
+	subject, err := db.ResolveKey("admin")
+	if err != nil || !IsGod(subject) {
+		t.Errorf("Admin key did not resolve to God")
+	}
+
+	_, err = db.ResolveKey("")
+	if err == nil {
+		t.Errorf("Empty key resolved")
+	}
+
+	account, _ := db.CreateAccount("test")
+	key, secret, err := db.CreateKey(account.ID, "reporting", ScopeFull)
+	if err != nil {
+		t.Fatalf("CreateKey() failed: %s", err.Error())
+	}
+
+	subject, err = db.ResolveKey(secret)
+	if err != nil {
+		t.Fatalf("ResolveKey() failed: %s", err.Error())
+	}
+
+	if subject.GetId() != account.ID {
+		t.Errorf("Key resolved to %s, expected %s", subject.GetId(), account.ID)
+	}
+
+	_, rotated, err := db.RotateKey(key.ID)
+	if err != nil {
+		t.Fatalf("RotateKey() failed: %s", err.Error())
+	}
+
+	_, err = db.ResolveKey(secret)
+	if err == nil {
+		t.Errorf("Old key still resolves after rotation")
+	}
+
+	_, err = db.ResolveKey(rotated)
+	if err != nil {
+		t.Errorf("Rotated key does not resolve: %s", err.Error())
+	}
+
+	err = db.RevokeKey(key.ID)
+	if err != nil {
+		t.Fatalf("RevokeKey() failed: %s", err.Error())
+	}
+
+	_, err = db.ResolveKey(rotated)
+	if err == nil {
+		t.Errorf("Revoked key still resolves")
+	}
+
+	_, _, err = db.CreateKey("nonexisting", "test", ScopeFull)
+	if err == nil {
+		t.Errorf("CreateKey() accepted unknown owner")
+	}
+}
+
+func TestMultiUserCanAccess(t *testing.T) {
+	db := newTestMultiUser(t)
+
+	a, _ := db.CreateAccount("a")
+	b, _ := db.CreateAccount("b")
+
+	if a.CanAccess(ObjectProxy(a.ID)) != nil {
+		t.Errorf("Account cannot access own objects")
+	}
+
+	if a.CanAccess(ObjectProxy(b.ID)) != ErrorNoAccess {
+		t.Errorf("Account can access objects from other account")
+	}
+
+	user, err := db.CreateUser("user", []string{a.ID})
+	if err != nil {
+		t.Fatalf("CreateUser() failed: %s", err.Error())
+	}
+
+	if user.CanAccess(ObjectProxy(a.ID)) != nil {
+		t.Errorf("User cannot access objects from own account")
+	}
+
+	if user.CanAccess(ObjectProxy(b.ID)) != ErrorNoAccess {
+		t.Errorf("User can access objects from foreign account")
+	}
+
+	err = db.DeleteAccount(a.ID)
+	if err != nil {
+		t.Fatalf("DeleteAccount() failed: %s", err.Error())
+	}
+
+	user, _ = db.GetUser(user.ID)
+	if len(user.AccountIDs) != 0 {
+		t.Errorf("User is still connected to deleted account")
+	}
+
+	_, err = db.CreateUser("user2", []string{a.ID})
+	if err == nil {
+		t.Errorf("CreateUser() accepted deleted account")
+	}
+}
+
+func TestFileStorePersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "agento-userdb")
+	if err != nil {
+		t.Fatalf("TempDir(): %s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "userdb.json")
+
+	store, _ := NewFileStore(path)
+	db := NewMultiUser(store, "")
+	account, _ := db.CreateAccount("persisted")
+	_, secret, _ := db.CreateKey(account.ID, "key", ScopeFull)
+
+	store, err = NewFileStore(path)
+	if err != nil {
+		t.Fatalf("NewFileStore() failed to read back: %s", err.Error())
+	}
+	db = NewMultiUser(store, "")
+
+	subject, err := db.ResolveKey(secret)
+	if err != nil {
+		t.Fatalf("Key did not survive reload: %s", err.Error())
+	}
+
+	if subject.GetId() != account.ID {
+		t.Errorf("Key resolved to wrong subject after reload")
+	}
+}
+
+func TestMultiUserAuthorize(t *testing.T) {
+	db := newTestMultiUser(t)
+
+	a, _ := db.CreateAccount("a")
+	object := ObjectProxy(a.ID)
+
+	user, _ := db.CreateUser("viewer", []string{a.ID})
+	err := user.SetRole(a.ID, RoleViewer)
+	if err != nil {
+		t.Fatalf("SetRole() failed: %s", err.Error())
+	}
+	user.Save()
+
+	if user.Authorize(object, PermissionRead) != nil {
+		t.Errorf("Viewer cannot read")
+	}
+
+	if user.Authorize(object, PermissionWriteHosts) != ErrorNoAccess {
+		t.Errorf("Viewer can write hosts")
+	}
+
+	if user.SetRole(a.ID, "superuser") != ErrorInvalidRole {
+		t.Errorf("SetRole() accepted unknown role")
+	}
+
+	if user.SetRole("unknown", RoleAdmin) != ErrorInvalidAccountId {
+		t.Errorf("SetRole() accepted unconnected account")
+	}
+
+	cases := []struct {
+		scope   Scope
+		allowed []Permission
+		denied  []Permission
+	}{
+		{ScopeFull, []Permission{PermissionRead, PermissionReport, PermissionWriteHosts, PermissionAdmin}, nil},
+		{ScopeReport, []Permission{PermissionReport}, []Permission{PermissionRead, PermissionWriteHosts, PermissionWriteProbes, PermissionAdmin}},
+		{ScopeReadOnly, []Permission{PermissionRead}, []Permission{PermissionReport, PermissionWriteHosts, PermissionAdmin}},
+		{ScopeProbeAdmin, []Permission{PermissionRead, PermissionWriteProbes}, []Permission{PermissionWriteHosts, PermissionAdmin}},
+	}
+
+	for _, c := range cases {
+		_, secret, err := db.CreateKey(a.ID, string(c.scope), c.scope)
+		if err != nil {
+			t.Fatalf("CreateKey() failed: %s", err.Error())
+		}
+
+		subject, err := db.ResolveKey(secret)
+		if err != nil {
+			t.Fatalf("ResolveKey() failed: %s", err.Error())
+		}
+
+		if _, ok := subject.(Account); !ok {
+			t.Errorf("Scoped account key did not resolve to an Account")
+		}
+
+		for _, p := range c.allowed {
+			if subject.Authorize(object, p) != nil {
+				t.Errorf("Scope '%s' denied %s", c.scope, p)
+			}
+		}
+
+		for _, p := range c.denied {
+			if subject.Authorize(object, p) != ErrorNoAccess {
+				t.Errorf("Scope '%s' allowed %s", c.scope, p)
+			}
+		}
+
+		if subject.Authorize(ObjectProxy("other"), PermissionRead) == nil {
+			t.Errorf("Scope '%s' allowed access to other account", c.scope)
+		}
+	}
+
+	_, _, err = db.CreateKey(a.ID, "invalid", "everything")
+	if err != ErrorInvalidScope {
+		t.Errorf("CreateKey() accepted unknown scope")
+	}
+
+	// A scoped user key can never exceed the role of the user.
+	_, secret, _ := db.CreateKey(user.ID, "probes", ScopeProbeAdmin)
+	subject, _ := db.ResolveKey(secret)
+	if subject.Authorize(object, PermissionWriteProbes) != ErrorNoAccess {
+		t.Errorf("Scoped key for viewer can write probes")
+	}
+}
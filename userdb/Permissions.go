@@ -0,0 +1,129 @@
+package userdb
+
+import (
+	"errors"
+)
+
+type (
+	// Permission is the right to perform a class of operations on objects
+	// belonging to an account.
+	Permission string
+
+	// Role is a named set of permissions a user can have on an account.
+	Role string
+
+	// Scope limits what an API key can be used for, regardless of the
+	// permissions of the owner.
+	Scope string
+)
+
+const (
+	// PermissionRead allows reading hosts, probes and metrics.
+	PermissionRead Permission = "read"
+
+	// PermissionReport allows reporting metrics.
+	PermissionReport Permission = "report"
+
+	// PermissionWriteHosts allows adding, changing and deleting hosts.
+	PermissionWriteHosts Permission = "hosts:write"
+
+	// PermissionWriteProbes allows adding, changing and deleting probes.
+	PermissionWriteProbes Permission = "probes:write"
+
+	// PermissionAdmin allows managing users and API keys.
+	PermissionAdmin Permission = "admin"
+)
+
+const (
+	// RoleViewer can read everything in an account.
+	RoleViewer Role = "viewer"
+
+	// RoleOperator can read and change hosts and probes in an account.
+	RoleOperator Role = "operator"
+
+	// RoleAdmin can do everything in an account.
+	RoleAdmin Role = "admin"
+
+	// DefaultRole is used for users without an explicit role on an account.
+	DefaultRole = RoleAdmin
+)
+
+const (
+	// ScopeFull gives a key all permissions of its owner.
+	ScopeFull Scope = ""
+
+	// ScopeReport limits a key to reporting metrics.
+	ScopeReport Scope = "report"
+
+	// ScopeReadOnly limits a key to reading.
+	ScopeReadOnly Scope = "read-only"
+
+	// ScopeProbeAdmin limits a key to reading and managing probes.
+	ScopeProbeAdmin Scope = "probe-admin"
+)
+
+var (
+	rolePermissions = map[Role][]Permission{
+		RoleViewer:   {PermissionRead},
+		RoleOperator: {PermissionRead, PermissionReport, PermissionWriteHosts, PermissionWriteProbes},
+		RoleAdmin:    {PermissionRead, PermissionReport, PermissionWriteHosts, PermissionWriteProbes, PermissionAdmin},
+	}
+
+	scopePermissions = map[Scope][]Permission{
+		ScopeReport:     {PermissionReport},
+		ScopeReadOnly:   {PermissionRead},
+		ScopeProbeAdmin: {PermissionRead, PermissionWriteProbes},
+	}
+
+	// ErrorInvalidRole is returned when trying to use an unknown role.
+	ErrorInvalidRole = errors.New("invalid role")
+
+	// ErrorInvalidScope is returned when trying to use an unknown scope.
+	ErrorInvalidScope = errors.New("invalid scope")
+)
+
+func hasPermission(permissions []Permission, permission Permission) bool {
+	for _, p := range permissions {
+		if p == permission {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Allows returns true if the role grants permission.
+func (r Role) Allows(permission Permission) bool {
+	return hasPermission(rolePermissions[r], permission)
+}
+
+// Valid returns ErrorInvalidRole if the role is unknown.
+func (r Role) Valid() error {
+	if _, found := rolePermissions[r]; !found {
+		return ErrorInvalidRole
+	}
+
+	return nil
+}
+
+// Allows returns true if the scope permits permission.
+func (s Scope) Allows(permission Permission) bool {
+	if s == ScopeFull {
+		return true
+	}
+
+	return hasPermission(scopePermissions[s], permission)
+}
+
+// Valid returns ErrorInvalidScope if the scope is unknown.
+func (s Scope) Valid() error {
+	if s == ScopeFull {
+		return nil
+	}
+
+	if _, found := scopePermissions[s]; !found {
+		return ErrorInvalidScope
+	}
+
+	return nil
+}
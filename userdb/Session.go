@@ -0,0 +1,85 @@
+package userdb
+
+import (
+	"errors"
+	"time"
+)
+
+type (
+	// Session is a logged in user. The token identifying the session is only
+	// known by the client, the session is stored by the hash of the token.
+	Session struct {
+		ID        string    `json:"id" bson:"_id"`
+		UserID    string    `json:"user" bson:"user"`
+		CSRFToken string    `json:"csrf" bson:"csrf"`
+		Created   time.Time `json:"created" bson:"created"`
+		Expires   time.Time `json:"expires" bson:"expires"`
+	}
+
+	// SessionStore is a persistent storage for sessions.
+	SessionStore interface {
+		GetSession(id string) (*Session, error)
+		SaveSession(session *Session) error
+		DeleteSession(id string) error
+	}
+)
+
+var (
+	// SessionLifetime is the time a session is valid after login.
+	SessionLifetime = 24 * time.Hour
+
+	// ErrorInvalidLogin will be returned if a username or password is wrong.
+	ErrorInvalidLogin = errors.New("invalid username or password")
+
+	// ErrorSessionNotFound will be returned if a session is unknown or
+	// expired.
+	ErrorSessionNotFound = errors.New("session not found")
+)
+
+// IsExpired returns true if the session has expired.
+func (s *Session) IsExpired() bool {
+	return time.Now().After(s.Expires)
+}
+
+// newSession will start a new session for userID. The token identifying the
+// session is returned.
+func newSession(store SessionStore, userID string) (*Session, string, error) {
+	token := newKey()
+	now := time.Now()
+
+	s := &Session{
+		ID:        hashKey(token),
+		UserID:    userID,
+		CSRFToken: newKey(),
+		Created:   now,
+		Expires:   now.Add(SessionLifetime),
+	}
+
+	return s, token, store.SaveSession(s)
+}
+
+// getSession returns the session identified by token. Expired sessions are
+// deleted.
+func getSession(store SessionStore, token string) (*Session, error) {
+	if token == "" {
+		return nil, ErrorSessionNotFound
+	}
+
+	s, err := store.GetSession(hashKey(token))
+	if err != nil {
+		return nil, ErrorSessionNotFound
+	}
+
+	if s.IsExpired() {
+		store.DeleteSession(s.ID)
+
+		return nil, ErrorSessionNotFound
+	}
+
+	return s, nil
+}
+
+// deleteSession ends the session identified by token.
+func deleteSession(store SessionStore, token string) error {
+	return store.DeleteSession(hashKey(token))
+}
@@ -0,0 +1,117 @@
+package userdb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestMultiUserLogin(t *testing.T) {
+	db := newTestMultiUser(t)
+
+	a, _ := db.CreateAccount("a")
+	user, _ := db.CreateUser("jane", []string{a.ID})
+
+	_, _, err := db.Login("jane", "")
+	if err != ErrorInvalidLogin {
+		t.Errorf("User without password could log in")
+	}
+
+	if user.SetPassword("") != ErrorEmptyPassword {
+		t.Errorf("SetPassword() accepted empty password")
+	}
+
+	user.SetPassword("secret")
+	user.Save()
+
+	if user.Password == "secret" {
+		t.Errorf("Password stored in clear text")
+	}
+
+	_, _, err = db.Login("jane", "wrong")
+	if err != ErrorInvalidLogin {
+		t.Errorf("Login() accepted wrong password")
+	}
+
+	_, _, err = db.Login("john", "secret")
+	if err != ErrorInvalidLogin {
+		t.Errorf("Login() accepted unknown user")
+	}
+
+	session, token, err := db.Login("jane", "secret")
+	if err != nil {
+		t.Fatalf("Login() failed: %s", err.Error())
+	}
+
+	if session.ID == token {
+		t.Errorf("Session is stored by token")
+	}
+
+	resolved, err := db.ResolveCookie(token)
+	if err != nil {
+		t.Fatalf("ResolveCookie() failed: %s", err.Error())
+	}
+
+	if resolved.GetId() != user.ID {
+		t.Errorf("Cookie resolved to %s, expected %s", resolved.GetId(), user.ID)
+	}
+
+	err = db.Logout(token)
+	if err != nil {
+		t.Fatalf("Logout() failed: %s", err.Error())
+	}
+
+	_, err = db.ResolveCookie(token)
+	if err == nil {
+		t.Errorf("Cookie resolves after logout")
+	}
+
+	_, err = db.CreateUser("jane", nil)
+	if err != ErrorUserExists {
+		t.Errorf("CreateUser() accepted duplicate username")
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	db := newTestMultiUser(t)
+
+	user, _ := db.CreateUser("jane", nil)
+	user.SetPassword("secret")
+	user.Save()
+
+	defer func(lifetime time.Duration) { SessionLifetime = lifetime }(SessionLifetime)
+	SessionLifetime = -time.Second
+
+	_, token, err := db.Login("jane", "secret")
+	if err != nil {
+		t.Fatalf("Login() failed: %s", err.Error())
+	}
+
+	_, err = db.GetSession(token)
+	if err != ErrorSessionNotFound {
+		t.Errorf("Expired session is still valid")
+	}
+}
+
+func TestSingleUserLogin(t *testing.T) {
+	db := NewSingleUser("key")
+
+	_, _, err := db.Login(SingleUserName, "wrong")
+	if err != ErrorInvalidLogin {
+		t.Errorf("Login() accepted wrong password")
+	}
+
+	_, token, err := db.Login(SingleUserName, "key")
+	if err != nil {
+		t.Fatalf("Login() failed: %s", err.Error())
+	}
+
+	user, err := db.ResolveCookie(token)
+	if err != nil || user != User(db) {
+		t.Errorf("Cookie did not resolve to the single user")
+	}
+
+	_, _, err = God.Login(SingleUserName, "")
+	if err != ErrorInvalidLogin {
+		t.Errorf("It is possible to log in as God")
+	}
+}
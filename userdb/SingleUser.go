@@ -1,24 +1,43 @@
 package userdb
 
 import (
+	"crypto/subtle"
 	"errors"
 )
 
 type (
 	// This implements Subject, User, Account and Database for a single user system.
 	SingleUser struct {
-		key string
+		key      string
+		sessions SessionStore
 	}
 )
 
+const (
+	// SingleUserName is the username used for logging in to a single user
+	// system. The password is the API key.
+	SingleUserName = "admin"
+)
+
 var (
 	// God can be used as a user with access to everything - even in multiuser
 	// environments.
 	God = &SingleUser{}
 )
 
+// IsGod returns true if subject is God. The user of a single user system is
+// the operator, and is God too.
+func IsGod(subject Subject) bool {
+	_, ok := subject.(*SingleUser)
+
+	return ok
+}
+
 func NewSingleUser(key string) *SingleUser {
-	return &SingleUser{key: key}
+	// A FileStore without a path cannot fail.
+	sessions, _ := NewFileStore("")
+
+	return &SingleUser{key: key, sessions: sessions}
 }
 
 func (s *SingleUser) GetId() string {
@@ -38,10 +57,46 @@ func (s *SingleUser) ResolveKey(key string) (Subject, error) {
 	return nil, errors.New("Wrong key")
 }
 
-// This is only here to satisfy the Database interface. This doesn't work
-// in singleuser mode. Will always return an error.
+// ResolveCookie will resolve a session token to the single user.
 func (s *SingleUser) ResolveCookie(value string) (User, error) {
-	return nil, errors.New("Cookie auth not supported")
+	_, err := s.GetSession(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Login will start a new session if username is SingleUserName and password
+// matches the API key. Sessions are kept in memory only.
+func (s *SingleUser) Login(username string, password string) (*Session, string, error) {
+	if s.sessions == nil || s.key == "" {
+		return nil, "", ErrorInvalidLogin
+	}
+
+	if username != SingleUserName || subtle.ConstantTimeCompare([]byte(password), []byte(s.key)) != 1 {
+		return nil, "", ErrorInvalidLogin
+	}
+
+	return newSession(s.sessions, s.GetId())
+}
+
+// GetSession returns the active session identified by token.
+func (s *SingleUser) GetSession(token string) (*Session, error) {
+	if s.sessions == nil {
+		return nil, ErrorSessionNotFound
+	}
+
+	return getSession(s.sessions, token)
+}
+
+// Logout ends the session identified by token.
+func (s *SingleUser) Logout(token string) error {
+	if s.sessions == nil {
+		return ErrorSessionNotFound
+	}
+
+	return deleteSession(s.sessions, token)
 }
 
 func (s *SingleUser) GetAccounts() ([]Account, error) {
@@ -53,6 +108,11 @@ func (s *SingleUser) CanAccess(object Object) error {
 	return nil
 }
 
+// Will allow everything.
+func (s *SingleUser) Authorize(object Object, permission Permission) error {
+	return nil
+}
+
 // This doesn't do anything in singleuser mode.
 func (s *SingleUser) Save() error {
 	return nil
@@ -67,3 +127,4 @@ var _ Object = (*SingleUser)(nil)
 var _ Subject = (*SingleUser)(nil)
 var _ User = (*SingleUser)(nil)
 var _ Account = (*SingleUser)(nil)
+var _ Database = (*SingleUser)(nil)
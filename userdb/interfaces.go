@@ -21,6 +21,11 @@ type (
 		// if allowed, ErrorNoAccess otherwise.
 		CanAccess(object Object) error
 
+		// Check if the Subject is allowed to perform operations requiring
+		// permission on an Object. Should return nil if allowed,
+		// ErrorNoAccess otherwise.
+		Authorize(object Object, permission Permission) error
+
 		// Save the Subject to database.
 		Save() error
 	}
@@ -48,6 +53,36 @@ type (
 
 		// Should map a cookie secret to a User.
 		ResolveCookie(value string) (User, error)
+
+		// Login authenticates a user by username and password and starts a
+		// new session. The token to be used as a cookie secret is returned.
+		Login(username string, password string) (*Session, string, error)
+
+		// GetSession returns the active session identified by token.
+		GetSession(token string) (*Session, error)
+
+		// Logout ends the session identified by token.
+		Logout(token string) error
+	}
+
+	// Store is a persistent storage for MultiUser.
+	Store interface {
+		SessionStore
+
+		GetAccounts() ([]*AccountRecord, error)
+		GetAccount(id string) (*AccountRecord, error)
+		SaveAccount(account *AccountRecord) error
+		DeleteAccount(id string) error
+
+		GetUsers() ([]*UserRecord, error)
+		GetUser(id string) (*UserRecord, error)
+		SaveUser(user *UserRecord) error
+		DeleteUser(id string) error
+
+		GetKeys(ownerID string) ([]*KeyRecord, error)
+		GetKey(id string) (*KeyRecord, error)
+		GetKeyByHash(hash string) (*KeyRecord, error)
+		SaveKey(key *KeyRecord) error
 	}
 )
 